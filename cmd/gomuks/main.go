@@ -17,9 +17,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/chzyer/readline"
 	"go.mau.fi/util/exhttp"
 	flag "maunium.net/go/mauflag"
 
@@ -31,13 +33,14 @@ import (
 
 var wantHelp, _ = flag.MakeHelpFlag()
 var wantVersion = flag.MakeFull("v", "version", "View gomuks version and quit.", "false").Bool()
+var fsckFix = flag.MakeFull("", "fix", "Fix the problems found by the fsck command.", "false").Bool()
 
 func main() {
 	hicli.InitialDeviceDisplayName = "gomuks web"
 	exhttp.AutoAllowCORS = false
 	flag.SetHelpTitles(
 		"gomuks - A Matrix client written in Go.",
-		"gomuks [-hv]",
+		"gomuks [-hv] [export <file> | import <file> | fsck [--fix] | migrate-db]",
 	)
 	err := flag.Parse()
 
@@ -59,5 +62,138 @@ func main() {
 	gmx.LinkifiedVersion = version.LinkifiedVersion
 	gmx.BuildTime = version.ParsedBuildTime
 	gmx.FrontendFS = web.Frontend
-	gmx.Run()
+	switch flag.Arg(0) {
+	case "export", "import":
+		os.Exit(runExportImport(gmx, flag.Arg(0), flag.Arg(1)))
+	case "fsck":
+		os.Exit(runFsck(gmx, *fsckFix))
+	case "migrate-db":
+		os.Exit(runMigrateDB(gmx))
+	case "":
+		gmx.Run()
+	default:
+		_, _ = fmt.Fprintln(os.Stderr, "Unknown command", flag.Arg(0))
+		flag.PrintHelp()
+		os.Exit(1)
+	}
+}
+
+func readPassphrase(confirm bool) (string, error) {
+	passphrase, err := readline.Password("Passphrase: ")
+	if err != nil {
+		return "", err
+	} else if len(passphrase) == 0 {
+		return "", fmt.Errorf("passphrase must not be empty")
+	}
+	if confirm {
+		confirmation, err := readline.Password("Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		} else if string(confirmation) != string(passphrase) {
+			return "", fmt.Errorf("passphrases don't match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+func runExportImport(gmx *gomuks.Gomuks, command, path string) int {
+	if path == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gomuks %s <file>\n", command)
+		return 1
+	}
+	gmx.InitDirectories()
+	err := gmx.LoadDatabaseConfig()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+		return 1
+	}
+	passphrase, err := readPassphrase(command == "export")
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to read passphrase:", err)
+		return 1
+	}
+	var manifest *gomuks.ExportManifest
+	verb := "Exported"
+	if command == "export" {
+		manifest, err = gmx.Export(context.Background(), path, passphrase)
+	} else {
+		verb = "Imported"
+		fmt.Println("Make sure gomuks is not running while importing")
+		manifest, err = gmx.Import(context.Background(), path, passphrase)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Failed to %s: %v\n", command, err)
+		return 2
+	}
+	for _, acc := range manifest.Accounts {
+		name := acc.Account
+		if name == "" {
+			name = "default account"
+		}
+		fmt.Printf("%s %s (%s, device %s)\n", verb, name, acc.UserID, acc.DeviceID)
+	}
+	return 0
+}
+
+func runFsck(gmx *gomuks.Gomuks, fix bool) int {
+	gmx.InitDirectories()
+	err := gmx.LoadDatabaseConfig()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+		return 1
+	}
+	fmt.Println("Make sure gomuks is not running while checking the database")
+	results, err := gmx.Fsck(context.Background(), fix)
+	for _, result := range results {
+		name := result.Account
+		if name == "" {
+			name = "default account"
+		}
+		fmt.Printf("%s (%s): %d problems found\n", name, result.UserID, len(result.Mismatches))
+		for _, mismatch := range result.Mismatches {
+			fmt.Println(" ", mismatch)
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to check database:", err)
+		return 2
+	}
+	for _, result := range results {
+		if len(result.Mismatches) > 0 {
+			if fix {
+				fmt.Println("All problems were fixed")
+				return 0
+			}
+			fmt.Println("Run with --fix to fix the problems")
+			return 1
+		}
+	}
+	return 0
+}
+
+func runMigrateDB(gmx *gomuks.Gomuks) int {
+	gmx.InitDirectories()
+	err := gmx.LoadDatabaseConfig()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+		return 1
+	}
+	fmt.Println("Make sure gomuks is not running while migrating the database")
+	results, err := gmx.MigrateToPostgres(context.Background())
+	for _, result := range results {
+		name := result.Account
+		if name == "" {
+			name = "default account"
+		}
+		fmt.Printf("Migrated %s (%s): %d rows copied\n", name, result.UserID, result.Rows)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to migrate database:", err)
+		return 2
+	} else if len(results) == 0 {
+		fmt.Println("No SQLite databases found")
+		return 0
+	}
+	fmt.Println("The SQLite databases were left in place and can be deleted after checking that everything works")
+	return 0
 }
@@ -12,6 +12,7 @@ require (
 	github.com/disintegration/imaging v1.6.2
 	github.com/gabriel-vasile/mimetype v1.4.9
 	github.com/jdeng/goheif v0.0.0-20250603221700-0b111b5c3adb
+	github.com/lib/pq v1.10.9
 	github.com/lucasb-eyer/go-colorful v1.2.0
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/rivo/uniseg v0.4.7
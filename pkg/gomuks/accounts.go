@@ -0,0 +1,299 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
+	"golang.org/x/net/http2"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+var (
+	ErrUnknownAccount       = errors.New("unknown account")
+	ErrInvalidAccountName   = errors.New("invalid account name")
+	ErrAccountAlreadyExists = errors.New("account already exists")
+)
+
+var accountNameRegex = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// accountDataDir returns the directory where the database of the given account is stored.
+// The default account (empty name) uses the data directory directly for backwards compatibility.
+func (gmx *Gomuks) accountDataDir(account string) string {
+	if account == "" {
+		return gmx.DataDir
+	}
+	return filepath.Join(gmx.DataDir, "accounts", account)
+}
+
+// GetClient returns the client for the given account, or nil if the account doesn't exist.
+func (gmx *Gomuks) GetClient(account string) *hicli.HiClient {
+	if account == "" {
+		return gmx.Client
+	}
+	gmx.accountsLock.RLock()
+	defer gmx.accountsLock.RUnlock()
+	return gmx.accounts[account]
+}
+
+// AccountNames returns the names of all accounts, starting with the default account.
+func (gmx *Gomuks) AccountNames() []string {
+	gmx.accountsLock.RLock()
+	names := slices.Sorted(maps.Keys(gmx.accounts))
+	gmx.accountsLock.RUnlock()
+	return append([]string{""}, names...)
+}
+
+// allClients returns the clients of all accounts, starting with the default account.
+func (gmx *Gomuks) allClients() []*hicli.HiClient {
+	gmx.accountsLock.RLock()
+	defer gmx.accountsLock.RUnlock()
+	clients := make([]*hicli.HiClient, 0, len(gmx.accounts)+1)
+	clients = append(clients, gmx.Client)
+	for _, name := range slices.Sorted(maps.Keys(gmx.accounts)) {
+		clients = append(clients, gmx.accounts[name])
+	}
+	return clients
+}
+
+// getRequestClient returns the client selected by the account query parameter of an HTTP request.
+// If the account doesn't exist, an error is written to the response and nil is returned.
+func (gmx *Gomuks) getRequestClient(w http.ResponseWriter, r *http.Request) *hicli.HiClient {
+	cli := gmx.GetClient(r.URL.Query().Get("account"))
+	if cli == nil {
+		mautrix.MNotFound.WithMessage("Unknown account").Write(w)
+	}
+	return cli
+}
+
+func (gmx *Gomuks) ListAccounts() []*jsoncmd.AccountInfo {
+	names := gmx.AccountNames()
+	accounts := make([]*jsoncmd.AccountInfo, 0, len(names))
+	for _, name := range names {
+		if cli := gmx.GetClient(name); cli != nil {
+			accounts = append(accounts, &jsoncmd.AccountInfo{Account: name, State: cli.State()})
+		}
+	}
+	return accounts
+}
+
+func (gmx *Gomuks) HandleAccountEvent(account string, evt any) {
+	gmx.EventBuffer.Push(account, evt)
+	syncComplete, ok := evt.(*jsoncmd.SyncComplete)
+	if ok && ptr.Val(syncComplete.Since) != "" {
+		if cli := gmx.GetClient(account); cli != nil {
+			go gmx.SendPushNotifications(account, cli, syncComplete)
+		}
+	}
+}
+
+func (gmx *Gomuks) openClient(account string) (*hicli.HiClient, error) {
+	dataDir := gmx.accountDataDir(account)
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	log := gmx.Log.With().Str("component", "hicli").Logger()
+	if account != "" {
+		log = log.With().Str("account", account).Logger()
+	}
+	rawDB, err := gmx.openDatabase(context.Background(), account, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	cli := hicli.New(
+		rawDB,
+		nil,
+		log,
+		[]byte("meow"),
+		func(evt any) {
+			gmx.HandleAccountEvent(account, evt)
+		},
+	)
+	if account == "" {
+		cli.LogoutFunc = gmx.Logout
+	} else {
+		cli.LogoutFunc = func(ctx context.Context) error {
+			return gmx.LogoutAccount(ctx, account)
+		}
+	}
+	if account != "" {
+		cli.HTMLSanitizerImgSrcTemplate = "_gomuks/media/%s/%s?encrypted=false&account=" + account
+	}
+	cli.EnablePresence = gmx.Config.Matrix.EnablePresence
+	cli.UseSlidingSync = gmx.Config.Matrix.SlidingSync
+	cli.Retention = hicli.RetentionPolicy(gmx.Config.Matrix.Retention)
+	cli.RoomRetention = make(map[id.RoomID]hicli.RetentionPolicy, len(gmx.Config.Matrix.RoomRetention))
+	for roomID, policy := range gmx.Config.Matrix.RoomRetention {
+		cli.RoomRetention[roomID] = hicli.RetentionPolicy(policy)
+	}
+	httpClient := cli.Client.Client
+	httpClient.Transport.(*http.Transport).ForceAttemptHTTP2 = false
+	if !gmx.Config.Matrix.DisableHTTP2 {
+		h2, err := http2.ConfigureTransports(httpClient.Transport.(*http.Transport))
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+		h2.ReadIdleTimeout = 30 * time.Second
+	}
+	return cli, nil
+}
+
+func startClient(ctx context.Context, cli *hicli.HiClient) error {
+	userID, err := cli.DB.Account.GetFirstUserID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get first user ID: %w", err)
+	}
+	err = cli.Start(ctx, userID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start client: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Stringer("user_id", userID).Msg("Client started")
+	return nil
+}
+
+// StartExtraAccounts starts clients for all non-default accounts found in the data directory.
+func (gmx *Gomuks) StartExtraAccounts() {
+	entries, err := os.ReadDir(filepath.Join(gmx.DataDir, "accounts"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			gmx.Log.Err(err).Msg("Failed to read accounts directory")
+		}
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !accountNameRegex.MatchString(entry.Name()) {
+			continue
+		}
+		_, err = gmx.startAccount(entry.Name())
+		if err != nil {
+			gmx.Log.Err(err).Str("account", entry.Name()).Msg("Failed to start account")
+		}
+	}
+}
+
+// reserveAccount marks the account as being started. The check and the reservation are done under
+// the same lock, so the same account can't be started twice concurrently.
+func (gmx *Gomuks) reserveAccount(account string) bool {
+	gmx.accountsLock.Lock()
+	defer gmx.accountsLock.Unlock()
+	_, exists := gmx.accounts[account]
+	_, starting := gmx.startingAccounts[account]
+	if exists || starting {
+		return false
+	}
+	gmx.startingAccounts[account] = struct{}{}
+	return true
+}
+
+func (gmx *Gomuks) startAccount(account string) (*hicli.HiClient, error) {
+	if !gmx.reserveAccount(account) {
+		return nil, ErrAccountAlreadyExists
+	}
+	defer func() {
+		gmx.accountsLock.Lock()
+		delete(gmx.startingAccounts, account)
+		gmx.accountsLock.Unlock()
+	}()
+	cli, err := gmx.openClient(account)
+	if err != nil {
+		return nil, err
+	}
+	ctx := gmx.Log.With().Str("account", account).Logger().WithContext(context.Background())
+	err = startClient(ctx, cli)
+	if err != nil {
+		cli.Stop()
+		return nil, err
+	}
+	gmx.accountsLock.Lock()
+	gmx.accounts[account] = cli
+	gmx.accountsLock.Unlock()
+	return cli, nil
+}
+
+// AddAccount creates a new empty account namespace, which can then be logged into
+// by sending the normal login commands with the account selector set.
+func (gmx *Gomuks) AddAccount(account string) (*jsoncmd.AccountInfo, error) {
+	if !accountNameRegex.MatchString(account) {
+		return nil, ErrInvalidAccountName
+	}
+	cli, err := gmx.startAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	cli.EventHandler(cli.State())
+	return &jsoncmd.AccountInfo{Account: account, State: cli.State()}, nil
+}
+
+// LogoutAccount logs out of a non-default account and deletes all of its local data.
+func (gmx *Gomuks) LogoutAccount(ctx context.Context, account string) error {
+	log := zerolog.Ctx(ctx).With().Str("account", account).Logger()
+	cli := gmx.GetClient(account)
+	if cli == nil {
+		return ErrUnknownAccount
+	}
+	cachedMedia, err := cli.DB.Media.GetCached(ctx, "")
+	if err != nil {
+		log.Err(err).Msg("Failed to get cached media, cache files won't be removed")
+	}
+	log.Info().Msg("Stopping client and logging out")
+	cli.Stop()
+	_, err = cli.Client.Logout(ctx)
+	if err != nil && !errors.Is(err, mautrix.MUnknownToken) {
+		log.Warn().Err(err).Msg("Failed to log out")
+		return err
+	}
+	gmx.accountsLock.Lock()
+	delete(gmx.accounts, account)
+	gmx.accountsLock.Unlock()
+	log.Info().Msg("Logout complete, removing data")
+	res := gmx.purgeAccountMediaCache(ctx, cachedMedia, gmx.allClients())
+	log.Debug().Int("freed_files", res.FreedFiles).Int64("freed_bytes", res.FreedBytes).Msg("Removed unused cache files")
+	err = os.RemoveAll(gmx.accountDataDir(account))
+	if err != nil {
+		log.Err(err).Msg("Failed to remove account data dir")
+	}
+	err = gmx.dropAccountSchema(ctx, account)
+	if err != nil {
+		log.Err(err).Msg("Failed to remove account database schema")
+	}
+	gmx.EventBuffer.Push(account, &jsoncmd.ClientState{})
+	return nil
+}
+
+func (gmx *Gomuks) stopExtraAccounts() {
+	gmx.accountsLock.Lock()
+	defer gmx.accountsLock.Unlock()
+	for _, cli := range gmx.accounts {
+		cli.Stop()
+	}
+}
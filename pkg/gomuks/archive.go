@@ -0,0 +1,207 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The export archive is encrypted in fixed-size chunks with XChaCha20-Poly1305, so that it can be streamed
+// without holding the whole archive in memory. The nonce of each chunk contains a counter and a flag for
+// the final chunk, which prevents reordering, dropping or truncating chunks.
+//
+// Format: magic (16 bytes) | salt (16 bytes) | nonce prefix (16 bytes) | chunks...
+// Each chunk: ciphertext length (uint32, big endian) | ciphertext
+const (
+	archiveMagic          = "gomuks-export-v1"
+	archiveSaltSize       = 16
+	archiveNoncePrefixLen = chacha20poly1305.NonceSizeX - 8
+	archiveChunkSize      = 64 * 1024
+	archiveLastChunkFlag  = 1 << 63
+)
+
+var (
+	ErrNotAnArchive        = errors.New("file is not a gomuks export archive")
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase or corrupted archive")
+	ErrArchiveTruncated    = errors.New("archive is truncated")
+)
+
+func deriveArchiveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, chacha20poly1305.KeySize)
+	return chacha20poly1305.NewX(key)
+}
+
+func makeArchiveNonce(prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, prefix)
+	if last {
+		counter |= archiveLastChunkFlag
+	}
+	binary.BigEndian.PutUint64(nonce[archiveNoncePrefixLen:], counter)
+	return nonce
+}
+
+type archiveWriter struct {
+	w           io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	counter     uint64
+	buf         []byte
+}
+
+// newArchiveWriter writes the archive header to the given writer and returns a writer that encrypts everything
+// written to it. The returned writer must be closed to write the final chunk.
+func newArchiveWriter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	header := make([]byte, len(archiveMagic)+archiveSaltSize+archiveNoncePrefixLen)
+	copy(header, archiveMagic)
+	_, err := rand.Read(header[len(archiveMagic):])
+	if err != nil {
+		return nil, err
+	}
+	salt := header[len(archiveMagic) : len(archiveMagic)+archiveSaltSize]
+	aead, err := deriveArchiveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveWriter{
+		w:           w,
+		aead:        aead,
+		noncePrefix: header[len(archiveMagic)+archiveSaltSize:],
+		buf:         make([]byte, 0, archiveChunkSize),
+	}, nil
+}
+
+func (aw *archiveWriter) writeChunk(data []byte, last bool) error {
+	ciphertext := aw.aead.Seal(nil, makeArchiveNonce(aw.noncePrefix, aw.counter, last), data, nil)
+	aw.counter++
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(ciphertext)))
+	_, err := aw.w.Write(length[:])
+	if err != nil {
+		return err
+	}
+	_, err = aw.w.Write(ciphertext)
+	return err
+}
+
+func (aw *archiveWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		copied := copy(aw.buf[len(aw.buf):cap(aw.buf)], p)
+		aw.buf = aw.buf[:len(aw.buf)+copied]
+		p = p[copied:]
+		n += copied
+		if len(aw.buf) == cap(aw.buf) {
+			err = aw.writeChunk(aw.buf, false)
+			if err != nil {
+				return
+			}
+			aw.buf = aw.buf[:0]
+		}
+	}
+	return
+}
+
+func (aw *archiveWriter) Close() error {
+	return aw.writeChunk(aw.buf, true)
+}
+
+type archiveReader struct {
+	r           io.Reader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	counter     uint64
+	buf         []byte
+	done        bool
+}
+
+// newArchiveReader reads the archive header from the given reader and returns a reader that decrypts the rest.
+func newArchiveReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, len(archiveMagic)+archiveSaltSize+archiveNoncePrefixLen)
+	_, err := io.ReadFull(r, header)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, ErrNotAnArchive
+	} else if err != nil {
+		return nil, err
+	}
+	aead, err := deriveArchiveKey(passphrase, header[len(archiveMagic):len(archiveMagic)+archiveSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &archiveReader{
+		r:           r,
+		aead:        aead,
+		noncePrefix: header[len(archiveMagic)+archiveSaltSize:],
+	}, nil
+}
+
+func (ar *archiveReader) readChunk() error {
+	var length [4]byte
+	_, err := io.ReadFull(ar.r, length[:])
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrArchiveTruncated
+	} else if err != nil {
+		return err
+	}
+	chunkLen := binary.BigEndian.Uint32(length[:])
+	if chunkLen > archiveChunkSize+uint32(ar.aead.Overhead()) {
+		return fmt.Errorf("%w: chunk too large", ErrIncorrectPassphrase)
+	}
+	ciphertext := make([]byte, chunkLen)
+	_, err = io.ReadFull(ar.r, ciphertext)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrArchiveTruncated
+	} else if err != nil {
+		return err
+	}
+	ar.buf, err = ar.aead.Open(nil, makeArchiveNonce(ar.noncePrefix, ar.counter, false), ciphertext, nil)
+	if err != nil {
+		ar.buf, err = ar.aead.Open(nil, makeArchiveNonce(ar.noncePrefix, ar.counter, true), ciphertext, nil)
+		if err != nil {
+			return ErrIncorrectPassphrase
+		}
+		ar.done = true
+	}
+	ar.counter++
+	return nil
+}
+
+func (ar *archiveReader) Read(p []byte) (n int, err error) {
+	for len(ar.buf) == 0 {
+		if ar.done {
+			return 0, io.EOF
+		}
+		err = ar.readChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, ar.buf)
+	ar.buf = ar.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,131 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func writeTestArchive(t *testing.T, data []byte, passphrase string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newArchiveWriter(&buf, passphrase)
+	if err != nil {
+		t.Fatalf("failed to create archive writer: %v", err)
+	}
+	// Write in odd-sized pieces to make sure chunking doesn't depend on write boundaries
+	for len(data) > 0 {
+		n := min(len(data), 12345)
+		_, err = w.Write(data[:n])
+		if err != nil {
+			t.Fatalf("failed to write to archive: %v", err)
+		}
+		data = data[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readTestArchive(archive []byte, passphrase string) ([]byte, error) {
+	r, err := newArchiveReader(bytes.NewReader(archive), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestArchive_RoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, archiveChunkSize - 1, archiveChunkSize, 3*archiveChunkSize + 17} {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		archive := writeTestArchive(t, data, "hunter2")
+		if !bytes.HasPrefix(archive, []byte(archiveMagic)) {
+			t.Fatalf("archive doesn't start with magic")
+		}
+		output, err := readTestArchive(archive, "hunter2")
+		if err != nil {
+			t.Errorf("failed to read archive of %d bytes: %v", size, err)
+		} else if !bytes.Equal(output, data) {
+			t.Errorf("round trip of %d bytes returned different data", size)
+		}
+	}
+}
+
+func TestArchive_WrongPassphrase(t *testing.T) {
+	archive := writeTestArchive(t, []byte("secret data"), "hunter2")
+	if _, err := readTestArchive(archive, "hunter3"); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Errorf("expected incorrect passphrase error, got %v", err)
+	}
+}
+
+func TestArchive_NotAnArchive(t *testing.T) {
+	for _, data := range []string{"", "short", "not-gomuks-exprt-but-long-enough-for-the-header-to-be-read"} {
+		if _, err := newArchiveReader(bytes.NewReader([]byte(data)), "hunter2"); !errors.Is(err, ErrNotAnArchive) {
+			t.Errorf("expected not an archive error for %q, got %v", data, err)
+		}
+	}
+}
+
+// splitTestArchive returns the header and the length-prefixed chunks of an archive.
+func splitTestArchive(t *testing.T, archive []byte) ([]byte, [][]byte) {
+	t.Helper()
+	headerLen := len(archiveMagic) + archiveSaltSize + archiveNoncePrefixLen
+	header, rest := archive[:headerLen], archive[headerLen:]
+	var chunks [][]byte
+	for len(rest) > 0 {
+		chunkLen := 4 + int(binary.BigEndian.Uint32(rest))
+		chunks = append(chunks, rest[:chunkLen])
+		rest = rest[chunkLen:]
+	}
+	return header, chunks
+}
+
+func TestArchive_Tampering(t *testing.T) {
+	data := make([]byte, 2*archiveChunkSize+100)
+	_, _ = rand.Read(data)
+	archive := writeTestArchive(t, data, "hunter2")
+	header, chunks := splitTestArchive(t, archive)
+	if len(chunks) != 3 {
+		t.Fatalf("unexpected chunk count %d", len(chunks))
+	}
+
+	truncated := bytes.Join(append([][]byte{header}, chunks[:2]...), nil)
+	if _, err := readTestArchive(truncated, "hunter2"); !errors.Is(err, ErrArchiveTruncated) {
+		t.Errorf("expected truncation error when dropping the last chunk, got %v", err)
+	}
+	reordered := bytes.Join([][]byte{header, chunks[1], chunks[0], chunks[2]}, nil)
+	if _, err := readTestArchive(reordered, "hunter2"); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Errorf("expected decryption error for reordered chunks, got %v", err)
+	}
+	cutShort := bytes.Join(append([][]byte{header}, chunks...), nil)
+	cutShort = cutShort[:len(cutShort)-10]
+	if _, err := readTestArchive(cutShort, "hunter2"); !errors.Is(err, ErrArchiveTruncated) {
+		t.Errorf("expected truncation error for partial chunk, got %v", err)
+	}
+	flipped := bytes.Clone(archive)
+	flipped[len(flipped)-1] ^= 1
+	if _, err := readTestArchive(flipped, "hunter2"); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Errorf("expected decryption error for modified chunk, got %v", err)
+	}
+}
@@ -54,7 +54,7 @@ func NewEventBuffer(maxSize int) *EventBuffer {
 	}
 }
 
-func (eb *EventBuffer) Push(evt any) {
+func (eb *EventBuffer) Push(account string, evt any) {
 	allowCache := true
 	if syncComplete, ok := evt.(*jsoncmd.SyncComplete); ok && syncComplete.Since != nil && *syncComplete.Since == "" {
 		// Don't cache initial sync responses
@@ -67,6 +67,7 @@ func (eb *EventBuffer) Push(evt any) {
 	defer eb.lock.Unlock()
 	jc := &BufferedEvent{
 		Command: jsoncmd.EventTypeName(evt),
+		Account: account,
 		Data:    evt,
 	}
 	if allowCache {
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/rs/zerolog"
@@ -29,18 +30,30 @@ import (
 	"go.mau.fi/zeroconfig"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/id"
 )
 
 type Config struct {
-	Web     WebConfig         `yaml:"web"`
-	Matrix  MatrixConfig      `yaml:"matrix"`
-	Push    PushConfig        `yaml:"push"`
-	Media   MediaConfig       `yaml:"media"`
-	Logging zeroconfig.Config `yaml:"logging"`
+	Web      WebConfig         `yaml:"web"`
+	Matrix   MatrixConfig      `yaml:"matrix"`
+	Push     PushConfig        `yaml:"push"`
+	Media    MediaConfig       `yaml:"media"`
+	Database DatabaseConfig    `yaml:"database"`
+	Logging  zeroconfig.Config `yaml:"logging"`
 }
 
 type MatrixConfig struct {
-	DisableHTTP2 bool `yaml:"disable_http2"`
+	DisableHTTP2   bool                          `yaml:"disable_http2"`
+	EnablePresence bool                          `yaml:"enable_presence"`
+	SlidingSync    bool                          `yaml:"sliding_sync"`
+	Retention      RetentionConfig               `yaml:"retention"`
+	RoomRetention  map[id.RoomID]RetentionConfig `yaml:"room_retention"`
+}
+
+// RetentionConfig limits how much history is kept in the local database. Zero values mean no limit.
+type RetentionConfig struct {
+	MaxAge    time.Duration `yaml:"max_age"`
+	MaxEvents int           `yaml:"max_events"`
 }
 
 type PushConfig struct {
@@ -49,6 +62,19 @@ type PushConfig struct {
 
 type MediaConfig struct {
 	ThumbnailSize int `yaml:"thumbnail_size"`
+	// MaxCacheSizeMB is the maximum size of the media cache in megabytes. Zero means no limit.
+	MaxCacheSizeMB int `yaml:"max_cache_size_mb"`
+}
+
+// DatabaseConfig selects where account data is stored. With the default type (sqlite3-fk-wal), each account
+// has its own database file in the data directory and URI is ignored. With the postgres type, each account uses
+// its own schema (gomuks for the default account and gomuks_<name> for others) in the database at URI.
+// Existing SQLite databases can be copied to Postgres using `gomuks migrate-db`.
+type DatabaseConfig struct {
+	Type         string `yaml:"type"`
+	URI          string `yaml:"uri"`
+	MaxOpenConns int    `yaml:"max_open_conns"`
+	MaxIdleConns int    `yaml:"max_idle_conns"`
 }
 
 type WebConfig struct {
@@ -77,11 +103,18 @@ func makeDefaultConfig() Config {
 			ListenAddress: "localhost:29325",
 		},
 		Matrix: MatrixConfig{
-			DisableHTTP2: false,
+			DisableHTTP2:   false,
+			EnablePresence: false,
+			SlidingSync:    false,
 		},
 		Media: MediaConfig{
 			ThumbnailSize: 120,
 		},
+		Database: DatabaseConfig{
+			Type:         DatabaseTypeSQLite,
+			MaxOpenConns: 5,
+			MaxIdleConns: 1,
+		},
 		Logging: zeroconfig.Config{
 			MinLevel: ptr.Ptr(zerolog.DebugLevel),
 			Writers: []zeroconfig.WriterConfig{{
@@ -143,6 +176,14 @@ func (gmx *Gomuks) LoadConfig() error {
 		gmx.Config.Media.ThumbnailSize = 120
 		changed = true
 	}
+	if gmx.Config.Database.Type == "" {
+		gmx.Config.Database.Type = DatabaseTypeSQLite
+		changed = true
+	}
+	if gmx.Config.Database.MaxOpenConns <= 0 {
+		gmx.Config.Database.MaxOpenConns = 5
+		changed = true
+	}
 	if len(gmx.Config.Web.OriginPatterns) == 0 {
 		gmx.Config.Web.OriginPatterns = []string{"localhost:*", "*.localhost:*"}
 		changed = true
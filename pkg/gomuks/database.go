@@ -0,0 +1,152 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DatabaseTypeSQLite   = "sqlite3-fk-wal"
+	DatabaseTypePostgres = "postgres"
+)
+
+var ErrNotSQLite = errors.New("this operation is only supported with SQLite databases")
+
+func (dc *DatabaseConfig) IsPostgres() bool {
+	return dc.Type == DatabaseTypePostgres
+}
+
+// LoadDatabaseConfig loads only the database section of the config file without creating or modifying the file.
+// It's meant for command-line tools that access the databases directly instead of running gomuks.
+func (gmx *Gomuks) LoadDatabaseConfig() error {
+	cfg := makeDefaultConfig()
+	file, err := os.Open(filepath.Join(gmx.ConfigDir, "config.yaml"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else if file != nil {
+		defer file.Close()
+		err = yaml.NewDecoder(file).Decode(&cfg)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Database.Type == "" {
+		cfg.Database.Type = DatabaseTypeSQLite
+	}
+	gmx.Config.Database = cfg.Database
+	return nil
+}
+
+// accountDatabasePath returns the path of the SQLite database file of the given account.
+func (gmx *Gomuks) accountDatabasePath(account string) string {
+	return filepath.Join(gmx.accountDataDir(account), "gomuks.db")
+}
+
+// accountSchema returns the Postgres schema where the tables of the given account are stored.
+func accountSchema(account string) string {
+	if account == "" {
+		return "gomuks"
+	}
+	return "gomuks_" + account
+}
+
+// openDatabase opens the database of the given account using the configured database type.
+// For SQLite, the data directory of the account must already exist.
+func (gmx *Gomuks) openDatabase(ctx context.Context, account string, log zerolog.Logger) (*dbutil.Database, error) {
+	if gmx.Config.Database.IsPostgres() {
+		return openPostgresDatabase(ctx, gmx.Config.Database, accountSchema(account), log)
+	}
+	return openSQLiteDatabase(gmx.Config.Database, gmx.accountDatabasePath(account), log)
+}
+
+func openSQLiteDatabase(cfg DatabaseConfig, path string, log zerolog.Logger) (*dbutil.Database, error) {
+	return dbutil.NewFromConfig("gomuks", dbutil.Config{
+		PoolConfig: dbutil.PoolConfig{
+			Type:         cfg.Type,
+			URI:          fmt.Sprintf("file:%s?_txlock=immediate", path),
+			MaxOpenConns: cfg.MaxOpenConns,
+			MaxIdleConns: cfg.MaxIdleConns,
+		},
+	}, dbutil.ZeroLogger(log.With().Str("db_section", "main").Logger()))
+}
+
+// Version tables are checked with information_schema queries that don't filter by schema,
+// so they must always exist in the account schema to avoid finding the tables of other accounts.
+var postgresVersionTables = []string{"version", "crypto_version"}
+
+func openPostgresDatabase(ctx context.Context, cfg DatabaseConfig, schema string, log zerolog.Logger) (*dbutil.Database, error) {
+	uri := cfg.URI
+	if strings.Contains(uri, "://") {
+		var err error
+		uri, err = pq.ParseURL(uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse database URI: %w", err)
+		}
+	}
+	// Every connection of the pool uses the account schema, so the queries don't need to be aware of it.
+	uri = fmt.Sprintf("%s search_path='%s'", uri, pq.QuoteIdentifier(schema))
+	rawDB, err := dbutil.NewFromConfig("gomuks", dbutil.Config{
+		PoolConfig: dbutil.PoolConfig{
+			Type:         cfg.Type,
+			URI:          uri,
+			MaxOpenConns: cfg.MaxOpenConns,
+			MaxIdleConns: cfg.MaxIdleConns,
+		},
+	}, dbutil.ZeroLogger(log.With().Str("db_section", "main").Logger()))
+	if err != nil {
+		return nil, err
+	}
+	_, err = rawDB.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(schema))
+	if err != nil {
+		_ = rawDB.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	for _, table := range postgresVersionTables {
+		_, err = rawDB.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version INTEGER, compat INTEGER)", table))
+		if err != nil {
+			_ = rawDB.Close()
+			return nil, fmt.Errorf("failed to create %s table: %w", table, err)
+		}
+	}
+	return rawDB, nil
+}
+
+// dropAccountSchema deletes the Postgres schema of the given account along with all data in it.
+// It does nothing when using SQLite, as the database file is deleted with the data directory instead.
+func (gmx *Gomuks) dropAccountSchema(ctx context.Context, account string) error {
+	if !gmx.Config.Database.IsPostgres() {
+		return nil
+	}
+	rawDB, err := dbutil.NewWithDialect(gmx.Config.Database.URI, gmx.Config.Database.Type)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer rawDB.Close()
+	_, err = rawDB.Exec(ctx, "DROP SCHEMA IF EXISTS "+pq.QuoteIdentifier(accountSchema(account))+" CASCADE")
+	return err
+}
@@ -0,0 +1,474 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	exportManifestVersion = 1
+	exportManifestName    = "manifest.json"
+	exportConfigName      = "config.yaml"
+)
+
+var (
+	ErrNothingToExport           = errors.New("no logged in accounts found")
+	ErrUnsupportedExportVersion  = errors.New("unsupported export version")
+	ErrInvalidArchiveContents    = errors.New("invalid archive contents")
+	ErrImportDestinationConflict = errors.New("a different session already exists in the data directory")
+)
+
+type ExportManifest struct {
+	Version       int                `json:"version"`
+	GomuksVersion string             `json:"gomuks_version"`
+	CreatedAt     jsontime.UnixMilli `json:"created_at"`
+	Accounts      []*ExportedAccount `json:"accounts"`
+}
+
+type ExportedAccount struct {
+	// Account is the name of the account, or empty for the default account.
+	Account  string      `json:"account"`
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id"`
+}
+
+func (ea *ExportedAccount) archivePath() string {
+	if ea.Account == "" {
+		return "data/gomuks.db"
+	}
+	return path.Join("data/accounts", ea.Account, "gomuks.db")
+}
+
+type sessionInfo struct {
+	Account        *database.Account
+	CryptoDeviceID id.DeviceID
+}
+
+// readSessionInfo reads the account and crypto store device ID from a database file that isn't in use.
+// If the database doesn't have a logged in account, nil is returned.
+func readSessionInfo(ctx context.Context, dbPath string) (*sessionInfo, error) {
+	rawDB, err := dbutil.NewWithDialect(fmt.Sprintf("file:%s", dbPath), "sqlite3")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer rawDB.Close()
+	db := database.New(rawDB)
+	userID, err := db.Account.GetFirstUserID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user ID: %w", err)
+	} else if userID == "" {
+		return nil, nil
+	}
+	var info sessionInfo
+	info.Account, err = db.Account.Get(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	if exists, err := rawDB.TableExists(ctx, "crypto_account"); err != nil {
+		return nil, fmt.Errorf("failed to check if crypto store exists: %w", err)
+	} else if exists {
+		err = rawDB.QueryRow(ctx, "SELECT device_id FROM crypto_account WHERE account_id=$1", userID).Scan(&info.CryptoDeviceID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get crypto store device ID: %w", err)
+		}
+	}
+	return &info, nil
+}
+
+// snapshotDatabase copies the source database to the destination path using the SQLite backup API.
+// The whole database is copied in a single step, so the snapshot is consistent even if the source is in use.
+func snapshotDatabase(ctx context.Context, srcPath, dstPath string) error {
+	srcDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", srcPath))
+	if err != nil {
+		return fmt.Errorf("failed to open source database: %w", err)
+	}
+	defer srcDB.Close()
+	dstDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", dstPath))
+	if err != nil {
+		return fmt.Errorf("failed to open destination database: %w", err)
+	}
+	defer dstDB.Close()
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to source database: %w", err)
+	}
+	defer srcConn.Close()
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to destination database: %w", err)
+	}
+	defer dstConn.Close()
+	return dstConn.Raw(func(dstRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			backup, err := dstRaw.(*sqlite3.SQLiteConn).Backup("main", srcRaw.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+			defer backup.Close()
+			_, err = backup.Step(-1)
+			if err != nil {
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// findAccountNames returns the names of all accounts that have a database in the data directory,
+// starting with the default account.
+func (gmx *Gomuks) findAccountNames() ([]string, error) {
+	names := []string{""}
+	entries, err := os.ReadDir(filepath.Join(gmx.DataDir, "accounts"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read accounts directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && accountNameRegex.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, data io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", name, err)
+	}
+	_, err = io.Copy(tw, data)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func writeTarFileFromDisk(tw *tar.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, name, stat.Size(), file)
+}
+
+// Export writes an encrypted archive containing snapshots of the databases of all logged in accounts
+// (including their crypto stores) and the config file to the given path. Export is safe to run while
+// gomuks is running, as the snapshots are taken using the SQLite backup API.
+func (gmx *Gomuks) Export(ctx context.Context, archivePath, passphrase string) (*ExportManifest, error) {
+	if gmx.Config.Database.IsPostgres() {
+		return nil, ErrNotSQLite
+	}
+	names, err := gmx.findAccountNames()
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := os.MkdirTemp(gmx.TempDir, "export-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manifest := &ExportManifest{
+		Version:       exportManifestVersion,
+		GomuksVersion: gmx.Version,
+		CreatedAt:     jsontime.UnixMilliNow(),
+	}
+	snapshots := make(map[*ExportedAccount]string)
+	for i, name := range names {
+		srcPath := filepath.Join(gmx.accountDataDir(name), "gomuks.db")
+		if _, err = os.Stat(srcPath); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		snapshotPath := filepath.Join(tempDir, fmt.Sprintf("%d.db", i))
+		err = snapshotDatabase(ctx, srcPath, snapshotPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot database of %q: %w", name, err)
+		}
+		info, err := readSessionInfo(ctx, snapshotPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session of %q: %w", name, err)
+		} else if info == nil {
+			continue
+		}
+		acc := &ExportedAccount{
+			Account:  name,
+			UserID:   info.Account.UserID,
+			DeviceID: info.Account.DeviceID,
+		}
+		manifest.Accounts = append(manifest.Accounts, acc)
+		snapshots[acc] = snapshotPath
+	}
+	if len(manifest.Accounts) == 0 {
+		return nil, ErrNothingToExport
+	}
+
+	file, err := os.OpenFile(archivePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+	err = gmx.writeExportArchive(file, passphrase, manifest, snapshots)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close archive: %w", closeErr)
+	}
+	if err != nil {
+		_ = os.Remove(archivePath)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (gmx *Gomuks) writeExportArchive(w io.Writer, passphrase string, manifest *ExportManifest, snapshots map[*ExportedAccount]string) error {
+	aw, err := newArchiveWriter(w, passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to initialize encryption: %w", err)
+	}
+	zw := gzip.NewWriter(aw)
+	tw := tar.NewWriter(zw)
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	err = writeTarFile(tw, exportManifestName, int64(len(manifestData)), bytes.NewReader(manifestData))
+	if err != nil {
+		return err
+	}
+	err = writeTarFileFromDisk(tw, exportConfigName, filepath.Join(gmx.ConfigDir, "config.yaml"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to add config: %w", err)
+	}
+	for _, acc := range manifest.Accounts {
+		err = writeTarFileFromDisk(tw, acc.archivePath(), snapshots[acc])
+		if err != nil {
+			return fmt.Errorf("failed to add database of %q: %w", acc.Account, err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar: %w", err)
+	} else if err = zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish gzip: %w", err)
+	} else if err = aw.Close(); err != nil {
+		return fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	return nil
+}
+
+// Import restores an archive created by Export into the data directory. The sessions in the archive are
+// verified before anything is replaced: the device ID in each database must match the manifest and the
+// crypto store, and existing databases may only be replaced if they belong to the same session.
+// The config file is only restored if there isn't one already. gomuks must not be running during import.
+func (gmx *Gomuks) Import(ctx context.Context, archivePath, passphrase string) (*ExportManifest, error) {
+	if gmx.Config.Database.IsPostgres() {
+		return nil, ErrNotSQLite
+	}
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+	ar, err := newArchiveReader(file, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// The temporary directory is in the data directory, so that the databases can be renamed into place
+	tempDir, err := os.MkdirTemp(gmx.DataDir, ".import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	manifest, configPath, err := extractExportArchive(ar, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, acc := range manifest.Accounts {
+		err = verifyImportedSession(ctx, acc, filepath.Join(tempDir, filepath.FromSlash(acc.archivePath())))
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify session of %q: %w", acc.Account, err)
+		}
+		existingPath := filepath.Join(gmx.accountDataDir(acc.Account), "gomuks.db")
+		if _, err = os.Stat(existingPath); errors.Is(err, os.ErrNotExist) {
+			// Opening the database would create it (and fail if the data dir doesn't exist yet)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to check existing database of %q: %w", acc.Account, err)
+		}
+		existing, err := readSessionInfo(ctx, existingPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read existing session of %q: %w", acc.Account, err)
+		} else if existing != nil && (existing.Account.UserID != acc.UserID || existing.Account.DeviceID != acc.DeviceID) {
+			return nil, fmt.Errorf(
+				"%w: %q is logged in as %s/%s, archive contains %s/%s",
+				ErrImportDestinationConflict, acc.Account,
+				existing.Account.UserID, existing.Account.DeviceID, acc.UserID, acc.DeviceID,
+			)
+		}
+	}
+
+	for _, acc := range manifest.Accounts {
+		dataDir := gmx.accountDataDir(acc.Account)
+		err = os.MkdirAll(dataDir, 0700)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+		dstPath := filepath.Join(dataDir, "gomuks.db")
+		for _, suffix := range []string{"-wal", "-shm"} {
+			err = os.Remove(dstPath + suffix)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("failed to remove old %s file of %q: %w", suffix, acc.Account, err)
+			}
+		}
+		err = os.Rename(filepath.Join(tempDir, filepath.FromSlash(acc.archivePath())), dstPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to move database of %q into place: %w", acc.Account, err)
+		}
+	}
+	if configPath != "" {
+		dstConfigPath := filepath.Join(gmx.ConfigDir, "config.yaml")
+		if _, err = os.Stat(dstConfigPath); errors.Is(err, os.ErrNotExist) {
+			err = os.Rename(configPath, dstConfigPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore config: %w", err)
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// verifyImportedSession checks that the database extracted from an archive contains the session
+// listed in the manifest, and that the crypto store belongs to the same device.
+func verifyImportedSession(ctx context.Context, acc *ExportedAccount, dbPath string) error {
+	info, err := readSessionInfo(ctx, dbPath)
+	if err != nil {
+		return err
+	} else if info == nil {
+		return fmt.Errorf("%w: database doesn't contain an account", ErrInvalidArchiveContents)
+	} else if info.Account.UserID != acc.UserID {
+		return fmt.Errorf("user ID mismatch: expected %s, got %s", acc.UserID, info.Account.UserID)
+	} else if info.Account.DeviceID != acc.DeviceID {
+		return fmt.Errorf("device ID mismatch: expected %s, got %s", acc.DeviceID, info.Account.DeviceID)
+	} else if info.CryptoDeviceID != acc.DeviceID {
+		return fmt.Errorf("crypto store device ID mismatch: expected %s, got %s", acc.DeviceID, info.CryptoDeviceID)
+	}
+	return nil
+}
+
+// extractExportArchive extracts the decrypted archive into the given directory. The manifest must be the first
+// file in the archive, and only files listed in it (plus the config) are accepted. The returned config path is
+// empty if the archive doesn't contain a config file.
+func extractExportArchive(r io.Reader, dir string) (manifest *ExportManifest, configPath string, err error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrInvalidArchiveContents, err)
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read manifest: %w", err)
+	} else if hdr.Name != exportManifestName {
+		return nil, "", fmt.Errorf("%w: first file is not the manifest", ErrInvalidArchiveContents)
+	}
+	err = json.NewDecoder(tr).Decode(&manifest)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to parse manifest: %w", ErrInvalidArchiveContents, err)
+	} else if manifest.Version != exportManifestVersion {
+		return nil, "", fmt.Errorf("%w %d", ErrUnsupportedExportVersion, manifest.Version)
+	}
+	expectedFiles := map[string]bool{exportConfigName: false}
+	for _, acc := range manifest.Accounts {
+		if acc.Account != "" && !accountNameRegex.MatchString(acc.Account) {
+			return nil, "", fmt.Errorf("%w: %w %q", ErrInvalidArchiveContents, ErrInvalidAccountName, acc.Account)
+		}
+		expectedFiles[acc.archivePath()] = false
+	}
+	for {
+		hdr, err = tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, "", fmt.Errorf("failed to read archive: %w", err)
+		}
+		alreadyExtracted, ok := expectedFiles[hdr.Name]
+		if !ok || alreadyExtracted || hdr.Typeflag != tar.TypeReg {
+			return nil, "", fmt.Errorf("%w: unexpected file %q", ErrInvalidArchiveContents, hdr.Name)
+		}
+		expectedFiles[hdr.Name] = true
+		filePath := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		err = extractTarFile(tr, filePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+		}
+		if hdr.Name == exportConfigName {
+			configPath = filePath
+		}
+	}
+	// Read the rest of the stream to verify the gzip checksum and make sure the archive isn't truncated
+	_, err = io.Copy(io.Discard, zr)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	for name, extracted := range expectedFiles {
+		if !extracted && name != exportConfigName {
+			return nil, "", fmt.Errorf("%w: missing %s", ErrInvalidArchiveContents, name)
+		}
+	}
+	return manifest, configPath, nil
+}
+
+func extractTarFile(r io.Reader, filePath string) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+var ErrSchemaVersionMismatch = errors.New("database schema version doesn't match this version of gomuks")
+
+type FsckResult struct {
+	// Account is the name of the account, or empty for the default account.
+	Account    string                   `json:"account"`
+	UserID     id.UserID                `json:"user_id"`
+	Mismatches []*database.FsckMismatch `json:"mismatches"`
+}
+
+// Fsck checks the derived state in the databases of all logged in accounts (see [database.Database.Fsck])
+// and optionally fixes it. gomuks must not be running at the same time.
+func (gmx *Gomuks) Fsck(ctx context.Context, fix bool) ([]*FsckResult, error) {
+	names, err := gmx.findAccountNames()
+	if err != nil {
+		return nil, err
+	}
+	var results []*FsckResult
+	for _, name := range names {
+		if !gmx.Config.Database.IsPostgres() {
+			if _, err = os.Stat(gmx.accountDatabasePath(name)); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		result, err := gmx.fsckDatabase(ctx, name, fix)
+		if err != nil {
+			return results, fmt.Errorf("failed to check database of %q: %w", name, err)
+		} else if result != nil {
+			result.Account = name
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (gmx *Gomuks) fsckDatabase(ctx context.Context, account string, fix bool) (*FsckResult, error) {
+	rawDB, err := gmx.openDatabase(ctx, account, zerolog.Nop())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer rawDB.Close()
+	db := database.New(rawDB)
+	if fix {
+		err = db.Upgrade(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade database: %w", err)
+		}
+	} else if err = checkSchemaVersion(ctx, db); err != nil {
+		return nil, err
+	}
+	userID, err := db.Account.GetFirstUserID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user ID: %w", err)
+	} else if userID == "" {
+		return nil, nil
+	}
+	mismatches, err := db.Fsck(ctx, userID, fix)
+	if err != nil {
+		return nil, err
+	}
+	return &FsckResult{UserID: userID, Mismatches: mismatches}, nil
+}
+
+// checkSchemaVersion makes sure the checks are running against the schema they were written for
+// without upgrading the database, as checking must not modify anything.
+func checkSchemaVersion(ctx context.Context, db *database.Database) error {
+	var version int
+	if exists, err := db.TableExists(ctx, db.VersionTable); err != nil {
+		return fmt.Errorf("failed to check if version table exists: %w", err)
+	} else if exists {
+		err = db.QueryRow(ctx, fmt.Sprintf("SELECT version FROM %s LIMIT 1", db.VersionTable)).Scan(&version)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get schema version: %w", err)
+		}
+	}
+	if latest := len(db.UpgradeTable); version != latest {
+		return fmt.Errorf("%w: found v%d, expected v%d (use --fix to upgrade the database)", ErrSchemaVersionMismatch, version, latest)
+	}
+	return nil
+}
@@ -31,23 +31,25 @@ import (
 
 	"github.com/coder/websocket"
 	"github.com/rs/zerolog"
-	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/exerrors"
 	"go.mau.fi/util/exzerolog"
-	"go.mau.fi/util/ptr"
-	"golang.org/x/net/http2"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/gomuks/pkg/hicli"
-	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
 )
 
 type Gomuks struct {
 	Log    *zerolog.Logger
 	Server *http.Server
+	// Client is the default account. Additional accounts are stored in the accounts map.
 	Client *hicli.HiClient
 
+	accounts     map[string]*hicli.HiClient
+	accountsLock sync.RWMutex
+	// startingAccounts contains the names of accounts that are being started, but aren't in the accounts map yet.
+	startingAccounts map[string]struct{}
+
 	Version          string
 	Commit           string
 	LinkifiedVersion string
@@ -80,6 +82,9 @@ type Gomuks struct {
 func NewGomuks() *Gomuks {
 	return &Gomuks{
 		stopChan: make(chan struct{}),
+		accounts: make(map[string]*hicli.HiClient),
+
+		startingAccounts: make(map[string]struct{}),
 
 		temporaryMXCToPermanent:         map[id.ContentURIString]id.ContentURIString{},
 		temporaryMXCToEncryptedFileInfo: map[id.ContentURIString]*event.EncryptedFileInfo{},
@@ -165,56 +170,21 @@ func (gmx *Gomuks) SetupLog() {
 
 func (gmx *Gomuks) StartClient() {
 	hicli.HTMLSanitizerImgSrcTemplate = "_gomuks/media/%s/%s?encrypted=false"
-	rawDB, err := dbutil.NewFromConfig("gomuks", dbutil.Config{
-		PoolConfig: dbutil.PoolConfig{
-			Type:         "sqlite3-fk-wal",
-			URI:          fmt.Sprintf("file:%s/gomuks.db?_txlock=immediate", gmx.DataDir),
-			MaxOpenConns: 5,
-			MaxIdleConns: 1,
-		},
-	}, dbutil.ZeroLogger(gmx.Log.With().Str("component", "hicli").Str("db_section", "main").Logger()))
+	var err error
+	gmx.Client, err = gmx.openClient("")
 	if err != nil {
-		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to open database")
+		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to initialize client")
 		os.Exit(10)
 	}
-	ctx := gmx.Log.WithContext(context.Background())
-	gmx.Client = hicli.New(
-		rawDB,
-		nil,
-		gmx.Log.With().Str("component", "hicli").Logger(),
-		[]byte("meow"),
-		gmx.HandleEvent,
-	)
-	gmx.Client.LogoutFunc = gmx.Logout
-	httpClient := gmx.Client.Client.Client
-	httpClient.Transport.(*http.Transport).ForceAttemptHTTP2 = false
-	if !gmx.Config.Matrix.DisableHTTP2 {
-		h2, err := http2.ConfigureTransports(httpClient.Transport.(*http.Transport))
-		if err != nil {
-			gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to configure HTTP/2")
-			os.Exit(13)
-		}
-		h2.ReadIdleTimeout = 30 * time.Second
-	}
-	userID, err := gmx.Client.DB.Account.GetFirstUserID(ctx)
-	if err != nil {
-		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to get first user ID")
-		os.Exit(11)
-	}
-	err = gmx.Client.Start(ctx, userID, nil)
+	err = startClient(gmx.Log.WithContext(context.Background()), gmx.Client)
 	if err != nil {
 		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to start client")
 		os.Exit(12)
 	}
-	gmx.Log.Info().Stringer("user_id", userID).Msg("Client started")
 }
 
 func (gmx *Gomuks) HandleEvent(evt any) {
-	gmx.EventBuffer.Push(evt)
-	syncComplete, ok := evt.(*jsoncmd.SyncComplete)
-	if ok && ptr.Val(syncComplete.Since) != "" {
-		go gmx.SendPushNotifications(syncComplete)
-	}
+	gmx.HandleAccountEvent("", evt)
 }
 
 func (gmx *Gomuks) Stop() {
@@ -236,6 +206,7 @@ func (gmx *Gomuks) DirectStop() {
 	for _, closer := range gmx.EventBuffer.GetClosers() {
 		closer(websocket.StatusServiceRestart, "Server shutting down")
 	}
+	gmx.stopExtraAccounts()
 	gmx.Client.Stop()
 	if gmx.Server != nil {
 		err := gmx.Server.Close()
@@ -260,6 +231,8 @@ func (gmx *Gomuks) Run() {
 		Msg("Initializing gomuks")
 	gmx.StartServer()
 	gmx.StartClient()
+	gmx.StartExtraAccounts()
+	go gmx.RunMediaCacheEvictor()
 	gmx.Log.Info().Msg("Initialization complete")
 	gmx.WaitForInterrupt()
 	gmx.Log.Info().Msg("Shutting down...")
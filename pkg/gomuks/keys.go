@@ -53,14 +53,18 @@ func (gmx *Gomuks) ExportKeys(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("Failed to parse form data\n"))
 		return
 	}
+	cli := gmx.getRequestClient(w, r)
+	if cli == nil {
+		return
+	}
 	roomID := id.RoomID(r.PathValue("room_id"))
 	var sessions dbutil.RowIter[*crypto.InboundGroupSession]
 	filename := "gomuks-keys.txt"
 	if roomID == "" {
-		sessions = gmx.Client.CryptoStore.GetAllGroupSessions(r.Context())
+		sessions = cli.CryptoStore.GetAllGroupSessions(r.Context())
 	} else {
 		filename = fmt.Sprintf("gomuks-keys-%s.txt", roomID)
-		sessions = gmx.Client.CryptoStore.GetGroupSessionsForRoom(r.Context(), roomID)
+		sessions = cli.CryptoStore.GetGroupSessionsForRoom(r.Context(), roomID)
 	}
 	export, err := crypto.ExportKeysIter(r.FormValue("passphrase"), sessions)
 	if errors.Is(err, crypto.ErrNoSessionsForExport) {
@@ -82,6 +86,10 @@ func (gmx *Gomuks) ExportKeys(w http.ResponseWriter, r *http.Request) {
 var badMultipartForm = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.BAD_FORM_DATA", Err: "Failed to parse form data", StatusCode: http.StatusBadRequest}
 
 func (gmx *Gomuks) ImportKeys(w http.ResponseWriter, r *http.Request) {
+	cli := gmx.getRequestClient(w, r)
+	if cli == nil {
+		return
+	}
 	err := r.ParseMultipartForm(5 * 1024 * 1024)
 	if err != nil {
 		badMultipartForm.Write(w)
@@ -97,7 +105,7 @@ func (gmx *Gomuks) ImportKeys(w http.ResponseWriter, r *http.Request) {
 		badMultipartForm.WithMessage("Failed to read export file: %w", err).Write(w)
 		return
 	}
-	importedCount, totalCount, err := gmx.Client.Crypto.ImportKeys(r.Context(), r.FormValue("passphrase"), exportData)
+	importedCount, totalCount, err := cli.Crypto.ImportKeys(r.Context(), r.FormValue("passphrase"), exportData)
 	if err != nil {
 		hlog.FromRequest(r).Err(err).Msg("Failed to import keys")
 		mautrix.MUnknown.WithMessage("Failed to import keys: %w", err).Write(w)
@@ -114,6 +122,10 @@ func (gmx *Gomuks) ImportKeys(w http.ResponseWriter, r *http.Request) {
 }
 
 func (gmx *Gomuks) RestoreKeyBackup(w http.ResponseWriter, r *http.Request) {
+	cli := gmx.getRequestClient(w, r)
+	if cli == nil {
+		return
+	}
 	roomID := id.RoomID(r.PathValue("room_id"))
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -133,7 +145,7 @@ func (gmx *Gomuks) RestoreKeyBackup(w http.ResponseWriter, r *http.Request) {
 			f.Flush()
 		}
 	}
-	err := gmx.Client.RestoreKeyBackup(r.Context(), roomID, sendProgress)
+	err := cli.RestoreKeyBackup(r.Context(), roomID, sendProgress)
 	if err != nil {
 		_, _ = fmt.Fprintf(w, "event: done\ndata: %s\n\n", err.Error())
 	} else {
@@ -19,15 +19,30 @@ package gomuks
 import (
 	"context"
 	"errors"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
 )
 
 func (gmx *Gomuks) Logout(ctx context.Context) error {
 	log := zerolog.Ctx(ctx)
+	gmx.accountsLock.RLock()
+	otherClients := slices.Collect(maps.Values(gmx.accounts))
+	gmx.accountsLock.RUnlock()
+	var cachedMedia []*database.Media
+	if len(otherClients) > 0 {
+		var err error
+		cachedMedia, err = gmx.Client.DB.Media.GetCached(ctx, "")
+		if err != nil {
+			log.Err(err).Msg("Failed to get cached media, cache files won't be removed")
+		}
+	}
 	log.Info().Msg("Stopping client and logging out")
 	gmx.Client.Stop()
 	_, err := gmx.Client.Client.Logout(ctx)
@@ -36,11 +51,18 @@ func (gmx *Gomuks) Logout(ctx context.Context) error {
 		return err
 	}
 	log.Info().Msg("Logout complete, removing data")
-	err = os.RemoveAll(gmx.CacheDir)
-	if err != nil {
-		log.Err(err).Str("cache_dir", gmx.CacheDir).Msg("Failed to remove cache dir")
+	if len(otherClients) == 0 {
+		err = os.RemoveAll(gmx.CacheDir)
+		if err != nil {
+			log.Err(err).Str("cache_dir", gmx.CacheDir).Msg("Failed to remove cache dir")
+		}
+	} else {
+		// The cache dir is shared with other accounts, so only remove files that they don't use
+		res := gmx.purgeAccountMediaCache(ctx, cachedMedia, otherClients)
+		log.Debug().Int("freed_files", res.FreedFiles).Int64("freed_bytes", res.FreedBytes).Msg("Removed unused cache files")
 	}
-	if gmx.DataDir == gmx.ConfigDir {
+	// Only delete the database if the data dir is shared with other things (config or other accounts)
+	if gmx.DataDir == gmx.ConfigDir || len(otherClients) > 0 {
 		err = os.Remove(filepath.Join(gmx.DataDir, "gomuks.db"))
 		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			log.Err(err).Str("data_dir", gmx.DataDir).Msg("Failed to remove database")
@@ -53,6 +75,10 @@ func (gmx *Gomuks) Logout(ctx context.Context) error {
 			log.Err(err).Str("data_dir", gmx.DataDir).Msg("Failed to remove data dir")
 		}
 	}
+	err = gmx.dropAccountSchema(ctx, "")
+	if err != nil {
+		log.Err(err).Msg("Failed to remove database schema")
+	}
 	log.Info().Msg("Re-initializing directories")
 	gmx.InitDirectories()
 	log.Info().Msg("Restarting client")
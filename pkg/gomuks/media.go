@@ -60,6 +60,7 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/gomuks/pkg/hicli"
 	"go.mau.fi/gomuks/pkg/hicli/database"
 	"go.mau.fi/gomuks/pkg/orientation"
 )
@@ -69,7 +70,7 @@ var ErrBadGateway = mautrix.RespError{
 	StatusCode: http.StatusBadGateway,
 }
 
-func (gmx *Gomuks) downloadMediaFromCache(ctx context.Context, w http.ResponseWriter, r *http.Request, entry *database.Media, force, useThumbnail bool) bool {
+func (gmx *Gomuks) downloadMediaFromCache(ctx context.Context, cli *hicli.HiClient, w http.ResponseWriter, r *http.Request, entry *database.Media, force, useThumbnail bool) bool {
 	if !entry.UseCache() {
 		if force {
 			mautrix.MNotFound.WithMessage("Media not found in cache").Write(w)
@@ -103,11 +104,11 @@ func (gmx *Gomuks) downloadMediaFromCache(ctx context.Context, w http.ResponseWr
 				return false
 			} else if err != nil {
 				log.Err(err).Msg("Failed to generate avatar thumbnail")
-				gmx.saveMediaCacheEntryWithThumbnail(ctx, entry, err)
+				gmx.saveMediaCacheEntryWithThumbnail(ctx, cli, entry, err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return true
 			} else {
-				gmx.saveMediaCacheEntryWithThumbnail(ctx, entry, nil)
+				gmx.saveMediaCacheEntryWithThumbnail(ctx, cli, entry, nil)
 			}
 		}
 		hash = entry.ThumbnailHash
@@ -119,11 +120,11 @@ func (gmx *Gomuks) downloadMediaFromCache(ctx context.Context, w http.ResponseWr
 			return false
 		} else if err != nil {
 			log.Err(err).Msg("Failed to generate avatar thumbnail")
-			gmx.saveMediaCacheEntryWithThumbnail(ctx, entry, err)
+			gmx.saveMediaCacheEntryWithThumbnail(ctx, cli, entry, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return true
 		} else {
-			gmx.saveMediaCacheEntryWithThumbnail(ctx, entry, nil)
+			gmx.saveMediaCacheEntryWithThumbnail(ctx, cli, entry, nil)
 			cacheFile, err = os.Open(gmx.cacheEntryToPath(hash[:]))
 		}
 	}
@@ -138,6 +139,7 @@ func (gmx *Gomuks) downloadMediaFromCache(ctx context.Context, w http.ResponseWr
 	defer func() {
 		_ = cacheFile.Close()
 	}()
+	gmx.touchMediaCacheEntry(ctx, cli, entry)
 	cacheEntryToHeaders(w, entry, useThumbnail)
 	w.WriteHeader(http.StatusOK)
 	_, err = io.Copy(w, cacheFile)
@@ -167,14 +169,14 @@ func cacheEntryToHeaders(w http.ResponseWriter, entry *database.Media, thumbnail
 	w.Header().Set("ETag", entry.ETag(thumbnail))
 }
 
-func (gmx *Gomuks) saveMediaCacheEntryWithThumbnail(ctx context.Context, entry *database.Media, err error) {
+func (gmx *Gomuks) saveMediaCacheEntryWithThumbnail(ctx context.Context, cli *hicli.HiClient, entry *database.Media, err error) {
 	if errors.Is(err, os.ErrNotExist) {
 		return
 	}
 	if err != nil {
 		entry.ThumbnailError = err.Error()
 	}
-	err = gmx.Client.DB.Media.Put(ctx, entry)
+	err = cli.DB.Media.Put(ctx, entry)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to save cache entry after generating thumbnail")
 	}
@@ -307,6 +309,10 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		mautrix.MInvalidParam.WithMessage("Invalid mxc URI").Write(w)
 		return
 	}
+	cli := gmx.getRequestClient(w, r)
+	if cli == nil {
+		return
+	}
 	query := r.URL.Query()
 	fallback := query.Get("fallback")
 	if fallback != "" {
@@ -329,7 +335,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		Logger()
 	log := &logVal
 	ctx := log.WithContext(r.Context())
-	cacheEntry, err := gmx.Client.DB.Media.Get(ctx, mxc)
+	cacheEntry, err := cli.DB.Media.Get(ctx, mxc)
 	if err != nil {
 		log.Err(err).Msg("Failed to get cached media entry")
 		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to get cached media entry: %v", err)).Write(w)
@@ -342,7 +348,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if gmx.downloadMediaFromCache(ctx, w, r, cacheEntry, false, useThumbnail) {
+	if gmx.downloadMediaFromCache(ctx, cli, w, r, cacheEntry, false, useThumbnail) {
 		return
 	}
 
@@ -357,7 +363,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		_ = os.Remove(tempFile.Name())
 	}()
 
-	resp, err := gmx.Client.Client.Download(mautrix.WithMaxRetries(ctx, 0), mxc)
+	resp, err := cli.Client.Download(mautrix.WithMaxRetries(ctx, 0), mxc)
 	if err != nil {
 		if ctx.Err() != nil {
 			w.WriteHeader(499)
@@ -394,7 +400,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 			cacheEntry.Error.Matrix = ptr.Ptr(ErrBadGateway.WithMessage(err.Error()))
 			cacheEntry.Error.StatusCode = http.StatusBadGateway
 		}
-		err = gmx.Client.DB.Media.Put(ctx, cacheEntry)
+		err = cli.DB.Media.Put(ctx, cacheEntry)
 		if err != nil {
 			log.Err(err).Msg("Failed to save errored cache entry")
 		}
@@ -466,7 +472,8 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 	_ = tempFile.Close()
 	cacheEntry.Hash = (*[32]byte)(fileHasher.Sum(nil))
 	cacheEntry.Error = nil
-	err = gmx.Client.DB.Media.Put(ctx, cacheEntry)
+	cacheEntry.LastAccessed = jsontime.UnixMilliNow()
+	err = cli.DB.Media.Put(ctx, cacheEntry)
 	if err != nil {
 		log.Err(err).Msg("Failed to save cache entry")
 		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to save cache entry: %v", err)).Write(w)
@@ -486,7 +493,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if w != nil {
-		gmx.downloadMediaFromCache(ctx, w, r, cacheEntry, true, useThumbnail)
+		gmx.downloadMediaFromCache(ctx, cli, w, r, cacheEntry, true, useThumbnail)
 	}
 }
 
@@ -495,7 +502,10 @@ func (gmx *Gomuks) reencodeMedia(ctx context.Context, query url.Values, tempFile
 		_ = tempFile.Close()
 	}()
 	encTo := query.Get("encode_to")
-	if encTo == "" {
+	isVoice, _ := strconv.ParseBool(query.Get("voice"))
+	if isVoice && encTo == "" {
+		encTo = "audio/ogg"
+	} else if encTo == "" {
 		return nil, nil
 	}
 	resizeWidthVal := query.Get("resize_width")
@@ -576,7 +586,7 @@ func (gmx *Gomuks) reencodeMedia(ctx context.Context, query url.Values, tempFile
 		if err != nil {
 			return nil, fmt.Errorf("failed to seek to start of temp file: %w", err)
 		}
-	case "video/webm", "video/mp4", "image/webp+anim":
+	case "video/webm", "video/mp4", "image/webp+anim", "audio/ogg":
 		_ = tempFile.Close()
 		var encToExtension string
 		var inputArgs, outputArgs []string
@@ -590,6 +600,13 @@ func (gmx *Gomuks) reencodeMedia(ctx context.Context, query url.Values, tempFile
 		case "image/webp+anim":
 			encToExtension = ".webp"
 			outputArgs = []string{"-c:v", "libwebp_anim", "-pix_fmt", "yuva420p", "-loop", "0"}
+		case "audio/ogg":
+			encToExtension = ".ogg"
+			if isVoice {
+				outputArgs = voiceEncodeArgs
+			} else {
+				outputArgs = []string{"-vn", "-c:a", "libopus"}
+			}
 		default:
 			panic("unreachable")
 		}
@@ -622,6 +639,10 @@ func (gmx *Gomuks) reencodeMedia(ctx context.Context, query url.Values, tempFile
 
 func (gmx *Gomuks) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	log := hlog.FromRequest(r)
+	cli := gmx.getRequestClient(w, r)
+	if cli == nil {
+		return
+	}
 	encrypt, _ := strconv.ParseBool(r.URL.Query().Get("encrypt"))
 	progress, _ := strconv.ParseBool(r.URL.Query().Get("progress"))
 	var respEnc *json.Encoder
@@ -650,7 +671,7 @@ func (gmx *Gomuks) UploadMedia(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	content, err := gmx.cacheAndUploadMedia(r.Context(), r.Body, encrypt, r.URL.Query(), progressCallback)
+	content, err := gmx.cacheAndUploadMedia(r.Context(), cli, r.Body, encrypt, r.URL.Query(), progressCallback)
 	if err != nil {
 		log.Err(err).Msg("Failed to upload media")
 		if respEnc != nil {
@@ -674,7 +695,11 @@ func (gmx *Gomuks) GetURLPreview(w http.ResponseWriter, r *http.Request) {
 		mautrix.MInvalidParam.WithMessage("URL must be provided to preview").Write(w)
 		return
 	}
-	linkPreview, err := gmx.Client.Client.GetURLPreview(r.Context(), url)
+	cli := gmx.getRequestClient(w, r)
+	if cli == nil {
+		return
+	}
+	linkPreview, err := cli.Client.GetURLPreview(r.Context(), url)
 	if err != nil {
 		log.Err(err).Msg("Failed to get URL preview")
 		writeMaybeRespError(err, w)
@@ -705,7 +730,7 @@ func (gmx *Gomuks) GetURLPreview(w http.ResponseWriter, r *http.Request) {
 		if content == nil && (err != nil || parsedImageURL.IsEmpty()) {
 			log.Warn().Err(err).Str("image_url", string(preview.ImageURL)).Msg("Failed to parse URL preview image mxc")
 		} else if content == nil && !parsedImageURL.IsEmpty() {
-			resp, err := gmx.Client.Client.Download(r.Context(), parsedImageURL)
+			resp, err := cli.Client.Download(r.Context(), parsedImageURL)
 			if err != nil {
 				log.Err(err).Msg("Failed to download URL preview image")
 				writeMaybeRespError(err, w)
@@ -713,7 +738,7 @@ func (gmx *Gomuks) GetURLPreview(w http.ResponseWriter, r *http.Request) {
 			}
 			defer resp.Body.Close()
 
-			content, err = gmx.cacheAndUploadMedia(r.Context(), resp.Body, encrypt, nil, nil)
+			content, err = gmx.cacheAndUploadMedia(r.Context(), cli, resp.Body, encrypt, nil, nil)
 			if err != nil {
 				log.Err(err).Msg("Failed to upload URL preview image")
 				writeMaybeRespError(err, w)
@@ -738,6 +763,7 @@ func (gmx *Gomuks) GetURLPreview(w http.ResponseWriter, r *http.Request) {
 
 func (gmx *Gomuks) cacheAndUploadMedia(
 	ctx context.Context,
+	cli *hicli.HiClient,
 	reader io.Reader,
 	encrypt bool,
 	query url.Values,
@@ -791,11 +817,17 @@ func (gmx *Gomuks) cacheAndUploadMedia(
 		return nil, fmt.Errorf("failed to generate file info: %w", err)
 	}
 	if msgType == event.MsgVideo {
-		err = gmx.generateVideoThumbnail(ctx, cacheFile.Name(), encrypt, info)
+		err = gmx.generateVideoThumbnail(ctx, cli, cacheFile.Name(), encrypt, info)
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to generate video thumbnail")
 		}
 	}
+	isVoice, _ := strconv.ParseBool(query.Get("voice"))
+	if isVoice && msgType != event.MsgAudio {
+		return nil, fmt.Errorf("voice message must be audio, got %s", info.MimeType)
+	} else if isVoice {
+		defaultFileName = "Voice message" + filepath.Ext(defaultFileName)
+	}
 	fileName := query.Get("filename")
 	if fileName == "" {
 		fileName = defaultFileName
@@ -806,8 +838,23 @@ func (gmx *Gomuks) cacheAndUploadMedia(
 		Info:     info,
 		FileName: fileName,
 	}
+	if isVoice {
+		// The voice fields are next to the file info in the content rather than inside it.
+		audioInfo, err := gmx.generateVoiceInfo(ctx, cacheFile.Name())
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to generate voice message waveform")
+			audioInfo = &event.MSC1767Audio{Waveform: []int{}}
+		}
+		if info.Duration == 0 {
+			info.Duration = audioInfo.Duration
+		} else {
+			audioInfo.Duration = info.Duration
+		}
+		content.MSC1767Audio = audioInfo
+		content.MSC3245Voice = &event.MSC3245Voice{}
+	}
 	content.File, content.URL, err = gmx.uploadFile(
-		ctx, checksum, cacheFile, encrypt, int64(info.Size), info.MimeType, fileName, progressCallback,
+		ctx, cli, checksum, cacheFile, encrypt, int64(info.Size), info.MimeType, fileName, progressCallback,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload media: %w", err)
@@ -850,6 +897,7 @@ func (pr *progressReader) Close() error {
 
 func (gmx *Gomuks) uploadFile(
 	ctx context.Context,
+	cli *hicli.HiClient,
 	checksum []byte,
 	cacheFile *os.File,
 	encrypt bool,
@@ -859,10 +907,11 @@ func (gmx *Gomuks) uploadFile(
 	progressCallback func(float64),
 ) (*event.EncryptedFileInfo, id.ContentURIString, error) {
 	cm := &database.Media{
-		FileName: fileName,
-		MimeType: mimeType,
-		Size:     fileSize,
-		Hash:     (*[32]byte)(checksum),
+		FileName:     fileName,
+		MimeType:     mimeType,
+		Size:         fileSize,
+		Hash:         (*[32]byte)(checksum),
+		LastAccessed: jsontime.UnixMilliNow(),
 	}
 	var cacheReader io.ReadSeekCloser = cacheFile
 	if encrypt {
@@ -871,7 +920,7 @@ func (gmx *Gomuks) uploadFile(
 		mimeType = "application/octet-stream"
 		fileName = ""
 	}
-	resp, err := gmx.Client.Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+	resp, err := cli.Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
 		Content: &progressReader{
 			cb:    progressCallback,
 			total: fileSize,
@@ -888,7 +937,7 @@ func (gmx *Gomuks) uploadFile(
 		return nil, "", fmt.Errorf("failed to close cache reader: %w", err)
 	}
 	cm.MXC = resp.ContentURI
-	err = gmx.Client.DB.Media.Put(ctx, cm)
+	err = cli.DB.Media.Put(ctx, cm)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).
 			Stringer("mxc", cm.MXC).
@@ -1019,7 +1068,7 @@ func (gmx *Gomuks) generateFileInfo(ctx context.Context, file *os.File) (event.M
 	return msgType, info, defaultFileName, nil
 }
 
-func (gmx *Gomuks) generateVideoThumbnail(ctx context.Context, filePath string, encrypt bool, saveInto *event.FileInfo) error {
+func (gmx *Gomuks) generateVideoThumbnail(ctx context.Context, cli *hicli.HiClient, filePath string, encrypt bool, saveInto *event.FileInfo) error {
 	tempPath := filepath.Join(gmx.TempDir, "thumbnail-"+random.String(12)+".jpeg")
 	defer os.Remove(tempPath)
 	err := ffmpeg.ConvertPathWithDestination(
@@ -1085,7 +1134,7 @@ func (gmx *Gomuks) generateVideoThumbnail(ctx context.Context, filePath string,
 		return fmt.Errorf("failed to open renamed file: %w", err)
 	}
 	saveInto.ThumbnailFile, saveInto.ThumbnailURL, err = gmx.uploadFile(
-		ctx, checksum, tempFile, encrypt, fileInfo.Size(), "image/jpeg", "thumbnail.jpeg", func(_ float64) {},
+		ctx, cli, checksum, tempFile, encrypt, fileInfo.Size(), "image/jpeg", "thumbnail.jpeg", func(_ float64) {},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to upload: %w", err)
@@ -0,0 +1,293 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	mediaCacheEvictionInterval  = 30 * time.Minute
+	mediaCacheEvictionBatchSize = 100
+	// Access timestamps are only updated if they're older than this to avoid writing to the database on every request
+	mediaCacheTouchInterval = 1 * time.Hour
+)
+
+type MediaCacheUsage struct {
+	database.MediaCacheUsage
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
+type MediaCachePurgeResult struct {
+	Entries    int   `json:"entries"`
+	FreedFiles int   `json:"freed_files"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+func (gmx *Gomuks) maxMediaCacheSize() int64 {
+	return int64(gmx.Config.Media.MaxCacheSizeMB) * 1024 * 1024
+}
+
+func (gmx *Gomuks) touchMediaCacheEntry(ctx context.Context, cli *hicli.HiClient, entry *database.Media) {
+	if time.Since(entry.LastAccessed.Time) < mediaCacheTouchInterval {
+		return
+	}
+	err := cli.DB.Media.Touch(ctx, entry.MXC, time.Now())
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to update media cache access timestamp")
+	}
+}
+
+// RunMediaCacheEvictor periodically removes the least recently used files from the media cache
+// until the total size is below the configured limit.
+func (gmx *Gomuks) RunMediaCacheEvictor() {
+	if gmx.maxMediaCacheSize() <= 0 {
+		return
+	}
+	log := gmx.Log.With().Str("action", "media cache eviction").Logger()
+	ctx := log.WithContext(context.Background())
+	ticker := time.NewTicker(mediaCacheEvictionInterval)
+	defer ticker.Stop()
+	for {
+		err := gmx.EvictMediaCache(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to evict media cache")
+		}
+		select {
+		case <-gmx.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// getMediaCacheUsage returns the combined cache usage of the given clients. The cache directory is shared,
+// so files used by multiple accounts are counted more than once.
+func getMediaCacheUsage(ctx context.Context, clients []*hicli.HiClient, roomID id.RoomID) (usage database.MediaCacheUsage, err error) {
+	for _, cli := range clients {
+		cliUsage, err := cli.DB.Media.GetUsage(ctx, roomID)
+		if err != nil {
+			return usage, err
+		}
+		usage.Files += cliUsage.Files
+		usage.Size += cliUsage.Size
+	}
+	return usage, nil
+}
+
+type clientMediaEntry struct {
+	cli   *hicli.HiClient
+	entry *database.Media
+}
+
+// getLeastRecentlyUsedMedia returns up to limit cached media entries from all clients, oldest first.
+func getLeastRecentlyUsedMedia(ctx context.Context, clients []*hicli.HiClient, limit int) ([]clientMediaEntry, error) {
+	var entries []clientMediaEntry
+	for _, cli := range clients {
+		cliEntries, err := cli.DB.Media.GetLeastRecentlyUsed(ctx, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range cliEntries {
+			entries = append(entries, clientMediaEntry{cli: cli, entry: entry})
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b clientMediaEntry) int {
+		return a.entry.LastAccessed.Compare(b.entry.LastAccessed.Time)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// EvictMediaCache removes the least recently used files from the media cache until it's
+// below 90% of the maximum size. Nothing is done if the cache is below the maximum size.
+// The cache directory is shared by all accounts, so entries of every account are considered.
+func (gmx *Gomuks) EvictMediaCache(ctx context.Context) error {
+	maxSize := gmx.maxMediaCacheSize()
+	if maxSize <= 0 {
+		return nil
+	}
+	clients := gmx.allClients()
+	usage, err := getMediaCacheUsage(ctx, clients, "")
+	if err != nil {
+		return fmt.Errorf("failed to get media cache usage: %w", err)
+	} else if usage.Size <= maxSize {
+		return nil
+	}
+	target := maxSize / 10 * 9
+	var res MediaCachePurgeResult
+	for usage.Size-res.FreedBytes > target {
+		entries, err := getLeastRecentlyUsedMedia(ctx, clients, mediaCacheEvictionBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to get least recently used media: %w", err)
+		} else if len(entries) == 0 {
+			break
+		}
+		for _, item := range entries {
+			err = gmx.purgeMediaCacheEntry(ctx, item.cli, item.entry, &res)
+			if err != nil {
+				return err
+			} else if usage.Size-res.FreedBytes <= target {
+				break
+			}
+		}
+	}
+	zerolog.Ctx(ctx).Info().
+		Int64("previous_size", usage.Size).
+		Int("entries", res.Entries).
+		Int("freed_files", res.FreedFiles).
+		Int64("freed_bytes", res.FreedBytes).
+		Msg("Evicted media from cache")
+	return nil
+}
+
+// purgeMediaCache removes all cached files of the given clients, or only files referenced in the given room
+// if a room ID is set. The metadata is kept, so the files will be redownloaded from the server when needed.
+func (gmx *Gomuks) purgeMediaCache(ctx context.Context, clients []*hicli.HiClient, roomID id.RoomID) (*MediaCachePurgeResult, error) {
+	var res MediaCachePurgeResult
+	for _, cli := range clients {
+		entries, err := cli.DB.Media.GetCached(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cached media: %w", err)
+		}
+		for _, entry := range entries {
+			err = gmx.purgeMediaCacheEntry(ctx, cli, entry, &res)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &res, nil
+}
+
+func (gmx *Gomuks) purgeMediaCacheEntry(ctx context.Context, cli *hicli.HiClient, entry *database.Media, res *MediaCachePurgeResult) error {
+	err := cli.DB.Media.ClearCachedFile(ctx, entry.MXC)
+	if err != nil {
+		return fmt.Errorf("failed to clear cache entry for %s: %w", entry.MXC, err)
+	}
+	res.Entries++
+	clients := gmx.allClients()
+	gmx.removeUnusedCacheFile(ctx, clients, entry.Hash, entry.Size, res)
+	gmx.removeUnusedCacheFile(ctx, clients, entry.ThumbnailHash, entry.ThumbnailSize, res)
+	return nil
+}
+
+// purgeAccountMediaCache removes the cached files of an account that is being logged out.
+// The cache directory is shared, so files that are still referenced by any of the remaining
+// clients are kept. The entries must be fetched before the account's database is closed.
+func (gmx *Gomuks) purgeAccountMediaCache(ctx context.Context, entries []*database.Media, remaining []*hicli.HiClient) *MediaCachePurgeResult {
+	var res MediaCachePurgeResult
+	for _, entry := range entries {
+		res.Entries++
+		gmx.removeUnusedCacheFile(ctx, remaining, entry.Hash, entry.Size, &res)
+		gmx.removeUnusedCacheFile(ctx, remaining, entry.ThumbnailHash, entry.ThumbnailSize, &res)
+	}
+	return &res
+}
+
+func isCacheFileInUse(ctx context.Context, clients []*hicli.HiClient, hash []byte) (bool, error) {
+	for _, cli := range clients {
+		inUse, err := cli.DB.Media.IsHashInUse(ctx, hash)
+		if err != nil || inUse {
+			return inUse, err
+		}
+	}
+	return false, nil
+}
+
+func (gmx *Gomuks) removeUnusedCacheFile(ctx context.Context, clients []*hicli.HiClient, hash *[32]byte, size int64, res *MediaCachePurgeResult) {
+	if hash == nil {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Hex("hash", hash[:]).Logger()
+	inUse, err := isCacheFileInUse(ctx, clients, hash[:])
+	if err != nil {
+		log.Err(err).Msg("Failed to check if cache file is still in use")
+		return
+	} else if inUse {
+		// Another entry (possibly in another account) has the same content, so the file has to be kept
+		return
+	}
+	err = os.Remove(gmx.cacheEntryToPath(hash[:]))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Err(err).Msg("Failed to remove cache file")
+		return
+	}
+	res.FreedFiles++
+	res.FreedBytes += size
+}
+
+// getMediaCacheClients returns the clients that a media cache request applies to. If the account query parameter
+// is set, only that account is used. Otherwise, the request applies to the whole shared cache of all accounts.
+func (gmx *Gomuks) getMediaCacheClients(w http.ResponseWriter, r *http.Request) []*hicli.HiClient {
+	if !r.URL.Query().Has("account") {
+		return gmx.allClients()
+	}
+	cli := gmx.getRequestClient(w, r)
+	if cli == nil {
+		return nil
+	}
+	return []*hicli.HiClient{cli}
+}
+
+func (gmx *Gomuks) GetMediaCacheUsage(w http.ResponseWriter, r *http.Request) {
+	clients := gmx.getMediaCacheClients(w, r)
+	if clients == nil {
+		return
+	}
+	usage, err := getMediaCacheUsage(r.Context(), clients, id.RoomID(r.URL.Query().Get("room_id")))
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to get media cache usage")
+		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to get media cache usage: %v", err)).Write(w)
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &MediaCacheUsage{
+		MediaCacheUsage: usage,
+		MaxSize:         gmx.maxMediaCacheSize(),
+	})
+}
+
+func (gmx *Gomuks) PurgeMediaCache(w http.ResponseWriter, r *http.Request) {
+	clients := gmx.getMediaCacheClients(w, r)
+	if clients == nil {
+		return
+	}
+	res, err := gmx.purgeMediaCache(r.Context(), clients, id.RoomID(r.URL.Query().Get("room_id")))
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to purge media cache")
+		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to purge media cache: %v", err)).Write(w)
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,266 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+var (
+	ErrNotPostgres        = errors.New("the database type must be set to postgres in the config")
+	ErrSchemaNotEmpty     = errors.New("the Postgres schema already contains data")
+	ErrMissingSQLiteTable = errors.New("table is missing from the SQLite database")
+)
+
+// Tables that are created by the database library itself rather than the schema upgrades, and the search index,
+// which uses a different format in Postgres and is rebuilt by InitSearchIndex when the account is started.
+var migrationSkippedTables = []string{"version", "crypto_version", "database_owner", "event_fts"}
+
+const (
+	getPostgresTablesQuery = `
+		SELECT relname FROM pg_class
+		WHERE relnamespace = to_regnamespace(current_schema()) AND relkind = 'r'
+		ORDER BY oid
+	`
+	getPostgresColumnsQuery = `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position
+	`
+	// The search index may already exist if the account was started with Postgres before. It's dropped so that
+	// InitSearchIndex rebuilds it from the migrated events, as the triggers are disabled while copying.
+	dropPostgresSearchIndexQuery = `
+		DROP TRIGGER IF EXISTS event_fts_insert ON event;
+		DROP TRIGGER IF EXISTS event_fts_update ON event;
+		DROP TABLE IF EXISTS event_fts;
+	`
+	getPostgresSequenceColumnsQuery = `
+		SELECT table_name, column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND (is_identity = 'YES' OR column_default LIKE 'nextval(%')
+	`
+)
+
+type sequenceColumn struct {
+	Table  string
+	Column string
+}
+
+var (
+	stringColumnScanner   = dbutil.ConvertRowFn[string](dbutil.ScanSingleColumn[string])
+	sequenceColumnScanner = dbutil.ConvertRowFn[sequenceColumn](func(row dbutil.Scannable) (sc sequenceColumn, err error) {
+		err = row.Scan(&sc.Table, &sc.Column)
+		return
+	})
+)
+
+type MigratedAccount struct {
+	// Account is the name of the account, or empty for the default account.
+	Account string    `json:"account"`
+	UserID  id.UserID `json:"user_id"`
+	Rows    int64     `json:"rows"`
+}
+
+// MigrateToPostgres copies the SQLite databases of all accounts into their schemas in the configured
+// Postgres database. The schemas must not contain any data yet. The SQLite files are left in place.
+// gomuks must not be running at the same time.
+func (gmx *Gomuks) MigrateToPostgres(ctx context.Context) ([]*MigratedAccount, error) {
+	if !gmx.Config.Database.IsPostgres() {
+		return nil, ErrNotPostgres
+	}
+	names, err := gmx.findAccountNames()
+	if err != nil {
+		return nil, err
+	}
+	var results []*MigratedAccount
+	for _, name := range names {
+		dbPath := gmx.accountDatabasePath(name)
+		if _, err = os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		result, err := gmx.migrateAccountToPostgres(ctx, name, dbPath)
+		if err != nil {
+			return results, fmt.Errorf("failed to migrate database of %q: %w", name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func upgradeAccountDatabase(ctx context.Context, rawDB *dbutil.Database) error {
+	err := database.New(rawDB).Upgrade(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade hicli db: %w", err)
+	}
+	err = crypto.NewSQLCryptoStore(rawDB, dbutil.NoopLogger, "", "", nil).DB.Upgrade(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade crypto db: %w", err)
+	}
+	return nil
+}
+
+func (gmx *Gomuks) migrateAccountToPostgres(ctx context.Context, account, dbPath string) (*MigratedAccount, error) {
+	src, err := openSQLiteDatabase(DatabaseConfig{Type: DatabaseTypeSQLite, MaxOpenConns: 1, MaxIdleConns: 1}, dbPath, zerolog.Nop())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	defer src.Close()
+	dst, err := openPostgresDatabase(ctx, gmx.Config.Database, accountSchema(account), zerolog.Nop())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres database: %w", err)
+	}
+	defer dst.Close()
+	// Upgrading both databases ensures they have the same schema version, so the tables and columns match.
+	if err = upgradeAccountDatabase(ctx, src); err != nil {
+		return nil, fmt.Errorf("failed to upgrade SQLite database: %w", err)
+	} else if err = upgradeAccountDatabase(ctx, dst); err != nil {
+		return nil, fmt.Errorf("failed to upgrade Postgres database: %w", err)
+	}
+	result := &MigratedAccount{Account: account}
+	result.UserID, err = database.New(src).Account.GetFirstUserID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user ID: %w", err)
+	}
+	tables, err := stringColumnScanner.NewRowIter(dst.Query(ctx, getPostgresTablesQuery)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables: %w", err)
+	}
+	tx, err := dst.RawDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	// The foreign key from room previews to events is deferrable, as rooms must be inserted before events.
+	_, err = tx.ExecContext(ctx, "SET CONSTRAINTS ALL DEFERRED")
+	if err != nil {
+		return nil, fmt.Errorf("failed to defer constraints: %w", err)
+	}
+	for _, table := range tables {
+		if slices.Contains(migrationSkippedTables, table) {
+			continue
+		}
+		rows, err := copyTableToPostgres(ctx, src, tx, table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", table, err)
+		}
+		result.Rows += rows
+	}
+	err = resetPostgresSequences(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, dropPostgresSearchIndexQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to drop search index: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
+
+func copyTableToPostgres(ctx context.Context, src *dbutil.Database, dst *sql.Tx, table string) (int64, error) {
+	quotedTable := pq.QuoteIdentifier(table)
+	var exists bool
+	if err := dst.QueryRowContext(ctx, fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s)", quotedTable)).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to check if table is empty: %w", err)
+	} else if exists {
+		return 0, ErrSchemaNotEmpty
+	} else if exists, err = src.TableExists(ctx, table); err != nil {
+		return 0, fmt.Errorf("failed to check if table exists in SQLite: %w", err)
+	} else if !exists {
+		return 0, ErrMissingSQLiteTable
+	}
+	columns, err := stringColumnScanner.NewRowIter(dst.QueryContext(ctx, getPostgresColumnsQuery, table)).AsList()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get columns: %w", err)
+	}
+	// The triggers maintain derived data like reaction counts and last edits, which are copied as-is.
+	_, err = dst.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DISABLE TRIGGER USER", quotedTable))
+	if err != nil {
+		return 0, fmt.Errorf("failed to disable triggers: %w", err)
+	}
+	stmt, err := dst.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare copy: %w", err)
+	}
+	defer stmt.Close()
+	quotedColumns := make([]string, len(columns))
+	for i, column := range columns {
+		// session_request uses the implicit rowid column in SQLite, which can still be selected by name.
+		quotedColumns[i] = pq.QuoteIdentifier(column)
+	}
+	rows, err := src.RawDB.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(quotedColumns, ", "), quotedTable))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rows: %w", err)
+	}
+	defer rows.Close()
+	values := make([]any, len(columns))
+	valuePtrs := make([]any, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	var count int64
+	for rows.Next() {
+		if err = rows.Scan(valuePtrs...); err != nil {
+			return count, fmt.Errorf("failed to scan row: %w", err)
+		} else if _, err = stmt.ExecContext(ctx, values...); err != nil {
+			return count, fmt.Errorf("failed to write row: %w", err)
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to read rows: %w", err)
+	} else if _, err = stmt.ExecContext(ctx); err != nil {
+		return count, fmt.Errorf("failed to flush rows: %w", err)
+	} else if _, err = dst.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ENABLE TRIGGER USER", quotedTable)); err != nil {
+		return count, fmt.Errorf("failed to re-enable triggers: %w", err)
+	}
+	return count, nil
+}
+
+// resetPostgresSequences moves the sequences of generated columns past the copied values.
+func resetPostgresSequences(ctx context.Context, tx *sql.Tx) error {
+	seqColumns, err := sequenceColumnScanner.NewRowIter(tx.QueryContext(ctx, getPostgresSequenceColumnsQuery)).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to get sequence columns: %w", err)
+	}
+	for _, sc := range seqColumns {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence($1, $2), COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)",
+			pq.QuoteIdentifier(sc.Column), pq.QuoteIdentifier(sc.Table),
+		), sc.Table, sc.Column)
+		if err != nil {
+			return fmt.Errorf("failed to reset sequence of %s.%s: %w", sc.Table, sc.Column, err)
+		}
+	}
+	return nil
+}
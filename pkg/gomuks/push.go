@@ -34,6 +34,7 @@ import (
 	"go.mau.fi/util/random"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/gomuks/pkg/hicli"
 	"go.mau.fi/gomuks/pkg/hicli/database"
 	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
 )
@@ -65,7 +66,7 @@ var pushClient = &http.Client{
 	Timeout: 60 * time.Second,
 }
 
-func (gmx *Gomuks) SendPushNotifications(sync *jsoncmd.SyncComplete) {
+func (gmx *Gomuks) SendPushNotifications(account string, cli *hicli.HiClient, sync *jsoncmd.SyncComplete) {
 	var ctx context.Context
 	var push PushNotification
 	for _, room := range sync.Rooms {
@@ -78,7 +79,7 @@ func (gmx *Gomuks) SendPushNotifications(sync *jsoncmd.SyncComplete) {
 					Str("action", "send push notification").
 					Logger().WithContext(context.Background())
 			}
-			msg := gmx.formatPushNotificationMessage(ctx, notif)
+			msg := gmx.formatPushNotificationMessage(ctx, account, cli, notif)
 			if msg == nil {
 				continue
 			}
@@ -109,7 +110,7 @@ func (gmx *Gomuks) SendPushNotifications(sync *jsoncmd.SyncComplete) {
 			Str("action", "send push notification").
 			Logger().WithContext(context.Background())
 	}
-	pushRegs, err := gmx.Client.DB.PushRegistration.GetAll(ctx)
+	pushRegs, err := cli.DB.PushRegistration.GetAll(ctx)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to get push registrations")
 		return
@@ -120,7 +121,7 @@ func (gmx *Gomuks) SendPushNotifications(sync *jsoncmd.SyncComplete) {
 		push.ImageAuthExpiry = ptr.Ptr(jsontime.UM(exp))
 	}
 	for notif := range push.Split {
-		gmx.SendPushNotification(ctx, pushRegs, notif)
+		gmx.SendPushNotification(ctx, cli, pushRegs, notif)
 	}
 }
 
@@ -156,7 +157,7 @@ func (pn *PushNotification) Split(yield func(*PushNotification) bool) {
 	})
 }
 
-func (gmx *Gomuks) SendPushNotification(ctx context.Context, pushRegs []*database.PushRegistration, notif *PushNotification) {
+func (gmx *Gomuks) SendPushNotification(ctx context.Context, cli *hicli.HiClient, pushRegs []*database.PushRegistration, notif *PushNotification) {
 	log := zerolog.Ctx(ctx).With().
 		Bool("important", notif.HasImportant).
 		Int("message_count", len(notif.RawMessages)).
@@ -197,11 +198,11 @@ func (gmx *Gomuks) SendPushNotification(ctx context.Context, pushRegs []*databas
 				log.Err(err).Str("device_id", reg.DeviceID).Msg("Failed to unmarshal FCM token")
 				continue
 			}
-			shouldDelete := gmx.SendFCMPush(ctx, token, devicePayload, notif.HasImportant)
+			shouldDelete := gmx.SendFCMPush(ctx, cli, token, devicePayload, notif.HasImportant)
 			if shouldDelete {
 				log.Debug().Str("device_id", reg.DeviceID).Msg("Expiring push registration as gateway returned 404")
 				reg.Expiration = jsontime.UnixNow()
-				err = gmx.Client.DB.PushRegistration.Put(ctx, reg)
+				err = cli.DB.PushRegistration.Put(ctx, reg)
 				if err != nil {
 					log.Err(err).Msg("Failed to mark push registration as expired")
 				}
@@ -235,13 +236,13 @@ type PushRequest struct {
 	HighPriority bool   `json:"high_priority"`
 }
 
-func (gmx *Gomuks) SendFCMPush(ctx context.Context, token string, payload []byte, highPriority bool) (shouldDelete bool) {
+func (gmx *Gomuks) SendFCMPush(ctx context.Context, cli *hicli.HiClient, token string, payload []byte, highPriority bool) (shouldDelete bool) {
 	wrappedPayload, _ := json.Marshal(&PushRequest{
 		Token:        token,
 		Payload:      payload,
 		HighPriority: highPriority,
 		// User ID is sent for debugging purposes and logged in the push gateway, but not sent to Google
-		Owner: gmx.Client.Account.UserID.String(),
+		Owner: cli.Account.UserID.String(),
 	})
 	url := fmt.Sprintf("%s/_gomuks/push/fcm", gmx.Config.Push.FCMGateway)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(wrappedPayload))
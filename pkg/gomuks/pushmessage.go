@@ -29,6 +29,7 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/gomuks/pkg/hicli"
 	"go.mau.fi/gomuks/pkg/hicli/database"
 	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
 )
@@ -57,7 +58,16 @@ type NotificationUser struct {
 	Avatar string    `json:"avatar,omitempty"`
 }
 
-func getAvatarLinkForNotification(name, ident string, uri id.ContentURIString) string {
+// accountMediaQuery returns the query parameter that selects the account to download media with.
+// The default account doesn't need it.
+func accountMediaQuery(account string) string {
+	if account == "" {
+		return ""
+	}
+	return "&account=" + url.QueryEscape(account)
+}
+
+func getAvatarLinkForNotification(account, name, ident string, uri id.ContentURIString) string {
 	parsed := uri.ParseOrIgnore()
 	if !parsed.IsValid() {
 		return ""
@@ -68,12 +78,15 @@ func getAvatarLinkForNotification(name, ident string, uri id.ContentURIString) s
 	} else {
 		fallbackChar, _ = utf8.DecodeRuneInString(name)
 	}
-	return fmt.Sprintf("_gomuks/media/%s/%s?encrypted=false&fallback=%s", parsed.Homeserver, parsed.FileID, url.QueryEscape(string(fallbackChar)))
+	return fmt.Sprintf(
+		"_gomuks/media/%s/%s?encrypted=false&fallback=%s%s",
+		parsed.Homeserver, parsed.FileID, url.QueryEscape(string(fallbackChar)), accountMediaQuery(account),
+	)
 }
 
-func (gmx *Gomuks) getNotificationUser(ctx context.Context, roomID id.RoomID, userID id.UserID) (user NotificationUser) {
+func (gmx *Gomuks) getNotificationUser(ctx context.Context, account string, cli *hicli.HiClient, roomID id.RoomID, userID id.UserID) (user NotificationUser) {
 	user = NotificationUser{ID: userID, Name: userID.Localpart()}
-	memberEvt, err := gmx.Client.DB.CurrentState.Get(ctx, roomID, event.StateMember, userID.String())
+	memberEvt, err := cli.DB.CurrentState.Get(ctx, roomID, event.StateMember, userID.String())
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Stringer("of_user_id", userID).Msg("Failed to get member event")
 		return
@@ -89,12 +102,12 @@ func (gmx *Gomuks) getNotificationUser(ctx context.Context, roomID id.RoomID, us
 		user.Name = user.Name[:50] + "…"
 	}
 	if memberContent.AvatarURL != "" {
-		user.Avatar = getAvatarLinkForNotification(memberContent.Displayname, userID.String(), memberContent.AvatarURL)
+		user.Avatar = getAvatarLinkForNotification(account, memberContent.Displayname, userID.String(), memberContent.AvatarURL)
 	}
 	return
 }
 
-func (gmx *Gomuks) formatPushNotificationMessage(ctx context.Context, notif jsoncmd.SyncNotification) *PushNewMessage {
+func (gmx *Gomuks) formatPushNotificationMessage(ctx context.Context, account string, cli *hicli.HiClient, notif jsoncmd.SyncNotification) *PushNewMessage {
 	evtType := notif.Event.Type
 	rawContent := notif.Event.Content
 	if evtType == event.EventEncrypted.Type {
@@ -118,7 +131,7 @@ func (gmx *Gomuks) formatPushNotificationMessage(ctx context.Context, notif json
 		if ptr.Val(notif.Room.DMUserID) != "" {
 			avatarIdent = notif.Room.DMUserID.String()
 		}
-		roomAvatar = getAvatarLinkForNotification(ptr.Val(notif.Room.Name), avatarIdent, notif.Room.Avatar.CUString())
+		roomAvatar = getAvatarLinkForNotification(account, ptr.Val(notif.Room.Name), avatarIdent, notif.Room.Avatar.CUString())
 	}
 	roomName := ptr.Val(notif.Room.Name)
 	if roomName == "" {
@@ -135,12 +148,12 @@ func (gmx *Gomuks) formatPushNotificationMessage(ctx context.Context, notif json
 		if content.File != nil && content.File.URL != "" {
 			parsed := content.File.URL.ParseOrIgnore()
 			if len(content.File.URL) < 255 && parsed.IsValid() {
-				image = fmt.Sprintf("_gomuks/media/%s/%s?encrypted=true", parsed.Homeserver, parsed.FileID)
+				image = fmt.Sprintf("_gomuks/media/%s/%s?encrypted=true%s", parsed.Homeserver, parsed.FileID, accountMediaQuery(account))
 			}
 		} else if content.URL != "" {
 			parsed := content.URL.ParseOrIgnore()
 			if len(content.URL) < 255 && parsed.IsValid() {
-				image = fmt.Sprintf("_gomuks/media/%s/%s?encrypted=false", parsed.Homeserver, parsed.FileID)
+				image = fmt.Sprintf("_gomuks/media/%s/%s?encrypted=false%s", parsed.Homeserver, parsed.FileID, accountMediaQuery(account))
 			}
 		}
 		if content.FileName == "" || content.FileName == content.Body {
@@ -159,12 +172,12 @@ func (gmx *Gomuks) formatPushNotificationMessage(ctx context.Context, notif json
 		RoomID:     notif.Room.ID,
 		RoomName:   roomName,
 		RoomAvatar: roomAvatar,
-		Sender:     gmx.getNotificationUser(ctx, notif.Room.ID, notif.Event.Sender),
-		Self:       gmx.getNotificationUser(ctx, notif.Room.ID, gmx.Client.Account.UserID),
+		Sender:     gmx.getNotificationUser(ctx, account, cli, notif.Room.ID, notif.Event.Sender),
+		Self:       gmx.getNotificationUser(ctx, account, cli, notif.Room.ID, cli.Account.UserID),
 
 		Text:    text,
 		Image:   image,
-		Mention: content.Mentions.Has(gmx.Client.Account.UserID),
+		Mention: content.Mentions.Has(cli.Account.UserID),
 		Reply:   content.RelatesTo.GetNonFallbackReplyTo() != "",
 		Sound:   notif.Sound,
 	}
@@ -26,6 +26,8 @@ import (
 
 	"go.mau.fi/util/random"
 	"maunium.net/go/mautrix"
+
+	"go.mau.fi/gomuks/pkg/hicli"
 )
 
 const ssoErrorPage = `<!DOCTYPE html>
@@ -51,36 +53,40 @@ const ssoErrorPage = `<!DOCTYPE html>
 </body>
 </html>`
 
-func (gmx *Gomuks) parseSSOServerURL(r *http.Request) error {
+func (gmx *Gomuks) parseSSOServerURL(r *http.Request) (*hicli.HiClient, error) {
 	cookie, _ := r.Cookie("gomuks_sso_session")
 	if cookie == nil {
-		return fmt.Errorf("no SSO session cookie")
+		return nil, fmt.Errorf("no SSO session cookie")
 	}
 	var cookieData SSOCookieData
 	if !gmx.validateToken(cookie.Value, &cookieData) {
-		return fmt.Errorf("invalid SSO session cookie")
+		return nil, fmt.Errorf("invalid SSO session cookie")
 	} else if cookieData.SessionID != r.URL.Query().Get("gomuksSession") {
-		return fmt.Errorf("session ID mismatch in query param and cookie")
+		return nil, fmt.Errorf("session ID mismatch in query param and cookie")
 	} else if time.Until(cookieData.Expiry) < 0 {
-		return fmt.Errorf("SSO session cookie expired")
+		return nil, fmt.Errorf("SSO session cookie expired")
+	}
+	cli := gmx.GetClient(cookieData.Account)
+	if cli == nil {
+		return nil, fmt.Errorf("unknown account %q", cookieData.Account)
 	}
 	var err error
-	gmx.Client.Client.HomeserverURL, err = url.Parse(cookieData.HomeserverURL)
+	cli.Client.HomeserverURL, err = url.Parse(cookieData.HomeserverURL)
 	if err != nil {
-		return fmt.Errorf("failed to parse server URL: %w", err)
+		return nil, fmt.Errorf("failed to parse server URL: %w", err)
 	}
-	return nil
+	return cli, nil
 }
 
 func (gmx *Gomuks) HandleSSOComplete(w http.ResponseWriter, r *http.Request) {
-	err := gmx.parseSSOServerURL(r)
+	cli, err := gmx.parseSSOServerURL(r)
 	if err != nil {
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = fmt.Fprintf(w, ssoErrorPage, html.EscapeString(err.Error()))
 		return
 	}
-	err = gmx.Client.Login(r.Context(), &mautrix.ReqLogin{
+	err = cli.Login(r.Context(), &mautrix.ReqLogin{
 		Type:  mautrix.AuthTypeToken,
 		Token: r.URL.Query().Get("loginToken"),
 	})
@@ -98,6 +104,7 @@ type SSOCookieData struct {
 	SessionID     string    `json:"session_id"`
 	HomeserverURL string    `json:"homeserver_url"`
 	Expiry        time.Time `json:"expiry"`
+	Account       string    `json:"account,omitempty"`
 }
 
 func (gmx *Gomuks) PrepareSSO(w http.ResponseWriter, r *http.Request) {
@@ -107,6 +114,11 @@ func (gmx *Gomuks) PrepareSSO(w http.ResponseWriter, r *http.Request) {
 		mautrix.MBadJSON.WithMessage("Failed to decode request JSON").Write(w)
 		return
 	}
+	// The account is stored in the cookie, as the query parameters don't survive the redirect through the SSO provider.
+	data.Account = r.URL.Query().Get("account")
+	if gmx.getRequestClient(w, r) == nil {
+		return
+	}
 	data.SessionID = random.String(16)
 	data.Expiry = time.Now().Add(30 * time.Minute)
 	cookieData, err := json.Marshal(&data)
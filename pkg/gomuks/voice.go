@@ -0,0 +1,94 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/util/ffmpeg"
+	"go.mau.fi/util/random"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// The waveform is calculated from mono 16-bit PCM. 8 kHz is enough for speech and keeps the raw file small.
+	waveformSampleRate = 8000
+	waveformPoints     = 100
+	// MSC3246 waveforms are integers between 0 and 1024.
+	waveformMaxValue = 1024
+)
+
+// voiceEncodeArgs are the ffmpeg output args used when re-encoding voice messages to Ogg/Opus.
+var voiceEncodeArgs = []string{"-vn", "-ac", "1", "-c:a", "libopus", "-b:a", "32k", "-application", "voip"}
+
+// generateVoiceInfo decodes the given audio file with ffmpeg and calculates the duration and an MSC1767 waveform.
+func (gmx *Gomuks) generateVoiceInfo(ctx context.Context, filePath string) (*event.MSC1767Audio, error) {
+	tempPath := filepath.Join(gmx.TempDir, "waveform-"+random.String(12)+".pcm")
+	defer os.Remove(tempPath)
+	err := ffmpeg.ConvertPathWithDestination(
+		ctx, filePath, tempPath, nil,
+		[]string{"-vn", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate), "-f", "s16le", "-c:a", "pcm_s16le"},
+		false,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	samples, err := os.ReadFile(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decoded audio: %w", err)
+	}
+	return &event.MSC1767Audio{
+		Duration: len(samples) / 2 * 1000 / waveformSampleRate,
+		Waveform: calculateWaveform(samples, waveformPoints),
+	}, nil
+}
+
+// calculateWaveform splits little-endian 16-bit PCM samples into the given number of buckets and returns the
+// RMS amplitude of each bucket, scaled so that the loudest bucket is 1024.
+func calculateWaveform(pcm []byte, points int) []int {
+	sampleCount := len(pcm) / 2
+	if sampleCount == 0 {
+		return []int{}
+	}
+	points = min(points, sampleCount)
+	rms := make([]float64, points)
+	var loudest float64
+	for i := range rms {
+		start := i * sampleCount / points
+		end := (i + 1) * sampleCount / points
+		var sumSquares float64
+		for j := start; j < end; j++ {
+			sample := float64(int16(binary.LittleEndian.Uint16(pcm[j*2:])))
+			sumSquares += sample * sample
+		}
+		rms[i] = math.Sqrt(sumSquares / float64(end-start))
+		loudest = max(loudest, rms[i])
+	}
+	waveform := make([]int, points)
+	if loudest == 0 {
+		return waveform
+	}
+	for i, val := range rms {
+		waveform[i] = int(math.Round(val / loudest * waveformMaxValue))
+	}
+	return waveform
+}
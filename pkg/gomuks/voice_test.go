@@ -0,0 +1,53 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2025 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func makeTestPCM(samples ...int16) []byte {
+	pcm := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(sample))
+	}
+	return pcm
+}
+
+func TestCalculateWaveform(t *testing.T) {
+	pcm := makeTestPCM(0, 0, 100, -100, 200, -200, 400, -400)
+	waveform := calculateWaveform(pcm, 4)
+	if !slices.Equal(waveform, []int{0, 256, 512, 1024}) {
+		t.Errorf("unexpected waveform %v", waveform)
+	}
+}
+
+func TestCalculateWaveform_Edges(t *testing.T) {
+	if waveform := calculateWaveform(nil, 100); len(waveform) != 0 {
+		t.Errorf("unexpected waveform for empty input %v", waveform)
+	}
+	if waveform := calculateWaveform(makeTestPCM(0, 0, 0, 0), 2); !slices.Equal(waveform, []int{0, 0}) {
+		t.Errorf("unexpected waveform for silence %v", waveform)
+	}
+	// There can't be more points than samples, and a trailing odd byte is ignored
+	pcm := append(makeTestPCM(-32768, 16384, 0), 0xff)
+	if waveform := calculateWaveform(pcm, 100); !slices.Equal(waveform, []int{1024, 512, 0}) {
+		t.Errorf("unexpected waveform for short input %v", waveform)
+	}
+}
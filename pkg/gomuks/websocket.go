@@ -31,6 +31,7 @@ import (
 
 	"github.com/coder/websocket"
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/exerrors"
 
 	"go.mau.fi/gomuks/pkg/hicli"
 	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
@@ -75,11 +76,14 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	resumeFrom, _ := strconv.ParseInt(r.URL.Query().Get("last_received_event"), 10, 64)
 	resumeRunID, _ := strconv.ParseInt(r.URL.Query().Get("run_id"), 10, 64)
 	compress, _ := strconv.ParseInt(r.URL.Query().Get("compress"), 10, 64)
+	// Clients that don't know about multiple accounts only receive events of the default account
+	multiAccount := r.URL.Query().Get("multi_account") == "1"
 	log.Info().
 		Int64("resume_from", resumeFrom).
 		Int64("resume_run_id", resumeRunID).
 		Int64("current_run_id", runID).
 		Int64("compress", compress).
+		Bool("multi_account", multiAccount).
 		Msg("Accepted new websocket connection")
 	var fp *flateProxy
 	if compress == 1 {
@@ -123,7 +127,7 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}
 	var resumeData []*BufferedEvent
 	listenerID, resumeData = gmx.EventBuffer.Subscribe(resumeFrom, closeManually, func(evt *BufferedEvent) {
-		if ctx.Err() != nil {
+		if ctx.Err() != nil || (!multiAccount && evt.Account != "") {
 			return
 		}
 		select {
@@ -139,6 +143,9 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 		}
 	})
 	didResume := resumeData != nil
+	if didResume && !multiAccount {
+		resumeData = filterDefaultAccountEvents(resumeData)
+	}
 
 	lastDataReceived := &atomic.Int64{}
 	lastDataReceived.Store(time.Now().UnixMilli())
@@ -240,9 +247,18 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 			} else if pingData.LastReceivedID != 0 {
 				gmx.EventBuffer.SetLastAckedID(listenerID, pingData.LastReceivedID)
 			}
+		} else if cmd.Command == jsoncmd.ReqListAccounts || cmd.Command == jsoncmd.ReqAddAccount {
+			resp = gmx.handleAccountCommand(cmd)
+		} else if cli := gmx.GetClient(cmd.Account); cli == nil {
+			resp = &hicli.JSONCommand{
+				Command:   jsoncmd.RespError,
+				RequestID: cmd.RequestID,
+				Data:      exerrors.Must(json.Marshal(ErrUnknownAccount.Error())),
+			}
 		} else {
-			resp = gmx.Client.SubmitJSONCommand(ctx, cmd)
+			resp = cli.SubmitJSONCommand(ctx, cmd)
 		}
+		resp.Account = cmd.Account
 		if ctx.Err() != nil {
 			return
 		}
@@ -265,25 +281,41 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 		log.Err(initErr).Msg("Failed to write init client state message")
 		return
 	}
-	initErr = writeCmd(ctx, conn, fp, &jsoncmd.Container[*jsoncmd.ClientState]{
-		Command: jsoncmd.EventClientState,
-		Data:    gmx.Client.State(),
-	})
-	if initErr != nil {
-		log.Err(initErr).Msg("Failed to write init client state message")
-		return
+	accounts := []string{""}
+	if multiAccount {
+		accounts = gmx.AccountNames()
 	}
-	initErr = writeCmd(ctx, conn, fp, &jsoncmd.Container[*jsoncmd.SyncStatus]{
-		Command: jsoncmd.EventSyncStatus,
-		Data:    gmx.Client.SyncStatus.Load(),
-	})
-	if initErr != nil {
-		log.Err(initErr).Msg("Failed to write init sync status message")
-		return
+	var loggedInAccounts []string
+	for _, account := range accounts {
+		cli := gmx.GetClient(account)
+		if cli == nil {
+			continue
+		}
+		initErr = writeCmd(ctx, conn, fp, &jsoncmd.Container[*jsoncmd.ClientState]{
+			Command: jsoncmd.EventClientState,
+			Account: account,
+			Data:    cli.State(),
+		})
+		if initErr != nil {
+			log.Err(initErr).Msg("Failed to write init client state message")
+			return
+		}
+		initErr = writeCmd(ctx, conn, fp, &jsoncmd.Container[*jsoncmd.SyncStatus]{
+			Command: jsoncmd.EventSyncStatus,
+			Account: account,
+			Data:    cli.SyncStatus.Load(),
+		})
+		if initErr != nil {
+			log.Err(initErr).Msg("Failed to write init sync status message")
+			return
+		}
+		if cli.IsLoggedIn() {
+			loggedInAccounts = append(loggedInAccounts, account)
+		}
 	}
 	go sendImageAuthToken()
-	if gmx.Client.IsLoggedIn() && !didResume {
-		go gmx.sendInitialData(ctx, fp, conn)
+	if len(loggedInAccounts) > 0 && !didResume {
+		go gmx.sendInitialData(ctx, fp, conn, loggedInAccounts)
 	}
 	log.Debug().Bool("did_resume", didResume).Msg("Connection initialization complete")
 	var closeErr websocket.CloseError
@@ -325,25 +357,69 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (gmx *Gomuks) sendInitialData(ctx context.Context, fp *flateProxy, conn *websocket.Conn) {
+func filterDefaultAccountEvents(evts []*BufferedEvent) []*BufferedEvent {
+	filtered := make([]*BufferedEvent, 0, len(evts))
+	for _, evt := range evts {
+		if evt.Account == "" {
+			filtered = append(filtered, evt)
+		}
+	}
+	return filtered
+}
+
+func (gmx *Gomuks) handleAccountCommand(cmd *hicli.JSONCommand) *hicli.JSONCommand {
+	var resp any
+	var err error
+	switch cmd.Command {
+	case jsoncmd.ReqListAccounts:
+		resp = gmx.ListAccounts()
+	case jsoncmd.ReqAddAccount:
+		var params jsoncmd.AddAccountParams
+		err = json.Unmarshal(cmd.Data, &params)
+		if err == nil {
+			resp, err = gmx.AddAccount(params.Account)
+		}
+	}
+	if err != nil {
+		return &hicli.JSONCommand{
+			Command:   jsoncmd.RespError,
+			RequestID: cmd.RequestID,
+			Data:      exerrors.Must(json.Marshal(err.Error())),
+		}
+	}
+	return &hicli.JSONCommand{
+		Command:   jsoncmd.RespSuccess,
+		RequestID: cmd.RequestID,
+		Data:      exerrors.Must(json.Marshal(resp)),
+	}
+}
+
+func (gmx *Gomuks) sendInitialData(ctx context.Context, fp *flateProxy, conn *websocket.Conn, accounts []string) {
 	log := zerolog.Ctx(ctx)
 	var roomCount int
 	var totalSize int
-	for payload := range gmx.Client.GetInitialSync(ctx, 100) {
-		roomCount += len(payload.Rooms)
-		n, err := writeCmdWithExtra(ctx, conn, fp, &jsoncmd.Container[*jsoncmd.SyncComplete]{
-			Command:   jsoncmd.EventSyncComplete,
-			RequestID: 0,
-			Data:      payload,
-		}, nil)
-		if err != nil {
-			log.Err(err).Msg("Failed to send initial rooms to client")
+	for _, account := range accounts {
+		cli := gmx.GetClient(account)
+		if cli == nil {
+			continue
+		}
+		for payload := range cli.GetInitialSync(ctx, 100) {
+			roomCount += len(payload.Rooms)
+			n, err := writeCmdWithExtra(ctx, conn, fp, &jsoncmd.Container[*jsoncmd.SyncComplete]{
+				Command:   jsoncmd.EventSyncComplete,
+				RequestID: 0,
+				Account:   account,
+				Data:      payload,
+			}, nil)
+			if err != nil {
+				log.Err(err).Msg("Failed to send initial rooms to client")
+				return
+			}
+			totalSize += n
+		}
+		if ctx.Err() != nil {
 			return
 		}
-		totalSize += n
-	}
-	if ctx.Err() != nil {
-		return
 	}
 	err := writeCmd(ctx, conn, fp, &hicli.JSONCommand{
 		Command:   jsoncmd.EventInitComplete,
@@ -21,26 +21,36 @@ import (
 )
 
 func (h *HiClient) uploadKeysToBackup(ctx context.Context) {
-	log := zerolog.Ctx(ctx)
 	version := h.KeyBackupVersion
 	key := h.KeyBackupKey
 	if version == "" || key == nil {
 		return
 	}
+	err := h.uploadKeysToBackupVersion(ctx, version, key, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to upload megolm sessions to key backup")
+	}
+}
 
+func (h *HiClient) uploadKeysToBackupVersion(
+	ctx context.Context,
+	version id.KeyBackupVersion,
+	key *backup.MegolmBackupKey,
+	progressCallback func(uploaded, total int),
+) error {
+	log := zerolog.Ctx(ctx)
 	sessions, err := h.CryptoStore.GetGroupSessionsWithoutKeyBackupVersion(ctx, version).AsList()
 	if err != nil {
-		log.Err(err).Msg("Failed to get megolm sessions that aren't backed up")
-		return
+		return fmt.Errorf("failed to get megolm sessions that aren't backed up: %w", err)
 	} else if len(sessions) == 0 {
-		return
+		return nil
 	}
 	log.Debug().Int("session_count", len(sessions)).Msg("Backing up megolm sessions")
+	uploaded := 0
 	for chunk := range slices.Chunk(sessions, 100) {
 		err = h.uploadKeyBackupBatch(ctx, version, key, chunk)
 		if err != nil {
-			log.Err(err).Msg("Failed to upload key backup batch")
-			return
+			return fmt.Errorf("failed to upload key backup batch: %w", err)
 		}
 		err = h.CryptoStore.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
 			for _, sess := range chunk {
@@ -53,11 +63,15 @@ func (h *HiClient) uploadKeysToBackup(ctx context.Context) {
 			return nil
 		})
 		if err != nil {
-			log.Err(err).Msg("Failed to update key backup version of uploaded megolm sessions in database")
-			return
+			return fmt.Errorf("failed to update key backup version of uploaded megolm sessions in database: %w", err)
+		}
+		uploaded += len(chunk)
+		if progressCallback != nil {
+			progressCallback(uploaded, len(sessions))
 		}
 	}
 	log.Info().Int("session_count", len(sessions)).Msg("Successfully uploaded megolm sessions to key backup")
+	return nil
 }
 
 func (h *HiClient) uploadKeyBackupBatch(ctx context.Context, version id.KeyBackupVersion, megolmBackupKey *backup.MegolmBackupKey, sessions []*crypto.InboundGroupSession) error {
@@ -0,0 +1,164 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+var AccountDataBookmarks = event.Type{Type: "fi.mau.gomuks.bookmarks", Class: event.AccountDataEventType}
+
+// BookmarksEventContent is the content of the bookmarks account data event. Bookmarks are ordered oldest first.
+type BookmarksEventContent struct {
+	Bookmarks []*Bookmark `json:"bookmarks"`
+}
+
+type Bookmark struct {
+	RoomID  id.RoomID          `json:"room_id"`
+	EventID id.EventID         `json:"event_id"`
+	AddedAt jsontime.UnixMilli `json:"added_at"`
+	Note    string             `json:"note,omitempty"`
+}
+
+func (h *HiClient) getBookmarks(ctx context.Context) (*BookmarksEventContent, error) {
+	ad, err := h.DB.AccountData.Get(ctx, h.Account.UserID, AccountDataBookmarks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bookmarks from database: %w", err)
+	}
+	if ad == nil {
+		return &BookmarksEventContent{}, nil
+	}
+	return parseBookmarks(ad.Content)
+}
+
+// parseBookmarks parses the bookmarks account data content. The content may have been written by other clients,
+// so entries that are null or don't point at an event are dropped.
+func parseBookmarks(data json.RawMessage) (*BookmarksEventContent, error) {
+	var content BookmarksEventContent
+	err := json.Unmarshal(data, &content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bookmarks: %w", err)
+	}
+	content.Bookmarks = slices.DeleteFunc(content.Bookmarks, func(bookmark *Bookmark) bool {
+		return bookmark == nil || bookmark.RoomID == "" || bookmark.EventID == ""
+	})
+	return &content, nil
+}
+
+func (h *HiClient) setBookmarks(ctx context.Context, content *BookmarksEventContent) error {
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bookmarks: %w", err)
+	}
+	err = h.Client.SetAccountData(ctx, AccountDataBookmarks.Type, json.RawMessage(contentJSON))
+	if err != nil {
+		return fmt.Errorf("failed to save bookmarks: %w", err)
+	}
+	// Store the new content immediately, so that another change before the next sync doesn't overwrite this one.
+	_, err = h.DB.AccountData.Put(ctx, h.Account.UserID, AccountDataBookmarks, contentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to save bookmarks to database: %w", err)
+	}
+	return nil
+}
+
+func (h *HiClient) getBookmarkedEvent(ctx context.Context, bookmark *Bookmark) *jsoncmd.BookmarkedEvent {
+	output := &jsoncmd.BookmarkedEvent{
+		RoomID:  bookmark.RoomID,
+		EventID: bookmark.EventID,
+		AddedAt: bookmark.AddedAt,
+		Note:    bookmark.Note,
+	}
+	var err error
+	output.Event, err = h.GetEvent(ctx, bookmark.RoomID, bookmark.EventID)
+	if err != nil {
+		output.Error = err.Error()
+	}
+	return output
+}
+
+// AddBookmark bookmarks the given event. If the event is already bookmarked, only the note is updated.
+func (h *HiClient) AddBookmark(ctx context.Context, roomID id.RoomID, eventID id.EventID, note string) (*jsoncmd.BookmarkedEvent, error) {
+	h.bookmarksLock.Lock()
+	defer h.bookmarksLock.Unlock()
+	evt, err := h.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	content, err := h.getBookmarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idx := slices.IndexFunc(content.Bookmarks, func(bookmark *Bookmark) bool {
+		return bookmark.EventID == eventID
+	})
+	var bookmark *Bookmark
+	if idx >= 0 {
+		bookmark = content.Bookmarks[idx]
+		bookmark.Note = note
+	} else {
+		bookmark = &Bookmark{
+			RoomID:  roomID,
+			EventID: eventID,
+			AddedAt: jsontime.UnixMilliNow(),
+			Note:    note,
+		}
+		content.Bookmarks = append(content.Bookmarks, bookmark)
+	}
+	err = h.setBookmarks(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	return &jsoncmd.BookmarkedEvent{
+		RoomID:  bookmark.RoomID,
+		EventID: bookmark.EventID,
+		AddedAt: bookmark.AddedAt,
+		Note:    bookmark.Note,
+		Event:   evt,
+	}, nil
+}
+
+// RemoveBookmark removes the given event from bookmarks. It returns false if the event wasn't bookmarked.
+func (h *HiClient) RemoveBookmark(ctx context.Context, eventID id.EventID) (bool, error) {
+	h.bookmarksLock.Lock()
+	defer h.bookmarksLock.Unlock()
+	content, err := h.getBookmarks(ctx)
+	if err != nil {
+		return false, err
+	}
+	origLen := len(content.Bookmarks)
+	content.Bookmarks = slices.DeleteFunc(content.Bookmarks, func(bookmark *Bookmark) bool {
+		return bookmark.EventID == eventID
+	})
+	if len(content.Bookmarks) == origLen {
+		return false, nil
+	}
+	return true, h.setBookmarks(ctx, content)
+}
+
+// ListBookmarks returns all bookmarks, newest first. Events that aren't in the local database are fetched
+// from the server. If fetching an event fails, the error is included in the bookmark instead of failing the request.
+func (h *HiClient) ListBookmarks(ctx context.Context) ([]*jsoncmd.BookmarkedEvent, error) {
+	content, err := h.getBookmarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output := make([]*jsoncmd.BookmarkedEvent, len(content.Bookmarks))
+	for i, bookmark := range content.Bookmarks {
+		output[len(output)-i-1] = h.getBookmarkedEvent(ctx, bookmark)
+	}
+	return output, nil
+}
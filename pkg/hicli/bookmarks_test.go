@@ -0,0 +1,33 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"testing"
+)
+
+func TestParseBookmarks(t *testing.T) {
+	content, err := parseBookmarks([]byte(`{"bookmarks": [
+		null,
+		{"room_id": "!room:example.com", "event_id": "$event1", "added_at": 1, "note": "hello"},
+		{"room_id": "!room:example.com"},
+		{"room_id": "!room:example.com", "event_id": "$event2", "added_at": 2}
+	]}`))
+	if err != nil {
+		t.Fatalf("failed to parse bookmarks: %v", err)
+	}
+	if len(content.Bookmarks) != 2 {
+		t.Fatalf("unexpected bookmark count %d", len(content.Bookmarks))
+	} else if content.Bookmarks[0].EventID != "$event1" || content.Bookmarks[0].Note != "hello" {
+		t.Errorf("unexpected first bookmark %+v", content.Bookmarks[0])
+	} else if content.Bookmarks[1].EventID != "$event2" {
+		t.Errorf("unexpected second bookmark %+v", content.Bookmarks[1])
+	}
+	if _, err = parseBookmarks([]byte(`{"bookmarks": {}}`)); err == nil {
+		t.Error("no error for invalid bookmarks")
+	}
+}
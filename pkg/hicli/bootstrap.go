@@ -0,0 +1,208 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/signatures"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+var ErrCrossSigningAlreadySetUp = errors.New("cross-signing keys already exist, verify with a recovery key instead")
+
+func (h *HiClient) passwordUIACallback(password string) mautrix.UIACallback {
+	return func(uia *mautrix.RespUserInteractive) any {
+		if password == "" {
+			return nil
+		}
+		return &mautrix.ReqUIAuthLogin{
+			BaseAuthData: mautrix.BaseAuthData{
+				Type:    mautrix.AuthTypePassword,
+				Session: uia.Session,
+			},
+			User:     h.Account.UserID.String(),
+			Password: password,
+		}
+	}
+}
+
+// BootstrapCrossSigning sets up cross-signing, secret storage and key backup for an account that doesn't have them.
+// The account password is needed if the server requires user-interactive auth for uploading cross-signing keys.
+// If passphrase is set, it can be used in addition to the returned recovery key to unlock secret storage.
+//
+// The generated private keys are stored locally before they're published, so if a later step fails,
+// calling this again will resume with the same keys instead of refusing because keys already exist.
+func (h *HiClient) BootstrapCrossSigning(ctx context.Context, password, passphrase string) (*jsoncmd.RecoveryKeyResponse, error) {
+	defer h.dispatchCurrentState()
+	log := zerolog.Ctx(ctx)
+	if h.Verified {
+		return nil, ErrCrossSigningAlreadySetUp
+	}
+	serverMasterKey, err := h.getServerMasterKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing cross-signing keys: %w", err)
+	}
+	keys, err := h.loadCrossSigningPrivateKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if serverMasterKey != "" {
+		if keys == nil || keys.MasterKey.PublicKey() != serverMasterKey {
+			h.Crypto.CrossSigningKeys = nil
+			return nil, ErrCrossSigningAlreadySetUp
+		}
+		log.Debug().Msg("Cross-signing keys from previous bootstrap attempt were already published, resuming")
+	} else {
+		if keys == nil {
+			log.Debug().Msg("Generating cross-signing keys")
+			keys, err = h.Crypto.GenerateCrossSigningKeys()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate cross-signing keys: %w", err)
+			}
+			err = h.Crypto.ImportCrossSigningKeys(crypto.CrossSigningSeeds{
+				MasterKey:      keys.MasterKey.Seed(),
+				SelfSigningKey: keys.SelfSigningKey.Seed(),
+				UserSigningKey: keys.UserSigningKey.Seed(),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to import cross-signing private keys: %w", err)
+			}
+			err = h.storeCrossSigningPrivateKeys(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to store cross-signing private keys: %w", err)
+			}
+		} else {
+			log.Debug().Msg("Reusing cross-signing keys from previous bootstrap attempt")
+		}
+		log.Debug().Msg("Publishing cross-signing keys")
+		err = h.Crypto.PublishCrossSigningKeys(ctx, keys, h.passwordUIACallback(password))
+		if err != nil {
+			return nil, fmt.Errorf("failed to publish cross-signing keys: %w", err)
+		}
+	}
+	// Secret storage is only set up after the cross-signing keys are published,
+	// so that failing user-interactive auth doesn't leave an orphaned default key behind.
+	log.Debug().Msg("Generating secret storage key")
+	ssssKey, err := h.Crypto.SSSS.GenerateAndUploadKey(ctx, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate and upload SSSS key: %w", err)
+	}
+	err = h.Crypto.SSSS.SetDefaultKeyID(ctx, ssssKey.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set default SSSS key: %w", err)
+	}
+	err = h.Crypto.UploadCrossSigningKeysToSSSS(ctx, ssssKey, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload cross-signing keys to SSSS: %w", err)
+	}
+	err = h.Crypto.SignOwnDevice(ctx, h.Crypto.OwnIdentity())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign own device: %w", err)
+	}
+	err = h.Crypto.SignOwnMasterKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign own master key: %w", err)
+	}
+	log.Debug().Msg("Creating key backup version")
+	err = h.createKeyBackup(ctx, ssssKey)
+	if err != nil {
+		return nil, err
+	}
+	err = h.markVerified(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &jsoncmd.RecoveryKeyResponse{
+		RecoveryKey: ssssKey.RecoveryKey(),
+	}, nil
+}
+
+// getServerMasterKey asks the server for the current master key of the user, bypassing the local device list cache.
+// An empty key is returned if the user doesn't have cross-signing keys.
+func (h *HiClient) getServerMasterKey(ctx context.Context) (id.Ed25519, error) {
+	resp, err := h.Client.QueryKeys(ctx, &mautrix.ReqQueryKeys{
+		DeviceKeys: mautrix.DeviceKeysRequest{h.Account.UserID: mautrix.DeviceIDList{}},
+	})
+	if err != nil {
+		return "", err
+	}
+	masterKeys, ok := resp.MasterKeys[h.Account.UserID]
+	if !ok {
+		return "", nil
+	}
+	for _, key := range masterKeys.Keys {
+		return key, nil
+	}
+	return "", nil
+}
+
+// createKeyBackup generates a new megolm backup key, creates a new key backup version on the server
+// and stores the private key in secret storage (encrypted with the given key) as well as the local crypto store.
+func (h *HiClient) createKeyBackup(ctx context.Context, ssssKey *ssss.Key) error {
+	key, err := backup.NewMegolmBackupKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate megolm backup key: %w", err)
+	}
+	version, err := h.createKeyBackupVersion(ctx, key)
+	if err != nil {
+		return err
+	}
+	err = h.Crypto.SSSS.SetEncryptedAccountData(ctx, event.AccountDataMegolmBackupKey, key.Bytes(), ssssKey)
+	if err != nil {
+		return fmt.Errorf("failed to store megolm backup key in SSSS: %w", err)
+	}
+	err = h.CryptoStore.PutSecret(ctx, id.SecretMegolmBackupV1, base64.StdEncoding.EncodeToString(key.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to store megolm backup key: %w", err)
+	}
+	h.KeyBackupKey = key
+	h.KeyBackupVersion = version
+	return nil
+}
+
+func (h *HiClient) createKeyBackupVersion(ctx context.Context, key *backup.MegolmBackupKey) (id.KeyBackupVersion, error) {
+	authData := backup.MegolmAuthData{
+		PublicKey: id.Ed25519(base64.RawStdEncoding.EncodeToString(key.PublicKey().Bytes())),
+	}
+	deviceSignature, err := h.Crypto.GetAccount().SignJSON(authData)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign backup auth data with device key: %w", err)
+	}
+	authData.Signatures = signatures.Signatures{
+		h.Account.UserID: {
+			id.NewKeyID(id.KeyAlgorithmEd25519, h.Account.DeviceID.String()): deviceSignature,
+		},
+	}
+	if h.Crypto.CrossSigningKeys != nil {
+		masterKey := h.Crypto.CrossSigningKeys.MasterKey
+		masterSignature, err := masterKey.SignJSON(backup.MegolmAuthData{PublicKey: authData.PublicKey})
+		if err != nil {
+			return "", fmt.Errorf("failed to sign backup auth data with master key: %w", err)
+		}
+		authData.Signatures[h.Account.UserID][id.NewKeyID(id.KeyAlgorithmEd25519, masterKey.PublicKey().String())] = masterSignature
+	}
+	resp, err := h.Client.CreateKeyBackupVersion(ctx, &mautrix.ReqRoomKeysVersionCreate[backup.MegolmAuthData]{
+		Algorithm: id.KeyBackupAlgorithmMegolmBackupV1,
+		AuthData:  authData,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create key backup version: %w", err)
+	}
+	return resp.Version, nil
+}
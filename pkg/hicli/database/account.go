@@ -26,6 +26,9 @@ const (
 			              homeserver_url = excluded.homeserver_url,
 			              next_batch = excluded.next_batch
 	`
+	// dbutil's TableExists checks all schemas on Postgres, but the account table
+	// must be in the schema of this database connection.
+	accountTableExistsQueryPostgres = `SELECT to_regclass('account') IS NOT NULL`
 )
 
 type AccountQuery struct {
@@ -34,7 +37,12 @@ type AccountQuery struct {
 
 func (aq *AccountQuery) GetFirstUserID(ctx context.Context) (userID id.UserID, err error) {
 	var exists bool
-	if exists, err = aq.GetDB().TableExists(ctx, "account"); err != nil || !exists {
+	if aq.GetDB().Dialect == dbutil.Postgres {
+		err = aq.GetDB().QueryRow(ctx, accountTableExistsQueryPostgres).Scan(&exists)
+	} else {
+		exists, err = aq.GetDB().TableExists(ctx, "account")
+	}
+	if err != nil || !exists {
 		return
 	}
 	err = aq.GetDB().QueryRow(ctx, `SELECT user_id FROM account LIMIT 1`).Scan(&userID)
@@ -29,7 +29,8 @@ const (
 	getGlobalAccountDataQuery = `
 		SELECT user_id, '', type, content FROM account_data WHERE user_id = $1
 	`
-	getRoomAccountDataQuery = `
+	getSpecificGlobalAccountDataQuery = getGlobalAccountDataQuery + `AND type = $2`
+	getRoomAccountDataQuery           = `
 		SELECT user_id, room_id, type, content FROM room_account_data WHERE user_id = $1 AND room_id = $2
 	`
 )
@@ -65,6 +66,10 @@ func (adq *AccountDataQuery) PutRoom(ctx context.Context, userID id.UserID, room
 	return ad, adq.Exec(ctx, upsertRoomAccountDataQuery, userID, roomID, eventType.Type, unsafeJSONString(content))
 }
 
+func (adq *AccountDataQuery) Get(ctx context.Context, userID id.UserID, eventType event.Type) (*AccountData, error) {
+	return adq.QueryOne(ctx, getSpecificGlobalAccountDataQuery, userID, eventType.Type)
+}
+
 func (adq *AccountDataQuery) GetAllGlobal(ctx context.Context, userID id.UserID) ([]*AccountData, error) {
 	return adq.QueryMany(ctx, getGlobalAccountDataQuery, userID)
 }
@@ -0,0 +1,211 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/exgjson"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Event types for MSC3489 live location sharing. Both the stable and unstable types are understood when receiving.
+var (
+	EventBeaconInfo         = event.Type{Type: "m.beacon_info", Class: event.StateEventType}
+	EventBeacon             = event.Type{Type: "m.beacon", Class: event.MessageEventType}
+	EventUnstableBeaconInfo = event.Type{Type: "org.matrix.msc3672.beacon_info", Class: event.StateEventType}
+	EventUnstableBeacon     = event.Type{Type: "org.matrix.msc3672.beacon", Class: event.MessageEventType}
+)
+
+const (
+	getBeaconBaseQuery = `
+		SELECT room_id, beacon_id, state_key, user_id, description, live, started_at, expires_at,
+		       location_event_id, geo_uri, location_ts
+		FROM beacon
+	`
+	getManyBeaconsQuery = getBeaconBaseQuery + `WHERE room_id = $1 AND beacon_id IN (%s)`
+	getLiveBeaconsQuery = getBeaconBaseQuery + `WHERE room_id = $1 AND live = true AND expires_at > $2 ORDER BY started_at`
+	insertBeaconQuery   = `
+		INSERT INTO beacon (room_id, beacon_id, state_key, user_id, description, live, started_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (room_id, beacon_id) DO NOTHING
+	`
+	// A new beacon_info event replaces the previous state, so older beacons with the same state key are no longer live.
+	endOtherBeaconsQuery = `
+		UPDATE beacon SET live = false
+		WHERE room_id = $1 AND state_key = $2 AND beacon_id <> $3 AND live = true
+		RETURNING beacon_id
+	`
+	updateBeaconLocationQuery = `
+		UPDATE beacon SET location_event_id = $4, geo_uri = $5, location_ts = $6
+		WHERE room_id = $1 AND beacon_id = $2 AND user_id = $3 AND (location_ts IS NULL OR location_ts < $6)
+		RETURNING beacon_id
+	`
+)
+
+var (
+	beaconLivePath          = exgjson.Path("live")
+	beaconDescriptionPath   = exgjson.Path("description")
+	beaconTimeoutPath       = exgjson.Path("timeout")
+	unstableLocationURIPath = exgjson.Path("org.matrix.msc3488.location", "uri")
+	stableLocationURIPath   = exgjson.Path("m.location", "uri")
+	unstableTimestampPath   = exgjson.Path("org.matrix.msc3488.ts")
+	stableTimestampPath     = exgjson.Path("m.ts")
+)
+
+var beaconIDScanner = dbutil.ConvertRowFn[id.EventID](dbutil.ScanSingleColumn[id.EventID])
+
+type BeaconQuery struct {
+	*dbutil.QueryHelper[*Beacon]
+}
+
+func (bq *BeaconQuery) GetMany(ctx context.Context, roomID id.RoomID, beaconIDs []id.EventID) ([]*Beacon, error) {
+	if len(beaconIDs) == 0 {
+		return []*Beacon{}, nil
+	}
+	query, params := buildMultiEventGetFunction([]any{roomID}, beaconIDs, getManyBeaconsQuery)
+	return bq.QueryMany(ctx, query, params...)
+}
+
+// GetLive returns the beacons in the room that are currently live and haven't expired.
+func (bq *BeaconQuery) GetLive(ctx context.Context, roomID id.RoomID) ([]*Beacon, error) {
+	return bq.QueryMany(ctx, getLiveBeaconsQuery, roomID, time.Now().UnixMilli())
+}
+
+// ProcessInfo saves the beacon started by the given beacon_info event and marks the previous beacons
+// with the same state key as ended. The IDs of all beacons that changed are returned.
+func (bq *BeaconQuery) ProcessInfo(ctx context.Context, evt *Event) ([]id.EventID, error) {
+	beacon := NewBeaconFromInfo(evt)
+	if beacon == nil {
+		return nil, nil
+	}
+	changed, err := beaconIDScanner.NewRowIter(
+		bq.GetDB().Query(ctx, endOtherBeaconsQuery, beacon.RoomID, beacon.StateKey, beacon.BeaconID),
+	).AsList()
+	if err != nil || !beacon.Live {
+		return changed, err
+	}
+	err = bq.Exec(
+		ctx, insertBeaconQuery, beacon.RoomID, beacon.BeaconID, beacon.StateKey, beacon.UserID,
+		dbutil.StrPtr(beacon.Description), beacon.Live, beacon.StartedAt.UnixMilli(), beacon.ExpiresAt.UnixMilli(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return append(changed, beacon.BeaconID), nil
+}
+
+// UpdateLocation stores the location in the given beacon event if it's newer than the previous location.
+// The ID of the updated beacon is returned, or an empty string if nothing was updated.
+func (bq *BeaconQuery) UpdateLocation(ctx context.Context, evt *Event) (id.EventID, error) {
+	geoURI, ts := evt.GetBeaconLocation()
+	if geoURI == "" {
+		return "", nil
+	}
+	var beaconID id.EventID
+	err := bq.GetDB().QueryRow(
+		ctx, updateBeaconLocationQuery, evt.RoomID, evt.RelatesTo, evt.Sender, evt.ID, geoURI, ts.UnixMilli(),
+	).Scan(&beaconID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return beaconID, err
+}
+
+// IsBeaconInfo returns true if the event is a beacon_info state event, which starts or stops a live location share.
+func (e *Event) IsBeaconInfo() bool {
+	return e.StateKey != nil && (e.Type == EventBeaconInfo.Type || e.Type == EventUnstableBeaconInfo.Type)
+}
+
+// IsBeacon returns true if the event is a location update referencing a beacon_info event.
+func (e *Event) IsBeacon() bool {
+	evtType, _ := e.getTypeAndContent()
+	return e.RelationType == event.RelReference && e.RelatesTo != "" &&
+		(evtType == EventBeacon.Type || evtType == EventUnstableBeacon.Type)
+}
+
+// GetBeaconLocation returns the geo URI and timestamp in a beacon event.
+// If the event doesn't contain a timestamp, the event timestamp is used instead.
+func (e *Event) GetBeaconLocation() (string, time.Time) {
+	_, content := e.getTypeAndContent()
+	geoURI := firstExisting(content, unstableLocationURIPath, stableLocationURIPath).Str
+	ts := firstExisting(content, unstableTimestampPath, stableTimestampPath).Int()
+	if ts == 0 {
+		return geoURI, e.Timestamp.Time
+	}
+	return geoURI, time.UnixMilli(ts)
+}
+
+// NewBeaconFromInfo parses a beacon_info state event. Redacted events and events without a timeout are treated
+// as ended beacons.
+func NewBeaconFromInfo(evt *Event) *Beacon {
+	if !evt.IsBeaconInfo() {
+		return nil
+	}
+	res := gjson.GetManyBytes(evt.Content, beaconLivePath, beaconDescriptionPath, beaconTimeoutPath)
+	startedAt := firstExisting(evt.Content, unstableTimestampPath, stableTimestampPath).Int()
+	if startedAt == 0 {
+		startedAt = evt.Timestamp.UnixMilli()
+	}
+	timeout := res[2].Int()
+	return &Beacon{
+		RoomID:      evt.RoomID,
+		BeaconID:    evt.ID,
+		StateKey:    *evt.StateKey,
+		UserID:      evt.Sender,
+		Description: res[1].Str,
+		Live:        res[0].Bool() && timeout > 0 && evt.RedactedBy == "",
+		StartedAt:   jsontime.UMInt(startedAt),
+		ExpiresAt:   jsontime.UMInt(startedAt + timeout),
+	}
+}
+
+// Beacon is the aggregated state of a live location share (MSC3489), including the latest location.
+type Beacon struct {
+	RoomID id.RoomID `json:"room_id"`
+	// BeaconID is the event ID of the beacon_info event that started the share.
+	BeaconID    id.EventID         `json:"beacon_id"`
+	StateKey    string             `json:"state_key"`
+	UserID      id.UserID          `json:"user_id"`
+	Description string             `json:"description,omitempty"`
+	Live        bool               `json:"live"`
+	StartedAt   jsontime.UnixMilli `json:"started_at"`
+	ExpiresAt   jsontime.UnixMilli `json:"expires_at"`
+
+	LocationEventID id.EventID         `json:"location_event_id,omitempty"`
+	GeoURI          string             `json:"geo_uri,omitempty"`
+	LocationTS      jsontime.UnixMilli `json:"location_ts,omitempty"`
+}
+
+func (b *Beacon) Scan(row dbutil.Scannable) (*Beacon, error) {
+	var description, locationEventID, geoURI sql.NullString
+	var startedAt, expiresAt int64
+	var locationTS sql.NullInt64
+	err := row.Scan(
+		&b.RoomID, &b.BeaconID, &b.StateKey, &b.UserID, &description, &b.Live, &startedAt, &expiresAt,
+		&locationEventID, &geoURI, &locationTS,
+	)
+	if err != nil {
+		return nil, err
+	}
+	b.Description = description.String
+	b.StartedAt = jsontime.UMInt(startedAt)
+	b.ExpiresAt = jsontime.UMInt(expiresAt)
+	b.LocationEventID = id.EventID(locationEventID.String)
+	b.GeoURI = geoURI.String
+	if locationTS.Valid {
+		b.LocationTS = jsontime.UMInt(locationTS.Int64)
+	}
+	return b, nil
+}
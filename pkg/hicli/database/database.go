@@ -27,6 +27,13 @@ type Database struct {
 	Media            *MediaQuery
 	SpaceEdge        *SpaceEdgeQuery
 	PushRegistration *PushRegistrationQuery
+	Presence         *PresenceQuery
+	Draft            *DraftQuery
+	Thread           *ThreadQuery
+	Outbox           *OutboxQuery
+	ScheduledMessage *ScheduledMessageQuery
+	Beacon           *BeaconQuery
+	LiveLocation     *LiveLocationShareQuery
 }
 
 func New(rawDB *dbutil.Database) *Database {
@@ -47,9 +54,24 @@ func New(rawDB *dbutil.Database) *Database {
 		Media:            &MediaQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newMedia)},
 		SpaceEdge:        &SpaceEdgeQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newSpaceEdge)},
 		PushRegistration: &PushRegistrationQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newPushRegistration)},
+		Presence:         &PresenceQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newPresence)},
+		Draft:            &DraftQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newDraft)},
+		Thread:           &ThreadQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newThread)},
+		Outbox:           &OutboxQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newOutboxEntry)},
+		ScheduledMessage: &ScheduledMessageQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newScheduledMessage)},
+		Beacon:           &BeaconQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newBeacon)},
+		LiveLocation:     &LiveLocationShareQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newLiveLocationShare)},
 	}
 }
 
+// dialectQuery returns postgresQuery if the database is Postgres and query otherwise.
+func dialectQuery(db *dbutil.Database, query, postgresQuery string) string {
+	if db.Dialect == dbutil.Postgres {
+		return postgresQuery
+	}
+	return query
+}
+
 func newSessionRequest(_ *dbutil.QueryHelper[*SessionRequest]) *SessionRequest {
 	return &SessionRequest{}
 }
@@ -89,3 +111,31 @@ func newSpaceEdge(_ *dbutil.QueryHelper[*SpaceEdge]) *SpaceEdge {
 func newPushRegistration(_ *dbutil.QueryHelper[*PushRegistration]) *PushRegistration {
 	return &PushRegistration{}
 }
+
+func newPresence(_ *dbutil.QueryHelper[*Presence]) *Presence {
+	return &Presence{}
+}
+
+func newDraft(_ *dbutil.QueryHelper[*Draft]) *Draft {
+	return &Draft{}
+}
+
+func newThread(_ *dbutil.QueryHelper[*Thread]) *Thread {
+	return &Thread{}
+}
+
+func newOutboxEntry(_ *dbutil.QueryHelper[*OutboxEntry]) *OutboxEntry {
+	return &OutboxEntry{}
+}
+
+func newScheduledMessage(_ *dbutil.QueryHelper[*ScheduledMessage]) *ScheduledMessage {
+	return &ScheduledMessage{}
+}
+
+func newBeacon(_ *dbutil.QueryHelper[*Beacon]) *Beacon {
+	return &Beacon{}
+}
+
+func newLiveLocationShare(_ *dbutil.QueryHelper[*LiveLocationShare]) *LiveLocationShare {
+	return &LiveLocationShare{}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getDraftBaseQuery = `
+		SELECT room_id, text, reply_to, extra, updated_at FROM draft
+	`
+	getDraftQuery     = getDraftBaseQuery + `WHERE room_id = $1`
+	getAllDraftsQuery = getDraftBaseQuery + `ORDER BY updated_at DESC`
+	upsertDraftQuery  = `
+		INSERT INTO draft (room_id, text, reply_to, extra, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (room_id) DO UPDATE SET
+			text = excluded.text,
+			reply_to = excluded.reply_to,
+			extra = excluded.extra,
+			updated_at = excluded.updated_at
+	`
+	deleteDraftQuery = `DELETE FROM draft WHERE room_id = $1`
+)
+
+type DraftQuery struct {
+	*dbutil.QueryHelper[*Draft]
+}
+
+func (dq *DraftQuery) Put(ctx context.Context, draft *Draft) error {
+	return dq.Exec(ctx, upsertDraftQuery, draft.sqlVariables()...)
+}
+
+func (dq *DraftQuery) Get(ctx context.Context, roomID id.RoomID) (*Draft, error) {
+	return dq.QueryOne(ctx, getDraftQuery, roomID)
+}
+
+func (dq *DraftQuery) GetAll(ctx context.Context) ([]*Draft, error) {
+	return dq.QueryMany(ctx, getAllDraftsQuery)
+}
+
+func (dq *DraftQuery) Delete(ctx context.Context, roomID id.RoomID) error {
+	return dq.Exec(ctx, deleteDraftQuery, roomID)
+}
+
+// Draft is an unsent message in the composer of a room.
+type Draft struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	Text    string     `json:"text"`
+	ReplyTo id.EventID `json:"reply_to,omitempty"`
+	// Extra contains any other composer state the frontend wants to persist (e.g. attached media).
+	// The backend doesn't parse it.
+	Extra     json.RawMessage    `json:"extra,omitempty"`
+	UpdatedAt jsontime.UnixMilli `json:"updated_at"`
+}
+
+// IsEmpty returns true if the draft has no content, i.e. it can be deleted instead of saved.
+func (d *Draft) IsEmpty() bool {
+	return d.Text == "" && d.ReplyTo == "" && len(d.Extra) == 0
+}
+
+func (d *Draft) Scan(row dbutil.Scannable) (*Draft, error) {
+	var replyTo, extra sql.NullString
+	var updatedAt int64
+	err := row.Scan(&d.RoomID, &d.Text, &replyTo, &extra, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.ReplyTo = id.EventID(replyTo.String)
+	if extra.Valid {
+		d.Extra = json.RawMessage(extra.String)
+	}
+	d.UpdatedAt = jsontime.UMInt(updatedAt)
+	return d, nil
+}
+
+func (d *Draft) sqlVariables() []any {
+	return []any{d.RoomID, d.Text, dbutil.StrPtr(d.ReplyTo), unsafeJSONString(d.Extra), d.UpdatedAt.UnixMilli()}
+}
@@ -10,6 +10,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
 	"strings"
@@ -28,7 +29,7 @@ const (
 		SELECT rowid, -1,
 		       room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type,
 		       unsigned, local_content, transaction_id, redacted_by, relates_to, relation_type,
-		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type
+		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, poll_tally
 		FROM event
 	`
 	getEventByRowID                  = getEventBaseQuery + `WHERE rowid = $1`
@@ -65,18 +66,42 @@ const (
 				unsigned=excluded.unsigned
 		RETURNING rowid
 	`
+	// Postgres doesn't support multiple conflict targets, so the transaction ID case is handled separately
+	// by updateEventByTransactionIDQuery before inserting.
+	upsertEventQueryPostgres = insertEventBaseQuery + `
+		ON CONFLICT (event_id) DO UPDATE
+			SET decrypted=COALESCE(event.decrypted, excluded.decrypted),
+			    decrypted_type=COALESCE(event.decrypted_type, excluded.decrypted_type),
+			    redacted_by=COALESCE(event.redacted_by, excluded.redacted_by),
+			    decryption_error=CASE WHEN COALESCE(event.decrypted, excluded.decrypted) IS NULL THEN COALESCE(excluded.decryption_error, event.decryption_error) END,
+			    send_error=excluded.send_error,
+				timestamp=excluded.timestamp,
+				unsigned=COALESCE(excluded.unsigned, event.unsigned),
+				local_content=COALESCE(excluded.local_content, event.local_content)
+		RETURNING rowid
+	`
+	updateEventByTransactionIDQuery = `
+		UPDATE event SET event_id=$2, timestamp=$3, unsigned=$4
+		WHERE transaction_id=$1 AND NOT EXISTS(SELECT 1 FROM event existing WHERE existing.event_id=$2)
+		RETURNING rowid
+	`
 	updateEventSendErrorQuery        = `UPDATE event SET send_error = $2 WHERE rowid = $1`
 	updateEventIDQuery               = `UPDATE event SET event_id = $2, send_error = NULL WHERE rowid=$1`
 	updateEventDecryptedQuery        = `UPDATE event SET decrypted = $2, decrypted_type = $3, decryption_error = NULL, unread_type = $4, local_content = $5 WHERE rowid = $1`
 	updateEventLocalContentQuery     = `UPDATE event SET local_content = $2 WHERE rowid = $1`
 	updateEventEncryptedContentQuery = `UPDATE event SET content = $2, megolm_session_id = $3 WHERE rowid = $1`
 	getEventReactionsQuery           = getEventBaseQuery + `
-		WHERE room_id = ?
+		WHERE room_id = $1
 		  AND type = 'm.reaction'
 		  AND relation_type = 'm.annotation'
 		  AND redacted_by IS NULL
 		  AND relates_to IN (%s)
 	`
+	// Edits are validated the same way as in the last edit trigger, but redacted edits are included too.
+	getEditHistoryQuery = getEventBaseQuery + `
+		WHERE room_id = $1 AND relates_to = $2 AND relation_type = 'm.replace' AND type = $3 AND sender = $4
+		ORDER BY timestamp, rowid
+	`
 	getEventEditRowIDsQuery = `
 		SELECT main.event_id, edit.rowid
 		FROM event main
@@ -87,7 +112,7 @@ const (
 		AND edit.type = main.type
 		AND edit.sender = main.sender
 		AND edit.redacted_by IS NULL
-		WHERE main.event_id IN (%s)
+		WHERE main.room_id = $1 AND main.event_id IN (%s)
 		ORDER BY main.event_id, edit.timestamp
 	`
 	setLastEditRowIDQuery = `
@@ -120,13 +145,34 @@ func (eq *EventQuery) GetRelatedEvents(ctx context.Context, roomID id.RoomID, ev
 	return eq.QueryMany(ctx, getRelatedEventsQuery, roomID, eventID, relationType)
 }
 
+// GetEditHistory returns all edits of the given event, oldest first. Redacted edits are included.
+func (eq *EventQuery) GetEditHistory(ctx context.Context, evt *Event) ([]*Event, error) {
+	return eq.QueryMany(ctx, getEditHistoryQuery, evt.RoomID, evt.ID, evt.Type, evt.Sender)
+}
+
 func (eq *EventQuery) GetByRowIDs(ctx context.Context, rowIDs ...EventRowID) ([]*Event, error) {
 	query, params := buildMultiEventGetFunction(nil, rowIDs, getManyEventsByRowID)
 	return eq.QueryMany(ctx, query, params...)
 }
 
 func (eq *EventQuery) Upsert(ctx context.Context, evt *Event) (rowID EventRowID, err error) {
-	err = eq.GetDB().QueryRow(ctx, upsertEventQuery, evt.sqlVariables()...).Scan(&rowID)
+	query := upsertEventQuery
+	if eq.GetDB().Dialect == dbutil.Postgres {
+		query = upsertEventQueryPostgres
+		if evt.TransactionID != "" {
+			err = eq.GetDB().QueryRow(
+				ctx, updateEventByTransactionIDQuery,
+				evt.TransactionID, evt.ID, evt.Timestamp.UnixMilli(), unsafeJSONString(evt.Unsigned),
+			).Scan(&rowID)
+			if err == nil {
+				evt.RowID = rowID
+				return
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return
+			}
+		}
+	}
+	err = eq.GetDB().QueryRow(ctx, query, evt.sqlVariables()...).Scan(&rowID)
 	if err == nil {
 		evt.RowID = rowID
 	}
@@ -141,9 +187,19 @@ func (eq *EventQuery) Insert(ctx context.Context, evt *Event) (rowID EventRowID,
 	return
 }
 
+const (
+	stateEventMassInsertPlaceholders = "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
+	stateEventMassInsertValues       = "($1, $%d, $%d, $%d, $%d, $%d, $%d, NULL, NULL, $%d, NULL, $%d, $%d, NULL, NULL, NULL, NULL, NULL, '{}', 0, 0)"
+)
+
 var stateEventMassInserter = dbutil.NewMassInsertBuilder[*Event, [1]any](
-	strings.ReplaceAll(upsertEventQuery, "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)", "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"),
-	"($1, $%d, $%d, $%d, $%d, $%d, $%d, NULL, NULL, $%d, NULL, $%d, $%d, NULL, NULL, NULL, NULL, NULL, '{}', 0, 0)",
+	strings.ReplaceAll(upsertEventQuery, "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)", stateEventMassInsertPlaceholders),
+	stateEventMassInsertValues,
+)
+
+var stateEventMassInserterPostgres = dbutil.NewMassInsertBuilder[*Event, [1]any](
+	strings.ReplaceAll(upsertEventQueryPostgres, "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)", stateEventMassInsertPlaceholders),
+	stateEventMassInsertValues,
 )
 
 var massInsertConverter = dbutil.ConvertRowFn[EventRowID](dbutil.ScanSingleColumn[EventRowID])
@@ -157,8 +213,12 @@ func (e *Event) GetMassInsertValues() [9]any {
 }
 
 func (eq *EventQuery) MassUpsertState(ctx context.Context, evts []*Event) error {
+	inserter := stateEventMassInserter
+	if eq.GetDB().Dialect == dbutil.Postgres {
+		inserter = stateEventMassInserterPostgres
+	}
 	for chunk := range slices.Chunk(evts, 500) {
-		query, params := stateEventMassInserter.Build([1]any{chunk[0].RoomID}, chunk)
+		query, params := inserter.Build([1]any{chunk[0].RoomID}, chunk)
 		i := 0
 		err := massInsertConverter.
 			NewRowIter(eq.GetDB().Query(ctx, query, params...)).
@@ -272,9 +332,11 @@ func buildMultiEventGetFunction[T any](preParams []any, eventIDs []T, query stri
 	for i, evtID := range eventIDs {
 		params[i+len(preParams)] = evtID
 	}
-	placeholders := strings.Repeat("?,", len(eventIDs))
-	placeholders = placeholders[:len(placeholders)-1]
-	return fmt.Sprintf(query, placeholders), params
+	placeholders := make([]string, len(eventIDs))
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+len(preParams)+1)
+	}
+	return fmt.Sprintf(query, strings.Join(placeholders, ",")), params
 }
 
 type editRowIDTuple struct {
@@ -378,6 +440,7 @@ type Event struct {
 	Reactions     map[string]int `json:"reactions,omitempty"`
 	LastEditRowID *EventRowID    `json:"last_edit_rowid,omitempty"`
 	UnreadType    UnreadType     `json:"unread_type,omitempty"`
+	PollTally     *PollTally     `json:"poll_tally,omitempty"`
 }
 
 func MautrixToEvent(evt *event.Event) *Event {
@@ -457,6 +520,7 @@ func (e *Event) Scan(row dbutil.Scannable) (*Event, error) {
 		dbutil.JSON{Data: &e.Reactions},
 		&e.LastEditRowID,
 		&e.UnreadType,
+		dbutil.JSON{Data: &e.PollTally},
 	)
 	if err != nil {
 		return nil, err
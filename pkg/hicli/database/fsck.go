@@ -0,0 +1,432 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	fsckGetRoomsQuery = `
+		SELECT room_id, COALESCE(preview_event_rowid, 0), unread_highlights, unread_notifications, unread_messages
+		FROM room
+		ORDER BY room_id
+	`
+	fsckGetStoredThreadUnreadsQuery = `
+		SELECT thread_root, unread_highlights, unread_notifications, unread_messages
+		FROM thread
+		WHERE room_id = $1 AND (unread_highlights > 0 OR unread_notifications > 0 OR unread_messages > 0)
+	`
+	fsckGetReactionCountsQuery = `
+		SELECT rowid, room_id, event_id, reactions, (
+			SELECT json_group_object(reaction_key, reaction_count)
+			FROM (
+				SELECT reaction.content ->> '$."m.relates_to".key' AS reaction_key, COUNT(*) AS reaction_count
+				FROM event reaction
+				WHERE reaction.room_id = event.room_id
+				  AND reaction.relates_to = event.event_id
+				  AND reaction.type = 'm.reaction'
+				  AND reaction.relation_type = 'm.annotation'
+				  AND reaction.redacted_by IS NULL
+				  AND typeof(reaction.content ->> '$."m.relates_to".key') = 'text'
+				GROUP BY 1
+			) reaction_counts
+		)
+		FROM event
+		WHERE reactions IS NOT NULL
+	`
+	fsckGetReactionCountsQueryPostgres = `
+		SELECT rowid, room_id, event_id, reactions, (
+			SELECT COALESCE(json_object_agg(reaction_key, reaction_count), '{}')
+			FROM (
+				SELECT safe_json_string(reaction.content, 'm.relates_to', 'key') AS reaction_key, COUNT(*) AS reaction_count
+				FROM event reaction
+				WHERE reaction.room_id = event.room_id
+				  AND reaction.relates_to = event.event_id
+				  AND reaction.type = 'm.reaction'
+				  AND reaction.relation_type = 'm.annotation'
+				  AND reaction.redacted_by IS NULL
+				GROUP BY 1
+			) reaction_counts
+			WHERE reaction_key IS NOT NULL
+		)
+		FROM event
+		WHERE reactions IS NOT NULL
+	`
+	// This must match the event_insert_update_last_edit and event_update_last_edit_when_redacted triggers
+	fsckGetLastEditMismatchesQuery = `
+		SELECT rowid, room_id, event_id, stored, expected
+		FROM (
+			SELECT rowid, room_id, event_id, COALESCE(last_edit_rowid, 0) AS stored, COALESCE(
+				(SELECT edit.rowid
+				 FROM event edit
+				 WHERE edit.room_id = event.room_id
+				   AND edit.relates_to = event.event_id
+				   AND edit.relation_type = 'm.replace'
+				   AND edit.type = event.type
+				   AND edit.sender = event.sender
+				   AND edit.redacted_by IS NULL
+				   AND edit.state_key IS NULL
+				 ORDER BY edit.timestamp DESC
+				 LIMIT 1),
+				0) AS expected
+			FROM event
+			WHERE state_key IS NULL
+			  AND (relation_type IS NULL OR relation_type NOT IN ('m.replace', 'm.annotation'))
+		) edits
+		WHERE stored <> expected
+	`
+	fsckGetSpaceEdgeMismatchesQuery = `
+		SELECT space_id, child_id, parent_event_rowid IS NULL AND child_event_rowid IS NULL, parent_validated, expected
+		FROM (
+			SELECT space_id, child_id, parent_event_rowid, child_event_rowid, parent_validated,
+			       CASE WHEN parent_event_rowid IS NULL THEN false ELSE (` + spaceParentValidQuery + `) END AS expected
+			FROM space_edge
+		) edges
+		WHERE parent_validated <> expected OR (parent_event_rowid IS NULL AND child_event_rowid IS NULL)
+		ORDER BY space_id, child_id
+	`
+	fsckSetPreviewQuery            = `UPDATE room SET preview_event_rowid = $2 WHERE room_id = $1`
+	fsckSetRoomUnreadsQuery        = `UPDATE room SET unread_highlights = $2, unread_notifications = $3, unread_messages = $4 WHERE room_id = $1`
+	fsckSetReactionsQuery          = `UPDATE event SET reactions = $2 WHERE rowid = $1`
+	fsckSetLastEditRowIDQuery      = `UPDATE event SET last_edit_rowid = $2 WHERE rowid = $1`
+	fsckSetSpaceEdgeValidatedQuery = `UPDATE space_edge SET parent_validated = $3 WHERE space_id = $1 AND child_id = $2`
+)
+
+var fsckGetSpaceEdgeMismatchesQueryPostgres = toPostgresSpaceQuery(fsckGetSpaceEdgeMismatchesQuery)
+
+type FsckCheck string
+
+const (
+	FsckCheckPreview       FsckCheck = "preview"
+	FsckCheckUnreads       FsckCheck = "unreads"
+	FsckCheckThreadUnreads FsckCheck = "thread_unreads"
+	FsckCheckReactions     FsckCheck = "reactions"
+	FsckCheckLastEdit      FsckCheck = "last_edit"
+	FsckCheckSpaceEdge     FsckCheck = "space_edge"
+)
+
+// FsckMismatch is a difference between a value stored in the database and the value recalculated from events.
+type FsckMismatch struct {
+	Check  FsckCheck `json:"check"`
+	RoomID id.RoomID `json:"room_id"`
+	// Target identifies the specific thing inside the room that differed, e.g. an event ID, a thread root
+	// or the child room ID of a space edge. It's empty for room-level values.
+	Target   string `json:"target,omitempty"`
+	Stored   string `json:"stored"`
+	Expected string `json:"expected"`
+
+	fix func(ctx context.Context) error
+}
+
+func (fm *FsckMismatch) String() string {
+	if fm.Target != "" {
+		return fmt.Sprintf("[%s] %s %s: stored %s, expected %s", fm.Check, fm.RoomID, fm.Target, fm.Stored, fm.Expected)
+	}
+	return fmt.Sprintf("[%s] %s: stored %s, expected %s", fm.Check, fm.RoomID, fm.Stored, fm.Expected)
+}
+
+func formatUnreadCounts(uc UnreadCounts) string {
+	return fmt.Sprintf("%d/%d/%d", uc.UnreadHighlights, uc.UnreadNotifications, uc.UnreadMessages)
+}
+
+type fsckRoom struct {
+	RoomID            id.RoomID
+	PreviewEventRowID EventRowID
+	UnreadCounts
+}
+
+var fsckRoomScanner = dbutil.ConvertRowFn[fsckRoom](func(row dbutil.Scannable) (room fsckRoom, err error) {
+	err = row.Scan(&room.RoomID, &room.PreviewEventRowID, &room.UnreadHighlights, &room.UnreadNotifications, &room.UnreadMessages)
+	return
+})
+
+// Fsck recalculates the values that are normally maintained incrementally (room previews, unread counts,
+// reaction counts, last edit pointers and space edge validity) and returns the ones that differ from
+// what's stored in the database. If fix is true, the stored values are replaced with the recalculated ones.
+//
+// Unread counts are calculated for the given user ID, which should be the user who's logged in.
+// gomuks should not be running while this is called, as it may change the same values concurrently.
+func (db *Database) Fsck(ctx context.Context, userID id.UserID, fix bool) ([]*FsckMismatch, error) {
+	var mismatches []*FsckMismatch
+	checks := []func(context.Context, id.UserID) ([]*FsckMismatch, error){
+		db.fsckRooms,
+		db.fsckReactions,
+		db.fsckLastEdits,
+		db.fsckSpaceEdges,
+	}
+	for _, check := range checks {
+		found, err := check(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, found...)
+	}
+	if !fix || len(mismatches) == 0 {
+		return mismatches, nil
+	}
+	return mismatches, db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, mismatch := range mismatches {
+			err := mismatch.fix(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to fix %s: %w", mismatch, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *Database) fsckRooms(ctx context.Context, userID id.UserID) ([]*FsckMismatch, error) {
+	rooms, err := fsckRoomScanner.NewRowIter(db.Query(ctx, fsckGetRoomsQuery)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rooms: %w", err)
+	}
+	var mismatches []*FsckMismatch
+	for _, room := range rooms {
+		found, err := db.fsckRoom(ctx, room, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", room.RoomID, err)
+		}
+		mismatches = append(mismatches, found...)
+	}
+	return mismatches, nil
+}
+
+func (db *Database) fsckRoom(ctx context.Context, room fsckRoom, userID id.UserID) ([]*FsckMismatch, error) {
+	var mismatches []*FsckMismatch
+	roomID := room.RoomID
+	expectedPreview, err := db.Room.RecalculatePreview(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recalculate preview: %w", err)
+	} else if expectedPreview != room.PreviewEventRowID {
+		mismatches = append(mismatches, &FsckMismatch{
+			Check:    FsckCheckPreview,
+			RoomID:   roomID,
+			Stored:   strconv.FormatInt(int64(room.PreviewEventRowID), 10),
+			Expected: strconv.FormatInt(int64(expectedPreview), 10),
+			fix: func(ctx context.Context) error {
+				return db.Exec(ctx, fsckSetPreviewQuery, roomID, dbutil.NumPtr(expectedPreview))
+			},
+		})
+	}
+
+	threadUnreads, err := db.Room.CalculateThreadUnreads(ctx, roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recalculate unread counts: %w", err)
+	}
+	var expectedUnreads UnreadCounts
+	for _, counts := range threadUnreads {
+		expectedUnreads.Add(counts)
+	}
+	if expectedUnreads != room.UnreadCounts {
+		mismatches = append(mismatches, &FsckMismatch{
+			Check:    FsckCheckUnreads,
+			RoomID:   roomID,
+			Stored:   formatUnreadCounts(room.UnreadCounts),
+			Expected: formatUnreadCounts(expectedUnreads),
+			fix: func(ctx context.Context) error {
+				return db.Exec(
+					ctx, fsckSetRoomUnreadsQuery, roomID,
+					expectedUnreads.UnreadHighlights, expectedUnreads.UnreadNotifications, expectedUnreads.UnreadMessages,
+				)
+			},
+		})
+	}
+
+	storedThreadUnreads, err := dbutil.RowIterAsMap(
+		threadUnreadCountsScanner.NewRowIter(db.Query(ctx, fsckGetStoredThreadUnreadsQuery, roomID)),
+		func(tuc threadUnreadCounts) (id.EventID, UnreadCounts) {
+			return tuc.ThreadRoot, tuc.UnreadCounts
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stored thread unread counts: %w", err)
+	}
+	// The main timeline is only counted in the room, and threads without unreads are simply not stored.
+	delete(threadUnreads, "")
+	maps.DeleteFunc(threadUnreads, func(_ id.EventID, uc UnreadCounts) bool {
+		return uc.IsZero()
+	})
+	threadsFixed := false
+	fixThreads := func(ctx context.Context) error {
+		if threadsFixed {
+			return nil
+		}
+		threadsFixed = true
+		// Make sure the summaries exist, as SetUnreads only updates existing threads
+		for threadRoot := range threadUnreads {
+			err := db.Thread.Recalculate(ctx, roomID, threadRoot, userID)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := db.Thread.SetUnreads(ctx, roomID, threadUnreads)
+		return err
+	}
+	for threadRoot, expected := range threadUnreads {
+		if stored := storedThreadUnreads[threadRoot]; stored != expected {
+			mismatches = append(mismatches, &FsckMismatch{
+				Check:    FsckCheckThreadUnreads,
+				RoomID:   roomID,
+				Target:   threadRoot.String(),
+				Stored:   formatUnreadCounts(stored),
+				Expected: formatUnreadCounts(expected),
+				fix:      fixThreads,
+			})
+		}
+	}
+	for threadRoot, stored := range storedThreadUnreads {
+		if _, ok := threadUnreads[threadRoot]; !ok {
+			mismatches = append(mismatches, &FsckMismatch{
+				Check:    FsckCheckThreadUnreads,
+				RoomID:   roomID,
+				Target:   threadRoot.String(),
+				Stored:   formatUnreadCounts(stored),
+				Expected: formatUnreadCounts(UnreadCounts{}),
+				fix:      fixThreads,
+			})
+		}
+	}
+	return mismatches, nil
+}
+
+type fsckReactionCounts struct {
+	RowID    EventRowID
+	RoomID   id.RoomID
+	EventID  id.EventID
+	Stored   string
+	Expected string
+}
+
+var fsckReactionCountsScanner = dbutil.ConvertRowFn[fsckReactionCounts](func(row dbutil.Scannable) (rc fsckReactionCounts, err error) {
+	err = row.Scan(&rc.RowID, &rc.RoomID, &rc.EventID, &rc.Stored, &rc.Expected)
+	return
+})
+
+func parseReactionCounts(data string) (map[string]int, error) {
+	var counts map[string]int
+	err := json.Unmarshal([]byte(data), &counts)
+	// The redaction trigger only decrements counts, so keys with zero reactions are left behind
+	maps.DeleteFunc(counts, func(_ string, count int) bool {
+		return count == 0
+	})
+	return counts, err
+}
+
+func (db *Database) fsckReactions(ctx context.Context, _ id.UserID) ([]*FsckMismatch, error) {
+	var mismatches []*FsckMismatch
+	query := dialectQuery(db.Database, fsckGetReactionCountsQuery, fsckGetReactionCountsQueryPostgres)
+	err := fsckReactionCountsScanner.NewRowIter(db.Query(ctx, query)).Iter(func(rc fsckReactionCounts) (bool, error) {
+		expected, err := parseReactionCounts(rc.Expected)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse recalculated reaction counts of %s: %w", rc.EventID, err)
+		}
+		// Invalid JSON is a mismatch too, so the error is ignored here
+		stored, _ := parseReactionCounts(rc.Stored)
+		if !maps.Equal(stored, expected) {
+			mismatches = append(mismatches, &FsckMismatch{
+				Check:    FsckCheckReactions,
+				RoomID:   rc.RoomID,
+				Target:   rc.EventID.String(),
+				Stored:   rc.Stored,
+				Expected: rc.Expected,
+				fix: func(ctx context.Context) error {
+					return db.Exec(ctx, fsckSetReactionsQuery, rc.RowID, rc.Expected)
+				},
+			})
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check reaction counts: %w", err)
+	}
+	return mismatches, nil
+}
+
+type fsckLastEdit struct {
+	RowID    EventRowID
+	RoomID   id.RoomID
+	EventID  id.EventID
+	Stored   EventRowID
+	Expected EventRowID
+}
+
+var fsckLastEditScanner = dbutil.ConvertRowFn[fsckLastEdit](func(row dbutil.Scannable) (le fsckLastEdit, err error) {
+	err = row.Scan(&le.RowID, &le.RoomID, &le.EventID, &le.Stored, &le.Expected)
+	return
+})
+
+func (db *Database) fsckLastEdits(ctx context.Context, _ id.UserID) ([]*FsckMismatch, error) {
+	edits, err := fsckLastEditScanner.NewRowIter(db.Query(ctx, fsckGetLastEditMismatchesQuery)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check last edits: %w", err)
+	}
+	mismatches := make([]*FsckMismatch, len(edits))
+	for i, le := range edits {
+		mismatches[i] = &FsckMismatch{
+			Check:    FsckCheckLastEdit,
+			RoomID:   le.RoomID,
+			Target:   le.EventID.String(),
+			Stored:   strconv.FormatInt(int64(le.Stored), 10),
+			Expected: strconv.FormatInt(int64(le.Expected), 10),
+			fix: func(ctx context.Context) error {
+				return db.Exec(ctx, fsckSetLastEditRowIDQuery, le.RowID, le.Expected)
+			},
+		}
+	}
+	return mismatches, nil
+}
+
+type fsckSpaceEdge struct {
+	SpaceID         id.RoomID
+	ChildID         id.RoomID
+	Empty           bool
+	ParentValidated bool
+	Expected        bool
+}
+
+var fsckSpaceEdgeScanner = dbutil.ConvertRowFn[fsckSpaceEdge](func(row dbutil.Scannable) (se fsckSpaceEdge, err error) {
+	err = row.Scan(&se.SpaceID, &se.ChildID, &se.Empty, &se.ParentValidated, &se.Expected)
+	return
+})
+
+func (db *Database) fsckSpaceEdges(ctx context.Context, _ id.UserID) ([]*FsckMismatch, error) {
+	query := dialectQuery(db.Database, fsckGetSpaceEdgeMismatchesQuery, fsckGetSpaceEdgeMismatchesQueryPostgres)
+	edges, err := fsckSpaceEdgeScanner.NewRowIter(db.Query(ctx, query)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check space edges: %w", err)
+	}
+	mismatches := make([]*FsckMismatch, len(edges))
+	for i, se := range edges {
+		mismatches[i] = &FsckMismatch{
+			Check:  FsckCheckSpaceEdge,
+			RoomID: se.SpaceID,
+			Target: se.ChildID.String(),
+		}
+		if se.Empty {
+			mismatches[i].Stored = "edge without events"
+			mismatches[i].Expected = "no edge"
+			mismatches[i].fix = func(ctx context.Context) error {
+				return db.Exec(ctx, deleteEmptySpaceEdgeRowsQuery)
+			}
+		} else {
+			mismatches[i].Stored = fmt.Sprintf("parent_validated=%t", se.ParentValidated)
+			mismatches[i].Expected = fmt.Sprintf("parent_validated=%t", se.Expected)
+			mismatches[i].fix = func(ctx context.Context) error {
+				return db.Exec(ctx, fsckSetSpaceEdgeValidatedQuery, se.SpaceID, se.ChildID, se.Expected)
+			}
+		}
+	}
+	return mismatches, nil
+}
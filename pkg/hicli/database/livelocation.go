@@ -0,0 +1,138 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getLiveLocationShareBaseQuery = `
+		SELECT room_id, beacon_id, description, started_at, expires_at, geo_uri, updated_at, last_sent_at
+		FROM live_location_share
+	`
+	getLiveLocationShareQuery     = getLiveLocationShareBaseQuery + `WHERE room_id = $1`
+	getAllLiveLocationSharesQuery = getLiveLocationShareBaseQuery + `ORDER BY started_at`
+	upsertLiveLocationShareQuery  = `
+		INSERT INTO live_location_share (room_id, beacon_id, description, started_at, expires_at, geo_uri, updated_at, last_sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (room_id) DO UPDATE
+			SET beacon_id = excluded.beacon_id,
+			    description = excluded.description,
+			    started_at = excluded.started_at,
+			    expires_at = excluded.expires_at,
+			    geo_uri = excluded.geo_uri,
+			    updated_at = excluded.updated_at,
+			    last_sent_at = excluded.last_sent_at
+	`
+	getExpiredLiveLocationSharesQuery = getLiveLocationShareBaseQuery + `WHERE expires_at <= $1 ORDER BY started_at`
+	updateLiveLocationQuery           = `UPDATE live_location_share SET geo_uri = $1, updated_at = $2`
+	markLiveLocationSentQuery         = `UPDATE live_location_share SET last_sent_at = $3 WHERE room_id = $1 AND beacon_id = $2`
+	deleteLiveLocationShareQuery      = `DELETE FROM live_location_share WHERE room_id = $1`
+	deleteLiveLocationShareByIDQuery  = `DELETE FROM live_location_share WHERE room_id = $1 AND beacon_id = $2`
+)
+
+type LiveLocationShareQuery struct {
+	*dbutil.QueryHelper[*LiveLocationShare]
+}
+
+func (llsq *LiveLocationShareQuery) Get(ctx context.Context, roomID id.RoomID) (*LiveLocationShare, error) {
+	return llsq.QueryOne(ctx, getLiveLocationShareQuery, roomID)
+}
+
+func (llsq *LiveLocationShareQuery) GetAll(ctx context.Context) ([]*LiveLocationShare, error) {
+	return llsq.QueryMany(ctx, getAllLiveLocationSharesQuery)
+}
+
+func (llsq *LiveLocationShareQuery) Put(ctx context.Context, share *LiveLocationShare) error {
+	return llsq.Exec(ctx, upsertLiveLocationShareQuery, share.sqlVariables()...)
+}
+
+// UpdateLocation sets the current location of all active shares. The location is sent by the beacon loop.
+func (llsq *LiveLocationShareQuery) UpdateLocation(ctx context.Context, geoURI string, ts time.Time) error {
+	return llsq.Exec(ctx, updateLiveLocationQuery, geoURI, ts.UnixMilli())
+}
+
+func (llsq *LiveLocationShareQuery) MarkSent(ctx context.Context, share *LiveLocationShare) error {
+	return llsq.Exec(ctx, markLiveLocationSentQuery, share.RoomID, share.BeaconID, share.LastSentAt.UnixMilli())
+}
+
+func (llsq *LiveLocationShareQuery) Delete(ctx context.Context, roomID id.RoomID) error {
+	return llsq.Exec(ctx, deleteLiveLocationShareQuery, roomID)
+}
+
+// DeleteByBeaconID deletes the share only if it's still the one that started the given beacon.
+func (llsq *LiveLocationShareQuery) DeleteByBeaconID(ctx context.Context, roomID id.RoomID, beaconID id.EventID) error {
+	return llsq.Exec(ctx, deleteLiveLocationShareByIDQuery, roomID, beaconID)
+}
+
+// GetExpired returns the shares whose timeout has passed. They should be deleted after the share is ended in the room.
+func (llsq *LiveLocationShareQuery) GetExpired(ctx context.Context, now time.Time) ([]*LiveLocationShare, error) {
+	return llsq.QueryMany(ctx, getExpiredLiveLocationSharesQuery, now.UnixMilli())
+}
+
+// LiveLocationShare is a live location share started by this client. The beacon loop sends the latest location
+// to the room and ends the share after it expires, even if no frontend is connected.
+type LiveLocationShare struct {
+	RoomID id.RoomID `json:"room_id"`
+	// BeaconID is the event ID of the beacon_info event that started the share.
+	BeaconID    id.EventID         `json:"beacon_id"`
+	Description string             `json:"description,omitempty"`
+	StartedAt   jsontime.UnixMilli `json:"started_at"`
+	ExpiresAt   jsontime.UnixMilli `json:"expires_at"`
+	// GeoURI is the latest location reported by a frontend, and UpdatedAt is when it was reported.
+	GeoURI     string             `json:"geo_uri,omitempty"`
+	UpdatedAt  jsontime.UnixMilli `json:"updated_at,omitempty"`
+	LastSentAt jsontime.UnixMilli `json:"last_sent_at,omitempty"`
+}
+
+func (lls *LiveLocationShare) Scan(row dbutil.Scannable) (*LiveLocationShare, error) {
+	var description, geoURI sql.NullString
+	var startedAt, expiresAt int64
+	var updatedAt, lastSentAt sql.NullInt64
+	err := row.Scan(
+		&lls.RoomID, &lls.BeaconID, &description, &startedAt, &expiresAt, &geoURI, &updatedAt, &lastSentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	lls.Description = description.String
+	lls.StartedAt = jsontime.UMInt(startedAt)
+	lls.ExpiresAt = jsontime.UMInt(expiresAt)
+	lls.GeoURI = geoURI.String
+	if updatedAt.Valid {
+		lls.UpdatedAt = jsontime.UMInt(updatedAt.Int64)
+	}
+	if lastSentAt.Valid {
+		lls.LastSentAt = jsontime.UMInt(lastSentAt.Int64)
+	}
+	return lls, nil
+}
+
+// HasUnsentLocation returns true if a frontend has reported a location that hasn't been sent to the room yet.
+func (lls *LiveLocationShare) HasUnsentLocation() bool {
+	return lls.GeoURI != "" && lls.UpdatedAt.After(lls.LastSentAt.Time)
+}
+
+func (lls *LiveLocationShare) sqlVariables() []any {
+	return []any{
+		lls.RoomID,
+		lls.BeaconID,
+		dbutil.StrPtr(lls.Description),
+		lls.StartedAt.UnixMilli(),
+		lls.ExpiresAt.UnixMilli(),
+		dbutil.StrPtr(lls.GeoURI),
+		dbutil.UnixMilliPtr(lls.UpdatedAt.Time),
+		dbutil.UnixMilliPtr(lls.LastSentAt.Time),
+	}
+}
@@ -23,13 +23,13 @@ import (
 
 const (
 	insertMediaQuery = `
-		INSERT INTO media (mxc, enc_file, file_name, mime_type, size, hash, error, thumbnail_size, thumbnail_hash, thumbnail_error)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO media (mxc, enc_file, file_name, mime_type, size, hash, error, thumbnail_size, thumbnail_hash, thumbnail_error, last_accessed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		ON CONFLICT (mxc) DO NOTHING
 	`
 	upsertMediaQuery = `
-		INSERT INTO media (mxc, enc_file, file_name, mime_type, size, hash, error, thumbnail_size, thumbnail_hash, thumbnail_error)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO media (mxc, enc_file, file_name, mime_type, size, hash, error, thumbnail_size, thumbnail_hash, thumbnail_error, last_accessed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		ON CONFLICT (mxc) DO UPDATE
 			SET enc_file = COALESCE(excluded.enc_file, media.enc_file),
 				file_name = COALESCE(excluded.file_name, media.file_name),
@@ -39,14 +39,57 @@ const (
 				error = excluded.error,
 				thumbnail_size = COALESCE(excluded.thumbnail_size, media.thumbnail_size),
 				thumbnail_hash = COALESCE(excluded.thumbnail_hash, media.thumbnail_hash),
-				thumbnail_error = excluded.thumbnail_error
+				thumbnail_error = excluded.thumbnail_error,
+				last_accessed = COALESCE(excluded.last_accessed, media.last_accessed)
 			WHERE excluded.error IS NULL OR media.hash IS NULL
 	`
-	getMediaQuery = `
-		SELECT mxc, enc_file, file_name, mime_type, size, hash, error, thumbnail_size, thumbnail_hash, thumbnail_error
+	getMediaBaseQuery = `
+		SELECT mxc, enc_file, file_name, mime_type, size, hash, error, thumbnail_size, thumbnail_hash, thumbnail_error, last_accessed
 		FROM media
+	`
+	getMediaQuery                  = getMediaBaseQuery + `WHERE mxc = $1`
+	getLeastRecentlyUsedMediaQuery = getMediaBaseQuery + `
+		WHERE hash IS NOT NULL OR thumbnail_hash IS NOT NULL
+		ORDER BY last_accessed
+		LIMIT $1
+	`
+	// roomMediaFilter matches all media if $1 is empty, or only media referenced by events in the room otherwise
+	roomMediaFilter = `
+		($1 = '' OR mxc IN (
+			SELECT media_reference.media_mxc
+			FROM media_reference
+			JOIN event ON event.rowid = media_reference.event_rowid
+			WHERE event.room_id = $1
+		))
+	`
+	getCachedMediaQuery = getMediaBaseQuery + `
+		WHERE (hash IS NOT NULL OR thumbnail_hash IS NOT NULL) AND ` + roomMediaFilter
+	// Files are stored by hash, so the same file may be referenced by multiple entries
+	getMediaCacheUsageQuery = `
+		SELECT COUNT(*), COALESCE(SUM(size), 0)
+		FROM (
+			SELECT hash, MAX(COALESCE(size, 0)) AS size
+			FROM media
+			WHERE hash IS NOT NULL AND ` + roomMediaFilter + `
+			GROUP BY hash
+			UNION
+			SELECT thumbnail_hash, MAX(COALESCE(thumbnail_size, 0))
+			FROM media
+			WHERE thumbnail_hash IS NOT NULL AND ` + roomMediaFilter + `
+			GROUP BY thumbnail_hash
+		) media_usage
+	`
+	touchMediaQuery = `
+		UPDATE media SET last_accessed = $2 WHERE mxc = $1
+	`
+	clearCachedMediaQuery = `
+		UPDATE media
+		SET hash = NULL, thumbnail_hash = NULL, thumbnail_size = NULL, thumbnail_error = NULL, last_accessed = NULL
 		WHERE mxc = $1
 	`
+	checkMediaHashInUseQuery = `
+		SELECT EXISTS(SELECT 1 FROM media WHERE hash = $1 OR thumbnail_hash = $1)
+	`
 	addMediaReferenceQuery = `
 		INSERT INTO media_reference (event_rowid, media_mxc)
 		VALUES ($1, $2)
@@ -104,6 +147,45 @@ func (mq *MediaQuery) Get(ctx context.Context, mxc id.ContentURI) (*Media, error
 	return mq.QueryOne(ctx, getMediaQuery, &mxc)
 }
 
+// GetLeastRecentlyUsed returns cached media entries in the order they were last accessed, oldest first.
+func (mq *MediaQuery) GetLeastRecentlyUsed(ctx context.Context, limit int) ([]*Media, error) {
+	return mq.QueryMany(ctx, getLeastRecentlyUsedMediaQuery, limit)
+}
+
+// GetCached returns all media entries with a cached file or thumbnail.
+// If a room ID is given, only media referenced by events in that room is returned.
+func (mq *MediaQuery) GetCached(ctx context.Context, roomID id.RoomID) ([]*Media, error) {
+	return mq.QueryMany(ctx, getCachedMediaQuery, roomID)
+}
+
+type MediaCacheUsage struct {
+	Files int64 `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// GetUsage returns the number of cached files and their total size.
+// If a room ID is given, only media referenced by events in that room is counted.
+func (mq *MediaQuery) GetUsage(ctx context.Context, roomID id.RoomID) (usage MediaCacheUsage, err error) {
+	err = mq.GetDB().QueryRow(ctx, getMediaCacheUsageQuery, roomID).Scan(&usage.Files, &usage.Size)
+	return
+}
+
+func (mq *MediaQuery) Touch(ctx context.Context, mxc id.ContentURI, ts time.Time) error {
+	return mq.Exec(ctx, touchMediaQuery, &mxc, ts.UnixMilli())
+}
+
+// ClearCachedFile removes the file hashes from the given entry, but keeps the metadata
+// (like encryption keys), so that the file can be downloaded again later.
+func (mq *MediaQuery) ClearCachedFile(ctx context.Context, mxc id.ContentURI) error {
+	return mq.Exec(ctx, clearCachedMediaQuery, &mxc)
+}
+
+// IsHashInUse checks if any media entry still refers to the cached file with the given hash.
+func (mq *MediaQuery) IsHashInUse(ctx context.Context, hash []byte) (inUse bool, err error) {
+	err = mq.GetDB().QueryRow(ctx, checkMediaHashInUseQuery, hash).Scan(&inUse)
+	return
+}
+
 type MediaError struct {
 	Matrix     *mautrix.RespError `json:"data"`
 	StatusCode int                `json:"status_code"`
@@ -144,6 +226,8 @@ type Media struct {
 	ThumbnailError string
 	ThumbnailSize  int64
 	ThumbnailHash  *[32]byte
+
+	LastAccessed jsontime.UnixMilli
 }
 
 func (m *Media) ETag(thumbnail bool) string {
@@ -179,6 +263,7 @@ func (m *Media) sqlVariables() []any {
 		dbutil.StrPtr(m.FileName), dbutil.StrPtr(m.MimeType), dbutil.NumPtr(m.Size),
 		hash, dbutil.JSONPtr(m.Error),
 		dbutil.NumPtr(m.ThumbnailSize), thumbnailHash, dbutil.StrPtr(m.ThumbnailError),
+		dbutil.UnixMilliPtr(m.LastAccessed.Time),
 	}
 }
 
@@ -193,11 +278,12 @@ var safeMimes = []string{
 
 func (m *Media) Scan(row dbutil.Scannable) (*Media, error) {
 	var mimeType, fileName, thumbnailError sql.NullString
-	var size, thumbnailSize sql.NullInt64
+	var size, thumbnailSize, lastAccessed sql.NullInt64
 	var hash, thumbnailHash []byte
 	err := row.Scan(
 		&m.MXC, dbutil.JSON{Data: &m.EncFile}, &fileName, &mimeType, &size,
 		&hash, dbutil.JSON{Data: &m.Error}, &thumbnailSize, &thumbnailHash, &thumbnailError,
+		&lastAccessed,
 	)
 	if err != nil {
 		return nil, err
@@ -207,6 +293,9 @@ func (m *Media) Scan(row dbutil.Scannable) (*Media, error) {
 	m.Size = size.Int64
 	m.ThumbnailSize = thumbnailSize.Int64
 	m.ThumbnailError = thumbnailError.String
+	if lastAccessed.Valid {
+		m.LastAccessed = jsontime.UM(time.UnixMilli(lastAccessed.Int64))
+	}
 	if len(hash) == 32 {
 		m.Hash = (*[32]byte)(hash)
 	}
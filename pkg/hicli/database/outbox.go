@@ -0,0 +1,103 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// Only the oldest queued event in each room can be sent, so that messages are always sent in order.
+	outboxRoomHeadsCondition = `event_rowid IN (SELECT MIN(event_rowid) FROM outbox GROUP BY room_id)`
+	getDueOutboxQuery        = `
+		SELECT event_rowid, room_id, queued_at, attempts, next_attempt, last_error
+		FROM outbox
+		WHERE ` + outboxRoomHeadsCondition + ` AND next_attempt <= $1
+		ORDER BY next_attempt
+		LIMIT $2
+	`
+	getNextOutboxAttemptQuery = `
+		SELECT MIN(next_attempt) FROM outbox WHERE ` + outboxRoomHeadsCondition + ` AND next_attempt > $1
+	`
+	upsertOutboxQuery = `
+		INSERT INTO outbox (event_rowid, room_id, queued_at, attempts, next_attempt, last_error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (event_rowid) DO UPDATE
+			SET attempts = excluded.attempts,
+			    next_attempt = excluded.next_attempt,
+			    last_error = excluded.last_error
+	`
+	deleteOutboxQuery = `DELETE FROM outbox WHERE event_rowid = $1`
+)
+
+type OutboxQuery struct {
+	*dbutil.QueryHelper[*OutboxEntry]
+}
+
+// GetDue returns the events that should be sent now. At most one event per room is returned.
+func (oq *OutboxQuery) GetDue(ctx context.Context, now time.Time, limit int) ([]*OutboxEntry, error) {
+	return oq.QueryMany(ctx, getDueOutboxQuery, now.UnixMilli(), limit)
+}
+
+// GetNextAttemptTime returns the earliest time after the given time when an event in the outbox should be sent,
+// or a zero time if there are no such events.
+func (oq *OutboxQuery) GetNextAttemptTime(ctx context.Context, after time.Time) (time.Time, error) {
+	var nextAttempt sql.NullInt64
+	err := oq.GetDB().QueryRow(ctx, getNextOutboxAttemptQuery, after.UnixMilli()).Scan(&nextAttempt)
+	if err != nil || !nextAttempt.Valid {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(nextAttempt.Int64), nil
+}
+
+func (oq *OutboxQuery) Put(ctx context.Context, entry *OutboxEntry) error {
+	return oq.Exec(ctx, upsertOutboxQuery, entry.sqlVariables()...)
+}
+
+func (oq *OutboxQuery) Delete(ctx context.Context, eventRowID EventRowID) error {
+	return oq.Exec(ctx, deleteOutboxQuery, eventRowID)
+}
+
+// OutboxEntry is an event that is waiting to be sent to the server.
+type OutboxEntry struct {
+	EventRowID  EventRowID
+	RoomID      id.RoomID
+	QueuedAt    jsontime.UnixMilli
+	Attempts    int
+	NextAttempt jsontime.UnixMilli
+	LastError   string
+}
+
+func (oe *OutboxEntry) Scan(row dbutil.Scannable) (*OutboxEntry, error) {
+	var queuedAt, nextAttempt int64
+	var lastError sql.NullString
+	err := row.Scan(&oe.EventRowID, &oe.RoomID, &queuedAt, &oe.Attempts, &nextAttempt, &lastError)
+	if err != nil {
+		return nil, err
+	}
+	oe.QueuedAt = jsontime.UMInt(queuedAt)
+	oe.NextAttempt = jsontime.UMInt(nextAttempt)
+	oe.LastError = lastError.String
+	return oe, nil
+}
+
+func (oe *OutboxEntry) sqlVariables() []any {
+	return []any{
+		oe.EventRowID,
+		oe.RoomID,
+		oe.QueuedAt.UnixMilli(),
+		oe.Attempts,
+		oe.NextAttempt.UnixMilli(),
+		dbutil.StrPtr(oe.LastError),
+	}
+}
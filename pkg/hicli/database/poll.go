@@ -0,0 +1,162 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"slices"
+
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/exgjson"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Event types for MSC3381 polls. Both the stable and unstable types are understood when tallying votes.
+var (
+	EventPollStart            = event.Type{Type: "m.poll.start", Class: event.MessageEventType}
+	EventPollResponse         = event.Type{Type: "m.poll.response", Class: event.MessageEventType}
+	EventPollEnd              = event.Type{Type: "m.poll.end", Class: event.MessageEventType}
+	EventUnstablePollResponse = event.Type{Type: "org.matrix.msc3381.poll.response", Class: event.MessageEventType}
+	EventUnstablePollEnd      = event.Type{Type: "org.matrix.msc3381.poll.end", Class: event.MessageEventType}
+)
+
+const updatePollTallyQuery = `UPDATE event SET poll_tally = $2 WHERE rowid = $1`
+
+var (
+	unstablePollAnswerIDsPath     = exgjson.Path("org.matrix.msc3381.poll.start", "answers", "#", "id")
+	unstablePollMaxSelectionsPath = exgjson.Path("org.matrix.msc3381.poll.start", "max_selections")
+	stablePollAnswerIDsPath       = exgjson.Path("m.poll", "answers", "#", "m.id")
+	stablePollMaxSelectionsPath   = exgjson.Path("m.poll", "max_selections")
+	unstablePollSelectionsPath    = exgjson.Path("org.matrix.msc3381.poll.response", "answers")
+	stablePollSelectionsPath      = exgjson.Path("m.selections")
+)
+
+// PollTally is the current result of a poll, calculated from the response and end events referencing it.
+type PollTally struct {
+	// Answers maps answer IDs to the number of users who selected them.
+	Answers map[string]int `json:"answers"`
+	// Votes maps user IDs to the answers selected in their latest response.
+	Votes map[id.UserID][]string `json:"votes"`
+	// Spoiled is the number of users whose latest response didn't contain any valid answers.
+	Spoiled int `json:"spoiled,omitempty"`
+
+	EndEventID id.EventID         `json:"end_event_id,omitempty"`
+	EndedAt    jsontime.UnixMilli `json:"ended_at,omitempty"`
+}
+
+func (e *Event) getTypeAndContent() (string, []byte) {
+	if e.Decrypted != nil {
+		return e.DecryptedType, e.Decrypted
+	}
+	return e.Type, e.Content
+}
+
+func (e *Event) IsPollStart() bool {
+	evtType, _ := e.getTypeAndContent()
+	return evtType == EventPollStart.Type || evtType == event.EventUnstablePollStart.Type
+}
+
+// IsUnstablePollStart returns true if the event is a poll using the unstable MSC3381 namespace.
+// Responses and end events for such polls must use the unstable namespace too.
+func (e *Event) IsUnstablePollStart() bool {
+	evtType, _ := e.getTypeAndContent()
+	return evtType == event.EventUnstablePollStart.Type
+}
+
+func (e *Event) isPollResponse() bool {
+	evtType, _ := e.getTypeAndContent()
+	return evtType == EventPollResponse.Type || evtType == EventUnstablePollResponse.Type
+}
+
+func (e *Event) isPollEnd() bool {
+	evtType, _ := e.getTypeAndContent()
+	return evtType == EventPollEnd.Type || evtType == EventUnstablePollEnd.Type
+}
+
+// IsPollRelation returns true if the event is a poll response or end event referencing a poll.
+func (e *Event) IsPollRelation() bool {
+	return e.RelationType == event.RelReference && e.RelatesTo != "" && (e.isPollResponse() || e.isPollEnd())
+}
+
+func firstExisting(content []byte, paths ...string) gjson.Result {
+	for _, res := range gjson.GetManyBytes(content, paths...) {
+		if res.Exists() {
+			return res
+		}
+	}
+	return gjson.Result{}
+}
+
+// CalculatePollTally counts the votes of a poll based on the events referencing it, which must be sorted by timestamp.
+// Only the latest response of each user counts. The selections are truncated to the maximum allowed count,
+// and responses with unknown answers are considered spoiled. Responses sent after the poll was ended are ignored.
+// The canEnd function is used to check whether the sender of an end event is allowed to end the poll.
+func CalculatePollTally(poll *Event, related []*Event, canEnd func(userID id.UserID) bool) *PollTally {
+	_, content := poll.getTypeAndContent()
+	answerIDs := firstExisting(content, unstablePollAnswerIDsPath, stablePollAnswerIDsPath).Array()
+	maxSelections := int(firstExisting(content, unstablePollMaxSelectionsPath, stablePollMaxSelectionsPath).Int())
+	if maxSelections < 1 {
+		maxSelections = 1
+	}
+	tally := &PollTally{
+		Answers: make(map[string]int, len(answerIDs)),
+		Votes:   make(map[id.UserID][]string),
+	}
+	for _, answerID := range answerIDs {
+		if answerID.Type == gjson.String {
+			tally.Answers[answerID.Str] = 0
+		}
+	}
+	for _, evt := range related {
+		if evt.RedactedBy == "" && evt.isPollEnd() && canEnd(evt.Sender) {
+			tally.EndEventID = evt.ID
+			tally.EndedAt = evt.Timestamp
+			break
+		}
+	}
+	latestResponses := make(map[id.UserID][]string)
+	for _, evt := range related {
+		if evt.RedactedBy != "" || !evt.isPollResponse() {
+			continue
+		} else if tally.EndEventID != "" && evt.Timestamp.After(tally.EndedAt.Time) {
+			break
+		}
+		_, responseContent := evt.getTypeAndContent()
+		var selections []string
+		for _, selection := range firstExisting(responseContent, unstablePollSelectionsPath, stablePollSelectionsPath).Array() {
+			if len(selections) >= maxSelections {
+				break
+			}
+			_, isValid := tally.Answers[selection.Str]
+			if selection.Type != gjson.String || !isValid {
+				selections = nil
+				break
+			} else if !slices.Contains(selections, selection.Str) {
+				selections = append(selections, selection.Str)
+			}
+		}
+		latestResponses[evt.Sender] = selections
+	}
+	for userID, selections := range latestResponses {
+		if len(selections) == 0 {
+			tally.Spoiled++
+			continue
+		}
+		tally.Votes[userID] = selections
+		for _, selection := range selections {
+			tally.Answers[selection]++
+		}
+	}
+	return tally
+}
+
+func (eq *EventQuery) UpdatePollTally(ctx context.Context, rowID EventRowID, tally *PollTally) error {
+	return eq.Exec(ctx, updatePollTallyQuery, rowID, dbutil.JSONPtr(tally))
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const testUnstablePoll = `{
+	"org.matrix.msc3381.poll.start": {
+		"kind": "org.matrix.msc3381.poll.disclosed",
+		"max_selections": 1,
+		"question": {"org.matrix.msc1767.text": "?"},
+		"answers": [{"id": "a", "org.matrix.msc1767.text": "A"}, {"id": "b", "org.matrix.msc1767.text": "B"}]
+	}
+}`
+
+func newTestPollRelation(evtType event.Type, sender id.UserID, ts int64, content string) *Event {
+	return &Event{
+		ID:           id.EventID("$" + string(sender) + evtType.Type),
+		Type:         evtType.Type,
+		Sender:       sender,
+		Timestamp:    jsontime.UMInt(ts),
+		Content:      []byte(content),
+		RelatesTo:    "$poll",
+		RelationType: event.RelReference,
+	}
+}
+
+func TestCalculatePollTally(t *testing.T) {
+	poll := &Event{ID: "$poll", Type: event.EventUnstablePollStart.Type, Content: []byte(testUnstablePoll)}
+	related := []*Event{
+		newTestPollRelation(EventUnstablePollResponse, "@alice:example.com", 1, `{"org.matrix.msc3381.poll.response": {"answers": ["a"]}}`),
+		newTestPollRelation(EventPollResponse, "@bob:example.com", 2, `{"m.selections": ["b", "a"]}`),
+		newTestPollRelation(EventUnstablePollResponse, "@alice:example.com", 3, `{"org.matrix.msc3381.poll.response": {"answers": ["b"]}}`),
+		newTestPollRelation(EventUnstablePollResponse, "@carol:example.com", 4, `{"org.matrix.msc3381.poll.response": {"answers": ["c"]}}`),
+		newTestPollRelation(EventUnstablePollEnd, "@mallory:example.com", 5, `{"org.matrix.msc3381.poll.end": {}}`),
+		newTestPollRelation(EventUnstablePollEnd, "@alice:example.com", 6, `{"org.matrix.msc3381.poll.end": {}}`),
+		newTestPollRelation(EventUnstablePollResponse, "@dave:example.com", 7, `{"org.matrix.msc3381.poll.response": {"answers": ["a"]}}`),
+	}
+	tally := CalculatePollTally(poll, related, func(userID id.UserID) bool {
+		return userID == "@alice:example.com"
+	})
+	if !maps.Equal(tally.Answers, map[string]int{"a": 0, "b": 2}) {
+		t.Errorf("unexpected answers %v", tally.Answers)
+	}
+	if !slices.Equal(tally.Votes["@alice:example.com"], []string{"b"}) || !slices.Equal(tally.Votes["@bob:example.com"], []string{"b"}) {
+		t.Errorf("unexpected votes %v", tally.Votes)
+	} else if _, ok := tally.Votes["@dave:example.com"]; ok {
+		t.Errorf("vote after poll end was counted")
+	}
+	if tally.Spoiled != 1 {
+		t.Errorf("unexpected spoiled count %d", tally.Spoiled)
+	}
+	if tally.EndEventID != related[5].ID || tally.EndedAt.UnixMilli() != 6 {
+		t.Errorf("unexpected end event %s at %d", tally.EndEventID, tally.EndedAt.UnixMilli())
+	}
+	if !poll.IsUnstablePollStart() || !related[0].IsPollRelation() || !related[4].IsPollRelation() {
+		t.Errorf("poll event types not detected")
+	}
+}
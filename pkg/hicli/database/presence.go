@@ -0,0 +1,87 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getPresenceBaseQuery = `
+		SELECT user_id, presence, status_msg, last_active_ts, currently_active, updated_at
+		FROM presence
+	`
+	getPresenceQuery     = getPresenceBaseQuery + `WHERE user_id = $1`
+	getManyPresenceQuery = getPresenceBaseQuery + `WHERE user_id IN (%s)`
+	upsertPresenceQuery  = `
+		INSERT INTO presence (user_id, presence, status_msg, last_active_ts, currently_active, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET
+			presence = excluded.presence,
+			status_msg = excluded.status_msg,
+			last_active_ts = excluded.last_active_ts,
+			currently_active = excluded.currently_active,
+			updated_at = excluded.updated_at
+	`
+)
+
+type PresenceQuery struct {
+	*dbutil.QueryHelper[*Presence]
+}
+
+func (pq *PresenceQuery) Put(ctx context.Context, presence *Presence) error {
+	return pq.Exec(ctx, upsertPresenceQuery, presence.sqlVariables()...)
+}
+
+func (pq *PresenceQuery) Get(ctx context.Context, userID id.UserID) (*Presence, error) {
+	return pq.QueryOne(ctx, getPresenceQuery, userID)
+}
+
+func (pq *PresenceQuery) GetMany(ctx context.Context, userIDs []id.UserID) ([]*Presence, error) {
+	if len(userIDs) == 0 {
+		return []*Presence{}, nil
+	}
+	query, params := buildMultiEventGetFunction(nil, userIDs, getManyPresenceQuery)
+	return pq.QueryMany(ctx, query, params...)
+}
+
+type Presence struct {
+	UserID          id.UserID          `json:"user_id"`
+	Presence        event.Presence     `json:"presence"`
+	StatusMsg       string             `json:"status_msg,omitempty"`
+	LastActiveTS    jsontime.UnixMilli `json:"last_active_ts,omitempty"`
+	CurrentlyActive bool               `json:"currently_active"`
+	UpdatedAt       jsontime.UnixMilli `json:"updated_at"`
+}
+
+func (p *Presence) Scan(row dbutil.Scannable) (*Presence, error) {
+	var lastActiveTS sql.NullInt64
+	var updatedAt int64
+	err := row.Scan(&p.UserID, &p.Presence, &p.StatusMsg, &lastActiveTS, &p.CurrentlyActive, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastActiveTS.Valid {
+		p.LastActiveTS = jsontime.UMInt(lastActiveTS.Int64)
+	}
+	p.UpdatedAt = jsontime.UMInt(updatedAt)
+	return p, nil
+}
+
+func (p *Presence) sqlVariables() []any {
+	return []any{
+		p.UserID, p.Presence, p.StatusMsg,
+		dbutil.UnixMilliPtr(p.LastActiveTS.Time),
+		p.CurrentlyActive, p.UpdatedAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getTimelineRoomIDsQuery      = `SELECT DISTINCT room_id FROM timeline`
+	getNewestTimelineRowIDQuery  = `SELECT MAX(rowid) FROM timeline WHERE room_id = $1`
+	getPruneBoundaryByCountQuery = `
+		SELECT rowid FROM timeline WHERE room_id = $1 ORDER BY rowid DESC LIMIT 1 OFFSET $2
+	`
+	getPruneBoundaryByAgeQuery = `
+		SELECT timeline.rowid
+		FROM timeline
+		JOIN event ON event.rowid = timeline.event_rowid
+		WHERE timeline.room_id = $1 AND event.timestamp >= $2
+		ORDER BY timeline.rowid
+		LIMIT 1
+	`
+	getPruneBoundaryEventQuery = `
+		SELECT timeline.rowid, event.event_id, event.timestamp
+		FROM timeline
+		JOIN event ON event.rowid = timeline.event_rowid
+		WHERE timeline.room_id = $1 AND timeline.rowid = $2
+		  AND EXISTS(SELECT 1 FROM timeline older WHERE older.room_id = $1 AND older.rowid < $2)
+	`
+	pruneTimelineQuery = `
+		DELETE FROM timeline WHERE room_id = $1 AND rowid < $2
+	`
+	// Events are only deleted if nothing that's kept depends on them: the remaining timeline, current state,
+	// room previews, space edges, the latest edits of kept events and relations (e.g. reactions) to kept events
+	// all need to stay for the client to keep working. Local echoes are never deleted.
+	pruneEventsQuery = `
+		DELETE FROM event
+		WHERE room_id = $1
+		  AND timestamp < $2
+		  AND event_id NOT LIKE '~%'
+		  AND rowid NOT IN (SELECT event_rowid FROM timeline WHERE room_id = $1)
+		  AND rowid NOT IN (SELECT event_rowid FROM current_state WHERE room_id = $1)
+		  AND rowid NOT IN (SELECT preview_event_rowid FROM room WHERE preview_event_rowid IS NOT NULL)
+		  AND rowid NOT IN (SELECT child_event_rowid FROM space_edge WHERE child_event_rowid IS NOT NULL)
+		  AND rowid NOT IN (SELECT parent_event_rowid FROM space_edge WHERE parent_event_rowid IS NOT NULL)
+		  AND rowid NOT IN (
+			SELECT kept.last_edit_rowid
+			FROM timeline
+			JOIN event kept ON kept.rowid = timeline.event_rowid
+			WHERE timeline.room_id = $1 AND kept.last_edit_rowid IS NOT NULL
+		  )
+		  AND (relates_to IS NULL OR relates_to NOT IN (
+			SELECT kept.event_id
+			FROM timeline
+			JOIN event kept ON kept.rowid = timeline.event_rowid
+			WHERE timeline.room_id = $1
+		  ))
+	`
+)
+
+// PruneBoundary is the oldest timeline entry that is kept when pruning a room.
+type PruneBoundary struct {
+	TimelineRowID TimelineRowID
+	EventID       id.EventID
+	Timestamp     time.Time
+}
+
+type PruneResult struct {
+	TimelineDeleted int64 `json:"timeline_deleted"`
+	EventsDeleted   int64 `json:"events_deleted"`
+}
+
+// GetRoomIDs returns the IDs of all rooms that have any events in the timeline table.
+func (tq *TimelineQuery) GetRoomIDs(ctx context.Context) ([]id.RoomID, error) {
+	return roomIDScanner.NewRowIter(tq.GetDB().Query(ctx, getTimelineRoomIDsQuery)).AsList()
+}
+
+// GetPruneBoundary finds the oldest timeline entry that should be kept in the given room,
+// so that at most maxEvents entries remain and entries older than minTimestamp are removed.
+// At least one entry is always kept. If there's nothing to prune, nil is returned.
+func (tq *TimelineQuery) GetPruneBoundary(ctx context.Context, roomID id.RoomID, maxEvents int, minTimestamp time.Time) (*PruneBoundary, error) {
+	var boundary TimelineRowID
+	var found bool
+	if maxEvents > 0 {
+		err := tq.GetDB().QueryRow(ctx, getPruneBoundaryByCountQuery, roomID, maxEvents-1).Scan(&boundary)
+		if err == nil {
+			found = true
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to find prune boundary by count: %w", err)
+		}
+	}
+	if !minTimestamp.IsZero() {
+		var byAge sql.NullInt64
+		err := tq.GetDB().QueryRow(ctx, getPruneBoundaryByAgeQuery, roomID, minTimestamp.UnixMilli()).Scan(&byAge)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Everything is too old, only keep the newest entry
+			err = tq.GetDB().QueryRow(ctx, getNewestTimelineRowIDQuery, roomID).Scan(&byAge)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find prune boundary by age: %w", err)
+		} else if byAge.Valid && (!found || TimelineRowID(byAge.Int64) > boundary) {
+			boundary = TimelineRowID(byAge.Int64)
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	var pb PruneBoundary
+	var ts int64
+	err := tq.GetDB().QueryRow(ctx, getPruneBoundaryEventQuery, roomID, boundary).Scan(&pb.TimelineRowID, &pb.EventID, &ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get prune boundary event: %w", err)
+	}
+	pb.Timestamp = time.UnixMilli(ts)
+	return &pb, nil
+}
+
+// Prune deletes all timeline entries older than the given boundary, as well as events that are no longer needed.
+// This should be called inside a transaction together with updating the room's prev_batch token.
+func (tq *TimelineQuery) Prune(ctx context.Context, roomID id.RoomID, boundary *PruneBoundary) (*PruneResult, error) {
+	var res PruneResult
+	sqlRes, err := tq.GetDB().Exec(ctx, pruneTimelineQuery, roomID, boundary.TimelineRowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune timeline: %w", err)
+	}
+	res.TimelineDeleted, _ = sqlRes.RowsAffected()
+	sqlRes, err = tq.GetDB().Exec(ctx, pruneEventsQuery, roomID, boundary.Timestamp.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune events: %w", err)
+	}
+	res.EventsDeleted, _ = sqlRes.RowsAffected()
+	return &res, nil
+}
@@ -52,21 +52,46 @@ func (rq *ReceiptQuery) PutMany(ctx context.Context, roomID id.RoomID, receipts
 			return nil
 		})
 	}
-	query, params := receiptMassInserter.Build([1]any{roomID}, receipts)
+	query, params := receiptMassInserter.Build([1]any{roomID}, dedupReceipts(receipts))
 	return rq.Exec(ctx, query, params...)
 }
 
+type receiptKey struct {
+	UserID      id.UserID
+	ReceiptType event.ReceiptType
+	ThreadID    event.ThreadID
+}
+
+// dedupReceipts removes all but the last receipt for each user, type and thread.
+// Postgres doesn't allow upserting the same row twice in one query, while SQLite would just apply them in order.
+func dedupReceipts(receipts []*Receipt) []*Receipt {
+	seen := make(map[receiptKey]int, len(receipts))
+	output := make([]*Receipt, 0, len(receipts))
+	for _, receipt := range receipts {
+		key := receiptKey{UserID: receipt.UserID, ReceiptType: receipt.ReceiptType, ThreadID: receipt.ThreadID}
+		if idx, ok := seen[key]; ok {
+			output[idx] = receipt
+		} else {
+			seen[key] = len(output)
+			output = append(output, receipt)
+		}
+	}
+	return output
+}
+
 func (rq *ReceiptQuery) GetManyRead(ctx context.Context, roomID id.RoomID, eventIDs []id.EventID) (map[id.EventID][]*Receipt, error) {
+	output := make(map[id.EventID][]*Receipt)
+	if len(eventIDs) == 0 {
+		return output, nil
+	}
 	args := make([]any, len(eventIDs)+1)
-	placeholders := make([]string, len(eventIDs)+1)
+	placeholders := make([]string, len(eventIDs))
 	args[0] = roomID
-	placeholders[0] = "?1"
 	for i, evtID := range eventIDs {
 		args[i+1] = evtID
-		placeholders[i+1] = fmt.Sprintf("?%d", i+2)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
 	}
 	query := strings.Replace(getReadReceiptsQuery, "$2", strings.Join(placeholders, ", "), 1)
-	output := make(map[id.EventID][]*Receipt)
 	err := rq.QueryManyIter(ctx, query, args...).Iter(func(receipt *Receipt) (bool, error) {
 		output[receipt.EventID] = append(output[receipt.EventID], receipt)
 		return true, nil
@@ -10,6 +10,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"go.mau.fi/util/dbutil"
@@ -34,9 +35,11 @@ const (
 		INSERT INTO room (room_id) VALUES ($1)
 		ON CONFLICT (room_id) DO NOTHING
 	`
-	upsertRoomFromSyncQuery = `
+	roomTypeFromCreationContent         = `json($2)->>'$.type'`
+	roomTypeFromCreationContentPostgres = `safe_json_string($2::json, 'type')`
+	upsertRoomFromSyncQuery             = `
 		UPDATE room
-		SET room_type = COALESCE(room.room_type, json($2)->>'$.type', ''),
+		SET room_type = COALESCE(room.room_type, ` + roomTypeFromCreationContent + `, ''),
 		    creation_content = COALESCE(room.creation_content, $2),
 		    tombstone_content = COALESCE(room.tombstone_content, $3),
 			name = COALESCE($4, room.name),
@@ -103,8 +106,10 @@ func (rq *RoomQuery) GetAllSpaces(ctx context.Context) ([]*Room, error) {
 	return rq.QueryMany(ctx, getRoomsByTypeQuery, event.RoomTypeSpace)
 }
 
+var upsertRoomFromSyncQueryPostgres = strings.Replace(upsertRoomFromSyncQuery, roomTypeFromCreationContent, roomTypeFromCreationContentPostgres, 1)
+
 func (rq *RoomQuery) Upsert(ctx context.Context, room *Room) error {
-	return rq.Exec(ctx, upsertRoomFromSyncQuery, room.sqlVariables()...)
+	return rq.Exec(ctx, dialectQuery(rq.GetDB(), upsertRoomFromSyncQuery, upsertRoomFromSyncQueryPostgres), room.sqlVariables()...)
 }
 
 func (rq *RoomQuery) Delete(ctx context.Context, roomID id.RoomID) error {
@@ -0,0 +1,148 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getScheduledMessageBaseQuery = `
+		SELECT schedule_id, room_id, type, content, edit_source, disable_encryption, send_at, delay_id, created_at
+		FROM scheduled_message
+	`
+	getScheduledMessageQuery                  = getScheduledMessageBaseQuery + `WHERE schedule_id = $1`
+	getAllScheduledMessagesQuery              = getScheduledMessageBaseQuery + `ORDER BY send_at`
+	getRoomScheduledMessagesQuery             = getScheduledMessageBaseQuery + `WHERE room_id = $1 ORDER BY send_at`
+	getNextScheduledSendQuery                 = `SELECT MIN(send_at) FROM scheduled_message`
+	getDueLocalScheduledMessagesQuery         = getScheduledMessageBaseQuery + `WHERE delay_id IS NULL AND send_at <= $1 ORDER BY send_at`
+	deleteExpiredRemoteScheduledMessagesQuery = `
+		DELETE FROM scheduled_message WHERE delay_id IS NOT NULL AND send_at <= $1
+	`
+	upsertScheduledMessageQuery = `
+		INSERT INTO scheduled_message (schedule_id, room_id, type, content, edit_source, disable_encryption, send_at, delay_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (schedule_id) DO UPDATE
+			SET send_at = excluded.send_at,
+			    delay_id = excluded.delay_id
+	`
+	deleteScheduledMessageQuery         = `DELETE FROM scheduled_message WHERE schedule_id = $1`
+	deleteDueLocalScheduledMessageQuery = `DELETE FROM scheduled_message WHERE schedule_id = $1 AND delay_id IS NULL AND send_at <= $2`
+)
+
+type ScheduledMessageQuery struct {
+	*dbutil.QueryHelper[*ScheduledMessage]
+}
+
+func (smq *ScheduledMessageQuery) Get(ctx context.Context, scheduleID string) (*ScheduledMessage, error) {
+	return smq.QueryOne(ctx, getScheduledMessageQuery, scheduleID)
+}
+
+// GetAll returns all scheduled messages in the given room, or in all rooms if the room ID is empty.
+func (smq *ScheduledMessageQuery) GetAll(ctx context.Context, roomID id.RoomID) ([]*ScheduledMessage, error) {
+	if roomID == "" {
+		return smq.QueryMany(ctx, getAllScheduledMessagesQuery)
+	}
+	return smq.QueryMany(ctx, getRoomScheduledMessagesQuery, roomID)
+}
+
+// GetNextSendTime returns the send time of the next scheduled message, or a zero time if there are no scheduled messages.
+func (smq *ScheduledMessageQuery) GetNextSendTime(ctx context.Context) (time.Time, error) {
+	var sendAt sql.NullInt64
+	err := smq.GetDB().QueryRow(ctx, getNextScheduledSendQuery).Scan(&sendAt)
+	if err != nil || !sendAt.Valid {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(sendAt.Int64), nil
+}
+
+// GetDueLocal returns the locally scheduled messages that should be sent now.
+// They stay in the database until DeleteDueLocal is called in the same transaction that queues the message.
+func (smq *ScheduledMessageQuery) GetDueLocal(ctx context.Context, now time.Time) ([]*ScheduledMessage, error) {
+	return smq.QueryMany(ctx, getDueLocalScheduledMessagesQuery, now.UnixMilli())
+}
+
+// DeleteDueLocal removes a locally scheduled message that is about to be sent. It returns false if the message
+// was cancelled or rescheduled after it was fetched, in which case it must not be sent.
+func (smq *ScheduledMessageQuery) DeleteDueLocal(ctx context.Context, msg *ScheduledMessage) (bool, error) {
+	res, err := smq.GetDB().Exec(ctx, deleteDueLocalScheduledMessageQuery, msg.ScheduleID, msg.SendAt.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// DeleteExpiredRemote removes messages scheduled on the server whose send time has passed.
+// The server sends those by itself, so they only need to be forgotten locally.
+func (smq *ScheduledMessageQuery) DeleteExpiredRemote(ctx context.Context, now time.Time) error {
+	return smq.Exec(ctx, deleteExpiredRemoteScheduledMessagesQuery, now.UnixMilli())
+}
+
+func (smq *ScheduledMessageQuery) Put(ctx context.Context, msg *ScheduledMessage) error {
+	return smq.Exec(ctx, upsertScheduledMessageQuery, msg.sqlVariables()...)
+}
+
+func (smq *ScheduledMessageQuery) Delete(ctx context.Context, scheduleID string) error {
+	return smq.Exec(ctx, deleteScheduledMessageQuery, scheduleID)
+}
+
+// ScheduledMessage is a message that will be sent at a specific time, either by the server using
+// delayed events (MSC4140) or by the local scheduler.
+type ScheduledMessage struct {
+	ScheduleID        string             `json:"schedule_id"`
+	RoomID            id.RoomID          `json:"room_id"`
+	Type              string             `json:"type"`
+	Content           json.RawMessage    `json:"content"`
+	EditSource        string             `json:"edit_source,omitempty"`
+	DisableEncryption bool               `json:"disable_encryption,omitempty"`
+	SendAt            jsontime.UnixMilli `json:"send_at"`
+	// DelayID is the ID of the delayed event on the server. If empty, the message is sent by the local scheduler,
+	// which only works while gomuks is running.
+	DelayID   string             `json:"delay_id,omitempty"`
+	CreatedAt jsontime.UnixMilli `json:"created_at"`
+}
+
+func (sm *ScheduledMessage) Scan(row dbutil.Scannable) (*ScheduledMessage, error) {
+	var content string
+	var editSource, delayID sql.NullString
+	var sendAt, createdAt int64
+	err := row.Scan(
+		&sm.ScheduleID, &sm.RoomID, &sm.Type, &content, &editSource, &sm.DisableEncryption,
+		&sendAt, &delayID, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	sm.Content = json.RawMessage(content)
+	sm.EditSource = editSource.String
+	sm.DelayID = delayID.String
+	sm.SendAt = jsontime.UMInt(sendAt)
+	sm.CreatedAt = jsontime.UMInt(createdAt)
+	return sm, nil
+}
+
+func (sm *ScheduledMessage) sqlVariables() []any {
+	return []any{
+		sm.ScheduleID,
+		sm.RoomID,
+		sm.Type,
+		unsafeJSONString(sm.Content),
+		dbutil.StrPtr(sm.EditSource),
+		sm.DisableEncryption,
+		sm.SendAt.UnixMilli(),
+		dbutil.StrPtr(sm.DelayID),
+		sm.CreatedAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// The full-text search index is not a part of the normal schema upgrades, because FTS5 is only available
+// when go-sqlite3 is built with the sqlite_fts5 tag. Triggers referring to the virtual table would break
+// inserting events on builds without FTS5, so they're created and dropped at startup as necessary.
+const (
+	searchIndexableEventSelect = `
+		SELECT event.rowid, COALESCE(
+			COALESCE(edit.decrypted, edit.content) ->> '$."m.new_content".body',
+			COALESCE(event.decrypted, event.content) ->> 'body'
+		) AS body
+		FROM event
+		LEFT JOIN event edit ON edit.rowid = event.last_edit_rowid
+		WHERE COALESCE(event.decrypted_type, event.type) IN ('m.room.message', 'm.sticker')
+		  AND event.state_key IS NULL
+		  AND event.redacted_by IS NULL
+		  AND (event.relation_type IS NULL OR event.relation_type <> 'm.replace')
+		  AND body IS NOT NULL
+	`
+	createSearchIndexQuery = `
+		CREATE VIRTUAL TABLE IF NOT EXISTS event_fts USING fts5(body, tokenize='unicode61 remove_diacritics 2');
+
+		DELETE FROM event_fts;
+		INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelect + `;
+
+		CREATE TRIGGER event_fts_insert
+			AFTER INSERT
+			ON event
+		BEGIN
+			INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelect + ` AND event.rowid = NEW.rowid;
+		END;
+
+		CREATE TRIGGER event_fts_update
+			AFTER UPDATE OF decrypted, redacted_by, last_edit_rowid
+			ON event
+		BEGIN
+			DELETE FROM event_fts WHERE rowid = NEW.rowid;
+			INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelect + ` AND event.rowid = NEW.rowid;
+			-- If the updated event is an edit, refresh the indexed body of the event it's editing
+			DELETE FROM event_fts
+			WHERE NEW.relation_type = 'm.replace'
+			  AND rowid IN (SELECT rowid FROM event WHERE last_edit_rowid = NEW.rowid);
+			INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelect + `
+			  AND NEW.relation_type = 'm.replace'
+			  AND event.last_edit_rowid = NEW.rowid;
+		END;
+
+		CREATE TRIGGER event_fts_delete
+			AFTER DELETE
+			ON event
+		BEGIN
+			DELETE FROM event_fts WHERE rowid = OLD.rowid;
+		END;
+	`
+	dropSearchIndexTriggersQuery = `
+		DROP TRIGGER IF EXISTS event_fts_insert;
+		DROP TRIGGER IF EXISTS event_fts_update;
+		DROP TRIGGER IF EXISTS event_fts_delete;
+	`
+	checkFTS5AvailableQuery     = `SELECT sqlite_compileoption_used('ENABLE_FTS5')`
+	checkSearchIndexExistsQuery = `SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'event_fts_insert')`
+	searchEventsBaseQuery       = getEventBaseQuery + `
+		JOIN (SELECT rowid AS fts_rowid, rank FROM event_fts WHERE event_fts MATCH $1) fts ON fts.fts_rowid = event.rowid
+	`
+)
+
+// On Postgres, the search index is a normal table with a tsvector column. The rank is negated to make it sort
+// the same way as the FTS5 rank (best match first in ascending order).
+const (
+	searchIndexableEventSelectPostgres = `
+		SELECT event.rowid, to_tsvector('simple', indexable.body)
+		FROM event
+		LEFT JOIN event edit ON edit.rowid = event.last_edit_rowid
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(
+				safe_json_string(COALESCE(edit.decrypted, edit.content), 'm.new_content', 'body'),
+				safe_json_string(COALESCE(event.decrypted, event.content), 'body')
+			) AS body
+		) indexable
+		WHERE COALESCE(event.decrypted_type, event.type) IN ('m.room.message', 'm.sticker')
+		  AND event.state_key IS NULL
+		  AND event.redacted_by IS NULL
+		  AND (event.relation_type IS NULL OR event.relation_type <> 'm.replace')
+		  AND indexable.body IS NOT NULL
+	`
+	createSearchIndexQueryPostgres = `
+		CREATE TABLE IF NOT EXISTS event_fts (
+			rowid BIGINT   NOT NULL PRIMARY KEY,
+			body  tsvector NOT NULL,
+
+			CONSTRAINT event_fts_event_fkey FOREIGN KEY (rowid) REFERENCES event (rowid) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS event_fts_body_idx ON event_fts USING gin (body);
+
+		DELETE FROM event_fts;
+		INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelectPostgres + `;
+
+		CREATE OR REPLACE FUNCTION event_fts_insert() RETURNS TRIGGER AS $$
+		BEGIN
+			INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelectPostgres + ` AND event.rowid = NEW.rowid;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE TRIGGER event_fts_insert
+			AFTER INSERT
+			ON event
+			FOR EACH ROW
+			EXECUTE FUNCTION event_fts_insert();
+
+		CREATE OR REPLACE FUNCTION event_fts_update() RETURNS TRIGGER AS $$
+		BEGIN
+			DELETE FROM event_fts WHERE rowid = NEW.rowid;
+			INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelectPostgres + ` AND event.rowid = NEW.rowid;
+			-- If the updated event is an edit, refresh the indexed body of the event it's editing
+			IF NEW.relation_type = 'm.replace' THEN
+				DELETE FROM event_fts WHERE rowid IN (SELECT rowid FROM event WHERE last_edit_rowid = NEW.rowid);
+				INSERT INTO event_fts (rowid, body) ` + searchIndexableEventSelectPostgres + `
+				  AND event.last_edit_rowid = NEW.rowid;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE TRIGGER event_fts_update
+			AFTER UPDATE OF decrypted, redacted_by, last_edit_rowid
+			ON event
+			FOR EACH ROW
+			EXECUTE FUNCTION event_fts_update();
+	`
+	checkSearchIndexExistsQueryPostgres = `
+		SELECT EXISTS(SELECT 1 FROM pg_trigger WHERE tgrelid = 'event'::regclass AND tgname = 'event_fts_insert')
+	`
+	searchEventsBaseQueryPostgres = getEventBaseQuery + `
+		JOIN (
+			SELECT rowid AS fts_rowid, -ts_rank(body, query) AS rank
+			FROM event_fts, to_tsquery('simple', $1) query
+			WHERE body @@ query
+		) fts ON fts.fts_rowid = event.rowid
+	`
+)
+
+// InitSearchIndex creates the full-text search index if the SQLite library supports FTS5.
+// If FTS5 isn't supported, any old search index triggers are removed and false is returned.
+// On Postgres, the search index is always available.
+func (db *Database) InitSearchIndex(ctx context.Context) (bool, error) {
+	if db.Dialect == dbutil.Postgres {
+		return db.initSearchIndexPostgres(ctx)
+	}
+	var available bool
+	err := db.QueryRow(ctx, checkFTS5AvailableQuery).Scan(&available)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if FTS5 is available: %w", err)
+	} else if !available {
+		_, err = db.Exec(ctx, dropSearchIndexTriggersQuery)
+		if err != nil {
+			return false, fmt.Errorf("failed to drop search index triggers: %w", err)
+		}
+		return false, nil
+	}
+	var exists bool
+	err = db.QueryRow(ctx, checkSearchIndexExistsQuery).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if search index exists: %w", err)
+	} else if exists {
+		return true, nil
+	}
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		_, err := db.Exec(ctx, createSearchIndexQuery)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create search index: %w", err)
+	}
+	return true, nil
+}
+
+func (db *Database) initSearchIndexPostgres(ctx context.Context) (bool, error) {
+	var exists bool
+	err := db.QueryRow(ctx, checkSearchIndexExistsQueryPostgres).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if search index exists: %w", err)
+	} else if exists {
+		return true, nil
+	}
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		_, err := db.Exec(ctx, createSearchIndexQueryPostgres)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create search index: %w", err)
+	}
+	return true, nil
+}
+
+type SearchOrder string
+
+const (
+	SearchOrderRank   SearchOrder = "rank"
+	SearchOrderRecent SearchOrder = "recent"
+)
+
+type SearchParams struct {
+	Query    string
+	RoomIDs  []id.RoomID
+	Senders  []id.UserID
+	MsgTypes []event.MessageType
+	After    time.Time
+	Before   time.Time
+	Order    SearchOrder
+	Limit    int
+	Offset   int
+}
+
+// makeFTSQuery converts user input into a safe FTS5 query where all words must match,
+// and the last word is treated as a prefix to allow search-as-you-type.
+func makeFTSQuery(input string) string {
+	terms := strings.Fields(input)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	if len(terms) > 0 {
+		terms[len(terms)-1] += "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// makeTSQuery is the Postgres equivalent of makeFTSQuery.
+func makeTSQuery(input string) string {
+	terms := strings.Fields(input)
+	for i, term := range terms {
+		term = strings.ReplaceAll(term, `\`, `\\`)
+		terms[i] = "'" + strings.ReplaceAll(term, "'", "''") + "'"
+	}
+	if len(terms) > 0 {
+		terms[len(terms)-1] += ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+func nextPlaceholder(args []any) string {
+	return fmt.Sprintf("$%d", len(args)+1)
+}
+
+func appendInFilter[T any](where []string, args []any, column string, values []T) ([]string, []any) {
+	if len(values) == 0 {
+		return where, args
+	}
+	placeholders := make([]string, len(values))
+	for i, val := range values {
+		placeholders[i] = nextPlaceholder(args)
+		args = append(args, val)
+	}
+	where = append(where, fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ",")))
+	return where, args
+}
+
+// Search finds events matching the given full-text query and filters.
+// One more result than the limit is fetched to allow the caller to check if there are more results.
+func (eq *EventQuery) Search(ctx context.Context, params *SearchParams) ([]*Event, error) {
+	baseQuery := searchEventsBaseQuery
+	ftsQuery := makeFTSQuery(params.Query)
+	if eq.GetDB().Dialect == dbutil.Postgres {
+		baseQuery = searchEventsBaseQueryPostgres
+		ftsQuery = makeTSQuery(params.Query)
+	}
+	if ftsQuery == "" {
+		return []*Event{}, nil
+	}
+	args := []any{ftsQuery}
+	var where []string
+	where, args = appendInFilter(where, args, "room_id", params.RoomIDs)
+	where, args = appendInFilter(where, args, "sender", params.Senders)
+	where, args = appendInFilter(where, args, "COALESCE(decrypted, content) ->> 'msgtype'", params.MsgTypes)
+	if !params.After.IsZero() {
+		where = append(where, "timestamp >= "+nextPlaceholder(args))
+		args = append(args, params.After.UnixMilli())
+	}
+	if !params.Before.IsZero() {
+		where = append(where, "timestamp < "+nextPlaceholder(args))
+		args = append(args, params.Before.UnixMilli())
+	}
+	var query strings.Builder
+	query.WriteString(baseQuery)
+	if len(where) > 0 {
+		query.WriteString("WHERE ")
+		query.WriteString(strings.Join(where, " AND "))
+	}
+	if params.Order == SearchOrderRecent {
+		query.WriteString(" ORDER BY timestamp DESC")
+	} else {
+		query.WriteString(" ORDER BY fts.rank, timestamp DESC")
+	}
+	query.WriteString(fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2))
+	args = append(args, params.Limit+1, params.Offset)
+	return eq.QueryMany(ctx, query.String(), args...)
+}
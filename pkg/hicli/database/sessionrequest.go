@@ -18,7 +18,7 @@ const (
 		INSERT INTO session_request (room_id, session_id, sender, min_index, backup_checked, request_sent)
 		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (session_id) DO UPDATE
-			SET min_index = MIN(excluded.min_index, session_request.min_index),
+			SET min_index = CASE WHEN excluded.min_index < session_request.min_index THEN excluded.min_index ELSE session_request.min_index END,
 			    backup_checked = excluded.backup_checked OR session_request.backup_checked,
 			    request_sent = excluded.request_sent OR session_request.request_sent
 	`
@@ -9,6 +9,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	"go.mau.fi/util/dbutil"
 	"maunium.net/go/mautrix/id"
@@ -37,11 +38,12 @@ const (
 			WHERE inneredge.child_id = outeredge.space_id
 				AND (inneredge.child_event_rowid IS NOT NULL OR inneredge.parent_validated)
 		) AND EXISTS(SELECT 1 FROM room WHERE room_id = space_id AND room_type = 'm.space')
-		ORDER BY room_account_data.content->>'$.order' NULLS LAST, space_id
+		ORDER BY room_account_data.content->>'order' NULLS LAST, space_id
 	`
-	revalidateAllParents = `
-		UPDATE space_edge
-		SET parent_validated=(SELECT EXISTS(
+	// spaceParentValidQuery checks whether the sender of the m.space.parent event of the edge
+	// has permission to send m.space.child events in the parent space.
+	spaceParentValidQuery = `
+		SELECT EXISTS(
 			SELECT 1
 			FROM room
 				INNER JOIN current_state cs ON cs.room_id = room.room_id AND cs.event_type = 'm.room.power_levels' AND cs.state_key = ''
@@ -49,7 +51,10 @@ const (
 				INNER JOIN event edgeevt ON space_edge.parent_event_rowid = edgeevt.rowid
 			WHERE	room.room_id = space_edge.space_id
 				AND room.room_type = 'm.space'
-				AND COALESCE(
+				AND ` + spaceParentPowerCheck + `
+		)
+	`
+	spaceParentPowerCheck = `COALESCE(
 					(
 						SELECT value
 						FROM json_each(pls.content, '$.users')
@@ -61,8 +66,19 @@ const (
 					pls.content->>'$.events."m.space.child"',
 					pls.content->>'$.state_default',
 					50
-				)
-		))
+				)`
+	spaceParentPowerCheckPostgres = `COALESCE(
+					safe_json_number(pls.content, 'users', edgeevt.sender),
+					safe_json_number(pls.content, 'users_default'),
+					0
+				) >= COALESCE(
+					safe_json_number(pls.content, 'events', 'm.space.child'),
+					safe_json_number(pls.content, 'state_default'),
+					50
+				)`
+	revalidateAllParents = `
+		UPDATE space_edge
+		SET parent_validated=(` + spaceParentValidQuery + `)
 		WHERE parent_event_rowid IS NOT NULL
 	`
 	revalidateAllParentsPointingAtSpaceQuery = revalidateAllParents + ` AND space_id=$1`
@@ -99,6 +115,17 @@ const (
 	`
 )
 
+var (
+	revalidateAllParentsPointingAtSpaceQueryPostgres = toPostgresSpaceQuery(revalidateAllParentsPointingAtSpaceQuery)
+	revalidateAllParentsOfRoomQueryPostgres          = toPostgresSpaceQuery(revalidateAllParentsOfRoomQuery)
+	revalidateSpecificParentQueryPostgres            = toPostgresSpaceQuery(revalidateSpecificParentQuery)
+)
+
+// toPostgresSpaceQuery replaces the SQLite-specific power level check in a query containing spaceParentValidQuery.
+func toPostgresSpaceQuery(query string) string {
+	return strings.Replace(query, spaceParentPowerCheck, spaceParentPowerCheckPostgres, 1)
+}
+
 var massInsertSpaceParentBuilder = dbutil.NewMassInsertBuilder[SpaceParentEntry, [1]any](addSpaceParentQuery, "($%d, $1, $%d, $%d)")
 var massInsertSpaceChildBuilder = dbutil.NewMassInsertBuilder[SpaceChildEntry, [1]any](addSpaceChildQuery, "($1, $%d, $%d, $%d, $%d)")
 
@@ -190,15 +217,15 @@ func (seq *SpaceEdgeQuery) SetParents(ctx context.Context, childID id.RoomID, pa
 }
 
 func (seq *SpaceEdgeQuery) RevalidateAllChildrenOfParentValidity(ctx context.Context, spaceID id.RoomID) error {
-	return seq.Exec(ctx, revalidateAllParentsPointingAtSpaceQuery, spaceID)
+	return seq.Exec(ctx, dialectQuery(seq.GetDB(), revalidateAllParentsPointingAtSpaceQuery, revalidateAllParentsPointingAtSpaceQueryPostgres), spaceID)
 }
 
 func (seq *SpaceEdgeQuery) RevalidateAllParentsOfRoomValidity(ctx context.Context, childID id.RoomID) error {
-	return seq.Exec(ctx, revalidateAllParentsOfRoomQuery, childID)
+	return seq.Exec(ctx, dialectQuery(seq.GetDB(), revalidateAllParentsOfRoomQuery, revalidateAllParentsOfRoomQueryPostgres), childID)
 }
 
 func (seq *SpaceEdgeQuery) RevalidateSpecificParentValidity(ctx context.Context, spaceID, childID id.RoomID) error {
-	return seq.Exec(ctx, revalidateSpecificParentQuery, spaceID, childID)
+	return seq.Exec(ctx, dialectQuery(seq.GetDB(), revalidateSpecificParentQuery, revalidateSpecificParentQueryPostgres), spaceID, childID)
 }
 
 func (seq *SpaceEdgeQuery) GetAll(ctx context.Context, spaceID id.RoomID) (map[id.RoomID][]*SpaceEdge, error) {
@@ -33,7 +33,7 @@ const (
 		SELECT event.rowid, -1,
 		       event.room_id, event.event_id, sender, event.type, event.state_key, timestamp, content, decrypted, decrypted_type,
 		       unsigned, local_content, transaction_id, redacted_by, relates_to, relation_type,
-		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type
+		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, poll_tally
 		FROM current_state cs
 		JOIN event ON cs.event_rowid = event.rowid
 	`
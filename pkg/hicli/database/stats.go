@@ -0,0 +1,285 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+// These are the parts of the stats queries that are different on Postgres, see EventQuery.statsQuery.
+const (
+	statsMsgTypeExpr     = `evt_content ->> 'msgtype'`
+	statsMediaSizeExpr   = `COALESCE(evt_content ->> '$.info.size', 0)`
+	statsReactionKeyExpr = `evt_content ->> '$."m.relates_to".key'`
+	statsDayExpr         = `date(timestamp / 1000, 'unixepoch')`
+
+	statsMsgTypeExprPostgres     = `safe_json_string(evt_content, 'msgtype')`
+	statsMediaSizeExprPostgres   = `CAST(COALESCE(safe_json_number(evt_content, 'info', 'size'), 0) AS BIGINT)`
+	statsReactionKeyExprPostgres = `safe_json_string(evt_content, 'm.relates_to', 'key')`
+	statsDayExprPostgres         = `to_char(to_timestamp(timestamp / 1000) AT TIME ZONE 'UTC', 'YYYY-MM-DD')`
+)
+
+var statsPostgresReplacer = strings.NewReplacer(
+	statsMsgTypeExpr, statsMsgTypeExprPostgres,
+	statsMediaSizeExpr, statsMediaSizeExprPostgres,
+	statsReactionKeyExpr, statsReactionKeyExprPostgres,
+	statsDayExpr, statsDayExprPostgres,
+)
+
+const (
+	// All statistics are calculated from the same set of events: non-state events in the room that have been
+	// sent to the server (i.e. not local echoes) within the requested time range. Edits aren't counted as messages,
+	// and encrypted events are classified by their decrypted type.
+	roomStatsEventsCTE = `
+		WITH stats_event AS (
+			SELECT
+				sender,
+				timestamp,
+				COALESCE(decrypted_type, type) AS evt_type,
+				COALESCE(decrypted, content) AS evt_content,
+				relation_type,
+				redacted_by,
+				type = 'm.room.encrypted' AND decrypted IS NULL AS undecryptable,
+				megolm_session_id,
+				decryption_error
+			FROM event
+			WHERE room_id = $1
+			  AND state_key IS NULL
+			  AND event_id NOT LIKE '~%'
+			  AND timestamp >= $2
+			  AND (CAST($3 AS BIGINT) = 0 OR timestamp < $3)
+		), stats_message AS (
+			SELECT
+				sender,
+				timestamp,
+				CASE
+					WHEN redacted_by IS NOT NULL THEN NULL
+					WHEN evt_type = 'm.sticker' THEN 'm.sticker'
+					WHEN ` + statsMsgTypeExpr + ` IN ('m.image', 'm.video', 'm.audio', 'm.file')
+						THEN ` + statsMsgTypeExpr + `
+				END AS media_type,
+				` + statsMediaSizeExpr + ` AS media_size
+			FROM stats_event
+			WHERE evt_type IN ('m.room.message', 'm.sticker')
+			  AND (relation_type IS NULL OR relation_type <> 'm.replace')
+		)
+	`
+	getRoomStatsTotalsQuery = roomStatsEventsCTE + `
+		SELECT
+			COUNT(*),
+			COALESCE(MIN(timestamp), 0),
+			COALESCE(MAX(timestamp), 0),
+			(SELECT COUNT(*) FROM stats_message),
+			COALESCE(SUM(CASE WHEN evt_type = 'm.reaction' AND redacted_by IS NULL THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN undecryptable THEN 1 ELSE 0 END), 0)
+		FROM stats_event
+	`
+	getRoomStatsSendersQuery = roomStatsEventsCTE + `
+		SELECT
+			sender,
+			COALESCE(SUM(is_message), 0),
+			COALESCE(SUM(CASE WHEN media_type IS NOT NULL THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN media_type IS NOT NULL THEN media_size ELSE 0 END), 0),
+			COALESCE(SUM(is_reaction), 0),
+			MAX(timestamp)
+		FROM (
+			SELECT sender, timestamp, 1 AS is_message, media_type, media_size, 0 AS is_reaction FROM stats_message
+			UNION ALL
+			SELECT sender, timestamp, 0, NULL, 0, 1 FROM stats_event WHERE evt_type = 'm.reaction' AND redacted_by IS NULL
+		) stats_activity
+		GROUP BY sender
+		ORDER BY 2 DESC, 5 DESC, sender
+	`
+	// Days are in UTC, because the backend doesn't know the timezone of the client.
+	getRoomStatsDaysQuery = roomStatsEventsCTE + `
+		SELECT ` + statsDayExpr + ` AS day, COUNT(*), COUNT(DISTINCT sender)
+		FROM stats_message
+		GROUP BY day
+		ORDER BY day
+	`
+	getRoomStatsMediaQuery = roomStatsEventsCTE + `
+		SELECT media_type, COUNT(*), COALESCE(SUM(media_size), 0)
+		FROM stats_message
+		WHERE media_type IS NOT NULL
+		GROUP BY media_type
+		ORDER BY 3 DESC
+	`
+	getRoomStatsReactionsQuery = roomStatsEventsCTE + `
+		SELECT ` + statsReactionKeyExpr + ` AS reaction_key, COUNT(*)
+		FROM stats_event
+		WHERE evt_type = 'm.reaction' AND redacted_by IS NULL AND relation_type = 'm.annotation' AND ` + statsReactionKeyExpr + ` IS NOT NULL
+		GROUP BY reaction_key
+		ORDER BY 2 DESC, reaction_key
+		LIMIT $4
+	`
+	getRoomStatsUndecryptableQuery = roomStatsEventsCTE + `
+		SELECT megolm_session_id, COUNT(*), COUNT(DISTINCT sender), MIN(timestamp), MAX(timestamp), MAX(decryption_error)
+		FROM stats_event
+		WHERE undecryptable
+		GROUP BY megolm_session_id
+		ORDER BY 2 DESC
+	`
+)
+
+const maxStatsReactionKeys = 100
+
+// RoomStats contains statistics about the events of a room that are stored in the local database.
+// Only events that have been synced or paginated are included, see FirstTimestamp for how far back the data goes.
+type RoomStats struct {
+	RoomID id.RoomID `json:"room_id"`
+	// FirstTimestamp and LastTimestamp are the timestamps of the oldest and newest events included in the stats.
+	FirstTimestamp jsontime.UnixMilli `json:"first_timestamp"`
+	LastTimestamp  jsontime.UnixMilli `json:"last_timestamp"`
+	// HistoryComplete is true if the whole room history has been paginated into the local database.
+	HistoryComplete bool `json:"history_complete"`
+
+	TotalEvents        int `json:"total_events"`
+	TotalMessages      int `json:"total_messages"`
+	TotalReactions     int `json:"total_reactions"`
+	TotalUndecryptable int `json:"total_undecryptable"`
+
+	Senders               []*SenderStats               `json:"senders"`
+	Days                  []*DayStats                  `json:"days"`
+	Media                 []*MediaStats                `json:"media"`
+	Reactions             []*ReactionStats             `json:"reactions"`
+	UndecryptableSessions []*UndecryptableSessionStats `json:"undecryptable_sessions"`
+}
+
+type SenderStats struct {
+	Sender       id.UserID          `json:"sender"`
+	Messages     int                `json:"messages"`
+	MediaCount   int                `json:"media_count"`
+	MediaBytes   int64              `json:"media_bytes"`
+	Reactions    int                `json:"reactions"`
+	LastActiveAt jsontime.UnixMilli `json:"last_active_at"`
+}
+
+type DayStats struct {
+	// Date is the UTC date in YYYY-MM-DD format.
+	Date     string `json:"date"`
+	Messages int    `json:"messages"`
+	Senders  int    `json:"senders"`
+}
+
+type MediaStats struct {
+	// MsgType is the msgtype of the media message, or m.sticker for stickers.
+	MsgType string `json:"msgtype"`
+	Count   int    `json:"count"`
+	// Bytes is the sum of the sizes in the info objects of the messages. Messages without a size aren't counted.
+	Bytes int64 `json:"bytes"`
+}
+
+type ReactionStats struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type UndecryptableSessionStats struct {
+	SessionID       id.SessionID       `json:"session_id"`
+	Count           int                `json:"count"`
+	Senders         int                `json:"senders"`
+	FirstTimestamp  jsontime.UnixMilli `json:"first_timestamp"`
+	LastTimestamp   jsontime.UnixMilli `json:"last_timestamp"`
+	DecryptionError string             `json:"decryption_error,omitempty"`
+}
+
+var senderStatsScanner = dbutil.ConvertRowFn[*SenderStats](func(row dbutil.Scannable) (*SenderStats, error) {
+	var ss SenderStats
+	var lastActive int64
+	err := row.Scan(&ss.Sender, &ss.Messages, &ss.MediaCount, &ss.MediaBytes, &ss.Reactions, &lastActive)
+	ss.LastActiveAt = jsontime.UMInt(lastActive)
+	return &ss, err
+})
+
+var dayStatsScanner = dbutil.ConvertRowFn[*DayStats](func(row dbutil.Scannable) (*DayStats, error) {
+	var ds DayStats
+	err := row.Scan(&ds.Date, &ds.Messages, &ds.Senders)
+	return &ds, err
+})
+
+var mediaStatsScanner = dbutil.ConvertRowFn[*MediaStats](func(row dbutil.Scannable) (*MediaStats, error) {
+	var ms MediaStats
+	err := row.Scan(&ms.MsgType, &ms.Count, &ms.Bytes)
+	return &ms, err
+})
+
+var reactionStatsScanner = dbutil.ConvertRowFn[*ReactionStats](func(row dbutil.Scannable) (*ReactionStats, error) {
+	var rs ReactionStats
+	err := row.Scan(&rs.Key, &rs.Count)
+	return &rs, err
+})
+
+var undecryptableSessionStatsScanner = dbutil.ConvertRowFn[*UndecryptableSessionStats](func(row dbutil.Scannable) (*UndecryptableSessionStats, error) {
+	var uss UndecryptableSessionStats
+	var sessionID, decryptionError sql.NullString
+	var firstTS, lastTS int64
+	err := row.Scan(&sessionID, &uss.Count, &uss.Senders, &firstTS, &lastTS, &decryptionError)
+	uss.SessionID = id.SessionID(sessionID.String)
+	uss.DecryptionError = decryptionError.String
+	uss.FirstTimestamp = jsontime.UMInt(firstTS)
+	uss.LastTimestamp = jsontime.UMInt(lastTS)
+	return &uss, err
+})
+
+// statsQuery converts the SQLite-specific expressions in the given stats query to Postgres if necessary.
+func (eq *EventQuery) statsQuery(query string) string {
+	if eq.GetDB().Dialect == dbutil.Postgres {
+		return statsPostgresReplacer.Replace(query)
+	}
+	return query
+}
+
+// GetRoomStats calculates statistics of the events in the given room. If since or until are non-zero,
+// only events within that time range are included.
+func (eq *EventQuery) GetRoomStats(ctx context.Context, roomID id.RoomID, since, until time.Time) (*RoomStats, error) {
+	var sinceTS, untilTS int64
+	if !since.IsZero() {
+		sinceTS = since.UnixMilli()
+	}
+	if !until.IsZero() {
+		untilTS = until.UnixMilli()
+	}
+	stats := &RoomStats{RoomID: roomID}
+	var firstTS, lastTS int64
+	err := eq.GetDB().QueryRow(ctx, eq.statsQuery(getRoomStatsTotalsQuery), roomID, sinceTS, untilTS).Scan(
+		&stats.TotalEvents, &firstTS, &lastTS, &stats.TotalMessages, &stats.TotalReactions, &stats.TotalUndecryptable,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get totals: %w", err)
+	}
+	stats.FirstTimestamp = jsontime.UMInt(firstTS)
+	stats.LastTimestamp = jsontime.UMInt(lastTS)
+	stats.Senders, err = senderStatsScanner.NewRowIter(eq.GetDB().Query(ctx, eq.statsQuery(getRoomStatsSendersQuery), roomID, sinceTS, untilTS)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender stats: %w", err)
+	}
+	stats.Days, err = dayStatsScanner.NewRowIter(eq.GetDB().Query(ctx, eq.statsQuery(getRoomStatsDaysQuery), roomID, sinceTS, untilTS)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daily stats: %w", err)
+	}
+	stats.Media, err = mediaStatsScanner.NewRowIter(eq.GetDB().Query(ctx, eq.statsQuery(getRoomStatsMediaQuery), roomID, sinceTS, untilTS)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media stats: %w", err)
+	}
+	stats.Reactions, err = reactionStatsScanner.NewRowIter(eq.GetDB().Query(ctx, eq.statsQuery(getRoomStatsReactionsQuery), roomID, sinceTS, untilTS, maxStatsReactionKeys)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reaction stats: %w", err)
+	}
+	stats.UndecryptableSessions, err = undecryptableSessionStatsScanner.NewRowIter(eq.GetDB().Query(ctx, eq.statsQuery(getRoomStatsUndecryptableQuery), roomID, sinceTS, untilTS)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get undecryptable event stats: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/exgjson"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getThreadBaseQuery = `
+		SELECT room_id, thread_root, reply_count, latest_event_rowid, latest_timestamp, participated,
+		       unread_highlights, unread_notifications, unread_messages
+		FROM thread
+	`
+	getThreadQuery        = getThreadBaseQuery + `WHERE room_id = $1 AND thread_root = $2`
+	getManyThreadsQuery   = getThreadBaseQuery + `WHERE room_id = $1 AND thread_root IN (%s)`
+	getUnreadThreadsQuery = getThreadBaseQuery + `
+		WHERE room_id = $1 AND (unread_highlights > 0 OR unread_notifications > 0 OR unread_messages > 0)
+	`
+	getRoomThreadsQuery = getThreadBaseQuery + `
+		WHERE room_id = $1 AND (CAST($2 AS BIGINT) = 0 OR latest_timestamp < $2) AND ($3 = false OR participated = true)
+		ORDER BY latest_timestamp DESC
+		LIMIT $4
+	`
+	// The summary is always recalculated from the event table rather than updated incrementally,
+	// so that redactions and events received in different orders (sync, pagination) are handled the same way.
+	// The bundled aggregation in the root event ($4-$7) covers replies that haven't been fetched yet.
+	recalculateThreadQuery = `
+		INSERT INTO thread (room_id, thread_root, reply_count, latest_event_rowid, latest_timestamp, participated)
+		SELECT
+			$1,
+			$2,
+			CASE WHEN COUNT(*) > $4 THEN COUNT(*) ELSE $4 END,
+			(
+				SELECT latest.rowid
+				FROM event latest
+				WHERE latest.room_id = $1
+				  AND ((latest.relates_to = $2 AND latest.relation_type = 'm.thread') OR latest.event_id = $6)
+				  AND latest.redacted_by IS NULL
+				ORDER BY latest.timestamp DESC
+				LIMIT 1
+			),
+			CASE WHEN COALESCE(MAX(reply.timestamp), 0) > $5 THEN COALESCE(MAX(reply.timestamp), 0) ELSE $5 END,
+			$7 OR EXISTS(
+				SELECT 1
+				FROM event own
+				WHERE own.room_id = $1
+				  AND own.sender = $3
+				  AND (own.event_id = $2 OR (own.relates_to = $2 AND own.relation_type = 'm.thread'))
+			)
+		FROM event reply
+		WHERE reply.room_id = $1 AND reply.relates_to = $2 AND reply.relation_type = 'm.thread' AND reply.redacted_by IS NULL
+		ON CONFLICT (room_id, thread_root) DO UPDATE
+			SET reply_count = excluded.reply_count,
+			    latest_event_rowid = excluded.latest_event_rowid,
+			    latest_timestamp = excluded.latest_timestamp,
+			    participated = excluded.participated
+	`
+	getThreadRootUnsignedQuery = `SELECT unsigned FROM event WHERE room_id = $1 AND event_id = $2`
+	clearThreadUnreadsQuery    = `
+		UPDATE thread
+		SET unread_highlights = 0, unread_notifications = 0, unread_messages = 0
+		WHERE room_id = $1 AND (unread_highlights > 0 OR unread_notifications > 0 OR unread_messages > 0)
+		RETURNING thread_root
+	`
+	setThreadUnreadsQuery = `
+		UPDATE thread
+		SET unread_highlights = $3, unread_notifications = $4, unread_messages = $5
+		WHERE room_id = $1 AND thread_root = $2
+		RETURNING thread_root
+	`
+)
+
+var threadRootScanner = dbutil.ConvertRowFn[id.EventID](dbutil.ScanSingleColumn[id.EventID])
+
+var bundledThreadPath = exgjson.Path("m.relations", "m.thread")
+
+// bundledThreadSummary is the m.thread aggregation that servers bundle in the unsigned data of thread roots.
+type bundledThreadSummary struct {
+	Count                   int64
+	LatestEventID           id.EventID
+	LatestTimestamp         int64
+	CurrentUserParticipated bool
+}
+
+func parseBundledThreadSummary(unsigned []byte) (summary bundledThreadSummary) {
+	if len(unsigned) == 0 {
+		return
+	}
+	res := gjson.GetBytes(unsigned, bundledThreadPath)
+	if !res.IsObject() {
+		return
+	}
+	summary.Count = res.Get("count").Int()
+	summary.LatestEventID = id.EventID(res.Get("latest_event.event_id").Str)
+	summary.LatestTimestamp = res.Get("latest_event.origin_server_ts").Int()
+	summary.CurrentUserParticipated = res.Get("current_user_participated").Bool()
+	return
+}
+
+// HasBundledThreadSummary returns true if the event has a bundled m.thread aggregation, i.e. it's a thread root.
+func (e *Event) HasBundledThreadSummary() bool {
+	return len(e.Unsigned) > 0 && gjson.GetBytes(e.Unsigned, bundledThreadPath).IsObject()
+}
+
+type ThreadQuery struct {
+	*dbutil.QueryHelper[*Thread]
+}
+
+func (tq *ThreadQuery) Get(ctx context.Context, roomID id.RoomID, threadRoot id.EventID) (*Thread, error) {
+	return tq.QueryOne(ctx, getThreadQuery, roomID, threadRoot)
+}
+
+func (tq *ThreadQuery) GetMany(ctx context.Context, roomID id.RoomID, threadRoots []id.EventID) ([]*Thread, error) {
+	if len(threadRoots) == 0 {
+		return []*Thread{}, nil
+	}
+	query, params := buildMultiEventGetFunction([]any{roomID}, threadRoots, getManyThreadsQuery)
+	return tq.QueryMany(ctx, query, params...)
+}
+
+// GetUnread returns all threads in the room that have unread messages.
+func (tq *ThreadQuery) GetUnread(ctx context.Context, roomID id.RoomID) ([]*Thread, error) {
+	return tq.QueryMany(ctx, getUnreadThreadsQuery, roomID)
+}
+
+// GetRoomThreads returns the threads in a room, ordered by the timestamp of the latest reply.
+// If before is set, only threads whose latest reply is older than it are returned.
+func (tq *ThreadQuery) GetRoomThreads(ctx context.Context, roomID id.RoomID, before time.Time, onlyParticipated bool, limit int) ([]*Thread, error) {
+	var beforeTS int64
+	if !before.IsZero() {
+		beforeTS = before.UnixMilli()
+	}
+	return tq.QueryMany(ctx, getRoomThreadsQuery, roomID, beforeTS, onlyParticipated, limit)
+}
+
+// Recalculate updates the reply count, latest event and participation flag of a thread based on the events
+// currently in the database and the aggregation bundled in the thread root. The thread row is created
+// if it doesn't exist yet.
+func (tq *ThreadQuery) Recalculate(ctx context.Context, roomID id.RoomID, threadRoot id.EventID, ownUserID id.UserID) error {
+	var unsigned []byte
+	err := tq.GetDB().QueryRow(ctx, getThreadRootUnsignedQuery, roomID, threadRoot).Scan(&unsigned)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	bundled := parseBundledThreadSummary(unsigned)
+	return tq.Exec(
+		ctx, recalculateThreadQuery, roomID, threadRoot, ownUserID,
+		bundled.Count, bundled.LatestTimestamp, bundled.LatestEventID, bundled.CurrentUserParticipated,
+	)
+}
+
+// SetUnreads replaces the unread counts of all threads in the room with the given counts.
+// The main timeline counts (empty key) are ignored. The roots of the threads that may have changed are returned.
+func (tq *ThreadQuery) SetUnreads(ctx context.Context, roomID id.RoomID, counts map[id.EventID]UnreadCounts) ([]id.EventID, error) {
+	changed, err := threadRootScanner.NewRowIter(tq.GetDB().Query(ctx, clearThreadUnreadsQuery, roomID)).AsList()
+	if err != nil {
+		return nil, err
+	}
+	for threadRoot, uc := range counts {
+		if threadRoot == "" || uc.IsZero() {
+			continue
+		}
+		var updatedRoot id.EventID
+		err = tq.GetDB().QueryRow(
+			ctx, setThreadUnreadsQuery, roomID, threadRoot, uc.UnreadHighlights, uc.UnreadNotifications, uc.UnreadMessages,
+		).Scan(&updatedRoot)
+		if err == nil {
+			changed = append(changed, updatedRoot)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+	return changed, nil
+}
+
+// Thread is a summary of a thread in a room.
+type Thread struct {
+	RoomID           id.RoomID          `json:"room_id"`
+	ThreadRoot       id.EventID         `json:"thread_root"`
+	ReplyCount       int                `json:"reply_count"`
+	LatestEventRowID EventRowID         `json:"latest_event_rowid,omitempty"`
+	LatestTimestamp  jsontime.UnixMilli `json:"latest_timestamp"`
+	Participated     bool               `json:"participated"`
+	UnreadCounts
+}
+
+func (t *Thread) Scan(row dbutil.Scannable) (*Thread, error) {
+	var latestEventRowID sql.NullInt64
+	var latestTimestamp int64
+	err := row.Scan(
+		&t.RoomID, &t.ThreadRoot, &t.ReplyCount, &latestEventRowID, &latestTimestamp, &t.Participated,
+		&t.UnreadHighlights, &t.UnreadNotifications, &t.UnreadMessages,
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.LatestEventRowID = EventRowID(latestEventRowID.Int64)
+	t.LatestTimestamp = jsontime.UMInt(latestTimestamp)
+	return t, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"testing"
+)
+
+func TestParseBundledThreadSummary(t *testing.T) {
+	unsigned := []byte(`{
+		"age": 1234,
+		"m.relations": {
+			"m.thread": {
+				"count": 7,
+				"current_user_participated": true,
+				"latest_event": {
+					"event_id": "$latest",
+					"origin_server_ts": 1700000000000,
+					"type": "m.room.message"
+				}
+			}
+		}
+	}`)
+	summary := parseBundledThreadSummary(unsigned)
+	expected := bundledThreadSummary{
+		Count:                   7,
+		LatestEventID:           "$latest",
+		LatestTimestamp:         1700000000000,
+		CurrentUserParticipated: true,
+	}
+	if summary != expected {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if !(&Event{Unsigned: unsigned}).HasBundledThreadSummary() {
+		t.Error("event with bundled thread summary not detected")
+	}
+}
+
+func TestParseBundledThreadSummary_Missing(t *testing.T) {
+	for _, unsigned := range []string{``, `{}`, `{"m.relations": {"m.annotation": {}}}`, `{"m.relations": {"m.thread": 5}}`} {
+		if summary := parseBundledThreadSummary([]byte(unsigned)); summary != (bundledThreadSummary{}) {
+			t.Errorf("unexpected summary %+v for %q", summary, unsigned)
+		}
+		if (&Event{Unsigned: []byte(unsigned)}).HasBundledThreadSummary() {
+			t.Errorf("bundled thread summary detected in %q", unsigned)
+		}
+	}
+}
@@ -36,10 +36,10 @@ const (
 		SELECT event.rowid, timeline.rowid,
 		       event.room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type,
 		       unsigned, local_content, transaction_id, redacted_by, relates_to, relation_type,
-		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type
+		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, poll_tally
 		FROM timeline
 		JOIN event ON event.rowid = timeline.event_rowid
-		WHERE timeline.room_id = $1 AND ($2 = 0 OR timeline.rowid < $2)
+		WHERE timeline.room_id = $1 AND (CAST($2 AS BIGINT) = 0 OR timeline.rowid < $2)
 		ORDER BY timeline.rowid DESC
 		LIMIT $3
 	`
@@ -9,35 +9,85 @@ package database
 import (
 	"context"
 
+	"go.mau.fi/util/dbutil"
 	"maunium.net/go/mautrix/id"
 )
 
 const (
 	// TODO find out if this needs to be wrapped in another query that limits the number of events it evaluates
 	//      (or maybe the timeline store just shouldn't be allowed to grow that big?)
-	calculateUnreadsQuery = `
-		SELECT
-			COALESCE(SUM(CASE WHEN unread_type & 0100 THEN 1 ELSE 0 END), 0) AS highlights,
-			COALESCE(SUM(CASE WHEN unread_type & 0010 THEN 1 ELSE 0 END), 0) AS notifications,
-			COALESCE(SUM(CASE WHEN unread_type & 0001 THEN 1 ELSE 0 END), 0) AS messages
-		FROM timeline
-		JOIN event ON event.rowid = timeline.event_rowid
-		WHERE timeline.room_id = $1 AND timeline.rowid > (
-			SELECT MAX(rowid)
+	//
+	// Events are grouped by thread (the empty string being the main timeline). Thread events are read if there's
+	// an own receipt in the same thread or an unthreaded receipt after them, main timeline events only look at
+	// unthreaded receipts. Note that receipts for the "main" thread are stored as unthreaded receipts.
+	//
+	// The position of the latest own receipt in each thread is calculated once, after which only the part of the
+	// timeline after the oldest of those positions has to be scanned.
+	calculateThreadUnreadsQuery = `
+		WITH receipt_position AS (
+			SELECT receipt.thread_id, MAX(receipt_timeline.rowid) AS timeline_rowid
+			FROM receipt
+			JOIN event receipt_event ON receipt.event_id = receipt_event.event_id
+			JOIN timeline receipt_timeline ON receipt_timeline.event_rowid = receipt_event.rowid
+			WHERE receipt.room_id = $1 AND receipt.user_id = $2
+			GROUP BY receipt.thread_id
+		), unread_event AS (
+			SELECT
+				CASE WHEN event.relation_type = 'm.thread' THEN event.relates_to ELSE '' END AS thread_root,
+				timeline.rowid AS timeline_rowid,
+				event.unread_type
 			FROM timeline
-			WHERE room_id = $1 AND event_rowid IN (
-				SELECT event.rowid
-				FROM receipt
-				JOIN event ON receipt.event_id=event.event_id
-				WHERE receipt.room_id = $1 AND receipt.user_id = $2
-			)
-		) AND unread_type > 0 AND redacted_by IS NULL
+			JOIN event ON event.rowid = timeline.event_rowid
+			WHERE timeline.room_id = $1
+				AND timeline.rowid > (SELECT MIN(timeline_rowid) FROM receipt_position)
+				AND event.unread_type > 0 AND event.redacted_by IS NULL
+		)
+		SELECT
+			unread_event.thread_root,
+			COALESCE(SUM(CASE WHEN (unread_type & 0100) <> 0 THEN 1 ELSE 0 END), 0) AS highlights,
+			COALESCE(SUM(CASE WHEN (unread_type & 0010) <> 0 THEN 1 ELSE 0 END), 0) AS notifications,
+			COALESCE(SUM(CASE WHEN (unread_type & 0001) <> 0 THEN 1 ELSE 0 END), 0) AS messages
+		FROM unread_event
+		LEFT JOIN receipt_position main_receipt ON main_receipt.thread_id = ''
+		LEFT JOIN receipt_position thread_receipt
+			ON unread_event.thread_root <> '' AND thread_receipt.thread_id = unread_event.thread_root
+		-- Events are unread if they're after both receipts. If neither receipt exists, the condition is null.
+		WHERE unread_event.timeline_rowid > COALESCE(main_receipt.timeline_rowid, thread_receipt.timeline_rowid)
+			AND unread_event.timeline_rowid > COALESCE(thread_receipt.timeline_rowid, main_receipt.timeline_rowid)
+		GROUP BY 1
 	`
 )
 
+type threadUnreadCounts struct {
+	ThreadRoot id.EventID
+	UnreadCounts
+}
+
+var threadUnreadCountsScanner = dbutil.ConvertRowFn[threadUnreadCounts](func(row dbutil.Scannable) (tuc threadUnreadCounts, err error) {
+	err = row.Scan(&tuc.ThreadRoot, &tuc.UnreadHighlights, &tuc.UnreadNotifications, &tuc.UnreadMessages)
+	return
+})
+
+// CalculateThreadUnreads calculates the unread counts of each thread in the room.
+// The counts of the main timeline are under the empty string key.
+func (rq *RoomQuery) CalculateThreadUnreads(ctx context.Context, roomID id.RoomID, userID id.UserID) (map[id.EventID]UnreadCounts, error) {
+	return dbutil.RowIterAsMap(
+		threadUnreadCountsScanner.NewRowIter(rq.GetDB().Query(ctx, calculateThreadUnreadsQuery, roomID, userID)),
+		func(tuc threadUnreadCounts) (id.EventID, UnreadCounts) {
+			return tuc.ThreadRoot, tuc.UnreadCounts
+		},
+	)
+}
+
+// CalculateUnreads calculates the total unread counts of the room, including all threads.
 func (rq *RoomQuery) CalculateUnreads(ctx context.Context, roomID id.RoomID, userID id.UserID) (uc UnreadCounts, err error) {
-	err = rq.GetDB().QueryRow(ctx, calculateUnreadsQuery, roomID, userID).
-		Scan(&uc.UnreadHighlights, &uc.UnreadNotifications, &uc.UnreadMessages)
+	threadUnreads, err := rq.CalculateThreadUnreads(ctx, roomID, userID)
+	if err != nil {
+		return
+	}
+	for _, counts := range threadUnreads {
+		uc.Add(counts)
+	}
 	return
 }
 
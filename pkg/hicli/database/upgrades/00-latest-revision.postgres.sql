@@ -0,0 +1,479 @@
+-- v0 -> v22 (compatible with v10+): Latest revision
+
+-- Postgres can't convert JSON strings containing \u0000 (or invalid surrogate pairs) to text, and throws an error
+-- for any operator that needs to parse such a document. These helpers return NULL instead, so that a single bad
+-- event can't break inserting events.
+CREATE FUNCTION safe_json_string(data json, VARIADIC path TEXT[]) RETURNS TEXT AS $$
+DECLARE
+	value json;
+BEGIN
+	value := data #> path;
+	IF json_typeof(value) = 'string' THEN
+		RETURN value #>> '{}';
+	END IF;
+	RETURN NULL;
+EXCEPTION WHEN untranslatable_character OR invalid_text_representation THEN
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE STRICT;
+
+CREATE FUNCTION safe_json_number(data json, VARIADIC path TEXT[]) RETURNS NUMERIC AS $$
+DECLARE
+	value json;
+BEGIN
+	value := data #> path;
+	IF json_typeof(value) = 'number' THEN
+		RETURN (value #>> '{}')::NUMERIC;
+	END IF;
+	RETURN NULL;
+EXCEPTION WHEN untranslatable_character OR invalid_text_representation THEN
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE STRICT;
+
+CREATE TABLE account (
+	user_id        TEXT NOT NULL PRIMARY KEY,
+	device_id      TEXT NOT NULL,
+	access_token   TEXT NOT NULL,
+	homeserver_url TEXT NOT NULL,
+
+	next_batch     TEXT NOT NULL
+);
+
+CREATE TABLE room (
+	room_id              TEXT    NOT NULL PRIMARY KEY,
+	room_type            TEXT,
+	creation_content     json,
+	tombstone_content    json,
+
+	name                 TEXT,
+	name_quality         INTEGER NOT NULL DEFAULT 0,
+	avatar               TEXT,
+	explicit_avatar      BOOLEAN NOT NULL DEFAULT false,
+	dm_user_id           TEXT,
+	topic                TEXT,
+	canonical_alias      TEXT,
+	lazy_load_summary    json,
+
+	encryption_event     json,
+	has_member_list      BOOLEAN NOT NULL DEFAULT false,
+
+	preview_event_rowid  BIGINT,
+	sorting_timestamp    BIGINT,
+	unread_highlights    INTEGER NOT NULL DEFAULT 0,
+	unread_notifications INTEGER NOT NULL DEFAULT 0,
+	unread_messages      INTEGER NOT NULL DEFAULT 0,
+	marked_unread        BOOLEAN NOT NULL DEFAULT false,
+
+	prev_batch           TEXT
+	-- room_preview_event_fkey is added after the event table is created
+);
+CREATE INDEX room_type_idx ON room (room_type);
+CREATE INDEX room_sorting_timestamp_idx ON room (sorting_timestamp DESC);
+CREATE INDEX room_preview_idx ON room (preview_event_rowid);
+
+CREATE TABLE invited_room (
+	room_id      TEXT   NOT NULL PRIMARY KEY,
+	received_at  BIGINT NOT NULL,
+	invite_state json   NOT NULL
+);
+
+CREATE FUNCTION invited_room_delete_on_room_insert() RETURNS TRIGGER AS $$
+BEGIN
+	DELETE FROM invited_room WHERE room_id = NEW.room_id;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER invited_room_delete_on_room_insert
+	AFTER INSERT
+	ON room
+	FOR EACH ROW
+EXECUTE FUNCTION invited_room_delete_on_room_insert();
+
+CREATE TABLE account_data (
+	user_id TEXT NOT NULL,
+	type    TEXT NOT NULL,
+	content json NOT NULL,
+
+	PRIMARY KEY (user_id, type)
+);
+
+CREATE TABLE room_account_data (
+	user_id TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	type    TEXT NOT NULL,
+	content json NOT NULL,
+
+	PRIMARY KEY (user_id, room_id, type),
+	CONSTRAINT room_account_data_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
+CREATE INDEX room_account_data_room_id_idx ON room_account_data (room_id);
+
+CREATE TABLE event (
+	rowid             BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+	room_id           TEXT    NOT NULL,
+	event_id          TEXT    NOT NULL,
+	sender            TEXT    NOT NULL,
+	type              TEXT    NOT NULL,
+	state_key         TEXT,
+	timestamp         BIGINT  NOT NULL,
+
+	content           json    NOT NULL,
+	decrypted         json,
+	decrypted_type    TEXT,
+	unsigned          json    NOT NULL,
+	local_content     json,
+
+	transaction_id    TEXT,
+
+	redacted_by       TEXT,
+	relates_to        TEXT,
+	relation_type     TEXT,
+
+	megolm_session_id TEXT,
+	decryption_error  TEXT,
+	send_error        TEXT,
+
+	reactions         json,
+	last_edit_rowid   BIGINT,
+	unread_type       INTEGER NOT NULL DEFAULT 0,
+	poll_tally        json,
+
+	CONSTRAINT event_id_unique_key UNIQUE (event_id),
+	CONSTRAINT transaction_id_unique_key UNIQUE (transaction_id),
+	CONSTRAINT event_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
+CREATE INDEX event_room_id_idx ON event (room_id);
+CREATE INDEX event_redacted_by_idx ON event (room_id, redacted_by);
+CREATE INDEX event_relates_to_idx ON event (room_id, relates_to);
+CREATE INDEX event_megolm_session_id_idx ON event (room_id, megolm_session_id);
+
+-- The room and event tables reference each other, so the constraint is deferrable to allow copying data
+-- from an SQLite database.
+ALTER TABLE room
+	ADD CONSTRAINT room_preview_event_fkey FOREIGN KEY (preview_event_rowid) REFERENCES event (rowid)
+		ON DELETE SET NULL DEFERRABLE INITIALLY IMMEDIATE;
+
+CREATE FUNCTION event_update_redacted_by() RETURNS TRIGGER AS $$
+BEGIN
+	UPDATE event
+	SET redacted_by = NEW.event_id
+	WHERE room_id = NEW.room_id AND event_id = safe_json_string(NEW.content, 'redacts');
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER event_update_redacted_by
+	AFTER INSERT
+	ON event
+	FOR EACH ROW
+	WHEN (NEW.type = 'm.room.redaction')
+	-- TODO check that event isn't soft failed
+EXECUTE FUNCTION event_update_redacted_by();
+
+CREATE FUNCTION event_update_last_edit_when_redacted() RETURNS TRIGGER AS $$
+BEGIN
+	UPDATE event
+	SET last_edit_rowid = COALESCE(
+		(SELECT edit.rowid
+		 FROM event edit
+		 WHERE edit.room_id = event.room_id
+		   AND edit.relates_to = event.event_id
+		   AND edit.relation_type = 'm.replace'
+		   AND edit.type = event.type
+		   AND edit.sender = event.sender
+		   AND edit.redacted_by IS NULL
+		   AND edit.state_key IS NULL
+		 ORDER BY edit.timestamp DESC
+		 LIMIT 1),
+		0)
+	WHERE event_id = NEW.relates_to
+	  AND last_edit_rowid = NEW.rowid
+	  AND state_key IS NULL
+	  AND (relation_type IS NULL OR relation_type NOT IN ('m.replace', 'm.annotation'));
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER event_update_last_edit_when_redacted
+	AFTER UPDATE
+	ON event
+	FOR EACH ROW
+	WHEN (OLD.redacted_by IS NULL
+		AND NEW.redacted_by IS NOT NULL
+		AND NEW.relation_type = 'm.replace'
+		AND NEW.state_key IS NULL)
+EXECUTE FUNCTION event_update_last_edit_when_redacted();
+
+CREATE FUNCTION event_insert_update_last_edit() RETURNS TRIGGER AS $$
+BEGIN
+	UPDATE event
+	SET last_edit_rowid = NEW.rowid
+	WHERE event_id = NEW.relates_to
+	  AND type = NEW.type
+	  AND sender = NEW.sender
+	  AND state_key IS NULL
+	  AND (relation_type IS NULL OR relation_type NOT IN ('m.replace', 'm.annotation'))
+	  AND NEW.timestamp >
+		  COALESCE((SELECT prev_edit.timestamp FROM event prev_edit WHERE prev_edit.rowid = event.last_edit_rowid), 0);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER event_insert_update_last_edit
+	AFTER INSERT
+	ON event
+	FOR EACH ROW
+	WHEN (NEW.relation_type = 'm.replace'
+		AND NEW.redacted_by IS NULL
+		AND NEW.state_key IS NULL)
+EXECUTE FUNCTION event_insert_update_last_edit();
+
+-- The first trigger argument is the amount to add to the reaction count.
+CREATE FUNCTION event_update_reaction_counts() RETURNS TRIGGER AS $$
+DECLARE
+	reaction_key TEXT    := safe_json_string(NEW.content, 'm.relates_to', 'key');
+	delta        INTEGER := TG_ARGV[0]::INTEGER;
+BEGIN
+	IF reaction_key IS NULL THEN
+		RETURN NULL;
+	END IF;
+	UPDATE event
+	SET reactions = jsonb_set(
+		reactions::jsonb,
+		ARRAY [reaction_key],
+		to_jsonb(COALESCE((reactions::jsonb ->> reaction_key)::INTEGER, 0) + delta)
+	)::json
+	WHERE event_id = NEW.relates_to
+	  AND reactions IS NOT NULL;
+	RETURN NULL;
+EXCEPTION WHEN untranslatable_character OR invalid_text_representation THEN
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER event_insert_fill_reactions
+	AFTER INSERT
+	ON event
+	FOR EACH ROW
+	WHEN (NEW.type = 'm.reaction'
+		AND NEW.relation_type = 'm.annotation'
+		AND NEW.redacted_by IS NULL)
+EXECUTE FUNCTION event_update_reaction_counts('1');
+
+CREATE TRIGGER event_redact_fill_reactions
+	AFTER UPDATE
+	ON event
+	FOR EACH ROW
+	WHEN (NEW.type = 'm.reaction'
+		AND NEW.relation_type = 'm.annotation'
+		AND NEW.redacted_by IS NOT NULL
+		AND OLD.redacted_by IS NULL)
+EXECUTE FUNCTION event_update_reaction_counts('-1');
+
+CREATE TABLE media (
+	mxc             TEXT NOT NULL PRIMARY KEY,
+	enc_file        json,
+	file_name       TEXT,
+	mime_type       TEXT,
+	size            BIGINT,
+	hash            bytea,
+	error           json,
+
+	thumbnail_size  BIGINT,
+	thumbnail_hash  bytea,
+	thumbnail_error TEXT,
+
+	last_accessed   BIGINT
+);
+CREATE INDEX media_last_accessed_idx ON media (last_accessed) WHERE hash IS NOT NULL;
+
+CREATE TABLE media_reference (
+	event_rowid BIGINT NOT NULL,
+	media_mxc   TEXT   NOT NULL,
+
+	PRIMARY KEY (event_rowid, media_mxc),
+	CONSTRAINT media_reference_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT media_reference_media_fkey FOREIGN KEY (media_mxc) REFERENCES media (mxc) ON DELETE CASCADE
+);
+
+CREATE TABLE session_request (
+	-- SQLite has an implicit rowid, which is used to request sessions in the order they were added
+	rowid          BIGINT GENERATED BY DEFAULT AS IDENTITY,
+	room_id        TEXT    NOT NULL,
+	session_id     TEXT    NOT NULL,
+	sender         TEXT    NOT NULL,
+	min_index      BIGINT  NOT NULL,
+	backup_checked BOOLEAN NOT NULL DEFAULT false,
+	request_sent   BOOLEAN NOT NULL DEFAULT false,
+
+	PRIMARY KEY (session_id),
+	CONSTRAINT session_request_queue_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
+CREATE INDEX session_request_room_idx ON session_request (room_id);
+
+CREATE TABLE timeline (
+	rowid       BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	room_id     TEXT   NOT NULL,
+	event_rowid BIGINT NOT NULL,
+
+	CONSTRAINT timeline_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE,
+	CONSTRAINT timeline_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT timeline_event_unique_key UNIQUE (event_rowid)
+);
+CREATE INDEX timeline_room_id_idx ON timeline (room_id);
+
+CREATE TABLE current_state (
+	room_id     TEXT   NOT NULL,
+	event_type  TEXT   NOT NULL,
+	state_key   TEXT   NOT NULL,
+	event_rowid BIGINT NOT NULL,
+
+	membership  TEXT,
+
+	PRIMARY KEY (room_id, event_type, state_key),
+	CONSTRAINT current_state_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE,
+	CONSTRAINT current_state_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid),
+	CONSTRAINT current_state_rowid_unique UNIQUE (event_rowid)
+);
+
+CREATE TABLE receipt (
+	room_id      TEXT   NOT NULL,
+	user_id      TEXT   NOT NULL,
+	receipt_type TEXT   NOT NULL,
+	thread_id    TEXT   NOT NULL,
+	event_id     TEXT   NOT NULL,
+	timestamp    BIGINT NOT NULL,
+
+	PRIMARY KEY (room_id, user_id, receipt_type, thread_id),
+	CONSTRAINT receipt_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+	-- note: there's no foreign key on event ID because receipts could point at events that are too far in history.
+);
+
+CREATE TABLE space_edge (
+	space_id           TEXT    NOT NULL,
+	child_id           TEXT    NOT NULL,
+
+	-- m.space.child fields
+	child_event_rowid  BIGINT,
+	"order"            TEXT    NOT NULL DEFAULT '',
+	suggested          BOOLEAN NOT NULL DEFAULT false,
+	-- m.space.parent fields
+	parent_event_rowid BIGINT,
+	canonical          BOOLEAN NOT NULL DEFAULT false,
+	parent_validated   BOOLEAN NOT NULL DEFAULT false,
+
+	PRIMARY KEY (space_id, child_id),
+	CONSTRAINT space_edge_child_event_fkey FOREIGN KEY (child_event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT space_edge_parent_event_fkey FOREIGN KEY (parent_event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT space_edge_child_event_unique UNIQUE (child_event_rowid),
+	CONSTRAINT space_edge_parent_event_unique UNIQUE (parent_event_rowid)
+);
+CREATE INDEX space_edge_child_idx ON space_edge (child_id);
+
+CREATE TABLE push_registration (
+	device_id  TEXT   NOT NULL,
+	type       TEXT   NOT NULL,
+	data       json   NOT NULL,
+	encryption json   NOT NULL,
+	expiration BIGINT NOT NULL,
+
+	PRIMARY KEY (device_id)
+);
+
+CREATE TABLE presence (
+	user_id          TEXT    NOT NULL PRIMARY KEY,
+	presence         TEXT    NOT NULL,
+	status_msg       TEXT    NOT NULL DEFAULT '',
+	last_active_ts   BIGINT,
+	currently_active BOOLEAN NOT NULL DEFAULT false,
+	updated_at       BIGINT  NOT NULL
+);
+
+CREATE TABLE draft (
+	room_id    TEXT   NOT NULL PRIMARY KEY,
+	text       TEXT   NOT NULL,
+	reply_to   TEXT,
+	extra      json,
+	updated_at BIGINT NOT NULL,
+
+	CONSTRAINT draft_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
+
+CREATE TABLE thread (
+	room_id              TEXT    NOT NULL,
+	thread_root          TEXT    NOT NULL,
+	reply_count          INTEGER NOT NULL DEFAULT 0,
+	latest_event_rowid   BIGINT,
+	latest_timestamp     BIGINT  NOT NULL DEFAULT 0,
+	participated         BOOLEAN NOT NULL DEFAULT false,
+	unread_highlights    INTEGER NOT NULL DEFAULT 0,
+	unread_notifications INTEGER NOT NULL DEFAULT 0,
+	unread_messages      INTEGER NOT NULL DEFAULT 0,
+
+	PRIMARY KEY (room_id, thread_root),
+	CONSTRAINT thread_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE,
+	CONSTRAINT thread_latest_event_fkey FOREIGN KEY (latest_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
+);
+CREATE INDEX thread_room_latest_idx ON thread (room_id, latest_timestamp DESC);
+
+CREATE TABLE outbox (
+	event_rowid  BIGINT  NOT NULL PRIMARY KEY,
+	room_id      TEXT    NOT NULL,
+	queued_at    BIGINT  NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt BIGINT  NOT NULL,
+	last_error   TEXT,
+
+	CONSTRAINT outbox_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT outbox_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
+CREATE INDEX outbox_room_idx ON outbox (room_id, event_rowid);
+
+CREATE TABLE scheduled_message (
+	schedule_id        TEXT    NOT NULL PRIMARY KEY,
+	room_id            TEXT    NOT NULL,
+	type               TEXT    NOT NULL,
+	content            json    NOT NULL,
+	edit_source        TEXT,
+	disable_encryption BOOLEAN NOT NULL DEFAULT false,
+	send_at            BIGINT  NOT NULL,
+	delay_id           TEXT,
+	created_at         BIGINT  NOT NULL,
+
+	CONSTRAINT scheduled_message_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
+CREATE INDEX scheduled_message_send_at_idx ON scheduled_message (send_at);
+
+CREATE TABLE beacon (
+	room_id           TEXT    NOT NULL,
+	beacon_id         TEXT    NOT NULL,
+	state_key         TEXT    NOT NULL,
+	user_id           TEXT    NOT NULL,
+	description       TEXT,
+	live              BOOLEAN NOT NULL,
+	started_at        BIGINT  NOT NULL,
+	expires_at        BIGINT  NOT NULL,
+	location_event_id TEXT,
+	geo_uri           TEXT,
+	location_ts       BIGINT,
+
+	PRIMARY KEY (room_id, beacon_id),
+	CONSTRAINT beacon_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
+CREATE INDEX beacon_room_state_key_idx ON beacon (room_id, state_key);
+
+CREATE TABLE live_location_share (
+	room_id      TEXT    NOT NULL PRIMARY KEY,
+	beacon_id    TEXT    NOT NULL,
+	description  TEXT,
+	started_at   BIGINT  NOT NULL,
+	expires_at   BIGINT  NOT NULL,
+	geo_uri      TEXT,
+	updated_at   BIGINT,
+	last_sent_at BIGINT,
+
+	CONSTRAINT live_location_share_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+);
@@ -1,4 +1,4 @@
--- v0 -> v14 (compatible with v10+): Latest revision
+-- v0 -> v22 (compatible with v10+): Latest revision
 CREATE TABLE account (
 	user_id        TEXT NOT NULL PRIMARY KEY,
 	device_id      TEXT NOT NULL,
@@ -104,6 +104,7 @@ CREATE TABLE event (
 	reactions         TEXT,
 	last_edit_rowid   INTEGER,
 	unread_type       INTEGER NOT NULL DEFAULT 0,
+	poll_tally        TEXT,
 
 	CONSTRAINT event_id_unique_key UNIQUE (event_id),
 	CONSTRAINT transaction_id_unique_key UNIQUE (transaction_id),
@@ -223,8 +224,11 @@ CREATE TABLE media (
 
 	thumbnail_size  INTEGER,
 	thumbnail_hash  BLOB,
-	thumbnail_error TEXT
+	thumbnail_error TEXT,
+
+	last_accessed   INTEGER
 ) STRICT;
+CREATE INDEX media_last_accessed_idx ON media (last_accessed) WHERE hash IS NOT NULL;
 
 CREATE TABLE media_reference (
 	event_rowid INTEGER NOT NULL,
@@ -316,3 +320,98 @@ CREATE TABLE push_registration (
 
 	PRIMARY KEY (device_id)
 ) STRICT;
+
+CREATE TABLE presence (
+	user_id          TEXT    NOT NULL PRIMARY KEY,
+	presence         TEXT    NOT NULL,
+	status_msg       TEXT    NOT NULL DEFAULT '',
+	last_active_ts   INTEGER,
+	currently_active INTEGER NOT NULL DEFAULT false CHECK ( currently_active IN (false, true) ),
+	updated_at       INTEGER NOT NULL
+) STRICT;
+
+CREATE TABLE draft (
+	room_id    TEXT    NOT NULL PRIMARY KEY,
+	text       TEXT    NOT NULL,
+	reply_to   TEXT,
+	extra      TEXT,
+	updated_at INTEGER NOT NULL,
+
+	CONSTRAINT draft_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+
+CREATE TABLE thread (
+	room_id              TEXT    NOT NULL,
+	thread_root          TEXT    NOT NULL,
+	reply_count          INTEGER NOT NULL DEFAULT 0,
+	latest_event_rowid   INTEGER,
+	latest_timestamp     INTEGER NOT NULL DEFAULT 0,
+	participated         INTEGER NOT NULL DEFAULT false CHECK ( participated IN (false, true) ),
+	unread_highlights    INTEGER NOT NULL DEFAULT 0,
+	unread_notifications INTEGER NOT NULL DEFAULT 0,
+	unread_messages      INTEGER NOT NULL DEFAULT 0,
+
+	PRIMARY KEY (room_id, thread_root),
+	CONSTRAINT thread_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE,
+	CONSTRAINT thread_latest_event_fkey FOREIGN KEY (latest_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
+) STRICT;
+CREATE INDEX thread_room_latest_idx ON thread (room_id, latest_timestamp DESC);
+
+CREATE TABLE outbox (
+	event_rowid  INTEGER NOT NULL PRIMARY KEY,
+	room_id      TEXT    NOT NULL,
+	queued_at    INTEGER NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt INTEGER NOT NULL,
+	last_error   TEXT,
+
+	CONSTRAINT outbox_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT outbox_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX outbox_room_idx ON outbox (room_id, event_rowid);
+
+CREATE TABLE scheduled_message (
+	schedule_id        TEXT    NOT NULL PRIMARY KEY,
+	room_id            TEXT    NOT NULL,
+	type               TEXT    NOT NULL,
+	content            TEXT    NOT NULL,
+	edit_source        TEXT,
+	disable_encryption INTEGER NOT NULL DEFAULT false CHECK ( disable_encryption IN (false, true) ),
+	send_at            INTEGER NOT NULL,
+	delay_id           TEXT,
+	created_at         INTEGER NOT NULL,
+
+	CONSTRAINT scheduled_message_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX scheduled_message_send_at_idx ON scheduled_message (send_at);
+
+CREATE TABLE beacon (
+	room_id           TEXT    NOT NULL,
+	beacon_id         TEXT    NOT NULL,
+	state_key         TEXT    NOT NULL,
+	user_id           TEXT    NOT NULL,
+	description       TEXT,
+	live              INTEGER NOT NULL CHECK ( live IN (false, true) ),
+	started_at        INTEGER NOT NULL,
+	expires_at        INTEGER NOT NULL,
+	location_event_id TEXT,
+	geo_uri           TEXT,
+	location_ts       INTEGER,
+
+	PRIMARY KEY (room_id, beacon_id),
+	CONSTRAINT beacon_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX beacon_room_state_key_idx ON beacon (room_id, state_key);
+
+CREATE TABLE live_location_share (
+	room_id      TEXT    NOT NULL PRIMARY KEY,
+	beacon_id    TEXT    NOT NULL,
+	description  TEXT,
+	started_at   INTEGER NOT NULL,
+	expires_at   INTEGER NOT NULL,
+	geo_uri      TEXT,
+	updated_at   INTEGER,
+	last_sent_at INTEGER,
+
+	CONSTRAINT live_location_share_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
@@ -0,0 +1,9 @@
+-- v15 (compatible with v10+): Add table for presence
+CREATE TABLE presence (
+	user_id          TEXT    NOT NULL PRIMARY KEY,
+	presence         TEXT    NOT NULL,
+	status_msg       TEXT    NOT NULL DEFAULT '',
+	last_active_ts   INTEGER,
+	currently_active INTEGER NOT NULL DEFAULT false CHECK ( currently_active IN (false, true) ),
+	updated_at       INTEGER NOT NULL
+) STRICT;
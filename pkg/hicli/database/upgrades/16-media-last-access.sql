@@ -0,0 +1,3 @@
+-- v16 (compatible with v10+): Add last access timestamp for media cache eviction
+ALTER TABLE media ADD COLUMN last_accessed INTEGER;
+CREATE INDEX media_last_accessed_idx ON media (last_accessed) WHERE hash IS NOT NULL;
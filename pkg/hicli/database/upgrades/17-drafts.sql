@@ -0,0 +1,10 @@
+-- v17 (compatible with v10+): Add table for drafts
+CREATE TABLE draft (
+	room_id    TEXT    NOT NULL PRIMARY KEY,
+	text       TEXT    NOT NULL,
+	reply_to   TEXT,
+	extra      TEXT,
+	updated_at INTEGER NOT NULL,
+
+	CONSTRAINT draft_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
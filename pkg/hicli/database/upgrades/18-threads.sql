@@ -0,0 +1,47 @@
+-- v18 (compatible with v10+): Add table for thread summaries
+CREATE TABLE thread (
+	room_id              TEXT    NOT NULL,
+	thread_root          TEXT    NOT NULL,
+	reply_count          INTEGER NOT NULL DEFAULT 0,
+	latest_event_rowid   INTEGER,
+	latest_timestamp     INTEGER NOT NULL DEFAULT 0,
+	participated         INTEGER NOT NULL DEFAULT false CHECK ( participated IN (false, true) ),
+	unread_highlights    INTEGER NOT NULL DEFAULT 0,
+	unread_notifications INTEGER NOT NULL DEFAULT 0,
+	unread_messages      INTEGER NOT NULL DEFAULT 0,
+
+	PRIMARY KEY (room_id, thread_root),
+	CONSTRAINT thread_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE,
+	CONSTRAINT thread_latest_event_fkey FOREIGN KEY (latest_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
+) STRICT;
+CREATE INDEX thread_room_latest_idx ON thread (room_id, latest_timestamp DESC);
+
+INSERT INTO thread (room_id, thread_root, reply_count, latest_event_rowid, latest_timestamp, participated)
+SELECT
+	reply.room_id,
+	reply.relates_to,
+	COUNT(*),
+	(
+		SELECT latest.rowid
+		FROM event latest
+		WHERE latest.room_id = reply.room_id
+		  AND latest.relates_to = reply.relates_to
+		  AND latest.relation_type = 'm.thread'
+		  AND latest.redacted_by IS NULL
+		ORDER BY latest.timestamp DESC
+		LIMIT 1
+	),
+	MAX(reply.timestamp),
+	EXISTS(
+		SELECT 1
+		FROM event own
+		WHERE own.room_id = reply.room_id
+		  AND own.sender = (SELECT user_id FROM account LIMIT 1)
+		  AND (own.event_id = reply.relates_to OR (own.relates_to = reply.relates_to AND own.relation_type = 'm.thread'))
+	)
+FROM event reply
+WHERE reply.relation_type = 'm.thread'
+  AND reply.relates_to IS NOT NULL
+  AND reply.redacted_by IS NULL
+  AND reply.room_id IN (SELECT room_id FROM room)
+GROUP BY reply.room_id, reply.relates_to;
@@ -0,0 +1,15 @@
+-- v20 (compatible with v10+): Add table for scheduled messages
+CREATE TABLE scheduled_message (
+	schedule_id        TEXT    NOT NULL PRIMARY KEY,
+	room_id            TEXT    NOT NULL,
+	type               TEXT    NOT NULL,
+	content            TEXT    NOT NULL,
+	edit_source        TEXT,
+	disable_encryption INTEGER NOT NULL DEFAULT false CHECK ( disable_encryption IN (false, true) ),
+	send_at            INTEGER NOT NULL,
+	delay_id           TEXT,
+	created_at         INTEGER NOT NULL,
+
+	CONSTRAINT scheduled_message_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX scheduled_message_send_at_idx ON scheduled_message (send_at);
@@ -0,0 +1,6 @@
+-- v21 (compatible with v10+): Add poll tally column to events
+-- Postgres uses the json type for all JSON columns
+-- only: postgres
+ALTER TABLE event ADD COLUMN poll_tally json;
+-- only: sqlite
+ALTER TABLE event ADD COLUMN poll_tally TEXT;
@@ -0,0 +1,31 @@
+-- v22 (compatible with v10+): Add tables for live location beacons
+CREATE TABLE beacon (
+	room_id           TEXT    NOT NULL,
+	beacon_id         TEXT    NOT NULL,
+	state_key         TEXT    NOT NULL,
+	user_id           TEXT    NOT NULL,
+	description       TEXT,
+	live              INTEGER NOT NULL CHECK ( live IN (false, true) ),
+	started_at        INTEGER NOT NULL,
+	expires_at        INTEGER NOT NULL,
+	location_event_id TEXT,
+	geo_uri           TEXT,
+	location_ts       INTEGER,
+
+	PRIMARY KEY (room_id, beacon_id),
+	CONSTRAINT beacon_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX beacon_room_state_key_idx ON beacon (room_id, state_key);
+
+CREATE TABLE live_location_share (
+	room_id      TEXT    NOT NULL PRIMARY KEY,
+	beacon_id    TEXT    NOT NULL,
+	description  TEXT,
+	started_at   INTEGER NOT NULL,
+	expires_at   INTEGER NOT NULL,
+	geo_uri      TEXT,
+	updated_at   INTEGER,
+	last_sent_at INTEGER,
+
+	CONSTRAINT live_location_share_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
@@ -84,6 +84,8 @@ func (h *HiClient) handleReceivedMegolmSession(ctx context.Context, roomID id.Ro
 	}
 	if len(decrypted) > 0 {
 		var newPreview database.EventRowID
+		var extraPolls []*database.Event
+		var beacons []*database.Beacon
 		err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
 			for _, evt := range decrypted {
 				err = h.DB.Event.UpdateDecrypted(ctx, evt)
@@ -100,12 +102,36 @@ func (h *HiClient) handleReceivedMegolmSession(ctx context.Context, roomID id.Ro
 					}
 				}
 			}
+			extraPolls, err = h.recalculatePollsOfEvents(ctx, decrypted)
+			if err != nil {
+				return fmt.Errorf("failed to update poll tallies: %w", err)
+			}
+			var beaconIDs []id.EventID
+			for _, evt := range decrypted {
+				var changed []id.EventID
+				changed, err = h.processBeaconEvent(ctx, evt)
+				if err != nil {
+					return err
+				}
+				beaconIDs = append(beaconIDs, changed...)
+			}
+			if len(beaconIDs) > 0 {
+				beacons, err = h.DB.Beacon.GetMany(ctx, roomID, beaconIDs)
+				if err != nil {
+					return fmt.Errorf("failed to get changed beacons: %w", err)
+				}
+			}
 			return nil
 		})
 		if err != nil {
 			log.Err(err).Msg("Failed to save decrypted events")
 		} else {
-			h.EventHandler(&jsoncmd.EventsDecrypted{Events: decrypted, PreviewEventRowID: newPreview, RoomID: roomID})
+			h.EventHandler(&jsoncmd.EventsDecrypted{
+				Events:            append(decrypted, extraPolls...),
+				PreviewEventRowID: newPreview,
+				RoomID:            roomID,
+				Beacons:           beacons,
+			})
 		}
 	}
 }
@@ -0,0 +1,44 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"go.mau.fi/util/jsontime"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+// SetDraft saves the draft of a room, or deletes it if it's empty. The change is dispatched to all connected
+// frontends, so they all see the same draft.
+func (h *HiClient) SetDraft(ctx context.Context, draft *database.Draft) (*database.Draft, error) {
+	if draft.RoomID == "" {
+		return nil, fmt.Errorf("room ID is required")
+	}
+	if draft.IsEmpty() {
+		err := h.DB.Draft.Delete(ctx, draft.RoomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete draft: %w", err)
+		}
+		h.EventHandler(&jsoncmd.Draft{RoomID: draft.RoomID})
+		return nil, nil
+	}
+	draft.UpdatedAt = jsontime.UnixMilliNow()
+	err := h.DB.Draft.Put(ctx, draft)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save draft: %w", err)
+	}
+	h.EventHandler(&jsoncmd.Draft{RoomID: draft.RoomID, Draft: draft})
+	return draft, nil
+}
+
+func (h *HiClient) GetDrafts(ctx context.Context) ([]*database.Draft, error) {
+	return h.DB.Draft.GetAll(ctx)
+}
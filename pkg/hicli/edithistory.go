@@ -0,0 +1,121 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+const (
+	editHistoryPageLimit = 100
+	// maxEditHistoryPages limits how many edits are fetched from the server, so that a spammy event
+	// can't make the request take forever.
+	maxEditHistoryPages = 10
+)
+
+// GetEditHistory returns the original version of an event along with all of its edits. The edits are fetched
+// from the server first, so that edits which weren't received through sync or pagination are included too.
+// If the given event is itself an edit, the history of the event it edits is returned instead.
+func (h *HiClient) GetEditHistory(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*jsoncmd.EditHistoryResponse, error) {
+	evt, err := h.getEditHistoryTarget(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if evt.RelationType == event.RelReplace && evt.RelatesTo != "" {
+		evt, err = h.getEditHistoryTarget(ctx, roomID, evt.RelatesTo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = h.fetchEdits(ctx, roomID, evt.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Stringer("event_id", evt.ID).
+			Msg("Failed to fetch edits from server, returning locally known edits")
+	}
+	edits, err := h.DB.Event.GetEditHistory(ctx, evt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get edits: %w", err)
+	}
+	return &jsoncmd.EditHistoryResponse{Event: evt, Edits: edits}, nil
+}
+
+func (h *HiClient) getEditHistoryTarget(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*database.Event, error) {
+	evt, err := h.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event %s: %w", eventID, err)
+	} else if evt.RoomID != roomID {
+		return nil, fmt.Errorf("event %s not found in %s", eventID, roomID)
+	}
+	return evt, nil
+}
+
+// fetchEdits fetches the m.replace relations of the given event from the server and stores them in the database.
+func (h *HiClient) fetchEdits(ctx context.Context, roomID id.RoomID, eventID id.EventID) error {
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get room from database: %w", err)
+	} else if room == nil {
+		return fmt.Errorf("not in room %s", roomID)
+	}
+	var from string
+	for range maxEditHistoryPages {
+		resp, err := h.Client.GetRelations(ctx, roomID, eventID, &mautrix.ReqGetRelations{
+			RelationType: event.RelReplace,
+			Dir:          mautrix.DirectionBackward,
+			From:         from,
+			Limit:        editHistoryPageLimit,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get edits from server: %w", err)
+		}
+		err = h.processFetchedRelations(ctx, room, resp.Chunk)
+		if err != nil {
+			return err
+		}
+		if resp.NextBatch == "" {
+			break
+		}
+		from = resp.NextBatch
+	}
+	return nil
+}
+
+// processFetchedRelations stores events fetched from the relations endpoint and queues key requests
+// for the ones that can't be decrypted yet.
+func (h *HiClient) processFetchedRelations(ctx context.Context, room *database.Room, evts []*event.Event) error {
+	wakeupSessionRequests := false
+	err := h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		decryptionQueue := make(map[id.SessionID]*database.SessionRequest)
+		for _, evt := range evts {
+			evt.RoomID = room.ID
+			_, err := h.processEvent(ctx, evt, room.LazyLoadSummary, decryptionQueue, true)
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		wakeupSessionRequests, err = h.saveDecryptionQueue(ctx, decryptionQueue)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if wakeupSessionRequests {
+		h.WakeupRequestQueue()
+	}
+	return nil
+}
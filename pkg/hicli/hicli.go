@@ -25,6 +25,8 @@ import (
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mautrix/pushrules"
 
@@ -53,6 +55,19 @@ type HiClient struct {
 	lastSync   time.Time
 
 	ToDeviceInSync atomic.Bool
+	// EnablePresence controls whether presence updates are requested in the sync filter.
+	// Changing this only takes effect when syncing is restarted.
+	EnablePresence bool
+	// UseSlidingSync switches the sync loop to simplified sliding sync (MSC4186) instead of the classic /sync endpoint.
+	UseSlidingSync bool
+	// SearchAvailable is set at startup if the SQLite library supports FTS5 and the search index was initialized.
+	SearchAvailable bool
+	// Retention is the default policy for pruning old events from the local database.
+	Retention RetentionPolicy
+	// RoomRetention overrides the default retention policy for specific rooms.
+	RoomRetention map[id.RoomID]RetentionPolicy
+	// HTMLSanitizerImgSrcTemplate overrides the global template for media URLs in sanitized HTML if set.
+	HTMLSanitizerImgSrcTemplate string
 
 	EventHandler func(evt any)
 	LogoutFunc   func(context.Context) error
@@ -63,14 +78,28 @@ type HiClient struct {
 	stopSync          atomic.Pointer[context.CancelFunc]
 	encryptLock       sync.Mutex
 	loginLock         sync.Mutex
+	bookmarksLock     sync.Mutex
+	keyBackupLock     sync.Mutex
 
 	requestQueueWakeup chan struct{}
+	outboxWakeup       chan struct{}
+	schedulerWakeup    chan struct{}
+	liveLocationWakeup chan struct{}
 
 	jsonRequestsLock sync.Mutex
 	jsonRequests     map[int64]context.CancelCauseFunc
 
 	paginationInterrupterLock sync.Mutex
 	paginationInterrupter     map[id.RoomID]context.CancelCauseFunc
+
+	VerificationHelper *verificationhelper.VerificationHelper
+	verificationsLock  sync.Mutex
+	verifications      map[id.VerificationTransactionID]*verificationTransaction
+
+	syncerHandlersLock   sync.RWMutex
+	syncerEventHandlers  map[event.Type][]mautrix.EventHandler
+	syncerGlobalHandlers []mautrix.EventHandler
+	syncerSyncHandlers   []mautrix.SyncHandler
 }
 
 var (
@@ -98,8 +127,13 @@ func New(rawDB, cryptoDB *dbutil.Database, log zerolog.Logger, pickleKey []byte,
 		Log: log,
 
 		requestQueueWakeup:    make(chan struct{}, 1),
+		outboxWakeup:          make(chan struct{}, 1),
+		schedulerWakeup:       make(chan struct{}, 1),
+		liveLocationWakeup:    make(chan struct{}, 1),
 		jsonRequests:          make(map[int64]context.CancelCauseFunc),
 		paginationInterrupter: make(map[id.RoomID]context.CancelCauseFunc),
+		verifications:         make(map[id.VerificationTransactionID]*verificationTransaction),
+		syncerEventHandlers:   make(map[event.Type][]mautrix.EventHandler),
 
 		EventHandler: evtHandler,
 	}
@@ -142,6 +176,11 @@ func New(rawDB, cryptoDB *dbutil.Database, log zerolog.Logger, pickleKey []byte,
 	c.Crypto.DisableDecryptKeyFetching = true
 	c.Crypto.IgnorePostDecryptionParseErrors = true
 	c.Client.Crypto = (*hiCryptoHelper)(c)
+	c.VerificationHelper = newVerificationHelper(c)
+	err := c.VerificationHelper.Init(context.Background())
+	if err != nil {
+		log.Err(err).Msg("Failed to initialize verification helper")
+	}
 	return c
 }
 
@@ -174,6 +213,12 @@ func (h *HiClient) Start(ctx context.Context, userID id.UserID, expectedAccount
 	if err != nil {
 		return fmt.Errorf("failed to upgrade crypto db: %w", err)
 	}
+	h.SearchAvailable, err = h.DB.InitSearchIndex(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize search index: %w", err)
+	} else if !h.SearchAvailable {
+		zerolog.Ctx(ctx).Warn().Msg("SQLite was built without FTS5, local message search is disabled")
+	}
 	account, err := h.DB.Account.Get(ctx, userID)
 	if err != nil {
 		return err
@@ -258,14 +303,31 @@ func (h *HiClient) Sync() {
 		Str("action", "sync").
 		Int("sync_id", syncingID).
 		Logger()
+	// The background loops are waited for before releasing the sync lock,
+	// so that they never run concurrently with the loops of the next sync.
+	var wg sync.WaitGroup
+	defer wg.Wait()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	h.stopSync.Store(&cancel)
-	go h.RunRequestQueue(h.Log.WithContext(ctx))
-	go h.LoadPushRules(h.Log.WithContext(ctx))
+	bgCtx := h.Log.WithContext(ctx)
+	for _, loop := range []func(context.Context){
+		h.RunRequestQueue, h.LoadPushRules, h.RunRetention, h.RunOutbox, h.RunScheduler, h.RunLiveLocation,
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop(bgCtx)
+		}()
+	}
 	ctx = log.WithContext(ctx)
 	log.Info().Msg("Starting syncing")
-	err := h.Client.SyncWithContext(ctx)
+	var err error
+	if h.UseSlidingSync {
+		err = h.syncSliding(ctx)
+	} else {
+		err = h.Client.SyncWithContext(ctx)
+	}
 	if err != nil && ctx.Err() == nil {
 		h.markSyncErrored(err, true)
 		log.Err(err).Msg("Fatal error in syncer")
@@ -275,13 +337,18 @@ func (h *HiClient) Sync() {
 	}
 }
 
-func (h *HiClient) Stop() {
+// stopSyncAndLock stops the sync loop and waits for it to exit. The sync lock is held when this returns,
+// so the caller must unlock it, after which a new sync loop can be started.
+func (h *HiClient) stopSyncAndLock() {
 	h.Client.StopSync()
 	if fn := h.stopSync.Swap(nil); fn != nil {
 		(*fn)()
 	}
 	h.syncLock.Lock()
-	//lint:ignore SA2001 just acquire the lock to make sure Sync is done
+}
+
+func (h *HiClient) Stop() {
+	h.stopSyncAndLock()
 	h.syncLock.Unlock()
 	err := h.DB.Close()
 	if err != nil {
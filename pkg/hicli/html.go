@@ -184,7 +184,7 @@ func writeMention(w *strings.Builder, mention []byte) {
 	w.WriteString("</a>")
 }
 
-func writeURL(w *strings.Builder, addr []byte) {
+func writeURL(w *strings.Builder, addr []byte, imgSrcTemplate string) {
 	addrString := string(addr)
 	parsedURL, err := url.Parse(addrString)
 	if err != nil {
@@ -232,7 +232,7 @@ func writeURL(w *strings.Builder, addr []byte) {
 		writeAttribute(w, "class", "hicli-mxc-url")
 		writeAttribute(w, "target", "_blank")
 		writeAttribute(w, "data-mxc", mxc.String())
-		writeAttribute(w, "href", fmt.Sprintf(HTMLSanitizerImgSrcTemplate, mxc.Homeserver, mxc.FileID))
+		writeAttribute(w, "href", fmt.Sprintf(imgSrcTemplate, mxc.Homeserver, mxc.FileID))
 		w.WriteByte('>')
 		writeEscapedBytes(w, addr)
 		w.WriteString("</a>")
@@ -262,7 +262,7 @@ func init() {
 	}
 }
 
-func linkifyAndWriteBytes(w *strings.Builder, s []byte) {
+func linkifyAndWriteBytes(w *strings.Builder, s []byte, imgSrcTemplate string) {
 	mentions := plainUserOrAliasMentionRegex.FindAllIndex(s, -1)
 	urls := xurls.Relaxed().FindAllIndex(s, -1)
 	minIndex := 0
@@ -276,7 +276,7 @@ func linkifyAndWriteBytes(w *strings.Builder, s []byte) {
 			mentions = mentions[mentionIdx:]
 		} else if hasURL && (!hasMention || nextURLStart < nextMentionStart) {
 			writeEscapedBytes(w, s[minIndex:nextURLStart])
-			writeURL(w, s[nextURLStart:nextURLEnd])
+			writeURL(w, s[nextURLStart:nextURLEnd], imgSrcTemplate)
 			minIndex = nextURLEnd
 			urls = urls[urlIdx:]
 		} else {
@@ -355,7 +355,7 @@ func matrixURIClassName(uri *id.MatrixURI) string {
 	}
 }
 
-func writeA(w *strings.Builder, attr []html.Attribute) (mxc id.ContentURI) {
+func writeA(w *strings.Builder, attr []html.Attribute, imgSrcTemplate string) (mxc id.ContentURI) {
 	w.WriteString("<a")
 	href := parseAAttributes(attr)
 	if href == "" {
@@ -398,7 +398,7 @@ func writeA(w *strings.Builder, attr []html.Attribute) (mxc id.ContentURI) {
 		writeAttribute(w, "class", "hicli-mxc-url")
 		writeAttribute(w, "target", "_blank")
 		writeAttribute(w, "data-mxc", mxc.String())
-		href = fmt.Sprintf(HTMLSanitizerImgSrcTemplate, mxc.Homeserver, mxc.FileID)
+		href = fmt.Sprintf(imgSrcTemplate, mxc.Homeserver, mxc.FileID)
 	default:
 		return
 	}
@@ -412,7 +412,14 @@ func writeA(w *strings.Builder, attr []html.Attribute) (mxc id.ContentURI) {
 
 var HTMLSanitizerImgSrcTemplate = "mxc://%s/%s"
 
-func writeImg(w *strings.Builder, attr []html.Attribute) id.ContentURI {
+func (h *HiClient) imgSrcTemplate() string {
+	if h.HTMLSanitizerImgSrcTemplate != "" {
+		return h.HTMLSanitizerImgSrcTemplate
+	}
+	return HTMLSanitizerImgSrcTemplate
+}
+
+func writeImg(w *strings.Builder, attr []html.Attribute, imgSrcTemplate string) id.ContentURI {
 	src, alt, title, isCustomEmoji, width, height := parseImgAttributes(attr)
 	mxc := id.ContentURIString(src).ParseOrIgnore()
 	if !mxc.IsValid() {
@@ -423,7 +430,7 @@ func writeImg(w *strings.Builder, attr []html.Attribute) id.ContentURI {
 		w.WriteString("</span>")
 		return id.ContentURI{}
 	}
-	url := fmt.Sprintf(HTMLSanitizerImgSrcTemplate, mxc.Homeserver, mxc.FileID)
+	url := fmt.Sprintf(imgSrcTemplate, mxc.Homeserver, mxc.FileID)
 
 	w.WriteString("<a")
 	writeAttribute(w, "class", "hicli-inline-img-fallback hicli-mxc-url")
@@ -517,7 +524,7 @@ func getCodeBlockLanguage(token html.Token) string {
 
 const builderPreallocBuffer = 100
 
-func sanitizeAndLinkifyHTML(body string) (string, []id.ContentURI, error) {
+func sanitizeAndLinkifyHTML(body, imgSrcTemplate string) (string, []id.ContentURI, error) {
 	tz := html.NewTokenizer(strings.NewReader(body))
 	var built strings.Builder
 	built.Grow(len(body) + builderPreallocBuffer)
@@ -551,12 +558,12 @@ Loop:
 				codeBlock = &strings.Builder{}
 				continue
 			case atom.A:
-				mxc := writeA(&built, token.Attr)
+				mxc := writeA(&built, token.Attr, imgSrcTemplate)
 				if !mxc.IsEmpty() {
 					inlineImages = append(inlineImages, mxc)
 				}
 			case atom.Img:
-				mxc := writeImg(&built, token.Attr)
+				mxc := writeImg(&built, token.Attr, imgSrcTemplate)
 				if !mxc.IsEmpty() {
 					inlineImages = append(inlineImages, mxc)
 				}
@@ -636,7 +643,7 @@ Loop:
 			} else if ts.contains(atom.Pre, atom.Code, atom.A) {
 				writeEscapedBytes(&built, tz.Text())
 			} else {
-				linkifyAndWriteBytes(&built, tz.Text())
+				linkifyAndWriteBytes(&built, tz.Text(), imgSrcTemplate)
 			}
 		case html.DoctypeToken, html.CommentToken:
 			// ignore
@@ -61,6 +61,22 @@ func (h *HiClient) getInitialSyncRoom(ctx context.Context, room *database.Room)
 			syncRoom.Events = append(syncRoom.Events, previewEvent)
 		}
 	}
+	if !room.UnreadCounts.IsZero() {
+		syncRoom.Threads, err = h.DB.Thread.GetUnread(ctx, room.ID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("room_id", room.ID).Msg("Failed to get unread threads for room")
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+	syncRoom.Beacons, err = h.DB.Beacon.GetLive(ctx, room.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("room_id", room.ID).Msg("Failed to get live beacons for room")
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
 	return syncRoom
 }
 
@@ -105,6 +121,17 @@ func (h *HiClient) GetInitialSync(ctx context.Context, batchSize int) iter.Seq[*
 				}
 				return
 			}
+			drafts, err := h.DB.Draft.GetAll(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					zerolog.Ctx(ctx).Err(err).Msg("Failed to get drafts to send to client")
+				}
+				return
+			}
+			payload.Drafts = make(map[id.RoomID]*database.Draft, len(drafts))
+			for _, draft := range drafts {
+				payload.Drafts[draft.RoomID] = draft
+			}
 			payload.ClearState = true
 			if !yield(&payload) {
 				return
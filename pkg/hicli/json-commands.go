@@ -44,9 +44,24 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 			return true, nil
 		})
 	case jsoncmd.ReqSendMessage:
-		return unmarshalAndCall(req.Data, func(params *jsoncmd.SendMessageParams) (*database.Event, error) {
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.SendMessageParams) (any, error) {
+			if !params.ScheduleAt.IsZero() {
+				return h.ScheduleMessage(ctx, params.RoomID, params.BaseContent, params.Extra, params.Text, params.RelatesTo, params.Mentions, params.URLPreviews, params.ScheduleAt.Time)
+			}
 			return h.SendMessage(ctx, params.RoomID, params.BaseContent, params.Extra, params.Text, params.RelatesTo, params.Mentions, params.URLPreviews)
 		})
+	case jsoncmd.ReqGetScheduledMessages:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetScheduledMessagesParams) ([]*database.ScheduledMessage, error) {
+			return h.GetScheduledMessages(ctx, params.RoomID)
+		})
+	case jsoncmd.ReqCancelScheduledMessage:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.CancelScheduledMessageParams) (bool, error) {
+			return true, h.CancelScheduledMessage(ctx, params.ScheduleID)
+		})
+	case jsoncmd.ReqRescheduleMessage:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.RescheduleMessageParams) (*database.ScheduledMessage, error) {
+			return h.RescheduleMessage(ctx, params.ScheduleID, params.SendAt.Time)
+		})
 	case jsoncmd.ReqSendEvent:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.SendEventParams) (*database.Event, error) {
 			return h.Send(ctx, params.RoomID, params.EventType, params.Content, params.DisableEncryption, params.Synchronous)
@@ -55,6 +70,44 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.ResendEventParams) (*database.Event, error) {
 			return h.Resend(ctx, params.TransactionID)
 		})
+	case jsoncmd.ReqSendPoll:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.SendPollParams) (*database.Event, error) {
+			maxSelections := params.MaxSelections
+			if maxSelections == 0 {
+				maxSelections = 1
+			}
+			return h.SendPoll(ctx, params.RoomID, params.Question, params.Answers, maxSelections, params.Undisclosed)
+		})
+	case jsoncmd.ReqVotePoll:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.VotePollParams) (*database.Event, error) {
+			return h.VotePoll(ctx, params.RoomID, params.PollID, params.Answers)
+		})
+	case jsoncmd.ReqEndPoll:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.EndPollParams) (*database.Event, error) {
+			return h.EndPoll(ctx, params.RoomID, params.PollID)
+		})
+	case jsoncmd.ReqSendLocation:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.SendLocationParams) (*database.Event, error) {
+			return h.SendLocation(ctx, params.RoomID, params.GeoURI, params.Description, params.Pin)
+		})
+	case jsoncmd.ReqStartLiveLocation:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.StartLiveLocationParams) (*database.LiveLocationShare, error) {
+			return h.StartLiveLocation(ctx, params.RoomID, time.Duration(params.DurationMS)*time.Millisecond, params.Description, params.GeoURI)
+		})
+	case jsoncmd.ReqUpdateLiveLocation:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.UpdateLiveLocationParams) (bool, error) {
+			return true, h.UpdateLiveLocation(ctx, params.GeoURI)
+		})
+	case jsoncmd.ReqStopLiveLocation:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.StopLiveLocationParams) (bool, error) {
+			return true, h.StopLiveLocation(ctx, params.RoomID)
+		})
+	case jsoncmd.ReqGetLiveLocationShares:
+		return h.GetLiveLocationShares(ctx)
+	case jsoncmd.ReqGetBeacons:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetBeaconsParams) ([]*database.Beacon, error) {
+			return h.GetBeacons(ctx, params.RoomID)
+		})
 	case jsoncmd.ReqReportEvent:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.ReportEventParams) (bool, error) {
 			return true, h.Client.ReportEvent(ctx, params.RoomID, params.EventID, params.Reason)
@@ -103,7 +156,7 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		})
 	case jsoncmd.ReqMarkRead:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.MarkReadParams) (bool, error) {
-			return true, h.MarkRead(ctx, params.RoomID, params.EventID, params.ReceiptType)
+			return true, h.MarkRead(ctx, params.RoomID, params.EventID, params.ReceiptType, params.ThreadID)
 		})
 	case jsoncmd.ReqSetTyping:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.SetTypingParams) (bool, error) {
@@ -113,6 +166,14 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetProfileParams) (*mautrix.RespUserProfile, error) {
 			return h.Client.GetProfile(mautrix.WithMaxRetries(ctx, 0), params.UserID)
 		})
+	case jsoncmd.ReqSetPresence:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.SetPresenceParams) (bool, error) {
+			return true, h.SetPresence(ctx, params.Presence, params.StatusMsg)
+		})
+	case jsoncmd.ReqGetPresence:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetPresenceParams) ([]*database.Presence, error) {
+			return h.GetPresence(ctx, params.UserIDs)
+		})
 	case jsoncmd.ReqSetProfileField:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.SetProfileFieldParams) (bool, error) {
 			return true, h.Client.UnstableSetProfileField(ctx, params.Field, params.Value)
@@ -133,6 +194,16 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetProfileParams) (*jsoncmd.ProfileEncryptionInfo, error) {
 			return h.GetProfileEncryptionInfo(ctx, params.UserID)
 		})
+	case jsoncmd.ReqGetOwnDevices:
+		return h.GetOwnDevices(ctx)
+	case jsoncmd.ReqRenameDevice:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.RenameDeviceParams) (bool, error) {
+			return true, h.RenameDevice(ctx, params.DeviceID, params.Name)
+		})
+	case jsoncmd.ReqDeleteDevices:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.DeleteDevicesParams) (*jsoncmd.DeleteDevicesResponse, error) {
+			return h.DeleteDevices(ctx, params.DeviceIDs, params.Password, params.Session)
+		})
 	case jsoncmd.ReqGetEvent:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetEventParams) (*database.Event, error) {
 			if params.Unredact {
@@ -144,6 +215,10 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetRelatedEventsParams) ([]*database.Event, error) {
 			return h.DB.Event.GetRelatedEvents(ctx, params.RoomID, params.EventID, params.RelationType)
 		})
+	case jsoncmd.ReqGetEditHistory:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetEditHistoryParams) (*jsoncmd.EditHistoryResponse, error) {
+			return h.GetEditHistory(ctx, params.RoomID, params.EventID)
+		})
 	case jsoncmd.ReqGetRoomState:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetRoomStateParams) ([]*database.Event, error) {
 			return h.GetRoomState(ctx, params.RoomID, params.IncludeMembers, params.FetchMembers, params.Refetch)
@@ -160,6 +235,36 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.PaginateParams) (*jsoncmd.PaginationResponse, error) {
 			return h.Paginate(ctx, params.RoomID, params.MaxTimelineID, params.Limit, params.Reset)
 		})
+	case jsoncmd.ReqGetThreads:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetThreadsParams) (*jsoncmd.GetThreadsResponse, error) {
+			return h.GetThreads(ctx, params.RoomID, params.Before.Time, params.OnlyParticipated, params.Limit)
+		})
+	case jsoncmd.ReqPaginateThread:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.PaginateThreadParams) (*jsoncmd.ThreadPaginationResponse, error) {
+			return h.PaginateThread(ctx, params.RoomID, params.ThreadRoot, params.From, params.Limit)
+		})
+	case jsoncmd.ReqGetRoomStats:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.GetRoomStatsParams) (*database.RoomStats, error) {
+			return h.GetRoomStats(ctx, params.RoomID, params.Since.Time, params.Until.Time)
+		})
+	case jsoncmd.ReqSearchMessages:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.SearchMessagesParams) (*jsoncmd.SearchMessagesResponse, error) {
+			return h.SearchMessages(ctx, &database.SearchParams{
+				Query:    params.Query,
+				RoomIDs:  params.RoomIDs,
+				Senders:  params.Senders,
+				MsgTypes: params.MsgTypes,
+				After:    params.After.Time,
+				Before:   params.Before.Time,
+				Order:    params.OrderBy,
+				Limit:    params.Limit,
+				Offset:   params.Offset,
+			})
+		})
+	case jsoncmd.ReqSearchServer:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.SearchServerParams) (*jsoncmd.ServerSearchResponse, error) {
+			return h.SearchServer(ctx, params.Query, params.RoomIDs, params.Senders, params.OrderBy, params.NextBatch, params.Limit)
+		})
 	case jsoncmd.ReqGetRoomSummary:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.JoinRoomParams) (*mautrix.RespRoomSummary, error) {
 			return h.Client.GetRoomSummary(mautrix.WithMaxRetries(ctx, 2), params.RoomIDOrAlias, params.Via...)
@@ -209,6 +314,29 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 				return false, h.Client.DeletePushRule(ctx, "global", pushrules.RoomRule, string(params.RoomID))
 			}
 		})
+	case jsoncmd.ReqCompactRoom:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.CompactRoomParams) (*database.PruneResult, error) {
+			return h.CompactRoom(ctx, params.RoomID, RetentionPolicy{
+				MaxAge:    params.MaxAge.Get(),
+				MaxEvents: params.MaxEvents,
+			}, params.Vacuum)
+		})
+	case jsoncmd.ReqSetDraft:
+		return unmarshalAndCall(req.Data, func(params *database.Draft) (*database.Draft, error) {
+			return h.SetDraft(ctx, params)
+		})
+	case jsoncmd.ReqGetDrafts:
+		return h.GetDrafts(ctx)
+	case jsoncmd.ReqAddBookmark:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.AddBookmarkParams) (*jsoncmd.BookmarkedEvent, error) {
+			return h.AddBookmark(ctx, params.RoomID, params.EventID, params.Note)
+		})
+	case jsoncmd.ReqRemoveBookmark:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.RemoveBookmarkParams) (bool, error) {
+			return h.RemoveBookmark(ctx, params.EventID)
+		})
+	case jsoncmd.ReqListBookmarks:
+		return h.ListBookmarks(ctx)
 	case jsoncmd.ReqEnsureGroupSessionShared:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.EnsureGroupSessionSharedParams) (bool, error) {
 			return true, h.EnsureGroupSessionShared(ctx, params.RoomID)
@@ -246,6 +374,56 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.VerifyParams) (bool, error) {
 			return true, h.Verify(ctx, params.RecoveryKey)
 		})
+	case jsoncmd.ReqBootstrapCrossSigning:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.BootstrapCrossSigningParams) (*jsoncmd.RecoveryKeyResponse, error) {
+			return h.BootstrapCrossSigning(ctx, params.Password, params.Passphrase)
+		})
+	case jsoncmd.ReqGetLatestKeyBackup:
+		return h.GetLatestKeyBackupVersion(ctx)
+	case jsoncmd.ReqCreateKeyBackupVersion:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.CreateKeyBackupVersionParams) (*jsoncmd.KeyBackupVersion, error) {
+			return h.CreateKeyBackupVersion(ctx, params.RecoveryKey)
+		})
+	case jsoncmd.ReqDeleteKeyBackupVersion:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.DeleteKeyBackupVersionParams) (bool, error) {
+			return true, h.DeleteKeyBackupVersion(ctx, params.Version)
+		})
+	case jsoncmd.ReqRotateRecoveryKey:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.RotateRecoveryKeyParams) (*jsoncmd.RecoveryKeyResponse, error) {
+			return h.RotateRecoveryKey(ctx, params.RecoveryKey, params.NewPassphrase)
+		})
+	case jsoncmd.ReqStartVerification:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.StartVerificationParams) (*jsoncmd.Verification, error) {
+			return h.StartVerification(ctx, params.UserID)
+		})
+	case jsoncmd.ReqAcceptVerification:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.VerificationParams) (bool, error) {
+			return true, h.AcceptVerification(ctx, params.TransactionID)
+		})
+	case jsoncmd.ReqStartSAS:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.VerificationParams) (bool, error) {
+			return true, h.StartSAS(ctx, params.TransactionID)
+		})
+	case jsoncmd.ReqConfirmSAS:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.ConfirmSASParams) (bool, error) {
+			return true, h.ConfirmSAS(ctx, params.TransactionID, params.Match)
+		})
+	case jsoncmd.ReqGetVerificationQRCode:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.VerificationParams) (*jsoncmd.VerificationQRCode, error) {
+			return h.GetVerificationQRCode(ctx, params.TransactionID)
+		})
+	case jsoncmd.ReqScanVerificationQRCode:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.ScanVerificationQRCodeParams) (bool, error) {
+			return true, h.ScanVerificationQRCode(ctx, params.Data)
+		})
+	case jsoncmd.ReqConfirmQRCodeScanned:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.ConfirmQRCodeScannedParams) (bool, error) {
+			return true, h.ConfirmQRCodeScanned(ctx, params.TransactionID, params.Confirmed)
+		})
+	case jsoncmd.ReqCancelVerification:
+		return unmarshalAndCall(req.Data, func(params *jsoncmd.CancelVerificationParams) (bool, error) {
+			return true, h.CancelVerification(ctx, params.TransactionID, params.Reason)
+		})
 	case jsoncmd.ReqDiscoverHomeserver:
 		return unmarshalAndCall(req.Data, func(params *jsoncmd.DiscoverHomeserverParams) (*mautrix.ClientWellKnown, error) {
 			_, homeserver, err := params.UserID.Parse()
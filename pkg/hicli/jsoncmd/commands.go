@@ -9,7 +9,10 @@ package jsoncmd
 type Container[T any] struct {
 	Command   Name  `json:"command"`
 	RequestID int64 `json:"request_id"`
-	Data      T     `json:"data"`
+	// Account selects which account a command is meant for, or which account an event came from.
+	// An empty value refers to the default account.
+	Account string `json:"account,omitempty"`
+	Data    T      `json:"data"`
 }
 
 type Name string
@@ -24,6 +27,18 @@ const (
 	ReqSendMessage              Name = "send_message"
 	ReqSendEvent                Name = "send_event"
 	ReqResendEvent              Name = "resend_event"
+	ReqSendPoll                 Name = "send_poll"
+	ReqVotePoll                 Name = "vote_poll"
+	ReqEndPoll                  Name = "end_poll"
+	ReqSendLocation             Name = "send_location"
+	ReqStartLiveLocation        Name = "start_live_location"
+	ReqUpdateLiveLocation       Name = "update_live_location"
+	ReqStopLiveLocation         Name = "stop_live_location"
+	ReqGetLiveLocationShares    Name = "get_live_location_shares"
+	ReqGetBeacons               Name = "get_beacons"
+	ReqGetScheduledMessages     Name = "get_scheduled_messages"
+	ReqCancelScheduledMessage   Name = "cancel_scheduled_message"
+	ReqRescheduleMessage        Name = "reschedule_message"
 	ReqReportEvent              Name = "report_event"
 	ReqRedactEvent              Name = "redact_event"
 	ReqSetState                 Name = "set_state"
@@ -33,22 +48,39 @@ const (
 	ReqMarkRead                 Name = "mark_read"
 	ReqSetTyping                Name = "set_typing"
 	ReqGetProfile               Name = "get_profile"
+	ReqSetPresence              Name = "set_presence"
+	ReqGetPresence              Name = "get_presence"
 	ReqSetProfileField          Name = "set_profile_field"
 	ReqGetMutualRooms           Name = "get_mutual_rooms"
 	ReqTrackUserDevices         Name = "track_user_devices"
 	ReqGetProfileEncryptionInfo Name = "get_profile_encryption_info"
+	ReqGetOwnDevices            Name = "get_own_devices"
+	ReqRenameDevice             Name = "rename_device"
+	ReqDeleteDevices            Name = "delete_devices"
 	ReqGetEvent                 Name = "get_event"
 	ReqGetRelatedEvents         Name = "get_related_events"
+	ReqGetEditHistory           Name = "get_edit_history"
 	ReqGetRoomState             Name = "get_room_state"
 	ReqGetSpecificRoomState     Name = "get_specific_room_state"
 	ReqGetReceipts              Name = "get_receipts"
 	ReqPaginate                 Name = "paginate"
+	ReqGetThreads               Name = "get_threads"
+	ReqPaginateThread           Name = "paginate_thread"
+	ReqSearchMessages           Name = "search_messages"
+	ReqSearchServer             Name = "search_server"
 	ReqGetRoomSummary           Name = "get_room_summary"
+	ReqGetRoomStats             Name = "get_room_stats"
 	ReqJoinRoom                 Name = "join_room"
 	ReqKnockRoom                Name = "knock_room"
 	ReqLeaveRoom                Name = "leave_room"
 	ReqCreateRoom               Name = "create_room"
 	ReqMuteRoom                 Name = "mute_room"
+	ReqCompactRoom              Name = "compact_room"
+	ReqSetDraft                 Name = "set_draft"
+	ReqGetDrafts                Name = "get_drafts"
+	ReqAddBookmark              Name = "add_bookmark"
+	ReqRemoveBookmark           Name = "remove_bookmark"
+	ReqListBookmarks            Name = "list_bookmarks"
 	ReqEnsureGroupSessionShared Name = "ensure_group_session_shared"
 	ReqSendToDevice             Name = "send_to_device"
 	ReqResolveAlias             Name = "resolve_alias"
@@ -57,12 +89,27 @@ const (
 	ReqLogin                    Name = "login"
 	ReqLoginCustom              Name = "login_custom"
 	ReqVerify                   Name = "verify"
+	ReqBootstrapCrossSigning    Name = "bootstrap_cross_signing"
+	ReqGetLatestKeyBackup       Name = "get_latest_key_backup"
+	ReqCreateKeyBackupVersion   Name = "create_key_backup_version"
+	ReqDeleteKeyBackupVersion   Name = "delete_key_backup_version"
+	ReqRotateRecoveryKey        Name = "rotate_recovery_key"
+	ReqStartVerification        Name = "start_verification"
+	ReqAcceptVerification       Name = "accept_verification"
+	ReqStartSAS                 Name = "start_sas"
+	ReqConfirmSAS               Name = "confirm_sas"
+	ReqCancelVerification       Name = "cancel_verification"
+	ReqGetVerificationQRCode    Name = "get_verification_qr_code"
+	ReqScanVerificationQRCode   Name = "scan_verification_qr_code"
+	ReqConfirmQRCodeScanned     Name = "confirm_qr_code_scanned"
 	ReqDiscoverHomeserver       Name = "discover_homeserver"
 	ReqGetLoginFlows            Name = "get_login_flows"
 	ReqRegisterPush             Name = "register_push"
 	ReqListenToDevice           Name = "listen_to_device"
 	ReqGetTurnServers           Name = "get_turn_servers"
 	ReqGetMediaConfig           Name = "get_media_config"
+	ReqListAccounts             Name = "list_accounts"
+	ReqAddAccount               Name = "add_account"
 
 	RespError   Name = "error"
 	RespSuccess Name = "response"
@@ -70,13 +117,17 @@ const (
 	ReqPing  Name = "ping"
 	RespPong Name = "pong"
 
-	EventSyncComplete    Name = "sync_complete"
-	EventSyncStatus      Name = "sync_status"
-	EventEventsDecrypted Name = "events_decrypted"
-	EventTyping          Name = "typing"
-	EventSendComplete    Name = "send_complete"
-	EventClientState     Name = "client_state"
-	EventImageAuthToken  Name = "image_auth_token"
-	EventInitComplete    Name = "init_complete"
-	EventRunID           Name = "run_id"
+	EventSyncComplete      Name = "sync_complete"
+	EventSyncStatus        Name = "sync_status"
+	EventEventsDecrypted   Name = "events_decrypted"
+	EventTyping            Name = "typing"
+	EventSendComplete      Name = "send_complete"
+	EventClientState       Name = "client_state"
+	EventImageAuthToken    Name = "image_auth_token"
+	EventInitComplete      Name = "init_complete"
+	EventRunID             Name = "run_id"
+	EventVerification      Name = "verification"
+	EventKeyBackupProgress Name = "key_backup_progress"
+	EventPresence          Name = "presence"
+	EventDraft             Name = "draft"
 )
@@ -31,6 +31,14 @@ func EventTypeName(evt any) Name {
 		return EventSendComplete
 	case *ClientState:
 		return EventClientState
+	case *Verification:
+		return EventVerification
+	case *KeyBackupProgress:
+		return EventKeyBackupProgress
+	case *Presence:
+		return EventPresence
+	case *Draft:
+		return EventDraft
 	default:
 		panic(fmt.Errorf("unknown event type %T", evt))
 	}
@@ -44,6 +52,8 @@ type SyncRoom struct {
 	Events      []*database.Event                             `json:"events"`
 	Reset       bool                                          `json:"reset"`
 	Receipts    map[id.EventID][]*database.Receipt            `json:"receipts"`
+	Threads     []*database.Thread                            `json:"threads,omitempty"`
+	Beacons     []*database.Beacon                            `json:"beacons,omitempty"`
 
 	DismissNotifications bool               `json:"dismiss_notifications"`
 	Notifications        []SyncNotification `json:"notifications"`
@@ -73,6 +83,7 @@ type SyncComplete struct {
 	InvitedRooms   []*database.InvitedRoom              `json:"invited_rooms"`
 	SpaceEdges     map[id.RoomID][]*database.SpaceEdge  `json:"space_edges"`
 	TopLevelSpaces []id.RoomID                          `json:"top_level_spaces"`
+	Drafts         map[id.RoomID]*database.Draft        `json:"drafts,omitempty"`
 
 	ToDevice []*SyncToDevice `json:"to_device,omitempty"`
 }
@@ -111,6 +122,7 @@ type EventsDecrypted struct {
 	RoomID            id.RoomID           `json:"room_id"`
 	PreviewEventRowID database.EventRowID `json:"preview_event_rowid,omitempty"`
 	Events            []*database.Event   `json:"events"`
+	Beacons           []*database.Beacon  `json:"beacons,omitempty"`
 }
 
 type Typing struct {
@@ -118,6 +130,16 @@ type Typing struct {
 	event.TypingEventContent
 }
 
+type Presence struct {
+	Users []*database.Presence `json:"users"`
+}
+
+// Draft is dispatched when the draft of a room is changed. Draft is nil if the draft was cleared.
+type Draft struct {
+	RoomID id.RoomID       `json:"room_id"`
+	Draft  *database.Draft `json:"draft"`
+}
+
 type SendComplete struct {
 	Event *database.Event `json:"event"`
 	Error error           `json:"error"`
@@ -131,6 +153,67 @@ type ClientState struct {
 	HomeserverURL string      `json:"homeserver_url,omitempty"`
 }
 
+type VerificationState string
+
+const (
+	VerificationStateRequested VerificationState = "requested"
+	VerificationStateReady     VerificationState = "ready"
+	VerificationStateStarted   VerificationState = "started"
+	VerificationStateAccepted  VerificationState = "accepted"
+	VerificationStateSAS       VerificationState = "sas"
+	VerificationStateQRScanned VerificationState = "qr_scanned"
+	VerificationStateConfirmed VerificationState = "confirmed"
+	VerificationStateDone      VerificationState = "done"
+	VerificationStateCancelled VerificationState = "cancelled"
+)
+
+type SASEmoji struct {
+	Emoji       string `json:"emoji"`
+	Description string `json:"description"`
+}
+
+type Verification struct {
+	TransactionID string            `json:"transaction_id"`
+	UserID        id.UserID         `json:"user_id"`
+	DeviceID      id.DeviceID       `json:"device_id,omitempty"`
+	Incoming      bool              `json:"incoming"`
+	State         VerificationState `json:"state"`
+
+	Methods  []string   `json:"methods,omitempty"`
+	Emojis   []SASEmoji `json:"emojis,omitempty"`
+	Decimals []int      `json:"decimals,omitempty"`
+
+	CancelCode   string `json:"cancel_code,omitempty"`
+	CancelReason string `json:"cancel_reason,omitempty"`
+}
+
+type KeyBackupOperation string
+
+const (
+	KeyBackupOperationCreate            KeyBackupOperation = "create"
+	KeyBackupOperationRotateRecoveryKey KeyBackupOperation = "rotate_recovery_key"
+)
+
+type KeyBackupStage string
+
+const (
+	KeyBackupStageCreating   KeyBackupStage = "creating"
+	KeyBackupStageUploading  KeyBackupStage = "uploading"
+	KeyBackupStageDecrypting KeyBackupStage = "decrypting"
+	KeyBackupStageEncrypting KeyBackupStage = "encrypting"
+	KeyBackupStageDone       KeyBackupStage = "done"
+	KeyBackupStageFailed     KeyBackupStage = "failed"
+)
+
+type KeyBackupProgress struct {
+	Operation KeyBackupOperation  `json:"operation"`
+	Stage     KeyBackupStage      `json:"stage"`
+	Version   id.KeyBackupVersion `json:"version,omitempty"`
+	Uploaded  int                 `json:"uploaded"`
+	Total     int                 `json:"total"`
+	Error     string              `json:"error,omitempty"`
+}
+
 type ImageAuthToken string
 
 type InitComplete struct{}
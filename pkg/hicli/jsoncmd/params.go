@@ -9,6 +9,7 @@ package jsoncmd
 import (
 	"encoding/json"
 
+	"go.mau.fi/util/jsontime"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -31,6 +32,21 @@ type SendMessageParams struct {
 	RelatesTo   *event.RelatesTo           `json:"relates_to"`
 	Mentions    *event.Mentions            `json:"mentions"`
 	URLPreviews []*event.BeeperLinkPreview `json:"url_previews"`
+	// ScheduleAt can be set to send the message at a later time instead of immediately.
+	ScheduleAt jsontime.UnixMilli `json:"schedule_at,omitempty"`
+}
+
+type GetScheduledMessagesParams struct {
+	RoomID id.RoomID `json:"room_id,omitempty"`
+}
+
+type CancelScheduledMessageParams struct {
+	ScheduleID string `json:"schedule_id"`
+}
+
+type RescheduleMessageParams struct {
+	ScheduleID string             `json:"schedule_id"`
+	SendAt     jsontime.UnixMilli `json:"send_at"`
 }
 
 type SendEventParams struct {
@@ -45,6 +61,54 @@ type ResendEventParams struct {
 	TransactionID string `json:"transaction_id"`
 }
 
+type SendPollParams struct {
+	RoomID        id.RoomID `json:"room_id"`
+	Question      string    `json:"question"`
+	Answers       []string  `json:"answers"`
+	MaxSelections int       `json:"max_selections"`
+	// Undisclosed polls only show the results after the poll has ended.
+	Undisclosed bool `json:"undisclosed"`
+}
+
+type VotePollParams struct {
+	RoomID id.RoomID  `json:"room_id"`
+	PollID id.EventID `json:"poll_id"`
+	// Answers contains the IDs of the selected answers. An empty list retracts the vote.
+	Answers []string `json:"answers"`
+}
+
+type EndPollParams struct {
+	RoomID id.RoomID  `json:"room_id"`
+	PollID id.EventID `json:"poll_id"`
+}
+
+type SendLocationParams struct {
+	RoomID      id.RoomID `json:"room_id"`
+	GeoURI      string    `json:"geo_uri"`
+	Description string    `json:"description,omitempty"`
+	// Pin should be set if the location was picked on a map rather than being the user's own position.
+	Pin bool `json:"pin,omitempty"`
+}
+
+type StartLiveLocationParams struct {
+	RoomID      id.RoomID `json:"room_id"`
+	DurationMS  int64     `json:"duration_ms"`
+	Description string    `json:"description,omitempty"`
+	GeoURI      string    `json:"geo_uri,omitempty"`
+}
+
+type UpdateLiveLocationParams struct {
+	GeoURI string `json:"geo_uri"`
+}
+
+type StopLiveLocationParams struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
+type GetBeaconsParams struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
 type ReportEventParams struct {
 	RoomID  id.RoomID  `json:"room_id"`
 	EventID id.EventID `json:"event_id"`
@@ -87,6 +151,7 @@ type MarkReadParams struct {
 	RoomID      id.RoomID         `json:"room_id"`
 	EventID     id.EventID        `json:"event_id"`
 	ReceiptType event.ReceiptType `json:"receipt_type"`
+	ThreadID    event.ThreadID    `json:"thread_id,omitempty"`
 }
 
 type SetTypingParams struct {
@@ -98,6 +163,26 @@ type GetProfileParams struct {
 	UserID id.UserID `json:"user_id"`
 }
 
+type SetPresenceParams struct {
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg"`
+}
+
+type GetPresenceParams struct {
+	UserIDs []id.UserID `json:"user_ids"`
+}
+
+type RenameDeviceParams struct {
+	DeviceID id.DeviceID `json:"device_id"`
+	Name     string      `json:"name"`
+}
+
+type DeleteDevicesParams struct {
+	DeviceIDs []id.DeviceID `json:"device_ids"`
+	Password  string        `json:"password,omitempty"`
+	Session   string        `json:"session,omitempty"`
+}
+
 type SetProfileFieldParams struct {
 	Field string `json:"field"`
 	Value any    `json:"value"`
@@ -116,6 +201,11 @@ type GetRelatedEventsParams struct {
 	RelationType event.RelationType `json:"relation_type"`
 }
 
+type GetEditHistoryParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+}
+
 type GetRoomStateParams struct {
 	RoomID         id.RoomID `json:"room_id"`
 	Refetch        bool      `json:"refetch"`
@@ -156,6 +246,51 @@ type VerifyParams struct {
 	RecoveryKey string `json:"recovery_key"`
 }
 
+type BootstrapCrossSigningParams struct {
+	Password   string `json:"password,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+type CreateKeyBackupVersionParams struct {
+	RecoveryKey string `json:"recovery_key"`
+}
+
+type DeleteKeyBackupVersionParams struct {
+	Version id.KeyBackupVersion `json:"version"`
+}
+
+type RotateRecoveryKeyParams struct {
+	RecoveryKey   string `json:"recovery_key"`
+	NewPassphrase string `json:"new_passphrase,omitempty"`
+}
+
+type StartVerificationParams struct {
+	UserID id.UserID `json:"user_id"`
+}
+
+type VerificationParams struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+type ConfirmSASParams struct {
+	TransactionID string `json:"transaction_id"`
+	Match         bool   `json:"match"`
+}
+
+type ScanVerificationQRCodeParams struct {
+	Data []byte `json:"data"`
+}
+
+type ConfirmQRCodeScannedParams struct {
+	TransactionID string `json:"transaction_id"`
+	Confirmed     bool   `json:"confirmed"`
+}
+
+type CancelVerificationParams struct {
+	TransactionID string `json:"transaction_id"`
+	Reason        string `json:"reason"`
+}
+
 type DiscoverHomeserverParams struct {
 	UserID id.UserID `json:"user_id"`
 }
@@ -171,6 +306,57 @@ type PaginateParams struct {
 	Reset         bool                   `json:"reset"`
 }
 
+type GetThreadsParams struct {
+	RoomID           id.RoomID          `json:"room_id"`
+	Before           jsontime.UnixMilli `json:"before,omitempty"`
+	OnlyParticipated bool               `json:"only_participated,omitempty"`
+	Limit            int                `json:"limit,omitempty"`
+}
+
+type PaginateThreadParams struct {
+	RoomID     id.RoomID  `json:"room_id"`
+	ThreadRoot id.EventID `json:"thread_root"`
+	From       string     `json:"from,omitempty"`
+	Limit      int        `json:"limit,omitempty"`
+}
+
+type GetRoomStatsParams struct {
+	RoomID id.RoomID          `json:"room_id"`
+	Since  jsontime.UnixMilli `json:"since,omitempty"`
+	Until  jsontime.UnixMilli `json:"until,omitempty"`
+}
+
+type AddBookmarkParams struct {
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+	Note    string     `json:"note,omitempty"`
+}
+
+type RemoveBookmarkParams struct {
+	EventID id.EventID `json:"event_id"`
+}
+
+type SearchMessagesParams struct {
+	Query    string               `json:"query"`
+	RoomIDs  []id.RoomID          `json:"room_ids,omitempty"`
+	Senders  []id.UserID          `json:"senders,omitempty"`
+	MsgTypes []event.MessageType  `json:"msgtypes,omitempty"`
+	After    jsontime.UnixMilli   `json:"after,omitempty"`
+	Before   jsontime.UnixMilli   `json:"before,omitempty"`
+	OrderBy  database.SearchOrder `json:"order_by,omitempty"`
+	Limit    int                  `json:"limit,omitempty"`
+	Offset   int                  `json:"offset,omitempty"`
+}
+
+type SearchServerParams struct {
+	Query     string               `json:"query"`
+	RoomIDs   []id.RoomID          `json:"room_ids,omitempty"`
+	Senders   []id.UserID          `json:"senders,omitempty"`
+	OrderBy   database.SearchOrder `json:"order_by,omitempty"`
+	NextBatch string               `json:"next_batch,omitempty"`
+	Limit     int                  `json:"limit,omitempty"`
+}
+
 type JoinRoomParams struct {
 	RoomIDOrAlias string   `json:"room_id_or_alias"`
 	Via           []string `json:"via"`
@@ -192,6 +378,17 @@ type MuteRoomParams struct {
 	Muted  bool      `json:"muted"`
 }
 
+type CompactRoomParams struct {
+	RoomID    id.RoomID        `json:"room_id"`
+	MaxAge    jsontime.Seconds `json:"max_age,omitempty"`
+	MaxEvents int              `json:"max_events,omitempty"`
+	Vacuum    bool             `json:"vacuum,omitempty"`
+}
+
 type PingParams struct {
 	LastReceivedID int64 `json:"last_received_id"`
 }
+
+type AddAccountParams struct {
+	Account string `json:"account"`
+}
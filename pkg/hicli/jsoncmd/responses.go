@@ -7,6 +7,7 @@
 package jsoncmd
 
 import (
+	"go.mau.fi/util/jsontime"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/gomuks/pkg/hicli/database"
@@ -30,6 +31,25 @@ type ProfileEncryptionInfo struct {
 	Errors         []string         `json:"errors"`
 }
 
+type OwnDevice struct {
+	DeviceID    id.DeviceID   `json:"device_id"`
+	Name        string        `json:"name"`
+	LastSeenIP  string        `json:"last_seen_ip,omitempty"`
+	LastSeenTS  int64         `json:"last_seen_ts,omitempty"`
+	Current     bool          `json:"current"`
+	IdentityKey id.Curve25519 `json:"identity_key,omitempty"`
+	SigningKey  id.Ed25519    `json:"signing_key,omitempty"`
+	Fingerprint string        `json:"fingerprint,omitempty"`
+	Trust       id.TrustState `json:"trust_state"`
+}
+
+type DeleteDevicesResponse struct {
+	Deleted       bool   `json:"deleted"`
+	Session       string `json:"session,omitempty"`
+	PasswordLogin bool   `json:"password_login,omitempty"`
+	FallbackURL   string `json:"fallback_url,omitempty"`
+}
+
 type PaginationResponse struct {
 	Events        []*database.Event                  `json:"events"`
 	Receipts      map[id.EventID][]*database.Receipt `json:"receipts"`
@@ -37,3 +57,70 @@ type PaginationResponse struct {
 	HasMore       bool                               `json:"has_more"`
 	FromServer    bool                               `json:"from_server"`
 }
+
+type EditHistoryResponse struct {
+	// Event is the original event, whose content is the first version of the message.
+	Event *database.Event `json:"event"`
+	// Edits contains all the edits of the event, oldest first. Redacted edits have redacted_by set.
+	Edits []*database.Event `json:"edits"`
+}
+
+type GetThreadsResponse struct {
+	Threads []*database.Thread `json:"threads"`
+	Events  []*database.Event  `json:"events"`
+	HasMore bool               `json:"has_more"`
+}
+
+type ThreadPaginationResponse struct {
+	Thread    *database.Thread  `json:"thread"`
+	Root      *database.Event   `json:"root,omitempty"`
+	Events    []*database.Event `json:"events"`
+	NextBatch string            `json:"next_batch,omitempty"`
+	HasMore   bool              `json:"has_more"`
+}
+
+type BookmarkedEvent struct {
+	RoomID  id.RoomID          `json:"room_id"`
+	EventID id.EventID         `json:"event_id"`
+	AddedAt jsontime.UnixMilli `json:"added_at"`
+	Note    string             `json:"note,omitempty"`
+	Event   *database.Event    `json:"event,omitempty"`
+	// Error is set if the event couldn't be fetched.
+	Error string `json:"error,omitempty"`
+}
+
+type SearchMessagesResponse struct {
+	Events     []*database.Event `json:"events"`
+	HasMore    bool              `json:"has_more"`
+	NextOffset int               `json:"next_offset,omitempty"`
+}
+
+type ServerSearchResponse struct {
+	Events     []*database.Event `json:"events"`
+	Count      int               `json:"count"`
+	Highlights []string          `json:"highlights"`
+	NextBatch  string            `json:"next_batch,omitempty"`
+}
+
+type VerificationQRCode struct {
+	TransactionID string `json:"transaction_id"`
+	Data          []byte `json:"data"`
+}
+
+type RecoveryKeyResponse struct {
+	RecoveryKey string `json:"recovery_key"`
+}
+
+type KeyBackupVersion struct {
+	Version    id.KeyBackupVersion   `json:"version"`
+	Algorithm  id.KeyBackupAlgorithm `json:"algorithm"`
+	Count      int                   `json:"count"`
+	ETag       string                `json:"etag"`
+	Current    bool                  `json:"current"`
+	KeyMatches bool                  `json:"key_matches"`
+}
+
+type AccountInfo struct {
+	Account string       `json:"account"`
+	State   *ClientState `json:"state"`
+}
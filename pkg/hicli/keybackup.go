@@ -0,0 +1,200 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+func (h *HiClient) keyBackupVersionInfo(resp *mautrix.RespRoomKeysVersion[backup.MegolmAuthData]) *jsoncmd.KeyBackupVersion {
+	info := &jsoncmd.KeyBackupVersion{
+		Version:   resp.Version,
+		Algorithm: resp.Algorithm,
+		Count:     resp.Count,
+		ETag:      resp.ETag,
+		Current:   resp.Version == h.KeyBackupVersion,
+	}
+	if key := h.KeyBackupKey; key != nil {
+		expectedKey, err := base64.RawStdEncoding.DecodeString(string(resp.AuthData.PublicKey))
+		info.KeyMatches = err == nil && bytes.Equal(expectedKey, key.PublicKey().Bytes())
+	}
+	return info
+}
+
+// GetLatestKeyBackupVersion returns the latest key backup version on the server, or nil if there's no backup.
+// The spec doesn't have an endpoint for listing older versions.
+func (h *HiClient) GetLatestKeyBackupVersion(ctx context.Context) (*jsoncmd.KeyBackupVersion, error) {
+	latest, err := h.Client.GetKeyBackupLatestVersion(ctx)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get latest key backup version: %w", err)
+	}
+	return h.keyBackupVersionInfo(latest), nil
+}
+
+func (h *HiClient) dispatchKeyBackupProgress(progress *jsoncmd.KeyBackupProgress) {
+	evt := *progress
+	h.EventHandler(&evt)
+}
+
+// CreateKeyBackupVersion creates a new key backup version with a new key, stores the key in secret storage
+// and uploads all megolm sessions to the new version. The recovery key is needed to update secret storage.
+func (h *HiClient) CreateKeyBackupVersion(ctx context.Context, recoveryKey string) (*jsoncmd.KeyBackupVersion, error) {
+	h.keyBackupLock.Lock()
+	defer h.keyBackupLock.Unlock()
+	progress := &jsoncmd.KeyBackupProgress{Operation: jsoncmd.KeyBackupOperationCreate}
+	err := h.createKeyBackupVersionAndUpload(ctx, recoveryKey, progress)
+	if err != nil {
+		progress.Stage = jsoncmd.KeyBackupStageFailed
+		progress.Error = err.Error()
+		h.dispatchKeyBackupProgress(progress)
+		return nil, err
+	}
+	resp, err := h.Client.GetKeyBackupVersion(ctx, h.KeyBackupVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new key backup version: %w", err)
+	}
+	return h.keyBackupVersionInfo(resp), nil
+}
+
+func (h *HiClient) createKeyBackupVersionAndUpload(ctx context.Context, recoveryKey string, progress *jsoncmd.KeyBackupProgress) error {
+	if !h.Verified {
+		return fmt.Errorf("current device must be verified to create a key backup")
+	}
+	ssssKey, err := h.getDefaultSSSSKey(ctx, recoveryKey)
+	if err != nil {
+		return err
+	}
+	progress.Stage = jsoncmd.KeyBackupStageCreating
+	h.dispatchKeyBackupProgress(progress)
+	err = h.createKeyBackup(ctx, ssssKey)
+	if err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Stringer("key_backup_version", h.KeyBackupVersion).Msg("Created new key backup version")
+	progress.Version = h.KeyBackupVersion
+	progress.Stage = jsoncmd.KeyBackupStageUploading
+	h.dispatchKeyBackupProgress(progress)
+	err = h.uploadKeysToBackupVersion(ctx, h.KeyBackupVersion, h.KeyBackupKey, func(uploaded, total int) {
+		progress.Uploaded = uploaded
+		progress.Total = total
+		h.dispatchKeyBackupProgress(progress)
+	})
+	if err != nil {
+		return err
+	}
+	progress.Stage = jsoncmd.KeyBackupStageDone
+	h.dispatchKeyBackupProgress(progress)
+	return nil
+}
+
+// DeleteKeyBackupVersion deletes the given key backup version from the server.
+// If it's the version currently in use, the local copy of the backup key is deleted too,
+// and uploading keys to backup is stopped until a new version is created.
+func (h *HiClient) DeleteKeyBackupVersion(ctx context.Context, version id.KeyBackupVersion) error {
+	h.keyBackupLock.Lock()
+	defer h.keyBackupLock.Unlock()
+	err := h.Client.DeleteKeyBackupVersion(ctx, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete key backup version: %w", err)
+	}
+	if h.KeyBackupVersion == version {
+		h.KeyBackupVersion = ""
+		h.KeyBackupKey = nil
+		err = h.CryptoStore.DeleteSecret(ctx, id.SecretMegolmBackupV1)
+		if err != nil {
+			return fmt.Errorf("failed to delete stored key backup key: %w", err)
+		}
+	}
+	return nil
+}
+
+var ssssSecretAccountDataTypes = []event.Type{
+	event.AccountDataCrossSigningMaster,
+	event.AccountDataCrossSigningSelf,
+	event.AccountDataCrossSigningUser,
+	event.AccountDataMegolmBackupKey,
+}
+
+// RotateRecoveryKey creates a new secret storage key, re-encrypts all known secrets with it
+// and marks it as the default key. The new recovery key is returned.
+func (h *HiClient) RotateRecoveryKey(ctx context.Context, recoveryKey, newPassphrase string) (*jsoncmd.RecoveryKeyResponse, error) {
+	h.keyBackupLock.Lock()
+	defer h.keyBackupLock.Unlock()
+	progress := &jsoncmd.KeyBackupProgress{Operation: jsoncmd.KeyBackupOperationRotateRecoveryKey}
+	resp, err := h.rotateRecoveryKey(ctx, recoveryKey, newPassphrase, progress)
+	if err != nil {
+		progress.Stage = jsoncmd.KeyBackupStageFailed
+		progress.Error = err.Error()
+		h.dispatchKeyBackupProgress(progress)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (h *HiClient) rotateRecoveryKey(ctx context.Context, recoveryKey, newPassphrase string, progress *jsoncmd.KeyBackupProgress) (*jsoncmd.RecoveryKeyResponse, error) {
+	log := zerolog.Ctx(ctx)
+	oldKey, err := h.getDefaultSSSSKey(ctx, recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	progress.Stage = jsoncmd.KeyBackupStageDecrypting
+	progress.Total = len(ssssSecretAccountDataTypes)
+	h.dispatchKeyBackupProgress(progress)
+	secrets := make(map[event.Type][]byte, len(ssssSecretAccountDataTypes))
+	for _, evtType := range ssssSecretAccountDataTypes {
+		data, err := h.Crypto.SSSS.GetDecryptedAccountData(ctx, evtType, oldKey)
+		if err != nil && evtType == event.AccountDataMegolmBackupKey {
+			log.Warn().Err(err).Msg("Failed to get megolm backup key from SSSS, not copying it to new key")
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", evtType.Type, err)
+		}
+		secrets[evtType] = data
+	}
+	progress.Stage = jsoncmd.KeyBackupStageEncrypting
+	h.dispatchKeyBackupProgress(progress)
+	newKey, err := h.Crypto.SSSS.GenerateAndUploadKey(ctx, newPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate and upload new SSSS key: %w", err)
+	}
+	for _, evtType := range ssssSecretAccountDataTypes {
+		data, ok := secrets[evtType]
+		if !ok {
+			continue
+		}
+		err = h.Crypto.SSSS.SetEncryptedAccountData(ctx, evtType, data, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt %s: %w", evtType.Type, err)
+		}
+		progress.Uploaded++
+		h.dispatchKeyBackupProgress(progress)
+	}
+	err = h.Crypto.SSSS.SetDefaultKeyID(ctx, newKey.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set new default SSSS key: %w", err)
+	}
+	log.Info().Str("key_id", newKey.ID).Msg("Rotated recovery key")
+	progress.Stage = jsoncmd.KeyBackupStageDone
+	h.dispatchKeyBackupProgress(progress)
+	return &jsoncmd.RecoveryKeyResponse{
+		RecoveryKey: newKey.RecoveryKey(),
+	}, nil
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	// Location updates reported more often than this are merged, so that rooms aren't spammed with beacons.
+	beaconMinInterval       = 10 * time.Second
+	maxLiveLocationDuration = 24 * time.Hour
+	liveLocationErrorDelay  = 1 * time.Minute
+	locationAssetTypeSelf   = "m.self"
+	locationAssetTypePin    = "m.pin"
+)
+
+var (
+	ErrInvalidGeoURI          = errors.New("invalid geo URI")
+	ErrInvalidShareDuration   = errors.New("live location duration must be positive and at most 24 hours")
+	ErrNoLiveLocationShare    = errors.New("no active live location share in room")
+	ErrNoActiveLocationShares = errors.New("no active live location shares")
+)
+
+type locationAsset struct {
+	Type string `json:"type"`
+}
+
+type locationContent struct {
+	URI         string `json:"uri"`
+	Description string `json:"description,omitempty"`
+}
+
+type beaconInfoContent struct {
+	Description string             `json:"description,omitempty"`
+	Live        bool               `json:"live"`
+	Timeout     int64              `json:"timeout"`
+	Timestamp   jsontime.UnixMilli `json:"org.matrix.msc3488.ts"`
+	Asset       locationAsset      `json:"org.matrix.msc3488.asset"`
+}
+
+type beaconContent struct {
+	RelatesTo event.RelatesTo    `json:"m.relates_to"`
+	Location  locationContent    `json:"org.matrix.msc3488.location"`
+	Timestamp jsontime.UnixMilli `json:"org.matrix.msc3488.ts"`
+}
+
+func validateGeoURI(geoURI string) error {
+	if !strings.HasPrefix(geoURI, "geo:") || len(geoURI) <= len("geo:") {
+		return fmt.Errorf("%w %q", ErrInvalidGeoURI, geoURI)
+	}
+	return nil
+}
+
+// SendLocation sends a static location message (MSC3488) with the legacy m.location fallback.
+// If pin is true, the location is a point chosen on a map rather than the user's own position.
+func (h *HiClient) SendLocation(ctx context.Context, roomID id.RoomID, geoURI, description string, pin bool) (*database.Event, error) {
+	if err := validateGeoURI(geoURI); err != nil {
+		return nil, err
+	}
+	body := "Location " + geoURI
+	if description != "" {
+		body = fmt.Sprintf("%s (%s)", description, geoURI)
+	}
+	assetType := locationAssetTypeSelf
+	if pin {
+		assetType = locationAssetTypePin
+	}
+	return h.Send(ctx, roomID, event.EventMessage, &event.Content{
+		Parsed: &event.MessageEventContent{
+			MsgType: event.MsgLocation,
+			Body:    body,
+			GeoURI:  geoURI,
+		},
+		Raw: map[string]any{
+			"org.matrix.msc1767.text":     body,
+			"org.matrix.msc3488.location": &locationContent{URI: geoURI, Description: description},
+			"org.matrix.msc3488.asset":    &locationAsset{Type: assetType},
+			"org.matrix.msc3488.ts":       jsontime.UnixMilliNow(),
+		},
+	}, false, false)
+}
+
+// StartLiveLocation starts sharing the user's live location (MSC3489) in the given room. Any previous share in
+// the room is replaced. The initial location is optional, more can be reported with UpdateLiveLocation.
+func (h *HiClient) StartLiveLocation(
+	ctx context.Context,
+	roomID id.RoomID,
+	duration time.Duration,
+	description string,
+	geoURI string,
+) (*database.LiveLocationShare, error) {
+	if duration <= 0 || duration > maxLiveLocationDuration {
+		return nil, ErrInvalidShareDuration
+	} else if geoURI != "" {
+		if err := validateGeoURI(geoURI); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	beaconID, err := h.SetState(ctx, roomID, database.EventUnstableBeaconInfo, h.Account.UserID.String(), &beaconInfoContent{
+		Description: description,
+		Live:        true,
+		Timeout:     duration.Milliseconds(),
+		Timestamp:   jsontime.UM(now),
+		Asset:       locationAsset{Type: locationAssetTypeSelf},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send beacon info: %w", err)
+	}
+	share := &database.LiveLocationShare{
+		RoomID:      roomID,
+		BeaconID:    beaconID,
+		Description: description,
+		StartedAt:   jsontime.UM(now),
+		ExpiresAt:   jsontime.UM(now.Add(duration)),
+	}
+	if geoURI != "" {
+		share.GeoURI = geoURI
+		share.UpdatedAt = jsontime.UM(now)
+	}
+	err = h.DB.LiveLocation.Put(ctx, share)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save live location share: %w", err)
+	}
+	h.WakeupLiveLocation()
+	return share, nil
+}
+
+// UpdateLiveLocation reports the user's current location. The location is sent to all rooms with an active share
+// by the beacon loop, which limits how often beacons are sent.
+func (h *HiClient) UpdateLiveLocation(ctx context.Context, geoURI string) error {
+	if err := validateGeoURI(geoURI); err != nil {
+		return err
+	}
+	shares, err := h.DB.LiveLocation.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get live location shares: %w", err)
+	} else if len(shares) == 0 {
+		return ErrNoActiveLocationShares
+	}
+	err = h.DB.LiveLocation.UpdateLocation(ctx, geoURI, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save location: %w", err)
+	}
+	h.WakeupLiveLocation()
+	return nil
+}
+
+// StopLiveLocation ends the live location share in the given room.
+func (h *HiClient) StopLiveLocation(ctx context.Context, roomID id.RoomID) error {
+	share, err := h.DB.LiveLocation.Get(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get live location share: %w", err)
+	} else if share == nil {
+		return ErrNoLiveLocationShare
+	}
+	err = h.stopLiveLocation(ctx, share)
+	if err != nil {
+		return err
+	}
+	err = h.DB.LiveLocation.DeleteByBeaconID(ctx, share.RoomID, share.BeaconID)
+	if err != nil {
+		return fmt.Errorf("failed to delete live location share: %w", err)
+	}
+	return nil
+}
+
+// GetLiveLocationShares returns the live location shares started by this client that haven't ended yet.
+func (h *HiClient) GetLiveLocationShares(ctx context.Context) ([]*database.LiveLocationShare, error) {
+	return h.DB.LiveLocation.GetAll(ctx)
+}
+
+// GetBeacons returns the live location shares of all users in the given room.
+func (h *HiClient) GetBeacons(ctx context.Context, roomID id.RoomID) ([]*database.Beacon, error) {
+	return h.DB.Beacon.GetLive(ctx, roomID)
+}
+
+func (h *HiClient) stopLiveLocation(ctx context.Context, share *database.LiveLocationShare) error {
+	_, err := h.SetState(ctx, share.RoomID, database.EventUnstableBeaconInfo, h.Account.UserID.String(), &beaconInfoContent{
+		Description: share.Description,
+		Live:        false,
+		Timeout:     share.ExpiresAt.Sub(share.StartedAt.Time).Milliseconds(),
+		Timestamp:   share.StartedAt,
+		Asset:       locationAsset{Type: locationAssetTypeSelf},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send beacon info: %w", err)
+	}
+	return nil
+}
+
+// sendBeacon sends the latest location of a share directly to the server. Beacons don't go through the outbox,
+// because the share must only be marked as sent after the server has accepted the event, and because retrying
+// an old location is pointless when a newer one will be sent anyway. The event is received back through sync.
+func (h *HiClient) sendBeacon(ctx context.Context, share *database.LiveLocationShare, now time.Time) error {
+	room, err := h.DB.Room.Get(ctx, share.RoomID)
+	if err != nil {
+		return fmt.Errorf("failed to get room metadata: %w", err)
+	} else if room == nil {
+		return fmt.Errorf("unknown room")
+	}
+	evtType := database.EventUnstableBeacon
+	var content any = &beaconContent{
+		RelatesTo: event.RelatesTo{Type: event.RelReference, EventID: share.BeaconID},
+		Location:  locationContent{URI: share.GeoURI},
+		Timestamp: share.UpdatedAt,
+	}
+	if room.EncryptionEvent != nil {
+		content, err = h.Encrypt(ctx, room, evtType, content)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt: %w", err)
+		}
+		evtType = event.EventEncrypted
+	}
+	_, err = h.Client.SendMessageEvent(ctx, room.ID, evtType, content, mautrix.ReqSendEvent{DontEncrypt: true})
+	if err != nil {
+		return err
+	}
+	// The time when the loop started is used instead of the current time,
+	// so that locations reported while sending aren't marked as sent.
+	share.LastSentAt = jsontime.UM(now)
+	return h.DB.LiveLocation.MarkSent(ctx, share)
+}
+
+// processBeaconEvent updates the aggregated beacon state based on a new beacon_info or beacon event.
+// The IDs of the beacons that changed are returned.
+func (h *HiClient) processBeaconEvent(ctx context.Context, evt *database.Event) ([]id.EventID, error) {
+	if evt.IsBeaconInfo() {
+		changed, err := h.DB.Beacon.ProcessInfo(ctx, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save beacon info %s: %w", evt.ID, err)
+		}
+		if evt.Sender == h.Account.UserID && *evt.StateKey == h.Account.UserID.String() {
+			// If the share was stopped or replaced by another client, there's no point in sending more updates.
+			share, err := h.DB.LiveLocation.Get(ctx, evt.RoomID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get live location share: %w", err)
+			} else if share != nil && share.BeaconID != evt.ID {
+				err = h.DB.LiveLocation.DeleteByBeaconID(ctx, share.RoomID, share.BeaconID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to delete replaced live location share: %w", err)
+				}
+			}
+		}
+		return changed, nil
+	} else if evt.IsBeacon() {
+		beaconID, err := h.DB.Beacon.UpdateLocation(ctx, evt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save beacon location %s: %w", evt.ID, err)
+		} else if beaconID != "" {
+			return []id.EventID{beaconID}, nil
+		}
+	}
+	return nil, nil
+}
+
+// RunLiveLocation sends the latest reported location to rooms with an active live location share
+// and ends the shares after they expire. It runs in the backend, so sharing continues while no frontend is open.
+func (h *HiClient) RunLiveLocation(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "live location").Logger()
+	ctx = log.WithContext(ctx)
+	for {
+		var timer <-chan time.Time
+		now := time.Now()
+		expired, err := h.DB.LiveLocation.GetExpired(ctx, now)
+		if err != nil {
+			log.Err(err).Msg("Failed to get expired live location shares")
+		}
+		for _, share := range expired {
+			err = h.stopLiveLocation(ctx, share)
+			if err != nil {
+				// The share is kept in the database, so ending it will be retried after the error delay
+				log.Err(err).Stringer("room_id", share.RoomID).Msg("Failed to end expired live location share")
+				continue
+			}
+			err = h.DB.LiveLocation.DeleteByBeaconID(ctx, share.RoomID, share.BeaconID)
+			if err != nil {
+				log.Err(err).Stringer("room_id", share.RoomID).Msg("Failed to delete expired live location share")
+			}
+		}
+		shares, err := h.DB.LiveLocation.GetAll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Err(err).Msg("Failed to get live location shares")
+			timer = time.After(liveLocationErrorDelay)
+		} else {
+			var nextWakeup time.Time
+			for _, share := range shares {
+				if !now.Before(share.ExpiresAt.Time) {
+					// Ending the share failed above, try again later
+					retryAt := now.Add(liveLocationErrorDelay)
+					if nextWakeup.IsZero() || retryAt.Before(nextWakeup) {
+						nextWakeup = retryAt
+					}
+					continue
+				}
+				if share.HasUnsentLocation() {
+					nextSend := share.LastSentAt.Add(beaconMinInterval)
+					if !now.Before(nextSend) {
+						err = h.sendBeacon(ctx, share, now)
+						if err != nil {
+							log.Err(err).Stringer("room_id", share.RoomID).Msg("Failed to send beacon")
+							nextSend = now.Add(liveLocationErrorDelay)
+						}
+					}
+					if now.Before(nextSend) && (nextWakeup.IsZero() || nextSend.Before(nextWakeup)) {
+						nextWakeup = nextSend
+					}
+				}
+				if nextWakeup.IsZero() || share.ExpiresAt.Before(nextWakeup) {
+					nextWakeup = share.ExpiresAt.Time
+				}
+			}
+			if !nextWakeup.IsZero() {
+				timer = time.After(time.Until(nextWakeup))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.liveLocationWakeup:
+		case <-timer:
+		}
+	}
+}
+
+func (h *HiClient) WakeupLiveLocation() {
+	select {
+	case h.liveLocationWakeup <- struct{}{}:
+	default:
+	}
+}
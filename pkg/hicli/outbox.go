@@ -0,0 +1,275 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+const (
+	MaxParallelSends = 5
+
+	outboxInitialBackoff = 2 * time.Second
+	outboxMaxBackoff     = 5 * time.Minute
+	outboxErrorDelay     = 1 * time.Minute
+)
+
+// outboxSlots tracks which rooms are currently sending, so that the rooms in the outbox are processed
+// independently: a slow send only blocks the room it's in, while other rooms can use the remaining slots.
+type outboxSlots struct {
+	lock  sync.Mutex
+	rooms map[id.RoomID]struct{}
+	sem   chan struct{}
+}
+
+func newOutboxSlots(size int) *outboxSlots {
+	return &outboxSlots{
+		rooms: make(map[id.RoomID]struct{}),
+		sem:   make(chan struct{}, size),
+	}
+}
+
+// acquire reserves a send slot for the given room. False is returned if the room is already busy
+// or all slots are in use.
+func (slots *outboxSlots) acquire(roomID id.RoomID) bool {
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+	if _, busy := slots.rooms[roomID]; busy {
+		return false
+	}
+	select {
+	case slots.sem <- struct{}{}:
+		slots.rooms[roomID] = struct{}{}
+		return true
+	default:
+		return false
+	}
+}
+
+func (slots *outboxSlots) releaseSlot() {
+	<-slots.sem
+}
+
+func (slots *outboxSlots) releaseRoom(roomID id.RoomID) {
+	slots.lock.Lock()
+	delete(slots.rooms, roomID)
+	slots.lock.Unlock()
+}
+
+func (slots *outboxSlots) busyRooms() int {
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+	return len(slots.rooms)
+}
+
+// RunOutbox sends events queued in the outbox. Events in the same room are sent one at a time in the order they
+// were created, and failed sends are retried with exponential backoff as long as the error looks temporary.
+// Different rooms are sent in parallel, up to MaxParallelSends at a time.
+// The outbox is stored in the database, so sending resumes after a restart.
+func (h *HiClient) RunOutbox(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "outbox").Logger()
+	ctx = log.WithContext(ctx)
+	log.Info().Msg("Starting outbox")
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		log.Info().Msg("Stopping outbox")
+	}()
+	slots := newOutboxSlots(MaxParallelSends)
+	for {
+		var timer <-chan time.Time
+		now := time.Now()
+		// The heads of busy rooms are still in the database, so fetch enough entries to fill all free slots.
+		entries, err := h.DB.Outbox.GetDue(ctx, now, MaxParallelSends+slots.busyRooms())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Err(err).Msg("Failed to get queued events to send")
+			timer = time.After(outboxErrorDelay)
+		} else {
+			for _, entry := range entries {
+				if !slots.acquire(entry.RoomID) {
+					// Either the room is already sending or all slots are in use.
+					// In both cases, the loop is woken up again when a send finishes.
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := h.sendOutboxEntry(ctx, entry)
+					slots.releaseSlot()
+					if err != nil {
+						// The outbox entry couldn't be updated, so retrying immediately would just send it again.
+						// Keep the room blocked for a while instead of spinning.
+						time.AfterFunc(outboxErrorDelay, func() {
+							slots.releaseRoom(entry.RoomID)
+							h.WakeupOutbox()
+						})
+					} else {
+						slots.releaseRoom(entry.RoomID)
+						h.WakeupOutbox()
+					}
+				}()
+			}
+			// Entries that are already due are either being sent or waiting for a slot,
+			// so only future attempts need a timer.
+			nextAttempt, err := h.DB.Outbox.GetNextAttemptTime(ctx, now)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Err(err).Msg("Failed to get next outbox attempt time")
+				timer = time.After(outboxErrorDelay)
+			} else if !nextAttempt.IsZero() {
+				timer = time.After(time.Until(nextAttempt))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.outboxWakeup:
+		case <-timer:
+		}
+	}
+}
+
+func (h *HiClient) WakeupOutbox() {
+	select {
+	case h.outboxWakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (h *HiClient) queueSend(ctx context.Context, dbEvt *database.Event) error {
+	now := jsontime.UnixMilliNow()
+	return h.DB.Outbox.Put(ctx, &database.OutboxEntry{
+		EventRowID:  dbEvt.RowID,
+		RoomID:      dbEvt.RoomID,
+		QueuedAt:    now,
+		NextAttempt: now,
+	})
+}
+
+func getOutboxBackoff(attempts int) time.Duration {
+	backoff := outboxInitialBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
+
+// isRetryableSendError returns true if the error is a network error or a server error that may go away
+// by itself. Other errors (e.g. lacking permissions to send) are returned to the user immediately.
+func isRetryableSendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	} else if httpErr.Response == nil {
+		return true
+	}
+	return httpErr.Response.StatusCode >= 500 || httpErr.Response.StatusCode == http.StatusTooManyRequests
+}
+
+// getSendEventType returns the type to pass to sendEventToServer for a local echo.
+func getSendEventType(dbEvt *database.Event) event.Type {
+	if dbEvt.Decrypted != nil && len(dbEvt.Content) <= 2 {
+		return event.Type{Type: dbEvt.DecryptedType, Class: event.MessageEventType}
+	}
+	return event.Type{Type: dbEvt.Type, Class: event.MessageEventType}
+}
+
+// sendOutboxEntry sends a single event from the outbox. An error is only returned if updating the outbox failed.
+func (h *HiClient) sendOutboxEntry(ctx context.Context, entry *database.OutboxEntry) error {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("room_id", entry.RoomID).
+		Int64("event_rowid", int64(entry.EventRowID)).
+		Int("attempt", entry.Attempts+1).
+		Logger()
+	dbEvt, err := h.DB.Event.GetByRowID(ctx, entry.EventRowID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get queued event")
+		return h.postponeOutboxEntry(ctx, entry, err)
+	} else if dbEvt == nil || !strings.HasPrefix(dbEvt.ID.String(), "~") {
+		// The event was either deleted or already received through sync (e.g. the response to the previous attempt
+		// was lost after the server accepted the event).
+		return h.removeOutboxEntry(ctx, entry)
+	}
+	room, err := h.DB.Room.Get(ctx, entry.RoomID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get room of queued event")
+		return h.postponeOutboxEntry(ctx, entry, err)
+	} else if room == nil {
+		return h.removeOutboxEntry(ctx, entry)
+	}
+	err = h.sendEventToServer(ctx, room, dbEvt, getSendEventType(dbEvt))
+	if err != nil {
+		if ctx.Err() != nil {
+			// The client is shutting down, the event will be retried after the next start
+			return nil
+		} else if isRetryableSendError(err) {
+			log.Warn().Err(err).Msg("Failed to send queued event, will retry")
+			return h.postponeOutboxEntry(ctx, entry, err)
+		}
+		log.Err(err).Msg("Failed to send queued event")
+		dbEvt.SendError = err.Error()
+		err2 := h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
+		if err2 != nil {
+			log.Err(err2).Msg("Failed to update send error in database after sending failed")
+		}
+	} else {
+		log.Debug().Stringer("event_id", dbEvt.ID).Msg("Sent queued event")
+		dbEvt.SendError = ""
+		err = h.DB.Event.UpdateID(ctx, dbEvt.RowID, dbEvt.ID)
+		if err != nil {
+			log.Err(err).Msg("Failed to update event ID in database")
+		}
+	}
+	removeErr := h.removeOutboxEntry(ctx, entry)
+	h.EventHandler(&jsoncmd.SendComplete{
+		Event: dbEvt,
+		Error: err,
+	})
+	return removeErr
+}
+
+func (h *HiClient) postponeOutboxEntry(ctx context.Context, entry *database.OutboxEntry, err error) error {
+	entry.Attempts++
+	entry.LastError = err.Error()
+	entry.NextAttempt = jsontime.UM(time.Now().Add(getOutboxBackoff(entry.Attempts)))
+	err = h.DB.Outbox.Put(ctx, entry)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Int64("event_rowid", int64(entry.EventRowID)).
+			Msg("Failed to update outbox entry after failed attempt")
+	}
+	return err
+}
+
+func (h *HiClient) removeOutboxEntry(ctx context.Context, entry *database.OutboxEntry) error {
+	err := h.DB.Outbox.Delete(ctx, entry.EventRowID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Int64("event_rowid", int64(entry.EventRowID)).
+			Msg("Failed to remove event from outbox")
+	}
+	return err
+}
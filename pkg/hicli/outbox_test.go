@@ -0,0 +1,52 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxSlots(t *testing.T) {
+	slots := newOutboxSlots(2)
+	if !slots.acquire("!a:example.com") {
+		t.Fatal("failed to acquire slot for free room")
+	} else if slots.acquire("!a:example.com") {
+		t.Error("acquired second slot for busy room")
+	} else if !slots.acquire("!b:example.com") {
+		t.Fatal("failed to acquire second slot")
+	} else if slots.acquire("!c:example.com") {
+		t.Error("acquired slot when all slots were in use")
+	}
+	slots.releaseSlot()
+	if slots.acquire("!a:example.com") {
+		t.Error("acquired slot for room that wasn't released")
+	} else if !slots.acquire("!c:example.com") {
+		t.Error("failed to acquire released slot for another room")
+	}
+	slots.releaseSlot()
+	slots.releaseRoom("!a:example.com")
+	if busy := slots.busyRooms(); busy != 2 {
+		t.Errorf("unexpected busy room count %d", busy)
+	} else if !slots.acquire("!a:example.com") {
+		t.Error("failed to acquire slot for released room")
+	}
+}
+
+func TestGetOutboxBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  outboxInitialBackoff,
+		2:  2 * outboxInitialBackoff,
+		3:  4 * outboxInitialBackoff,
+		50: outboxMaxBackoff,
+	}
+	for attempts, expected := range tests {
+		if backoff := getOutboxBackoff(attempts); backoff != expected {
+			t.Errorf("unexpected backoff %s for %d attempts, expected %s", backoff, attempts, expected)
+		}
+	}
+}
@@ -240,9 +240,14 @@ func (h *HiClient) Paginate(ctx context.Context, roomID id.RoomID, maxTimelineID
 			return nil, err
 		}
 	}
-	resp.RelatedEvents = make([]*database.Event, 0)
 	eventIDs := make([]id.EventID, len(resp.Events))
 	eventMap := make(map[id.EventID]struct{})
+	if resp.RelatedEvents == nil {
+		resp.RelatedEvents = make([]*database.Event, 0)
+	}
+	for _, evt := range resp.RelatedEvents {
+		eventMap[evt.ID] = struct{}{}
+	}
 	for i := len(resp.Events) - 1; i >= 0; i-- {
 		evt := resp.Events[i]
 		eventIDs[i] = evt.ID
@@ -348,6 +353,7 @@ func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit i
 		}, nil
 	}
 	wakeupSessionRequests := false
+	var extraPolls []*database.Event
 	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
 		if err = ctx.Err(); err != nil {
 			return err
@@ -378,12 +384,9 @@ func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit i
 		}
 		events = events[:len(events)-iOffset]
 		eventRowIDs = eventRowIDs[:len(eventRowIDs)-iOffset]
-		wakeupSessionRequests = len(decryptionQueue) > 0
-		for _, entry := range decryptionQueue {
-			err = h.DB.SessionRequest.Put(ctx, entry)
-			if err != nil {
-				return fmt.Errorf("failed to save session request for %s: %w", entry.SessionID, err)
-			}
+		wakeupSessionRequests, err = h.saveDecryptionQueue(ctx, decryptionQueue)
+		if err != nil {
+			return err
 		}
 		err = h.DB.Event.FillReactionCounts(ctx, roomID, events)
 		if err != nil {
@@ -402,8 +405,25 @@ func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit i
 		if err != nil {
 			return fmt.Errorf("failed to prepend events to timeline: %w", err)
 		}
+		threadRoots := make(map[id.EventID]struct{})
 		for i, evt := range events {
 			evt.TimelineRowID = tuples[i].Timeline
+			if evt.RelationType == event.RelThread && evt.RelatesTo != "" {
+				threadRoots[evt.RelatesTo] = struct{}{}
+			}
+			if evt.HasBundledThreadSummary() {
+				threadRoots[evt.ID] = struct{}{}
+			}
+		}
+		for threadRoot := range threadRoots {
+			err = h.DB.Thread.Recalculate(ctx, roomID, threadRoot, h.Account.UserID)
+			if err != nil {
+				return fmt.Errorf("failed to update thread summary of %s: %w", threadRoot, err)
+			}
+		}
+		extraPolls, err = h.recalculatePollsOfEvents(ctx, events)
+		if err != nil {
+			return fmt.Errorf("failed to update poll tallies: %w", err)
 		}
 		return nil
 	})
@@ -411,8 +431,9 @@ func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit i
 		h.WakeupRequestQueue()
 	}
 	return &jsoncmd.PaginationResponse{
-		Events:     events,
-		HasMore:    resp.End != database.PrevBatchPaginationComplete,
-		FromServer: true,
+		Events:        events,
+		RelatedEvents: extraPolls,
+		HasMore:       resp.End != database.PrevBatchPaginationComplete,
+		FromServer:    true,
 	}, err
 }
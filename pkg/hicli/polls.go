@@ -0,0 +1,340 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"go.mau.fi/util/exgjson"
+	"go.mau.fi/util/random"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	unstablePollKindDisclosed   = "org.matrix.msc3381.poll.disclosed"
+	unstablePollKindUndisclosed = "org.matrix.msc3381.poll.undisclosed"
+)
+
+var (
+	ErrNotAPoll          = errors.New("event is not a poll")
+	ErrPollEnded         = errors.New("poll has already ended")
+	ErrInvalidPollAnswer = errors.New("invalid poll answer")
+)
+
+type pollTextBody struct {
+	Body string `json:"body"`
+}
+
+// pollText is an MSC1767 text block with a single plaintext representation.
+type pollText struct {
+	Text []pollTextBody `json:"m.text"`
+}
+
+func newPollText(text string) pollText {
+	return pollText{Text: []pollTextBody{{Body: text}}}
+}
+
+// unstablePollText is the unstable MSC1767 text representation, which is just a plaintext string.
+type unstablePollText struct {
+	Text string `json:"org.matrix.msc1767.text"`
+}
+
+type unstablePollAnswer struct {
+	ID string `json:"id"`
+	unstablePollText
+}
+
+type unstablePollStartContent struct {
+	Kind          string               `json:"kind"`
+	MaxSelections int                  `json:"max_selections"`
+	Question      unstablePollText     `json:"question"`
+	Answers       []unstablePollAnswer `json:"answers"`
+}
+
+type unstablePollStartEventContent struct {
+	Poll unstablePollStartContent `json:"org.matrix.msc3381.poll.start"`
+	unstablePollText
+}
+
+type pollResponseEventContent struct {
+	RelatesTo  event.RelatesTo `json:"m.relates_to"`
+	Selections []string        `json:"m.selections"`
+}
+
+type unstablePollResponse struct {
+	Answers []string `json:"answers"`
+}
+
+type unstablePollResponseEventContent struct {
+	RelatesTo event.RelatesTo      `json:"m.relates_to"`
+	Response  unstablePollResponse `json:"org.matrix.msc3381.poll.response"`
+}
+
+type pollEndEventContent struct {
+	RelatesTo event.RelatesTo `json:"m.relates_to"`
+	Results   map[string]int  `json:"m.poll.results,omitempty"`
+	pollText
+}
+
+type unstablePollEndEventContent struct {
+	RelatesTo event.RelatesTo `json:"m.relates_to"`
+	End       struct{}        `json:"org.matrix.msc3381.poll.end"`
+	unstablePollText
+}
+
+// newPollStartContent creates the content of a new poll. Polls are sent with the unstable MSC3381 event type,
+// because most clients don't understand the stable type yet.
+func newPollStartContent(question string, answers []string, maxSelections int, undisclosed bool) (*unstablePollStartEventContent, error) {
+	if strings.TrimSpace(question) == "" {
+		return nil, fmt.Errorf("poll question can't be empty")
+	} else if len(answers) < 2 {
+		return nil, fmt.Errorf("poll must have at least two answers")
+	} else if maxSelections < 1 || maxSelections > len(answers) {
+		return nil, fmt.Errorf("max selections must be between 1 and the number of answers")
+	}
+	content := &unstablePollStartEventContent{
+		Poll: unstablePollStartContent{
+			Kind:          unstablePollKindDisclosed,
+			MaxSelections: maxSelections,
+			Question:      unstablePollText{Text: question},
+			Answers:       make([]unstablePollAnswer, len(answers)),
+		},
+	}
+	if undisclosed {
+		content.Poll.Kind = unstablePollKindUndisclosed
+	}
+	var fallback strings.Builder
+	fallback.WriteString(question)
+	for i, answer := range answers {
+		if strings.TrimSpace(answer) == "" {
+			return nil, fmt.Errorf("poll answers can't be empty")
+		}
+		content.Poll.Answers[i] = unstablePollAnswer{ID: random.String(16), unstablePollText: unstablePollText{Text: answer}}
+		_, _ = fmt.Fprintf(&fallback, "\n%d. %s", i+1, answer)
+	}
+	content.Text = fallback.String()
+	return content, nil
+}
+
+// newPollResponseContent creates the content of a poll response using the same namespace as the poll itself,
+// as clients only count responses in the namespace they understand.
+func newPollResponseContent(poll *database.Event, answers []string) (event.Type, any) {
+	relatesTo := event.RelatesTo{Type: event.RelReference, EventID: poll.ID}
+	if poll.IsUnstablePollStart() {
+		return database.EventUnstablePollResponse, &unstablePollResponseEventContent{
+			RelatesTo: relatesTo,
+			Response:  unstablePollResponse{Answers: answers},
+		}
+	}
+	return database.EventPollResponse, &pollResponseEventContent{
+		RelatesTo:  relatesTo,
+		Selections: answers,
+	}
+}
+
+// newPollEndContent creates the content of a poll end event using the same namespace as the poll itself.
+func newPollEndContent(poll *database.Event) (event.Type, any) {
+	relatesTo := event.RelatesTo{Type: event.RelReference, EventID: poll.ID}
+	text := "The poll has ended."
+	if topAnswers := getTopPollAnswers(poll); len(topAnswers) > 0 {
+		text = fmt.Sprintf("The poll has ended. Top answer: %s", strings.Join(topAnswers, ", "))
+	}
+	if poll.IsUnstablePollStart() {
+		return database.EventUnstablePollEnd, &unstablePollEndEventContent{
+			RelatesTo:        relatesTo,
+			unstablePollText: unstablePollText{Text: text},
+		}
+	}
+	return database.EventPollEnd, &pollEndEventContent{
+		RelatesTo: relatesTo,
+		Results:   poll.PollTally.Answers,
+		pollText:  newPollText(text),
+	}
+}
+
+// SendPoll sends a new poll with the given question and answers.
+func (h *HiClient) SendPoll(
+	ctx context.Context,
+	roomID id.RoomID,
+	question string,
+	answers []string,
+	maxSelections int,
+	undisclosed bool,
+) (*database.Event, error) {
+	content, err := newPollStartContent(question, answers, maxSelections, undisclosed)
+	if err != nil {
+		return nil, err
+	}
+	return h.Send(ctx, roomID, event.EventUnstablePollStart, content, false, false)
+}
+
+// VotePoll sends a response to a poll. An empty list of answers retracts the previous vote.
+func (h *HiClient) VotePoll(ctx context.Context, roomID id.RoomID, pollID id.EventID, answers []string) (*database.Event, error) {
+	poll, err := h.getPoll(ctx, roomID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	for _, answer := range answers {
+		if _, ok := poll.PollTally.Answers[answer]; !ok {
+			return nil, fmt.Errorf("%w %q", ErrInvalidPollAnswer, answer)
+		}
+	}
+	if answers == nil {
+		answers = []string{}
+	}
+	evtType, content := newPollResponseContent(poll, answers)
+	return h.Send(ctx, roomID, evtType, content, false, false)
+}
+
+// EndPoll ends a poll, after which new votes are no longer counted.
+func (h *HiClient) EndPoll(ctx context.Context, roomID id.RoomID, pollID id.EventID) (*database.Event, error) {
+	poll, err := h.getPoll(ctx, roomID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	canEnd, err := h.pollEndChecker(ctx, poll)
+	if err != nil {
+		return nil, err
+	} else if !canEnd(h.Account.UserID) {
+		return nil, fmt.Errorf("you don't have permission to end this poll")
+	}
+	evtType, content := newPollEndContent(poll)
+	return h.Send(ctx, roomID, evtType, content, false, false)
+}
+
+func (h *HiClient) getPoll(ctx context.Context, roomID id.RoomID, pollID id.EventID) (*database.Event, error) {
+	poll, err := h.DB.Event.GetByID(ctx, pollID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll event: %w", err)
+	} else if poll == nil || poll.RoomID != roomID {
+		return nil, fmt.Errorf("poll %s not found in %s", pollID, roomID)
+	} else if !poll.IsPollStart() || poll.RedactedBy != "" {
+		return nil, ErrNotAPoll
+	}
+	if poll.PollTally == nil {
+		err = h.recalculatePollTally(ctx, poll)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if poll.PollTally.EndEventID != "" {
+		return nil, ErrPollEnded
+	}
+	return poll, nil
+}
+
+// getTopPollAnswers returns the text of the answers with the most votes.
+func getTopPollAnswers(poll *database.Event) []string {
+	if poll.PollTally == nil {
+		return nil
+	}
+	maxVotes := 0
+	for _, votes := range poll.PollTally.Answers {
+		maxVotes = max(maxVotes, votes)
+	}
+	if maxVotes == 0 {
+		return nil
+	}
+	content := poll.Content
+	if poll.Decrypted != nil {
+		content = poll.Decrypted
+	}
+	var topAnswers []string
+	answers := gjson.GetBytes(content, exgjson.Path("org.matrix.msc3381.poll.start", "answers"))
+	if answers.Exists() {
+		for _, answer := range answers.Array() {
+			if poll.PollTally.Answers[answer.Get("id").Str] == maxVotes {
+				topAnswers = append(topAnswers, answer.Get(exgjson.Path("org.matrix.msc1767.text")).Str)
+			}
+		}
+	} else {
+		for _, answer := range gjson.GetBytes(content, exgjson.Path("m.poll", "answers")).Array() {
+			if poll.PollTally.Answers[answer.Get(exgjson.Path("m.id")).Str] == maxVotes {
+				topAnswers = append(topAnswers, answer.Get(exgjson.Path("m.text", "0", "body")).Str)
+			}
+		}
+	}
+	return topAnswers
+}
+
+// pollEndChecker returns a function that checks whether the given user is allowed to end the poll.
+// The poll creator can always end it, other users need to have permission to redact events.
+func (h *HiClient) pollEndChecker(ctx context.Context, poll *database.Event) (func(id.UserID) bool, error) {
+	pl, err := h.ClientStore.GetPowerLevels(ctx, poll.RoomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels: %w", err)
+	}
+	return func(userID id.UserID) bool {
+		return userID == poll.Sender || (pl != nil && pl.GetUserLevel(userID) >= pl.Redact())
+	}, nil
+}
+
+// recalculatePollTally recounts the votes of the given poll and stores the result in the database.
+func (h *HiClient) recalculatePollTally(ctx context.Context, poll *database.Event) error {
+	if !poll.IsPollStart() || poll.RedactedBy != "" {
+		return nil
+	}
+	related, err := h.DB.Event.GetRelatedEvents(ctx, poll.RoomID, poll.ID, event.RelReference)
+	if err != nil {
+		return fmt.Errorf("failed to get poll responses: %w", err)
+	}
+	canEnd, err := h.pollEndChecker(ctx, poll)
+	if err != nil {
+		return err
+	}
+	poll.PollTally = database.CalculatePollTally(poll, related, canEnd)
+	err = h.DB.Event.UpdatePollTally(ctx, poll.RowID, poll.PollTally)
+	if err != nil {
+		return fmt.Errorf("failed to save poll tally of %s: %w", poll.ID, err)
+	}
+	return nil
+}
+
+// recalculatePollsOfEvents recounts the votes of all polls that the given events start or respond to.
+// Polls that aren't in the given list are fetched from the database and returned,
+// so that the caller can send them to the frontend along with the events.
+func (h *HiClient) recalculatePollsOfEvents(ctx context.Context, events []*database.Event) ([]*database.Event, error) {
+	polls := make(map[id.EventID]*database.Event)
+	for _, evt := range events {
+		if evt.IsPollStart() {
+			polls[evt.ID] = evt
+		}
+	}
+	var extraPolls []*database.Event
+	for _, evt := range events {
+		if !evt.IsPollRelation() {
+			continue
+		} else if _, alreadyFound := polls[evt.RelatesTo]; alreadyFound {
+			continue
+		}
+		poll, err := h.DB.Event.GetByID(ctx, evt.RelatesTo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get poll %s: %w", evt.RelatesTo, err)
+		}
+		polls[evt.RelatesTo] = poll
+		if poll != nil && poll.RoomID == evt.RoomID {
+			extraPolls = append(extraPolls, poll)
+		}
+	}
+	for _, poll := range polls {
+		if poll == nil {
+			continue
+		}
+		err := h.recalculatePollTally(ctx, poll)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return extraPolls, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+func TestNewPollStartContent(t *testing.T) {
+	content, err := newPollStartContent("Lunch?", []string{"Pizza", "Sushi"}, 1, true)
+	if err != nil {
+		t.Fatalf("failed to create poll content: %v", err)
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("failed to marshal poll content: %v", err)
+	}
+	res := gjson.ParseBytes(data)
+	if fallback := res.Get(`org\.matrix\.msc1767\.text`).Str; fallback != "Lunch?\n1. Pizza\n2. Sushi" {
+		t.Errorf("unexpected fallback text %q", fallback)
+	}
+	poll := res.Get(`org\.matrix\.msc3381\.poll\.start`)
+	if kind := poll.Get("kind").Str; kind != unstablePollKindUndisclosed {
+		t.Errorf("unexpected poll kind %q", kind)
+	} else if question := poll.Get(`question.org\.matrix\.msc1767\.text`).Str; question != "Lunch?" {
+		t.Errorf("unexpected question %q", question)
+	} else if maxSelections := poll.Get("max_selections").Int(); maxSelections != 1 {
+		t.Errorf("unexpected max selections %d", maxSelections)
+	}
+	answers := poll.Get("answers").Array()
+	if len(answers) != 2 {
+		t.Fatalf("unexpected answer count %d", len(answers))
+	} else if answers[1].Get(`org\.matrix\.msc1767\.text`).Str != "Sushi" || answers[1].Get("id").Str == "" {
+		t.Errorf("unexpected answer %s", answers[1].Raw)
+	} else if answers[0].Get("id").Str == answers[1].Get("id").Str {
+		t.Errorf("answer IDs aren't unique")
+	}
+}
+
+func TestNewPollStartContent_Invalid(t *testing.T) {
+	tests := []struct {
+		question      string
+		answers       []string
+		maxSelections int
+	}{
+		{" ", []string{"a", "b"}, 1},
+		{"q", []string{"a"}, 1},
+		{"q", []string{"a", "b"}, 0},
+		{"q", []string{"a", "b"}, 3},
+		{"q", []string{"a", " "}, 1},
+	}
+	for _, test := range tests {
+		if _, err := newPollStartContent(test.question, test.answers, test.maxSelections, false); err == nil {
+			t.Errorf("no error for invalid poll %+v", test)
+		}
+	}
+}
+
+func TestNewPollRelationContent_MatchesPollNamespace(t *testing.T) {
+	unstablePoll := &database.Event{ID: "$unstable", Type: event.EventUnstablePollStart.Type, PollTally: &database.PollTally{}}
+	stablePoll := &database.Event{ID: "$stable", Type: database.EventPollStart.Type, PollTally: &database.PollTally{}}
+
+	evtType, content := newPollResponseContent(unstablePoll, []string{"a"})
+	data, _ := json.Marshal(content)
+	if evtType != database.EventUnstablePollResponse {
+		t.Errorf("unexpected response type %s for unstable poll", evtType.Type)
+	} else if answers := gjson.GetBytes(data, `org\.matrix\.msc3381\.poll\.response.answers.0`).Str; answers != "a" {
+		t.Errorf("unexpected unstable response content %s", data)
+	} else if relatesTo := gjson.GetBytes(data, `m\.relates_to.event_id`).Str; relatesTo != "$unstable" {
+		t.Errorf("unexpected relation target %s", relatesTo)
+	}
+	evtType, content = newPollResponseContent(stablePoll, []string{"a"})
+	data, _ = json.Marshal(content)
+	if evtType != database.EventPollResponse {
+		t.Errorf("unexpected response type %s for stable poll", evtType.Type)
+	} else if answers := gjson.GetBytes(data, `m\.selections.0`).Str; answers != "a" {
+		t.Errorf("unexpected stable response content %s", data)
+	}
+
+	evtType, content = newPollEndContent(unstablePoll)
+	data, _ = json.Marshal(content)
+	if evtType != database.EventUnstablePollEnd {
+		t.Errorf("unexpected end type %s for unstable poll", evtType.Type)
+	} else if !gjson.GetBytes(data, `org\.matrix\.msc3381\.poll\.end`).IsObject() {
+		t.Errorf("unexpected unstable end content %s", data)
+	}
+	evtType, _ = newPollEndContent(stablePoll)
+	if evtType != database.EventPollEnd {
+		t.Errorf("unexpected end type %s for stable poll", evtType.Type)
+	}
+}
+
+func TestGetTopPollAnswers(t *testing.T) {
+	unstablePoll := &database.Event{
+		Content: []byte(`{"org.matrix.msc3381.poll.start": {"answers": [
+			{"id": "a", "org.matrix.msc1767.text": "A"},
+			{"id": "b", "org.matrix.msc1767.text": "B"},
+			{"id": "c", "org.matrix.msc1767.text": "C"}
+		]}}`),
+		PollTally: &database.PollTally{Answers: map[string]int{"a": 2, "b": 1, "c": 2}},
+	}
+	if top := getTopPollAnswers(unstablePoll); !slices.Equal(top, []string{"A", "C"}) {
+		t.Errorf("unexpected top answers %v for unstable poll", top)
+	}
+	stablePoll := &database.Event{
+		Content: []byte(`{"m.poll": {"answers": [
+			{"m.id": "a", "m.text": [{"body": "A"}]},
+			{"m.id": "b", "m.text": [{"body": "B"}]}
+		]}}`),
+		PollTally: &database.PollTally{Answers: map[string]int{"a": 0, "b": 1}},
+	}
+	if top := getTopPollAnswers(stablePoll); !slices.Equal(top, []string{"B"}) {
+		t.Errorf("unexpected top answers %v for stable poll", top)
+	}
+	stablePoll.PollTally.Answers["b"] = 0
+	if top := getTopPollAnswers(stablePoll); top != nil {
+		t.Errorf("unexpected top answers %v for poll without votes", top)
+	}
+}
@@ -7,16 +7,23 @@
 package hicli
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"slices"
 
 	"github.com/rs/zerolog"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/gomuks/pkg/hicli/database"
 	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
 )
 
@@ -80,3 +87,149 @@ func (h *HiClient) TrackUserDevices(ctx context.Context, userID id.UserID) error
 	_, err := h.Crypto.FetchKeys(ctx, []id.UserID{userID}, true)
 	return err
 }
+
+func (h *HiClient) GetOwnDevices(ctx context.Context) ([]*jsoncmd.OwnDevice, error) {
+	log := zerolog.Ctx(ctx)
+	serverDevices, err := h.Client.GetDevicesInfo(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get own device list from server")
+		return nil, err
+	}
+	cryptoDevices := make(map[id.DeviceID]*id.Device)
+	cachedDevices, err := h.Crypto.GetCachedDevices(ctx, h.Account.UserID)
+	if err != nil && !errors.Is(err, crypto.ErrUserNotTracked) {
+		log.Err(err).Msg("Failed to get cached own devices")
+		return nil, err
+	} else if cachedDevices != nil {
+		for _, dev := range cachedDevices.Devices {
+			cryptoDevices[dev.DeviceID] = dev
+		}
+	}
+	output := make([]*jsoncmd.OwnDevice, len(serverDevices.Devices))
+	for i, serverDev := range serverDevices.Devices {
+		dev := &jsoncmd.OwnDevice{
+			DeviceID:   serverDev.DeviceID,
+			Name:       serverDev.DisplayName,
+			LastSeenIP: serverDev.LastSeenIP,
+			LastSeenTS: serverDev.LastSeenTS,
+			Current:    serverDev.DeviceID == h.Account.DeviceID,
+		}
+		if cryptoDev, ok := cryptoDevices[serverDev.DeviceID]; ok {
+			dev.IdentityKey = cryptoDev.IdentityKey
+			dev.SigningKey = cryptoDev.SigningKey
+			dev.Fingerprint = cryptoDev.Fingerprint()
+			dev.Trust = cryptoDev.Trust
+		}
+		output[i] = dev
+	}
+	slices.SortFunc(output, func(a, b *jsoncmd.OwnDevice) int {
+		return cmp.Compare(b.LastSeenTS, a.LastSeenTS)
+	})
+	return output, nil
+}
+
+func (h *HiClient) RenameDevice(ctx context.Context, deviceID id.DeviceID, name string) error {
+	return h.Client.SetDeviceInfo(ctx, deviceID, &mautrix.ReqDeviceInfo{DisplayName: name})
+}
+
+// DeleteDevices deletes (i.e. logs out) the given devices. Deleting devices requires user-interactive auth:
+// if a password is provided, it will be used for auth, otherwise a SSO fallback URL is returned.
+// After the fallback auth has been completed, this should be called again with the session ID.
+func (h *HiClient) DeleteDevices(ctx context.Context, deviceIDs []id.DeviceID, password, session string) (*jsoncmd.DeleteDevicesResponse, error) {
+	if len(deviceIDs) == 0 {
+		return nil, fmt.Errorf("no devices specified")
+	} else if slices.Contains(deviceIDs, h.Account.DeviceID) {
+		return nil, fmt.Errorf("can't delete current device, log out instead")
+	}
+	req := &mautrix.ReqDeleteDevices{Devices: deviceIDs}
+	if session != "" {
+		req.Auth = map[string]any{"session": session}
+	}
+	err := h.Client.DeleteDevices(ctx, req)
+	uia := getUIAResponse(err)
+	if uia == nil {
+		if err != nil {
+			return nil, err
+		}
+		return &jsoncmd.DeleteDevicesResponse{Deleted: true}, nil
+	}
+	if password != "" && uia.HasSingleStageFlow(mautrix.AuthTypePassword) {
+		req.Auth = &mautrix.ReqUIAuthLogin{
+			BaseAuthData: mautrix.BaseAuthData{
+				Type:    mautrix.AuthTypePassword,
+				Session: uia.Session,
+			},
+			User:     h.Account.UserID.String(),
+			Password: password,
+		}
+		err = h.Client.DeleteDevices(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return &jsoncmd.DeleteDevicesResponse{Deleted: true}, nil
+	}
+	resp := &jsoncmd.DeleteDevicesResponse{
+		Session:       uia.Session,
+		PasswordLogin: uia.HasSingleStageFlow(mautrix.AuthTypePassword),
+	}
+	for _, flow := range uia.Flows {
+		if len(flow.Stages) == 1 && flow.Stages[0] != mautrix.AuthTypePassword {
+			resp.FallbackURL = h.Client.BuildClientURL("v3", "auth", flow.Stages[0], "fallback", "web") + "?session=" + url.QueryEscape(uia.Session)
+			break
+		}
+	}
+	if resp.FallbackURL == "" && !resp.PasswordLogin {
+		return nil, fmt.Errorf("no supported auth flows for deleting devices")
+	}
+	return resp, nil
+}
+
+func getUIAResponse(err error) *mautrix.RespUserInteractive {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) || !httpErr.IsStatus(http.StatusUnauthorized) || httpErr.ResponseBody == "" {
+		return nil
+	}
+	var uia mautrix.RespUserInteractive
+	if json.Unmarshal([]byte(httpErr.ResponseBody), &uia) != nil || len(uia.Flows) == 0 {
+		return nil
+	}
+	return &uia
+}
+
+type reqSetPresence struct {
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg,omitempty"`
+}
+
+// SetPresence updates the user's presence and status message on the server.
+// The presence is also used as the set_presence parameter for future syncs,
+// so that syncing doesn't override it by marking the user as online again.
+func (h *HiClient) SetPresence(ctx context.Context, presence event.Presence, statusMsg string) error {
+	switch presence {
+	case event.PresenceOnline, event.PresenceUnavailable, event.PresenceOffline:
+	default:
+		return fmt.Errorf("invalid presence %q", presence)
+	}
+	_, err := h.Client.MakeRequest(
+		ctx,
+		http.MethodPut,
+		h.Client.BuildClientURL("v3", "presence", h.Account.UserID, "status"),
+		&reqSetPresence{Presence: presence, StatusMsg: statusMsg},
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	if presence == event.PresenceOnline {
+		h.Client.SyncPresence = ""
+	} else {
+		h.Client.SyncPresence = presence
+	}
+	return nil
+}
+
+// GetPresence returns the locally cached presence of the given users.
+// Users whose presence hasn't been received through sync are not included in the response.
+func (h *HiClient) GetPresence(ctx context.Context, userIDs []id.UserID) ([]*database.Presence, error) {
+	return h.DB.Presence.GetMany(ctx, userIDs)
+}
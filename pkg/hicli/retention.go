@@ -0,0 +1,243 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+const (
+	retentionInitialDelay = 5 * time.Minute
+	retentionInterval     = 6 * time.Hour
+	// retentionResetEventCount is the number of remaining events sent to the frontend when a pruned room is reset.
+	retentionResetEventCount = 50
+)
+
+// RetentionPolicy defines how much history is kept in the local database for a room.
+// Pruned history can still be paginated from the server.
+type RetentionPolicy struct {
+	// MaxAge is the maximum age of timeline events to keep. Zero means no limit.
+	MaxAge time.Duration
+	// MaxEvents is the maximum number of timeline events to keep. Zero means no limit.
+	MaxEvents int
+}
+
+func (rp RetentionPolicy) IsEnabled() bool {
+	return rp.MaxAge > 0 || rp.MaxEvents > 0
+}
+
+func (h *HiClient) getRetentionPolicy(roomID id.RoomID) RetentionPolicy {
+	if policy, ok := h.RoomRetention[roomID]; ok {
+		return policy
+	}
+	return h.Retention
+}
+
+func (h *HiClient) isRetentionEnabled() bool {
+	if h.Retention.IsEnabled() {
+		return true
+	}
+	for _, policy := range h.RoomRetention {
+		if policy.IsEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// RunRetention periodically prunes old events from all rooms according to the configured retention policies.
+func (h *HiClient) RunRetention(ctx context.Context) {
+	if !h.isRetentionEnabled() {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Str("action", "retention").Logger()
+	ctx = log.WithContext(ctx)
+	timer := time.NewTimer(retentionInitialDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		h.pruneAllRooms(ctx)
+		timer.Reset(retentionInterval)
+	}
+}
+
+func (h *HiClient) pruneAllRooms(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	roomIDs, err := h.DB.Timeline.GetRoomIDs(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get rooms to prune")
+		return
+	}
+	var totalTimeline, totalEvents int64
+	for _, roomID := range roomIDs {
+		policy := h.getRetentionPolicy(roomID)
+		if !policy.IsEnabled() {
+			continue
+		}
+		res, err := h.PruneRoom(ctx, roomID, policy)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Err(err).Stringer("room_id", roomID).Msg("Failed to prune room")
+			continue
+		}
+		totalTimeline += res.TimelineDeleted
+		totalEvents += res.EventsDeleted
+	}
+	log.Info().
+		Int64("timeline_deleted", totalTimeline).
+		Int64("events_deleted", totalEvents).
+		Msg("Finished pruning old events")
+}
+
+type respContextToken struct {
+	Start string `json:"start"`
+}
+
+// PruneRoom deletes local history older than the given policy allows. The room's pagination token is moved to
+// the oldest remaining event, so that older history can still be paginated from the server afterwards.
+func (h *HiClient) PruneRoom(ctx context.Context, roomID id.RoomID, policy RetentionPolicy) (*database.PruneResult, error) {
+	h.paginationInterrupterLock.Lock()
+	if _, alreadyPaginating := h.paginationInterrupter[roomID]; alreadyPaginating {
+		h.paginationInterrupterLock.Unlock()
+		return nil, ErrPaginationAlreadyInProgress
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(context.Canceled)
+	h.paginationInterrupter[roomID] = cancel
+	h.paginationInterrupterLock.Unlock()
+	defer func() {
+		h.paginationInterrupterLock.Lock()
+		delete(h.paginationInterrupter, roomID)
+		h.paginationInterrupterLock.Unlock()
+	}()
+
+	var minTimestamp time.Time
+	if policy.MaxAge > 0 {
+		minTimestamp = time.Now().Add(-policy.MaxAge)
+	}
+	boundary, err := h.DB.Timeline.GetPruneBoundary(ctx, roomID, policy.MaxEvents, minTimestamp)
+	if err != nil {
+		return nil, err
+	} else if boundary == nil {
+		return &database.PruneResult{}, nil
+	}
+	var resp respContextToken
+	_, err = h.Client.MakeRequest(
+		ctx,
+		http.MethodGet,
+		h.Client.BuildURLWithQuery(
+			mautrix.ClientURLPath{"v3", "rooms", roomID, "context", boundary.EventID},
+			map[string]string{"limit": "0"},
+		),
+		nil,
+		&resp,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pagination token for %s: %w", boundary.EventID, err)
+	} else if resp.Start == "" {
+		return nil, fmt.Errorf("server didn't return pagination token for %s", boundary.EventID)
+	}
+	var res *database.PruneResult
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		res, err = h.DB.Timeline.Prune(ctx, roomID, boundary)
+		if err != nil {
+			return err
+		}
+		err = h.DB.Room.SetPrevBatch(ctx, roomID, resp.Start)
+		if err != nil {
+			return fmt.Errorf("failed to set prev_batch: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("room_id", roomID).
+		Stringer("oldest_kept_event_id", boundary.EventID).
+		Int64("timeline_deleted", res.TimelineDeleted).
+		Int64("events_deleted", res.EventsDeleted).
+		Msg("Pruned room history")
+	if res.TimelineDeleted > 0 || res.EventsDeleted > 0 {
+		err = h.dispatchRoomReset(ctx, roomID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to send timeline reset after pruning")
+		}
+	}
+	return res, nil
+}
+
+// dispatchRoomReset replaces the frontend's timeline of the room with the latest remaining events,
+// so that it doesn't keep references to pruned events.
+func (h *HiClient) dispatchRoomReset(ctx context.Context, roomID id.RoomID) error {
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get room metadata: %w", err)
+	} else if room == nil {
+		return nil
+	}
+	evts, err := h.DB.Timeline.Get(ctx, roomID, retentionResetEventCount, 0)
+	if err != nil {
+		return fmt.Errorf("failed to get remaining timeline: %w", err)
+	}
+	slices.Reverse(evts)
+	timeline := make([]database.TimelineRowTuple, len(evts))
+	for i, evt := range evts {
+		h.ReprocessExistingEvent(ctx, evt)
+		timeline[i] = database.TimelineRowTuple{Timeline: evt.TimelineRowID, Event: evt.RowID}
+	}
+	h.EventHandler(&jsoncmd.SyncComplete{
+		Rooms: map[id.RoomID]*jsoncmd.SyncRoom{
+			roomID: {
+				Meta:     room,
+				Timeline: timeline,
+				Events:   evts,
+				Reset:    true,
+			},
+		},
+	})
+	return nil
+}
+
+// CompactRoom prunes a room immediately. If the given policy is empty, the configured policy for the room is used.
+// If vacuum is true, the database file is also vacuumed afterwards to return the freed space to the filesystem.
+func (h *HiClient) CompactRoom(ctx context.Context, roomID id.RoomID, policy RetentionPolicy, vacuum bool) (*database.PruneResult, error) {
+	if !policy.IsEnabled() {
+		policy = h.getRetentionPolicy(roomID)
+		if !policy.IsEnabled() {
+			return nil, fmt.Errorf("no retention policy configured for %s", roomID)
+		}
+	}
+	res, err := h.PruneRoom(ctx, roomID, policy)
+	if err != nil {
+		return nil, err
+	}
+	if vacuum {
+		_, err = h.DB.Exec(ctx, "VACUUM")
+		if err != nil {
+			return nil, fmt.Errorf("failed to vacuum database: %w", err)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	delayedEventsUnstableFeature = "org.matrix.msc4140"
+	// Messages scheduled closer than this are sent by the local scheduler, as there's no point in asking the server.
+	minServerScheduleDelay = 10 * time.Second
+	schedulerErrorDelay    = 1 * time.Minute
+)
+
+var (
+	ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+	ErrScheduleTimeInPast       = errors.New("scheduled time must be in the future")
+	ErrCommandNotSchedulable    = errors.New("commands can't be scheduled")
+)
+
+func (h *HiClient) supportsDelayedEvents() bool {
+	versions := h.Client.SpecVersions
+	return versions != nil && versions.UnstableFeatures[delayedEventsUnstableFeature]
+}
+
+// ScheduleMessage prepares a message like SendMessage, but sends it at the given time instead of immediately.
+// If the server supports delayed events (MSC4140), the message is sent by the server even if gomuks isn't running.
+// Otherwise, it's stored locally and sent by the scheduler (or right after startup if gomuks wasn't running).
+func (h *HiClient) ScheduleMessage(
+	ctx context.Context,
+	roomID id.RoomID,
+	base *event.MessageEventContent,
+	extra map[string]any,
+	text string,
+	relatesTo *event.RelatesTo,
+	mentions *event.Mentions,
+	urlPreviews []*event.BeeperLinkPreview,
+	sendAt time.Time,
+) (*database.ScheduledMessage, error) {
+	if !sendAt.After(time.Now()) {
+		return nil, ErrScheduleTimeInPast
+	} else if text == "/discardsession" || strings.HasPrefix(text, "/rawstate ") {
+		return nil, ErrCommandNotSchedulable
+	}
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room metadata: %w", err)
+	} else if room == nil {
+		return nil, fmt.Errorf("unknown room")
+	}
+	msg, err := h.prepareMessage(ctx, roomID, base, extra, text, relatesTo, mentions, urlPreviews)
+	if err != nil {
+		return nil, err
+	} else if msg == nil {
+		return nil, ErrCommandNotSchedulable
+	}
+	content, err := json.Marshal(msg.content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event content: %w", err)
+	}
+	sm := &database.ScheduledMessage{
+		ScheduleID:        "hicli-" + h.Client.TxnID(),
+		RoomID:            roomID,
+		Type:              msg.evtType.Type,
+		Content:           content,
+		EditSource:        msg.editSource,
+		DisableEncryption: msg.disableEncryption,
+		SendAt:            jsontime.UM(sendAt),
+		CreatedAt:         jsontime.UnixMilliNow(),
+	}
+	err = h.scheduleMessage(ctx, room, sm)
+	if err != nil {
+		return nil, err
+	}
+	return sm, nil
+}
+
+// GetScheduledMessages returns the scheduled messages in the given room, or in all rooms if the room ID is empty.
+func (h *HiClient) GetScheduledMessages(ctx context.Context, roomID id.RoomID) ([]*database.ScheduledMessage, error) {
+	return h.DB.ScheduledMessage.GetAll(ctx, roomID)
+}
+
+// CancelScheduledMessage cancels a scheduled message, including the delayed event on the server if there is one.
+func (h *HiClient) CancelScheduledMessage(ctx context.Context, scheduleID string) error {
+	sm, err := h.DB.ScheduledMessage.Get(ctx, scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed to get scheduled message: %w", err)
+	} else if sm == nil {
+		return ErrScheduledMessageNotFound
+	}
+	err = h.cancelDelayedEvent(ctx, sm)
+	if err != nil {
+		return err
+	}
+	err = h.DB.ScheduledMessage.Delete(ctx, scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete scheduled message: %w", err)
+	}
+	return nil
+}
+
+// RescheduleMessage changes the send time of a scheduled message. If the new time isn't in the future,
+// the message is sent immediately.
+func (h *HiClient) RescheduleMessage(ctx context.Context, scheduleID string, sendAt time.Time) (*database.ScheduledMessage, error) {
+	sm, err := h.DB.ScheduledMessage.Get(ctx, scheduleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled message: %w", err)
+	} else if sm == nil {
+		return nil, ErrScheduledMessageNotFound
+	}
+	room, err := h.DB.Room.Get(ctx, sm.RoomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room metadata: %w", err)
+	} else if room == nil {
+		return nil, fmt.Errorf("unknown room")
+	}
+	// Delayed events can only be restarted with the original delay, so changing the time requires re-creating it.
+	err = h.cancelDelayedEvent(ctx, sm)
+	if err != nil {
+		return nil, err
+	}
+	sm.SendAt = jsontime.UM(sendAt)
+	err = h.scheduleMessage(ctx, room, sm)
+	if err != nil {
+		return nil, err
+	}
+	return sm, nil
+}
+
+func (h *HiClient) cancelDelayedEvent(ctx context.Context, sm *database.ScheduledMessage) error {
+	if sm.DelayID == "" {
+		return nil
+	}
+	_, err := h.Client.UpdateDelayedEvent(ctx, &mautrix.ReqUpdateDelayedEvent{
+		DelayID: sm.DelayID,
+		Action:  "cancel",
+	})
+	if errors.Is(err, mautrix.MNotFound) {
+		return fmt.Errorf("delayed event was already sent or expired on the server")
+	} else if err != nil {
+		return fmt.Errorf("failed to cancel delayed event: %w", err)
+	}
+	sm.DelayID = ""
+	return nil
+}
+
+// scheduleMessage tries to schedule the message on the server and falls back to the local scheduler,
+// then saves it in the database.
+func (h *HiClient) scheduleMessage(ctx context.Context, room *database.Room, sm *database.ScheduledMessage) error {
+	if time.Until(sm.SendAt.Time) > minServerScheduleDelay && h.supportsDelayedEvents() {
+		delayID, err := h.sendDelayedEvent(ctx, room, sm)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).
+				Str("schedule_id", sm.ScheduleID).
+				Msg("Failed to schedule message on server, falling back to local scheduler")
+		} else {
+			sm.DelayID = delayID
+		}
+	}
+	err := h.DB.ScheduledMessage.Put(ctx, sm)
+	if err != nil {
+		return fmt.Errorf("failed to save scheduled message: %w", err)
+	}
+	h.WakeupScheduler()
+	return nil
+}
+
+func (h *HiClient) sendDelayedEvent(ctx context.Context, room *database.Room, sm *database.ScheduledMessage) (string, error) {
+	evtType := event.Type{Type: sm.Type, Class: event.MessageEventType}
+	var content any = sm.Content
+	if room.EncryptionEvent != nil && evtType != event.EventReaction && !sm.DisableEncryption {
+		encrypted, err := h.Encrypt(ctx, room, evtType, sm.Content)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt: %w", err)
+		}
+		evtType = event.EventEncrypted
+		content = encrypted
+	}
+	resp, err := h.Client.SendMessageEvent(ctx, room.ID, evtType, content, mautrix.ReqSendEvent{
+		UnstableDelay: time.Until(sm.SendAt.Time),
+		DontEncrypt:   true,
+	})
+	if err != nil {
+		return "", err
+	} else if resp.UnstableDelayID == "" {
+		return "", fmt.Errorf("server didn't return a delay ID")
+	}
+	return resp.UnstableDelayID, nil
+}
+
+// RunScheduler sends locally scheduled messages when they're due. Messages that were scheduled on the server
+// are only removed from the local database after their send time.
+func (h *HiClient) RunScheduler(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "scheduler").Logger()
+	ctx = log.WithContext(ctx)
+	for {
+		var timer <-chan time.Time
+		now := time.Now()
+		err := h.DB.ScheduledMessage.DeleteExpiredRemote(ctx, now)
+		if err != nil {
+			log.Err(err).Msg("Failed to delete expired delayed events")
+		}
+		due, err := h.DB.ScheduledMessage.GetDueLocal(ctx, now)
+		if err != nil {
+			log.Err(err).Msg("Failed to get due scheduled messages")
+		}
+		sendFailed := false
+		for _, sm := range due {
+			err = h.sendScheduledMessage(ctx, sm)
+			if errors.Is(err, ErrScheduledMessageNotFound) {
+				log.Debug().Str("schedule_id", sm.ScheduleID).Msg("Scheduled message was cancelled before sending")
+			} else if err != nil {
+				log.Err(err).Str("schedule_id", sm.ScheduleID).Msg("Failed to send scheduled message")
+				sendFailed = true
+			}
+		}
+		nextSend, err := h.DB.ScheduledMessage.GetNextSendTime(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Err(err).Msg("Failed to get next scheduled message time")
+			timer = time.After(schedulerErrorDelay)
+		} else if sendFailed {
+			// Messages that failed to send are still in the database, so wait a bit instead of retrying immediately.
+			timer = time.After(schedulerErrorDelay)
+		} else if !nextSend.IsZero() {
+			timer = time.After(time.Until(nextSend))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.schedulerWakeup:
+		case <-timer:
+		}
+	}
+}
+
+// sendScheduledMessage queues a due message in the outbox. The scheduled message is only deleted in the same
+// transaction, so it's kept for the next attempt if sending fails, and isn't sent if it was cancelled concurrently.
+func (h *HiClient) sendScheduledMessage(ctx context.Context, sm *database.ScheduledMessage) error {
+	evtType := event.Type{Type: sm.Type, Class: event.MessageEventType}
+	_, err := h.send(ctx, sm.RoomID, evtType, sm.Content, sm.EditSource, sm.DisableEncryption, false, func(ctx context.Context) error {
+		deleted, err := h.DB.ScheduledMessage.DeleteDueLocal(ctx, sm)
+		if err != nil {
+			return fmt.Errorf("failed to delete scheduled message: %w", err)
+		} else if !deleted {
+			return ErrScheduledMessageNotFound
+		}
+		return nil
+	})
+	return err
+}
+
+func (h *HiClient) WakeupScheduler() {
+	select {
+	case h.schedulerWakeup <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+var ErrSearchUnavailable = errors.New("local search is not available: gomuks was built without SQLite FTS5 support")
+
+// SearchMessages searches the local database for messages matching the given query.
+// Unlike server-side search, this also works for encrypted rooms, but only finds events that have been synced or paginated.
+func (h *HiClient) SearchMessages(ctx context.Context, params *database.SearchParams) (*jsoncmd.SearchMessagesResponse, error) {
+	if !h.SearchAvailable {
+		return nil, ErrSearchUnavailable
+	}
+	if params.Limit <= 0 {
+		params.Limit = defaultSearchLimit
+	} else if params.Limit > maxSearchLimit {
+		params.Limit = maxSearchLimit
+	}
+	params.Offset = max(params.Offset, 0)
+	evts, err := h.DB.Event.Search(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search events: %w", err)
+	}
+	resp := &jsoncmd.SearchMessagesResponse{Events: evts}
+	if len(evts) > params.Limit {
+		resp.Events = evts[:params.Limit]
+		resp.HasMore = true
+		resp.NextOffset = params.Offset + params.Limit
+	}
+	return resp, nil
+}
+
+type reqServerSearch struct {
+	SearchCategories reqSearchCategories `json:"search_categories"`
+}
+
+type reqSearchCategories struct {
+	RoomEvents *reqSearchRoomEvents `json:"room_events"`
+}
+
+type reqSearchRoomEvents struct {
+	SearchTerm string               `json:"search_term"`
+	Filter     *mautrix.FilterPart  `json:"filter,omitempty"`
+	OrderBy    database.SearchOrder `json:"order_by,omitempty"`
+}
+
+type respServerSearch struct {
+	SearchCategories struct {
+		RoomEvents struct {
+			Count      int      `json:"count"`
+			Highlights []string `json:"highlights"`
+			NextBatch  string   `json:"next_batch"`
+			Results    []struct {
+				Rank   float64      `json:"rank"`
+				Result *event.Event `json:"result"`
+			} `json:"results"`
+		} `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+// SearchServer searches messages using the homeserver's search API. This only finds events in unencrypted rooms,
+// but unlike local search, it can also find events that haven't been paginated into the local database.
+// Results are stored in the database like any other event fetched from the server.
+func (h *HiClient) SearchServer(
+	ctx context.Context, query string, roomIDs []id.RoomID, senders []id.UserID, order database.SearchOrder, nextBatch string, limit int,
+) (*jsoncmd.ServerSearchResponse, error) {
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	} else if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	req := &reqServerSearch{SearchCategories: reqSearchCategories{RoomEvents: &reqSearchRoomEvents{
+		SearchTerm: query,
+		Filter: &mautrix.FilterPart{
+			Rooms:   roomIDs,
+			Senders: senders,
+			Limit:   limit,
+		},
+		OrderBy: order,
+	}}}
+	queryParams := map[string]string{}
+	if nextBatch != "" {
+		queryParams["next_batch"] = nextBatch
+	}
+	reqURL := h.Client.BuildURLWithQuery(mautrix.ClientURLPath{"v3", "search"}, queryParams)
+	var resp respServerSearch
+	_, err := h.Client.MakeRequest(ctx, http.MethodPost, reqURL, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	results := resp.SearchCategories.RoomEvents
+	output := &jsoncmd.ServerSearchResponse{
+		Events:     make([]*database.Event, 0, len(results.Results)),
+		Count:      results.Count,
+		Highlights: results.Highlights,
+		NextBatch:  results.NextBatch,
+	}
+	if output.Highlights == nil {
+		output.Highlights = []string{}
+	}
+	for _, result := range results.Results {
+		evt := result.Result
+		if evt == nil || evt.ID == "" || evt.RoomID == "" {
+			continue
+		}
+		dbEvt, err := h.DB.Event.GetByID(ctx, evt.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if event %s exists: %w", evt.ID, err)
+		} else if dbEvt != nil {
+			h.ReprocessExistingEvent(ctx, dbEvt)
+		} else if dbEvt, err = h.processEvent(ctx, evt, nil, nil, false); err != nil {
+			zerolog.Ctx(ctx).Err(err).
+				Stringer("event_id", evt.ID).
+				Msg("Failed to process server search result")
+			continue
+		}
+		output.Events = append(output.Events, dbEvt)
+	}
+	return output, nil
+}
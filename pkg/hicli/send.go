@@ -27,7 +27,6 @@ import (
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/gomuks/pkg/hicli/database"
-	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
 	"go.mau.fi/gomuks/pkg/rainbow"
 )
 
@@ -73,6 +72,13 @@ func init() {
 	}
 }
 
+type preparedMessage struct {
+	evtType           event.Type
+	content           any
+	editSource        string
+	disableEncryption bool
+}
+
 func (h *HiClient) SendMessage(
 	ctx context.Context,
 	roomID id.RoomID,
@@ -83,6 +89,25 @@ func (h *HiClient) SendMessage(
 	mentions *event.Mentions,
 	urlPreviews []*event.BeeperLinkPreview,
 ) (*database.Event, error) {
+	msg, err := h.prepareMessage(ctx, roomID, base, extra, text, relatesTo, mentions, urlPreviews)
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	return h.send(ctx, roomID, msg.evtType, msg.content, msg.editSource, msg.disableEncryption, false, nil)
+}
+
+// prepareMessage builds the event content for SendMessage. If the text is a command that doesn't send a message,
+// the command is executed and nil is returned.
+func (h *HiClient) prepareMessage(
+	ctx context.Context,
+	roomID id.RoomID,
+	base *event.MessageEventContent,
+	extra map[string]any,
+	text string,
+	relatesTo *event.RelatesTo,
+	mentions *event.Mentions,
+	urlPreviews []*event.BeeperLinkPreview,
+) (*preparedMessage, error) {
 	if text == "/discardsession" {
 		err := h.CryptoStore.RemoveOutboundGroupSession(ctx, roomID)
 		if err != nil {
@@ -109,7 +134,11 @@ func (h *HiClient) SendMessage(
 		if !json.Valid(content) {
 			return nil, fmt.Errorf("invalid JSON in /raw command")
 		}
-		return h.send(ctx, roomID, event.Type{Type: parts[1]}, content, "", unencrypted, false)
+		return &preparedMessage{
+			evtType:           event.Type{Type: parts[1]},
+			content:           content,
+			disableEncryption: unencrypted,
+		}, nil
 	} else if strings.HasPrefix(text, "/rawstate ") {
 		parts := strings.SplitN(text, " ", 4)
 		if len(parts) < 4 || len(parts[1]) == 0 {
@@ -212,16 +241,33 @@ func (h *HiClient) SendMessage(
 		content.MsgType = ""
 		evtType = event.EventSticker
 	}
-	return h.send(ctx, roomID, evtType, &event.Content{Parsed: content, Raw: extra}, origText, unencrypted, false)
+	return &preparedMessage{
+		evtType:           evtType,
+		content:           &event.Content{Parsed: content, Raw: extra},
+		editSource:        origText,
+		disableEncryption: unencrypted,
+	}, nil
 }
 
-func (h *HiClient) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, receiptType event.ReceiptType) error {
+// MarkRead sends a read receipt for the given event. If a thread ID is given, a threaded receipt is sent,
+// which only marks that thread as read and doesn't move the fully read marker.
+func (h *HiClient) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, receiptType event.ReceiptType, threadID event.ThreadID) error {
 	room, err := h.DB.Room.Get(ctx, roomID)
 	if err != nil {
 		return fmt.Errorf("failed to get room metadata: %w", err)
 	} else if room == nil {
 		return fmt.Errorf("unknown room")
 	}
+	if threadID != "" {
+		if receiptType != event.ReceiptTypeRead && receiptType != event.ReceiptTypeReadPrivate {
+			return fmt.Errorf("invalid receipt type: %v", receiptType)
+		}
+		err = h.Client.SendReceipt(ctx, roomID, eventID, receiptType, &mautrix.ReqSendReceipt{ThreadID: string(threadID)})
+		if err != nil {
+			return fmt.Errorf("failed to mark thread as read: %w", err)
+		}
+		return nil
+	}
 	content := &mautrix.ReqSetReadMarkers{
 		FullyRead: eventID,
 	}
@@ -287,7 +333,7 @@ func (h *HiClient) Send(
 		// TODO implement
 		return nil, fmt.Errorf("redaction is not supported")
 	}
-	return h.send(ctx, roomID, evtType, content, "", disableEncryption, synchronous)
+	return h.send(ctx, roomID, evtType, content, "", disableEncryption, synchronous, nil)
 }
 
 func (h *HiClient) Resend(ctx context.Context, txnID string) (*database.Event, error) {
@@ -299,17 +345,27 @@ func (h *HiClient) Resend(ctx context.Context, txnID string) (*database.Event, e
 	} else if dbEvt.ID != "" && !strings.HasPrefix(dbEvt.ID.String(), "~") {
 		return nil, fmt.Errorf("event was already sent successfully")
 	}
-	room, err := h.DB.Room.Get(ctx, dbEvt.RoomID)
+	dbEvt.SendError = "not sent"
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		err = h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
+		if err != nil {
+			return fmt.Errorf("failed to reset send error: %w", err)
+		}
+		err = h.queueSend(ctx, dbEvt)
+		if err != nil {
+			return fmt.Errorf("failed to add event to outbox: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get room metadata: %w", err)
-	} else if room == nil {
-		return nil, fmt.Errorf("unknown room")
+		return nil, err
 	}
-	dbEvt.SendError = ""
-	go h.actuallySend(context.WithoutCancel(ctx), room, dbEvt, event.Type{Type: dbEvt.Type, Class: event.MessageEventType}, false)
+	h.WakeupOutbox()
 	return dbEvt, nil
 }
 
+// send inserts a local echo of the event and queues it in the outbox (or sends it immediately if synchronous is true).
+// If inTxn is set, it's called in the same transaction, and an error from it cancels the send.
 func (h *HiClient) send(
 	ctx context.Context,
 	roomID id.RoomID,
@@ -318,6 +374,7 @@ func (h *HiClient) send(
 	overrideEditSource string,
 	disableEncryption bool,
 	synchronous bool,
+	inTxn func(ctx context.Context) error,
 ) (*database.Event, error) {
 	room, err := h.DB.Room.Get(ctx, roomID)
 	if err != nil {
@@ -361,9 +418,24 @@ func (h *HiClient) send(
 	if overrideEditSource != "" && dbEvt.LocalContent != nil {
 		dbEvt.LocalContent.EditSource = overrideEditSource
 	}
-	_, err = h.DB.Event.Insert(ctx, dbEvt)
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		_, err = h.DB.Event.Insert(ctx, dbEvt)
+		if err != nil {
+			return fmt.Errorf("failed to insert event into database: %w", err)
+		}
+		if !synchronous {
+			err = h.queueSend(ctx, dbEvt)
+			if err != nil {
+				return fmt.Errorf("failed to add event to outbox: %w", err)
+			}
+		}
+		if inTxn != nil {
+			return inTxn(ctx)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert event into database: %w", err)
+		return nil, err
 	}
 	h.cacheMedia(ctx, mautrixEvt, dbEvt.RowID)
 	for _, uri := range inlineImages {
@@ -377,69 +449,63 @@ func (h *HiClient) send(
 		}
 	}()
 	if synchronous {
-		h.actuallySend(ctx, room, dbEvt, evtType, true)
+		h.actuallySend(ctx, room, dbEvt, evtType)
 	} else {
-		go h.actuallySend(ctx, room, dbEvt, evtType, false)
+		h.WakeupOutbox()
 	}
 	return dbEvt, nil
 }
 
-func (h *HiClient) actuallySend(ctx context.Context, room *database.Room, dbEvt *database.Event, evtType event.Type, synchronous bool) {
-	var err error
-	defer func() {
-		if dbEvt.SendError != "" {
-			err2 := h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
-			if err2 != nil {
-				zerolog.Ctx(ctx).Err(err2).AnErr("send_error", err).
-					Msg("Failed to update send error in database after sending failed")
-			}
-		}
-		if !synchronous {
-			h.EventHandler(&jsoncmd.SendComplete{
-				Event: dbEvt,
-				Error: err,
-			})
+// actuallySend sends an event immediately without going through the outbox. This is only used for synchronous sends,
+// where the caller wants to know the result right away.
+func (h *HiClient) actuallySend(ctx context.Context, room *database.Room, dbEvt *database.Event, evtType event.Type) {
+	err := h.sendEventToServer(ctx, room, dbEvt, evtType)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to send event")
+		dbEvt.SendError = err.Error()
+		err = h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to update send error in database after sending failed")
 		}
-	}()
+		return
+	}
+	dbEvt.SendError = ""
+	err = h.DB.Event.UpdateID(ctx, dbEvt.RowID, dbEvt.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to update event ID in database")
+	}
+}
+
+// sendEventToServer encrypts the given local echo if necessary and sends it to the server.
+// The event ID is set on the event, but not saved to the database. If encryption succeeds, the encrypted content
+// is saved, so that retries reuse the same ciphertext.
+func (h *HiClient) sendEventToServer(ctx context.Context, room *database.Room, dbEvt *database.Event, evtType event.Type) error {
 	if dbEvt.Decrypted != nil && len(dbEvt.Content) <= 2 {
-		var encryptedContent *event.EncryptedEventContent
-		encryptedContent, err = h.Encrypt(ctx, room, evtType, dbEvt.Decrypted)
+		encryptedContent, err := h.Encrypt(ctx, room, evtType, dbEvt.Decrypted)
 		if err != nil {
-			dbEvt.SendError = fmt.Sprintf("failed to encrypt: %v", err)
-			zerolog.Ctx(ctx).Err(err).Msg("Failed to encrypt event")
-			return
+			return fmt.Errorf("failed to encrypt: %w", err)
 		}
 		evtType = event.EventEncrypted
 		dbEvt.MegolmSessionID = encryptedContent.SessionID
 		dbEvt.Content, err = json.Marshal(encryptedContent)
 		if err != nil {
-			dbEvt.SendError = fmt.Sprintf("failed to marshal encrypted content: %v", err)
-			zerolog.Ctx(ctx).Err(err).Msg("Failed to marshal encrypted content")
-			return
+			return fmt.Errorf("failed to marshal encrypted content: %w", err)
 		}
 		err = h.DB.Event.UpdateEncryptedContent(ctx, dbEvt)
 		if err != nil {
-			dbEvt.SendError = fmt.Sprintf("failed to save event after encryption: %v", err)
-			zerolog.Ctx(ctx).Err(err).Msg("Failed to save event after encryption")
-			return
+			return fmt.Errorf("failed to save event after encryption: %w", err)
 		}
 	}
-	var resp *mautrix.RespSendEvent
-	resp, err = h.Client.SendMessageEvent(ctx, room.ID, evtType, dbEvt.Content, mautrix.ReqSendEvent{
+	resp, err := h.Client.SendMessageEvent(ctx, room.ID, evtType, dbEvt.Content, mautrix.ReqSendEvent{
 		Timestamp:     dbEvt.Timestamp.UnixMilli(),
 		TransactionID: dbEvt.TransactionID,
 		DontEncrypt:   true,
 	})
 	if err != nil {
-		dbEvt.SendError = err.Error()
-		err = fmt.Errorf("failed to send event: %w", err)
-		return
+		return fmt.Errorf("failed to send event: %w", err)
 	}
 	dbEvt.ID = resp.EventID
-	err = h.DB.Event.UpdateID(ctx, dbEvt.RowID, dbEvt.ID)
-	if err != nil {
-		err = fmt.Errorf("failed to update event ID in database: %w", err)
-	}
+	return nil
 }
 
 func (h *HiClient) Encrypt(ctx context.Context, room *database.Room, evtType event.Type, content any) (encrypted *event.EncryptedEventContent, err error) {
@@ -0,0 +1,404 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	slidingSyncConnID       = "gomuks"
+	slidingSyncListName     = "all"
+	slidingSyncTimeout      = 30 * time.Second
+	slidingSyncBatchSize    = 100
+	slidingSyncTimelineSize = 20
+	// Restarting the connection after M_UNKNOWN_POS is free the first time, but repeated expiries are backed off,
+	// so that a misbehaving server isn't hammered with initial sync requests.
+	slidingSyncInitialRestartDelay = 1 * time.Second
+	slidingSyncMaxRestartDelay     = 1 * time.Minute
+	// slidingSyncTokenPrefix is prepended to the next batch token stored in the account table when using
+	// sliding sync, so that switching between sync backends doesn't send incompatible tokens to the server.
+	slidingSyncTokenPrefix = "msc4186:"
+)
+
+var MUnknownPos = mautrix.RespError{ErrCode: "M_UNKNOWN_POS"}
+
+var slidingSyncRequiredState = [][2]string{
+	{event.StateCreate.Type, ""},
+	{event.StateRoomName.Type, ""},
+	{event.StateRoomAvatar.Type, ""},
+	{event.StateTopic.Type, ""},
+	{event.StateCanonicalAlias.Type, ""},
+	{event.StateJoinRules.Type, ""},
+	{event.StateHistoryVisibility.Type, ""},
+	{event.StateGuestAccess.Type, ""},
+	{event.StatePowerLevels.Type, ""},
+	{event.StateEncryption.Type, ""},
+	{event.StateTombstone.Type, ""},
+	{event.StatePinnedEvents.Type, ""},
+	{event.StateSpaceParent.Type, "*"},
+	{event.StateSpaceChild.Type, "*"},
+	{event.StateMember.Type, "$LAZY"},
+	{event.StateMember.Type, "$ME"},
+}
+
+type slidingSyncList struct {
+	Ranges        [][2]int    `json:"ranges"`
+	RequiredState [][2]string `json:"required_state"`
+	TimelineLimit int         `json:"timeline_limit"`
+}
+
+type slidingSyncExtension struct {
+	Enabled bool     `json:"enabled"`
+	Since   string   `json:"since,omitempty"`
+	Lists   []string `json:"lists,omitempty"`
+	Rooms   []string `json:"rooms,omitempty"`
+}
+
+type slidingSyncRequest struct {
+	ConnID     string                           `json:"conn_id"`
+	Lists      map[string]*slidingSyncList      `json:"lists"`
+	Extensions map[string]*slidingSyncExtension `json:"extensions"`
+}
+
+type slidingSyncHero struct {
+	UserID id.UserID `json:"user_id"`
+}
+
+type slidingSyncRoom struct {
+	Heroes        []slidingSyncHero `json:"heroes,omitempty"`
+	Initial       bool              `json:"initial,omitempty"`
+	RequiredState []*event.Event    `json:"required_state,omitempty"`
+	Timeline      []*event.Event    `json:"timeline,omitempty"`
+	PrevBatch     string            `json:"prev_batch,omitempty"`
+	Limited       bool              `json:"limited,omitempty"`
+	JoinedCount   *int              `json:"joined_count,omitempty"`
+	InvitedCount  *int              `json:"invited_count,omitempty"`
+	InviteState   []*event.Event    `json:"invite_state,omitempty"`
+}
+
+type slidingSyncResponse struct {
+	Pos   string `json:"pos"`
+	Lists map[string]struct {
+		Count int `json:"count"`
+	} `json:"lists"`
+	Rooms      map[id.RoomID]*slidingSyncRoom `json:"rooms"`
+	Extensions struct {
+		ToDevice *struct {
+			NextBatch string         `json:"next_batch"`
+			Events    []*event.Event `json:"events"`
+		} `json:"to_device"`
+		E2EE *struct {
+			DeviceLists                  mautrix.DeviceLists `json:"device_lists"`
+			DeviceOneTimeKeysCount       mautrix.OTKCount    `json:"device_one_time_keys_count"`
+			DeviceUnusedFallbackKeyTypes []id.KeyAlgorithm   `json:"device_unused_fallback_key_types"`
+		} `json:"e2ee"`
+		AccountData *struct {
+			Global []*event.Event               `json:"global"`
+			Rooms  map[id.RoomID][]*event.Event `json:"rooms"`
+		} `json:"account_data"`
+		Receipts *struct {
+			Rooms map[id.RoomID]*event.Event `json:"rooms"`
+		} `json:"receipts"`
+		Typing *struct {
+			Rooms map[id.RoomID]*event.Event `json:"rooms"`
+		} `json:"typing"`
+	} `json:"extensions"`
+}
+
+type slidingSyncState struct {
+	pos           string
+	toDeviceSince string
+	listSize      int
+}
+
+func parseSlidingSyncToken(token string) (pos, toDeviceSince string) {
+	if !strings.HasPrefix(token, slidingSyncTokenPrefix) {
+		return "", ""
+	}
+	values, _ := url.ParseQuery(strings.TrimPrefix(token, slidingSyncTokenPrefix))
+	return values.Get("pos"), values.Get("to_device")
+}
+
+func makeSlidingSyncToken(pos, toDeviceSince string) string {
+	return slidingSyncTokenPrefix + url.Values{"pos": {pos}, "to_device": {toDeviceSince}}.Encode()
+}
+
+// getSlidingSyncRestartDelay returns how long to wait before restarting the connection after the given number
+// of consecutive M_UNKNOWN_POS errors.
+func getSlidingSyncRestartDelay(restarts int) time.Duration {
+	if restarts <= 1 {
+		return 0
+	}
+	delay := slidingSyncInitialRestartDelay << (restarts - 2)
+	if delay <= 0 || delay > slidingSyncMaxRestartDelay {
+		return slidingSyncMaxRestartDelay
+	}
+	return delay
+}
+
+func (h *HiClient) makeSlidingSyncRequest(state *slidingSyncState) *slidingSyncRequest {
+	req := &slidingSyncRequest{
+		ConnID: slidingSyncConnID,
+		Lists:  map[string]*slidingSyncList{},
+		Extensions: map[string]*slidingSyncExtension{
+			"to_device": {Enabled: true, Since: state.toDeviceSince},
+			"e2ee":      {Enabled: true},
+		},
+	}
+	// Like the classic sync filter, rooms are only requested after the device is verified
+	if h.Verified {
+		req.Lists[slidingSyncListName] = &slidingSyncList{
+			Ranges:        [][2]int{{0, state.listSize - 1}},
+			RequiredState: slidingSyncRequiredState,
+			TimelineLimit: slidingSyncTimelineSize,
+		}
+		allLists := []string{"*"}
+		allRooms := []string{"*"}
+		req.Extensions["account_data"] = &slidingSyncExtension{Enabled: true, Lists: allLists, Rooms: allRooms}
+		req.Extensions["receipts"] = &slidingSyncExtension{Enabled: true, Lists: allLists, Rooms: allRooms}
+		req.Extensions["typing"] = &slidingSyncExtension{Enabled: true, Lists: allLists, Rooms: allRooms}
+	}
+	return req
+}
+
+func (h *HiClient) doSlidingSyncRequest(ctx context.Context, state *slidingSyncState) (*slidingSyncResponse, error) {
+	query := map[string]string{}
+	if state.pos != "" {
+		query["pos"] = state.pos
+		query["timeout"] = strconv.FormatInt(slidingSyncTimeout.Milliseconds(), 10)
+	}
+	reqURL := h.Client.BuildURLWithQuery(mautrix.ClientURLPath{"unstable", "org.matrix.simplified_msc3575", "sync"}, query)
+	var resp slidingSyncResponse
+	_, err := h.Client.MakeRequest(mautrix.WithMaxRetries(ctx, 0), http.MethodPost, reqURL, h.makeSlidingSyncRequest(state), &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// syncSliding is the equivalent of mautrix.Client.SyncWithContext for simplified sliding sync (MSC4186).
+// The responses are converted into classic sync responses and processed using the same code as normal syncs.
+func (h *HiClient) syncSliding(ctx context.Context) error {
+	log := zerolog.Ctx(ctx)
+	syncer := (*hiSyncer)(h)
+	state := &slidingSyncState{listSize: slidingSyncBatchSize}
+	state.pos, state.toDeviceSince = parseSlidingSyncToken(h.Account.NextBatch)
+	// since is the token of the last processed response. It's kept when the connection is restarted,
+	// so that the first response of the new connection isn't processed like an initial sync.
+	var since string
+	if state.pos != "" {
+		since = makeSlidingSyncToken(state.pos, state.toDeviceSince)
+	}
+	restarts := 0
+	for ctx.Err() == nil {
+		resp, err := h.doSlidingSyncRequest(ctx, state)
+		if ctx.Err() != nil {
+			return nil
+		} else if errors.Is(err, MUnknownPos) {
+			restarts++
+			delay := getSlidingSyncRestartDelay(restarts)
+			log.Warn().Int("restarts", restarts).Stringer("delay", delay).Msg("Sliding sync position expired, restarting sync connection")
+			state.pos = ""
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		} else if err != nil {
+			delay, _ := syncer.OnFailedSync(nil, err)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		restarts = 0
+		resentRooms, err := h.getResentSlidingSyncRooms(ctx, resp)
+		if err != nil {
+			return err
+		}
+		converted := h.convertSlidingSyncResponse(resp, state)
+		err = syncer.ProcessResponse(ctx, converted, since)
+		if err != nil {
+			return err
+		}
+		if len(resentRooms) > 0 {
+			go h.refetchResentRoomState(ctx, resentRooms)
+		}
+		since = converted.NextBatch
+		state.pos = resp.Pos
+		if resp.Extensions.ToDevice != nil {
+			state.toDeviceSince = resp.Extensions.ToDevice.NextBatch
+		}
+		if list, ok := resp.Lists[slidingSyncListName]; ok && list.Count > state.listSize {
+			state.listSize = min(list.Count, state.listSize+slidingSyncBatchSize)
+		}
+	}
+	return nil
+}
+
+// getResentSlidingSyncRooms returns the rooms which the server sent from scratch even though they're already
+// in the database, which happens when the sync connection is restarted. Any state changes that happened while
+// the connection was down and aren't covered by the required state are missing from the database.
+func (h *HiClient) getResentSlidingSyncRooms(ctx context.Context, resp *slidingSyncResponse) ([]id.RoomID, error) {
+	var roomIDs []id.RoomID
+	for roomID, room := range resp.Rooms {
+		if !room.Initial || len(room.InviteState) > 0 {
+			continue
+		}
+		existing, err := h.DB.Room.Get(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if %s exists: %w", roomID, err)
+		} else if existing != nil {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	return roomIDs, nil
+}
+
+// refetchResentRoomState replaces the stored state of rooms that were resent from scratch with the full state
+// from the server, the same way as when the frontend requests a state refetch.
+func (h *HiClient) refetchResentRoomState(ctx context.Context, roomIDs []id.RoomID) {
+	for _, roomID := range roomIDs {
+		err := h.processGetRoomState(ctx, roomID, false, true, true)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to refetch state of resent room")
+		}
+	}
+}
+
+func (h *HiClient) getSlidingSyncOwnMembership(room *slidingSyncRoom) event.Membership {
+	var membership event.Membership
+	checkEvents := func(evts []*event.Event) {
+		for _, evt := range evts {
+			if evt.Type == event.StateMember && evt.GetStateKey() == h.Account.UserID.String() {
+				membership = event.Membership(gjson.GetBytes(evt.Content.VeryRaw, "membership").Str)
+			}
+		}
+	}
+	checkEvents(room.RequiredState)
+	checkEvents(room.Timeline)
+	return membership
+}
+
+func (h *HiClient) convertSlidingSyncResponse(resp *slidingSyncResponse, state *slidingSyncState) *mautrix.RespSync {
+	nextToDevice := state.toDeviceSince
+	converted := &mautrix.RespSync{
+		Rooms: mautrix.RespSyncRooms{
+			Join:   make(map[id.RoomID]*mautrix.SyncJoinedRoom),
+			Invite: make(map[id.RoomID]*mautrix.SyncInvitedRoom),
+			Leave:  make(map[id.RoomID]*mautrix.SyncLeftRoom),
+		},
+	}
+	ext := &resp.Extensions
+	if ext.ToDevice != nil {
+		converted.ToDevice.Events = ext.ToDevice.Events
+		nextToDevice = ext.ToDevice.NextBatch
+	}
+	if ext.E2EE != nil {
+		converted.DeviceLists = ext.E2EE.DeviceLists
+		converted.DeviceOTKCount = ext.E2EE.DeviceOneTimeKeysCount
+		converted.FallbackKeys = ext.E2EE.DeviceUnusedFallbackKeyTypes
+	}
+	if ext.AccountData != nil {
+		converted.AccountData.Events = ext.AccountData.Global
+	}
+	getJoinedRoom := func(roomID id.RoomID) *mautrix.SyncJoinedRoom {
+		room, ok := converted.Rooms.Join[roomID]
+		if !ok {
+			room = &mautrix.SyncJoinedRoom{}
+			converted.Rooms.Join[roomID] = room
+		}
+		return room
+	}
+	for roomID, room := range resp.Rooms {
+		if len(room.InviteState) > 0 {
+			converted.Rooms.Invite[roomID] = &mautrix.SyncInvitedRoom{
+				State: mautrix.SyncEventsList{Events: room.InviteState},
+			}
+			continue
+		}
+		switch h.getSlidingSyncOwnMembership(room) {
+		case event.MembershipLeave, event.MembershipBan:
+			converted.Rooms.Leave[roomID] = &mautrix.SyncLeftRoom{
+				Timeline: mautrix.SyncTimeline{SyncEventsList: mautrix.SyncEventsList{Events: room.Timeline}},
+			}
+			continue
+		}
+		for _, evt := range room.RequiredState {
+			evt.RoomID = roomID
+		}
+		for _, evt := range room.Timeline {
+			evt.RoomID = roomID
+		}
+		joinedRoom := getJoinedRoom(roomID)
+		joinedRoom.State.Events = room.RequiredState
+		joinedRoom.Timeline = mautrix.SyncTimeline{
+			SyncEventsList: mautrix.SyncEventsList{Events: room.Timeline},
+			Limited:        room.Limited,
+			PrevBatch:      room.PrevBatch,
+		}
+		if room.Heroes != nil || room.JoinedCount != nil || room.InvitedCount != nil {
+			heroes := make([]id.UserID, 0, len(room.Heroes))
+			for _, hero := range room.Heroes {
+				if hero.UserID != h.Account.UserID {
+					heroes = append(heroes, hero.UserID)
+				}
+			}
+			joinedRoom.Summary = mautrix.LazyLoadSummary{
+				Heroes:             heroes,
+				JoinedMemberCount:  room.JoinedCount,
+				InvitedMemberCount: room.InvitedCount,
+			}
+		}
+	}
+	if ext.AccountData != nil {
+		for roomID, evts := range ext.AccountData.Rooms {
+			if _, left := converted.Rooms.Leave[roomID]; !left {
+				getJoinedRoom(roomID).AccountData.Events = evts
+			}
+		}
+	}
+	if ext.Receipts != nil {
+		for roomID, evt := range ext.Receipts.Rooms {
+			if _, left := converted.Rooms.Leave[roomID]; !left && evt != nil {
+				evt.Type = event.EphemeralEventReceipt
+				room := getJoinedRoom(roomID)
+				room.Ephemeral.Events = append(room.Ephemeral.Events, evt)
+			}
+		}
+	}
+	if ext.Typing != nil {
+		for roomID, evt := range ext.Typing.Rooms {
+			if _, left := converted.Rooms.Leave[roomID]; !left && evt != nil {
+				evt.Type = event.EphemeralEventTyping
+				room := getJoinedRoom(roomID)
+				room.Ephemeral.Events = append(room.Ephemeral.Events, evt)
+			}
+		}
+	}
+	converted.NextBatch = makeSlidingSyncToken(resp.Pos, nextToDevice)
+	return converted
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const testSlidingSyncPath = "/_matrix/client/unstable/org.matrix.simplified_msc3575/sync"
+
+const testSlidingSyncResponse = `{
+	"pos": "2",
+	"lists": {"all": {"count": 150}},
+	"rooms": {
+		"!room:example.com": {
+			"initial": true,
+			"heroes": [{"user_id": "@me:example.com"}, {"user_id": "@alice:example.com"}],
+			"joined_count": 2,
+			"required_state": [
+				{"type": "m.room.member", "state_key": "@me:example.com", "sender": "@me:example.com", "event_id": "$member", "content": {"membership": "join"}}
+			],
+			"timeline": [
+				{"type": "m.room.message", "sender": "@alice:example.com", "event_id": "$msg", "content": {"msgtype": "m.text", "body": "hi"}}
+			],
+			"prev_batch": "prev"
+		},
+		"!left:example.com": {
+			"timeline": [
+				{"type": "m.room.member", "state_key": "@me:example.com", "sender": "@me:example.com", "event_id": "$leave", "content": {"membership": "leave"}}
+			]
+		}
+	},
+	"extensions": {
+		"to_device": {"next_batch": "td2", "events": []},
+		"receipts": {"rooms": {"!room:example.com": {"content": {}}}}
+	}
+}`
+
+func newTestSlidingSyncClient(t *testing.T, handler http.HandlerFunc) *HiClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@me:example.com", "token")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return &HiClient{
+		Account:  &database.Account{UserID: "@me:example.com"},
+		Client:   cli,
+		Verified: true,
+	}
+}
+
+func TestDoSlidingSyncRequest_UnknownPos(t *testing.T) {
+	h := newTestSlidingSyncClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != testSlidingSyncPath {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		} else if pos := r.URL.Query().Get("pos"); pos != "expired" {
+			t.Errorf("unexpected pos %q", pos)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"errcode": "M_UNKNOWN_POS", "error": "Unknown position"}`))
+	})
+	_, err := h.doSlidingSyncRequest(context.Background(), &slidingSyncState{pos: "expired", listSize: slidingSyncBatchSize})
+	if !errors.Is(err, MUnknownPos) {
+		t.Errorf("expected M_UNKNOWN_POS, got %v", err)
+	}
+}
+
+func TestDoSlidingSyncRequest(t *testing.T) {
+	h := newTestSlidingSyncClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("pos") || r.URL.Query().Has("timeout") {
+			t.Errorf("unexpected query %s for initial request", r.URL.RawQuery)
+		}
+		var req slidingSyncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		} else if list := req.Lists[slidingSyncListName]; list == nil || list.Ranges[0] != [2]int{0, slidingSyncBatchSize - 1} {
+			t.Errorf("unexpected lists in request %+v", req.Lists)
+		} else if req.Extensions["to_device"].Since != "td1" {
+			t.Errorf("unexpected to-device since %q", req.Extensions["to_device"].Since)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(testSlidingSyncResponse))
+	})
+	state := &slidingSyncState{toDeviceSince: "td1", listSize: slidingSyncBatchSize}
+	resp, err := h.doSlidingSyncRequest(context.Background(), state)
+	if err != nil {
+		t.Fatalf("sliding sync request failed: %v", err)
+	}
+	converted := h.convertSlidingSyncResponse(resp, state)
+	if converted.NextBatch != makeSlidingSyncToken("2", "td2") {
+		t.Errorf("unexpected next batch %s", converted.NextBatch)
+	}
+	room := converted.Rooms.Join["!room:example.com"]
+	if room == nil {
+		t.Fatal("joined room missing from converted response")
+	} else if room.Timeline.Limited || room.Timeline.PrevBatch != "prev" {
+		t.Errorf("unexpected timeline %+v", room.Timeline)
+	} else if len(room.Summary.Heroes) != 1 || room.Summary.Heroes[0] != "@alice:example.com" {
+		t.Errorf("unexpected heroes %v", room.Summary.Heroes)
+	} else if room.Timeline.Events[0].RoomID != "!room:example.com" {
+		t.Errorf("room ID not set in timeline events")
+	} else if len(room.Ephemeral.Events) != 1 || room.Ephemeral.Events[0].Type != event.EphemeralEventReceipt {
+		t.Errorf("unexpected ephemeral events %v", room.Ephemeral.Events)
+	}
+	if _, ok := converted.Rooms.Leave[id.RoomID("!left:example.com")]; !ok {
+		t.Errorf("left room missing from converted response")
+	} else if _, ok = converted.Rooms.Join[id.RoomID("!left:example.com")]; ok {
+		t.Errorf("left room included in joined rooms")
+	}
+}
+
+func TestSlidingSyncToken(t *testing.T) {
+	pos, toDevice := parseSlidingSyncToken(makeSlidingSyncToken("12&3", "td/4"))
+	if pos != "12&3" || toDevice != "td/4" {
+		t.Errorf("unexpected parsed token %q %q", pos, toDevice)
+	}
+	if pos, toDevice = parseSlidingSyncToken("s123_456"); pos != "" || toDevice != "" {
+		t.Errorf("classic sync token parsed as sliding sync token")
+	}
+}
+
+func TestGetSlidingSyncRestartDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:   0,
+		2:   slidingSyncInitialRestartDelay,
+		3:   2 * slidingSyncInitialRestartDelay,
+		100: slidingSyncMaxRestartDelay,
+	}
+	for restarts, expected := range tests {
+		if delay := getSlidingSyncRestartDelay(restarts); delay != expected {
+			t.Errorf("unexpected delay %s after %d restarts, expected %s", delay, restarts, expected)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+// GetRoomStats calculates activity statistics for a room from the events stored in the local database.
+func (h *HiClient) GetRoomStats(ctx context.Context, roomID id.RoomID, since, until time.Time) (*database.RoomStats, error) {
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room from database: %w", err)
+	} else if room == nil {
+		return nil, fmt.Errorf("unknown room %s", roomID)
+	}
+	stats, err := h.DB.Event.GetRoomStats(ctx, roomID, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate room stats: %w", err)
+	}
+	stats.HistoryComplete = room.PrevBatch == database.PrevBatchPaginationComplete
+	return stats, nil
+}
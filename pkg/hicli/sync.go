@@ -10,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"slices"
 	"strings"
@@ -37,7 +38,8 @@ import (
 type syncContext struct {
 	shouldWakeupRequestQueue bool
 
-	evt *jsoncmd.SyncComplete
+	evt      *jsoncmd.SyncComplete
+	presence []*database.Presence
 }
 
 func (h *HiClient) markSyncErrored(err error, permanent bool) {
@@ -85,7 +87,13 @@ func (h *HiClient) preProcessSyncResponse(ctx context.Context, resp *mautrix.Res
 		switch content := evt.Content.Parsed.(type) {
 		case *event.EncryptedEventContent:
 			unhandledDecrypted := h.Crypto.HandleEncryptedEvent(ctx, evt)
-			if unhandledDecrypted != nil && listenToDevice {
+			if unhandledDecrypted != nil && isVerificationEventType(unhandledDecrypted.Type) {
+				postponedToDevices = append(postponedToDevices, &event.Event{
+					Sender:  evt.Sender,
+					Type:    event.Type{Type: unhandledDecrypted.Type.Type, Class: event.ToDeviceEventType},
+					Content: unhandledDecrypted.Content,
+				})
+			} else if unhandledDecrypted != nil && listenToDevice {
 				syncTD = append(syncTD, &jsoncmd.SyncToDevice{
 					Sender:    evt.Sender,
 					Type:      unhandledDecrypted.Type,
@@ -103,7 +111,9 @@ func (h *HiClient) preProcessSyncResponse(ctx context.Context, resp *mautrix.Res
 		case *event.SecretRequestEventContent, *event.RoomKeyRequestEventContent:
 			postponedToDevices = append(postponedToDevices, evt)
 		default:
-			if listenToDevice {
+			if isVerificationEventType(evt.Type) {
+				postponedToDevices = append(postponedToDevices, evt)
+			} else if listenToDevice {
 				syncTD = append(syncTD, &jsoncmd.SyncToDevice{
 					Sender:  evt.Sender,
 					Type:    evt.Type,
@@ -161,6 +171,12 @@ func (h *HiClient) maybeDiscardOutboundSession(ctx context.Context, newMembershi
 
 func (h *HiClient) postProcessSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string) {
 	h.Crypto.HandleOTKCounts(ctx, &resp.DeviceOTKCount)
+	for _, evt := range resp.ToDevice.Events {
+		// Verification events are handled synchronously to ensure they're processed in order
+		if isVerificationEventType(evt.Type) {
+			h.dispatchSyncerEvent(ctx, evt)
+		}
+	}
 	go h.asyncPostProcessSyncResponse(ctx, resp, since)
 	syncCtx := ctx.Value(syncContextKey).(*syncContext)
 	if syncCtx.shouldWakeupRequestQueue {
@@ -174,6 +190,9 @@ func (h *HiClient) postProcessSyncResponse(ctx context.Context, resp *mautrix.Re
 	if !syncCtx.evt.IsEmpty() {
 		h.EventHandler(syncCtx.evt)
 	}
+	if len(syncCtx.presence) > 0 {
+		h.EventHandler(&jsoncmd.Presence{Users: syncCtx.presence})
+	}
 }
 
 func (h *HiClient) asyncPostProcessSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string) {
@@ -218,6 +237,12 @@ func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSy
 		}
 	}
 	ctx.Value(syncContextKey).(*syncContext).evt.AccountData = accountData
+	if len(resp.Presence.Events) > 0 {
+		err = h.processSyncPresence(ctx, resp.Presence.Events)
+		if err != nil {
+			return err
+		}
+	}
 	for roomID, room := range resp.Rooms.Invite {
 		err = h.processSyncInvitedRoom(ctx, roomID, room)
 		if err != nil {
@@ -244,6 +269,42 @@ func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSy
 	return nil
 }
 
+func (h *HiClient) processSyncPresence(ctx context.Context, events []*event.Event) error {
+	now := time.Now()
+	presences := make([]*database.Presence, 0, len(events))
+	for _, evt := range events {
+		evt.Type.Class = event.EphemeralEventType
+		err := evt.Content.ParseRaw(evt.Type)
+		if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			zerolog.Ctx(ctx).Warn().Err(err).
+				Stringer("sender", evt.Sender).
+				Msg("Failed to parse presence event, skipping")
+			continue
+		}
+		content, ok := evt.Content.Parsed.(*event.PresenceEventContent)
+		if !ok {
+			continue
+		}
+		presence := &database.Presence{
+			UserID:          evt.Sender,
+			Presence:        content.Presence,
+			StatusMsg:       content.StatusMessage,
+			CurrentlyActive: content.CurrentlyActive,
+			UpdatedAt:       jsontime.UM(now),
+		}
+		if content.LastActiveAgo > 0 {
+			presence.LastActiveTS = jsontime.UM(now.Add(-time.Duration(content.LastActiveAgo) * time.Millisecond))
+		}
+		err = h.DB.Presence.Put(ctx, presence)
+		if err != nil {
+			return fmt.Errorf("failed to save presence of %s: %w", evt.Sender, err)
+		}
+		presences = append(presences, presence)
+	}
+	ctx.Value(syncContextKey).(*syncContext).presence = presences
+	return nil
+}
+
 func (h *HiClient) receiptsToList(content *event.ReceiptEventContent) ([]*database.Receipt, []id.EventID) {
 	receiptList := make([]*database.Receipt, 0)
 	var newOwnReceipts []id.EventID
@@ -548,7 +609,7 @@ func (h *HiClient) calculateLocalContent(ctx context.Context, dbEvt *database.Ev
 		var inlineImages []id.ContentURI
 		if content.Format == event.FormatHTML && content.FormattedBody != "" {
 			var err error
-			sanitizedHTML, inlineImages, err = sanitizeAndLinkifyHTML(content.FormattedBody)
+			sanitizedHTML, inlineImages, err = sanitizeAndLinkifyHTML(content.FormattedBody, h.imgSrcTemplate())
 			if err != nil {
 				zerolog.Ctx(ctx).Warn().Err(err).
 					Stringer("event_id", dbEvt.ID).
@@ -582,7 +643,7 @@ func (h *HiClient) calculateLocalContent(ctx context.Context, dbEvt *database.Ev
 			if hasSpecialCharacters {
 				var builder strings.Builder
 				builder.Grow(len(content.Body) + builderPreallocBuffer)
-				linkifyAndWriteBytes(&builder, []byte(content.Body))
+				linkifyAndWriteBytes(&builder, []byte(content.Body), h.imgSrcTemplate())
 				sanitizedHTML = builder.String()
 			} else if len(content.Body) < 100 && emojirunes.IsOnlyEmojis(content.Body) {
 				bigEmoji = true
@@ -731,6 +792,18 @@ func (h *HiClient) processEvent(
 	return dbEvt, err
 }
 
+// saveDecryptionQueue stores the key requests collected by processEvent for events that couldn't be decrypted.
+// It returns true if there were any requests, in which case the request queue should be woken up.
+func (h *HiClient) saveDecryptionQueue(ctx context.Context, decryptionQueue map[id.SessionID]*database.SessionRequest) (bool, error) {
+	for _, entry := range decryptionQueue {
+		err := h.DB.SessionRequest.Put(ctx, entry)
+		if err != nil {
+			return false, fmt.Errorf("failed to save session request for %s: %w", entry.SessionID, err)
+		}
+	}
+	return len(decryptionQueue) > 0, nil
+}
+
 var unsetSortingTimestamp = time.UnixMilli(1000000000000)
 
 func (h *HiClient) processStateAndTimeline(
@@ -765,8 +838,11 @@ func (h *HiClient) processStateAndTimeline(
 	allNewEvents := make([]*database.Event, 0, len(state.Events)+len(timeline.Events))
 	addedEvents := make(map[database.EventRowID]struct{})
 	newNotifications := make([]jsoncmd.SyncNotification, 0)
-	var recalculatePreviewEvent, unreadMessagesWereMaybeRedacted bool
+	var recalculatePreviewEvent, unreadMessagesWereMaybeRedacted, recalculateThreadUnreads bool
 	var newUnreadCounts database.UnreadCounts
+	changedThreads := make(map[id.EventID]struct{})
+	changedPolls := make(map[id.EventID]struct{})
+	changedBeacons := make(map[id.EventID]struct{})
 	addOldEvent := func(rowID database.EventRowID, evtID id.EventID) (dbEvt *database.Event, err error) {
 		if rowID != 0 {
 			dbEvt, err = h.DB.Event.GetByRowID(ctx, rowID)
@@ -796,6 +872,12 @@ func (h *HiClient) processStateAndTimeline(
 		if dbEvt.UnreadType > 0 {
 			unreadMessagesWereMaybeRedacted = true
 		}
+		if dbEvt.RelationType == event.RelThread && dbEvt.RelatesTo != "" {
+			changedThreads[dbEvt.RelatesTo] = struct{}{}
+		}
+		if dbEvt.IsPollRelation() {
+			changedPolls[dbEvt.RelatesTo] = struct{}{}
+		}
 		if dbEvt.RelationType == event.RelReplace || dbEvt.RelationType == event.RelAnnotation {
 			_, err = addOldEvent(0, dbEvt.RelatesTo)
 			if err != nil {
@@ -855,6 +937,27 @@ func (h *HiClient) processStateAndTimeline(
 		}
 		allNewEvents = append(allNewEvents, dbEvt)
 		addedEvents[dbEvt.RowID] = struct{}{}
+		if dbEvt.RelationType == event.RelThread && dbEvt.RelatesTo != "" {
+			changedThreads[dbEvt.RelatesTo] = struct{}{}
+			recalculateThreadUnreads = recalculateThreadUnreads || dbEvt.UnreadType > 0
+		}
+		if dbEvt.HasBundledThreadSummary() {
+			changedThreads[dbEvt.ID] = struct{}{}
+		}
+		if dbEvt.IsPollStart() {
+			changedPolls[dbEvt.ID] = struct{}{}
+		} else if dbEvt.IsPollRelation() {
+			changedPolls[dbEvt.RelatesTo] = struct{}{}
+		}
+		if !evt.Unsigned.MauSoftFailed {
+			beaconIDs, err := h.processBeaconEvent(ctx, dbEvt)
+			if err != nil {
+				return -1, err
+			}
+			for _, beaconID := range beaconIDs {
+				changedBeacons[beaconID] = struct{}{}
+			}
+		}
 		if evt.Type == event.EventRedaction && evt.Redacts != "" {
 			err = processRedaction(evt)
 			if err != nil {
@@ -899,6 +1002,7 @@ func (h *HiClient) processStateAndTimeline(
 	if len(timeline.Events) > 0 {
 		timelineIDs := make([]database.EventRowID, len(timeline.Events))
 		encounteredReceiptUsers := make(map[id.UserID]struct{})
+		encounteredOwnThreads := make(map[id.EventID]struct{})
 		readUpToIndex := -1
 		for i := len(timeline.Events) - 1; i >= 0; i-- {
 			evt := timeline.Events[i]
@@ -907,6 +1011,12 @@ func (h *HiClient) processStateAndTimeline(
 			}
 			isRead := slices.Contains(newOwnReceipts, evt.ID)
 			isOwnEvent := evt.Sender == h.Account.UserID
+			var threadRoot id.EventID
+			if evt.StateKey == nil {
+				if relatesTo, relType := database.GetRelatesToFromBytes(evt.Content.VeryRaw); relType == event.RelThread {
+					threadRoot = relatesTo
+				}
+			}
 			_, alreadyEncountered := encounteredReceiptUsers[evt.Sender]
 			if !isOwnEvent && !alreadyEncountered {
 				encounteredReceiptUsers[evt.Sender] = struct{}{}
@@ -920,7 +1030,25 @@ func (h *HiClient) processStateAndTimeline(
 				receipts = append(receipts, injectedReceipt)
 				receiptMap[evt.ID] = append(receiptMap[evt.ID], injectedReceipt)
 			}
-			if readUpToIndex == -1 && (isRead || isOwnEvent) {
+			if threadRoot != "" {
+				// Reading or sending a message in a thread doesn't mark the main timeline as read,
+				// the per-thread unread counts are recalculated after the receipts are saved instead.
+				_, threadAlreadyEncountered := encounteredOwnThreads[threadRoot]
+				if !threadAlreadyEncountered && (isRead || isOwnEvent) {
+					encounteredOwnThreads[threadRoot] = struct{}{}
+					recalculateThreadUnreads = true
+					if !isRead {
+						receipts = append(receipts, &database.Receipt{
+							RoomID:      room.ID,
+							UserID:      h.Account.UserID,
+							ReceiptType: event.ReceiptTypeRead,
+							ThreadID:    event.ThreadID(threadRoot),
+							EventID:     evt.ID,
+							Timestamp:   jsontime.UM(time.UnixMilli(evt.Timestamp)),
+						})
+					}
+				}
+			} else if readUpToIndex == -1 && (isRead || isOwnEvent) {
 				readUpToIndex = i
 				// Reset unread counts if we see our own read receipt in the timeline.
 				// It'll be updated with new unreads (if any) at the end.
@@ -954,13 +1082,9 @@ func (h *HiClient) processStateAndTimeline(
 		if updatedRoom.SortingTimestamp.Before(unsetSortingTimestamp) && len(timeline.Events) > 0 {
 			updatedRoom.SortingTimestamp = jsontime.UM(time.UnixMilli(timeline.Events[len(timeline.Events)-1].Timestamp))
 		}
-		for _, entry := range decryptionQueue {
-			err = h.DB.SessionRequest.Put(ctx, entry)
-			if err != nil {
-				return fmt.Errorf("failed to save session request for %s: %w", entry.SessionID, err)
-			}
-		}
-		if len(decryptionQueue) > 0 {
+		if wakeup, err := h.saveDecryptionQueue(ctx, decryptionQueue); err != nil {
+			return err
+		} else if wakeup {
 			ctx.Value(syncContextKey).(*syncContext).shouldWakeupRequestQueue = true
 		}
 		if timeline.Limited {
@@ -1017,10 +1141,57 @@ func (h *HiClient) processStateAndTimeline(
 			return fmt.Errorf("failed to save receipts: %w", err)
 		}
 	}
+	for _, receipt := range receipts {
+		if receipt.UserID == h.Account.UserID && receipt.ThreadID != "" {
+			recalculateThreadUnreads = true
+			break
+		}
+	}
+	for threadRoot := range changedThreads {
+		err = h.DB.Thread.Recalculate(ctx, room.ID, threadRoot, h.Account.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to update thread summary of %s: %w", threadRoot, err)
+		}
+	}
+	for pollID := range changedPolls {
+		// The poll event is included in the sync payload so that the frontend receives the new tally.
+		poll, err := addOldEvent(0, pollID)
+		if err != nil {
+			return fmt.Errorf("failed to get poll %s: %w", pollID, err)
+		} else if poll == nil {
+			continue
+		}
+		err = h.recalculatePollTally(ctx, poll)
+		if err != nil {
+			return fmt.Errorf("failed to update tally of poll %s: %w", pollID, err)
+		}
+		// addOldEvent returns a fresh copy if the poll was already added, so update the tally in the payload too
+		for _, evt := range allNewEvents {
+			if evt.RowID == poll.RowID {
+				evt.PollTally = poll.PollTally
+			}
+		}
+	}
+	// Thread unread counts are stored separately, so they have to be recalculated whenever the room's total is,
+	// otherwise redacted or read messages would stay unread in the thread list.
 	if !room.UnreadCounts.IsZero() && ((len(newOwnReceipts) > 0 && newUnreadCounts.IsZero()) || unreadMessagesWereMaybeRedacted) {
-		updatedRoom.UnreadCounts, err = h.DB.Room.CalculateUnreads(ctx, room.ID, h.Account.UserID)
+		recalculateThreadUnreads = true
+	}
+	if recalculateThreadUnreads {
+		threadUnreads, err := h.DB.Room.CalculateThreadUnreads(ctx, room.ID, h.Account.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to recalculate thread unread counts: %w", err)
+		}
+		updatedRoom.UnreadCounts = database.UnreadCounts{}
+		for _, counts := range threadUnreads {
+			updatedRoom.UnreadCounts.Add(counts)
+		}
+		threadsWithChangedUnreads, err := h.DB.Thread.SetUnreads(ctx, room.ID, threadUnreads)
 		if err != nil {
-			return fmt.Errorf("failed to recalculate unread counts: %w", err)
+			return fmt.Errorf("failed to save thread unread counts: %w", err)
+		}
+		for _, threadRoot := range threadsWithChangedUnreads {
+			changedThreads[threadRoot] = struct{}{}
 		}
 	} else {
 		updatedRoom.UnreadCounts.Add(newUnreadCounts)
@@ -1040,8 +1211,22 @@ func (h *HiClient) processStateAndTimeline(
 	if err != nil {
 		return err
 	}
+	var threads []*database.Thread
+	if len(changedThreads) > 0 {
+		threads, err = h.DB.Thread.GetMany(ctx, room.ID, slices.Collect(maps.Keys(changedThreads)))
+		if err != nil {
+			return fmt.Errorf("failed to get changed threads: %w", err)
+		}
+	}
+	var beacons []*database.Beacon
+	if len(changedBeacons) > 0 {
+		beacons, err = h.DB.Beacon.GetMany(ctx, room.ID, slices.Collect(maps.Keys(changedBeacons)))
+		if err != nil {
+			return fmt.Errorf("failed to get changed beacons: %w", err)
+		}
+	}
 	// TODO why is *old* unread count sometimes zero when processing the read receipt that is making it zero?
-	if roomChanged || len(accountData) > 0 || len(newOwnReceipts) > 0 || len(receipts) > 0 || len(timelineRowTuples) > 0 || len(allNewEvents) > 0 {
+	if roomChanged || len(accountData) > 0 || len(newOwnReceipts) > 0 || len(receipts) > 0 || len(timelineRowTuples) > 0 || len(allNewEvents) > 0 || len(threads) > 0 || len(beacons) > 0 {
 		for _, receipt := range receipts {
 			receipt.RoomID = ""
 		}
@@ -1053,6 +1238,8 @@ func (h *HiClient) processStateAndTimeline(
 			Reset:       timeline.Limited,
 			Events:      allNewEvents,
 			Receipts:    receiptMap,
+			Threads:     threads,
+			Beacons:     beacons,
 
 			Notifications:        newNotifications,
 			DismissNotifications: dismissNotifications,
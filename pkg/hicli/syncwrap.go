@@ -10,11 +10,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/gomuks/pkg/hicli/database"
@@ -23,7 +25,7 @@ import (
 
 type hiSyncer HiClient
 
-var _ mautrix.Syncer = (*hiSyncer)(nil)
+var _ mautrix.ExtensibleSyncer = (*hiSyncer)(nil)
 
 type contextKey int
 
@@ -62,9 +64,36 @@ func (h *hiSyncer) ProcessResponse(ctx context.Context, resp *mautrix.RespSync,
 	c.postProcessSyncResponse(ctx, resp, since)
 	c.syncErrors = 0
 	c.markSyncOK()
+	c.syncerHandlersLock.RLock()
+	syncHandlers := c.syncerSyncHandlers
+	c.syncerHandlersLock.RUnlock()
+	for _, handler := range syncHandlers {
+		handler(ctx, resp, since)
+	}
 	return nil
 }
 
+// OnEventType registers a handler for events received through sync. Only to-device verification events
+// are passed to handlers, as everything else is processed by hicli itself. This exists so that mautrix-go
+// helpers like the verification helper can be used.
+func (h *hiSyncer) OnEventType(eventType event.Type, callback mautrix.EventHandler) {
+	h.syncerHandlersLock.Lock()
+	h.syncerEventHandlers[eventType] = append(h.syncerEventHandlers[eventType], callback)
+	h.syncerHandlersLock.Unlock()
+}
+
+func (h *hiSyncer) OnEvent(callback mautrix.EventHandler) {
+	h.syncerHandlersLock.Lock()
+	h.syncerGlobalHandlers = append(h.syncerGlobalHandlers, callback)
+	h.syncerHandlersLock.Unlock()
+}
+
+func (h *hiSyncer) OnSync(callback mautrix.SyncHandler) {
+	h.syncerHandlersLock.Lock()
+	h.syncerSyncHandlers = append(h.syncerSyncHandlers, callback)
+	h.syncerHandlersLock.Unlock()
+}
+
 func (h *hiSyncer) OnFailedSync(_ *mautrix.RespSync, err error) (time.Duration, error) {
 	c := (*HiClient)(h)
 	c.syncErrors++
@@ -88,10 +117,14 @@ func (h *hiSyncer) GetFilterJSON(_ id.UserID) *mautrix.Filter {
 			},
 		}
 	}
-	return &mautrix.Filter{
-		Presence: &mautrix.FilterPart{
+	var presenceFilter *mautrix.FilterPart
+	if !h.EnablePresence {
+		presenceFilter = &mautrix.FilterPart{
 			NotRooms: []id.RoomID{"*"},
-		},
+		}
+	}
+	return &mautrix.Filter{
+		Presence: presenceFilter,
 		Room: &mautrix.RoomFilter{
 			State: &mautrix.FilterPart{
 				LazyLoadMembers: true,
@@ -121,6 +154,9 @@ func (h *hiStore) SaveNextBatch(ctx context.Context, userID id.UserID, nextBatch
 func (h *hiStore) LoadNextBatch(_ context.Context, userID id.UserID) (string, error) {
 	if h.Account.UserID != userID {
 		return "", fmt.Errorf("mismatching user ID")
+	} else if strings.HasPrefix(h.Account.NextBatch, slidingSyncTokenPrefix) {
+		// The previous sync was done using sliding sync, so the token can't be used for classic syncs
+		return "", nil
 	}
 	return h.Account.NextBatch, nil
 }
@@ -0,0 +1,145 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+const (
+	defaultThreadListLimit = 50
+	maxThreadListLimit     = 200
+	defaultThreadPageLimit = 50
+)
+
+// GetThreads returns the summaries of threads in a room from the local database, newest first,
+// along with the root and latest events of each thread.
+func (h *HiClient) GetThreads(ctx context.Context, roomID id.RoomID, before time.Time, onlyParticipated bool, limit int) (*jsoncmd.GetThreadsResponse, error) {
+	if limit <= 0 {
+		limit = defaultThreadListLimit
+	} else if limit > maxThreadListLimit {
+		limit = maxThreadListLimit
+	}
+	threads, err := h.DB.Thread.GetRoomThreads(ctx, roomID, before, onlyParticipated, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get threads: %w", err)
+	}
+	resp := &jsoncmd.GetThreadsResponse{
+		Threads: threads,
+		Events:  make([]*database.Event, 0, len(threads)*2),
+	}
+	if len(threads) > limit {
+		resp.Threads = threads[:limit]
+		resp.HasMore = true
+	}
+	latestRowIDs := make([]database.EventRowID, 0, len(resp.Threads))
+	for _, thread := range resp.Threads {
+		root, err := h.DB.Event.GetByID(ctx, thread.ThreadRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root event of %s: %w", thread.ThreadRoot, err)
+		} else if root != nil {
+			resp.Events = append(resp.Events, root)
+		}
+		if thread.LatestEventRowID != 0 {
+			latestRowIDs = append(latestRowIDs, thread.LatestEventRowID)
+		}
+	}
+	if len(latestRowIDs) > 0 {
+		latestEvents, err := h.DB.Event.GetByRowIDs(ctx, latestRowIDs...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get latest thread events: %w", err)
+		}
+		resp.Events = append(resp.Events, latestEvents...)
+	}
+	for _, evt := range resp.Events {
+		h.ReprocessExistingEvent(ctx, evt)
+	}
+	return resp, nil
+}
+
+// PaginateThread fetches events in a thread from the server, newest first. The root event is included
+// when paginating from the start of the thread (i.e. from is empty).
+func (h *HiClient) PaginateThread(ctx context.Context, roomID id.RoomID, threadRoot id.EventID, from string, limit int) (*jsoncmd.ThreadPaginationResponse, error) {
+	if limit <= 0 {
+		limit = defaultThreadPageLimit
+	}
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room from database: %w", err)
+	} else if room == nil {
+		return nil, fmt.Errorf("not in room %s", roomID)
+	}
+	resp, err := h.Client.GetRelations(ctx, roomID, threadRoot, &mautrix.ReqGetRelations{
+		RelationType: event.RelThread,
+		Dir:          mautrix.DirectionBackward,
+		From:         from,
+		Limit:        limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread events from server: %w", err)
+	}
+	output := &jsoncmd.ThreadPaginationResponse{
+		Events:    make([]*database.Event, 0, len(resp.Chunk)),
+		NextBatch: resp.NextBatch,
+		HasMore:   resp.NextBatch != "",
+	}
+	if from == "" {
+		output.Root, err = h.GetEvent(ctx, roomID, threadRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thread root: %w", err)
+		}
+	}
+	wakeupSessionRequests := false
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		decryptionQueue := make(map[id.SessionID]*database.SessionRequest)
+		for _, evt := range resp.Chunk {
+			evt.RoomID = roomID
+			dbEvt, err := h.processEvent(ctx, evt, room.LazyLoadSummary, decryptionQueue, true)
+			if err != nil {
+				return err
+			}
+			output.Events = append(output.Events, dbEvt)
+		}
+		wakeupSessionRequests, err = h.saveDecryptionQueue(ctx, decryptionQueue)
+		if err != nil {
+			return err
+		}
+		err = h.DB.Event.FillReactionCounts(ctx, roomID, output.Events)
+		if err != nil {
+			return fmt.Errorf("failed to fill reaction counts: %w", err)
+		}
+		err = h.DB.Event.FillLastEditRowIDs(ctx, roomID, output.Events)
+		if err != nil {
+			return fmt.Errorf("failed to fill last edit row IDs: %w", err)
+		}
+		err = h.DB.Thread.Recalculate(ctx, roomID, threadRoot, h.Account.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to update thread summary: %w", err)
+		}
+		output.Thread, err = h.DB.Thread.Get(ctx, roomID, threadRoot)
+		if err != nil {
+			return fmt.Errorf("failed to get thread summary: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if wakeupSessionRequests {
+		h.WakeupRequestQueue()
+	}
+	return output, nil
+}
@@ -0,0 +1,422 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+)
+
+const (
+	verificationMethodSAS    = "m.sas.v1"
+	verificationMethodQRShow = "m.qr_code.show.v1"
+	verificationMethodQRScan = "m.qr_code.scan.v1"
+
+	verificationCancelUser          event.VerificationCancelCode = "m.user"
+	verificationCancelMismatchedSAS event.VerificationCancelCode = "m.mismatched_sas"
+	verificationCancelTimeout       event.VerificationCancelCode = "m.timeout"
+
+	// Transactions that haven't finished within this time are cancelled, as recommended by the spec.
+	verificationTimeout = 10 * time.Minute
+)
+
+var (
+	ErrUnknownVerificationTransaction = errors.New("unknown verification transaction")
+	ErrUnexpectedVerificationState    = errors.New("verification is not in the expected state")
+)
+
+// verificationTransaction is the state of a verification that is sent to the frontend.
+// The actual verification protocol is handled by the mautrix-go verification helper.
+type verificationTransaction struct {
+	jsoncmd.Verification
+	QRCode  *verificationhelper.QRCode
+	timeout *time.Timer
+}
+
+func isVerificationEventType(evtType event.Type) bool {
+	return strings.HasPrefix(evtType.Type, "m.key.verification.")
+}
+
+func newVerificationHelper(h *HiClient) *verificationhelper.VerificationHelper {
+	return verificationhelper.NewVerificationHelper(h.Client, h.Crypto, nil, (*verificationCallbacks)(h), true, true, true)
+}
+
+// startVerificationSync starts syncing if the current device is unverified. Verification events are received
+// through sync, but unverified devices don't sync normally. The sync filter of unverified devices excludes all
+// rooms, so only to-device events are received.
+func (h *HiClient) startVerificationSync() {
+	if h.Verified || h.IsSyncing() {
+		return
+	}
+	h.Log.Info().Msg("Starting to-device-only sync for verifying the current device")
+	go h.Sync()
+}
+
+// stopVerificationSync stops the to-device-only sync if the current device is still unverified
+// and there are no ongoing verifications left. It doesn't wait for the sync loop to exit,
+// as it may be called from inside the sync loop.
+func (h *HiClient) stopVerificationSync() {
+	h.verificationsLock.Lock()
+	ongoing := len(h.verifications)
+	h.verificationsLock.Unlock()
+	if h.Verified || ongoing > 0 || !h.IsSyncing() {
+		return
+	}
+	h.Log.Info().Msg("Stopping to-device-only sync as there are no ongoing verifications")
+	h.Client.StopSync()
+	if fn := h.stopSync.Swap(nil); fn != nil {
+		(*fn)()
+	}
+}
+
+// addVerification stores a new transaction, dispatches it to the frontend and schedules it to be cancelled
+// if it doesn't finish in time.
+func (h *HiClient) addVerification(txn *verificationTransaction) {
+	txnID := id.VerificationTransactionID(txn.TransactionID)
+	h.verificationsLock.Lock()
+	if _, exists := h.verifications[txnID]; exists {
+		h.verificationsLock.Unlock()
+		return
+	}
+	h.verifications[txnID] = txn
+	txn.timeout = time.AfterFunc(verificationTimeout, func() {
+		h.expireVerification(txnID)
+	})
+	evt := txn.Verification
+	h.verificationsLock.Unlock()
+	h.EventHandler(&evt)
+}
+
+// updateVerification applies the given function to a transaction and dispatches the new state to the frontend.
+// Transactions that reach a final state are removed. If the transaction isn't known, false is returned.
+func (h *HiClient) updateVerification(txnID id.VerificationTransactionID, fn func(txn *verificationTransaction)) bool {
+	h.verificationsLock.Lock()
+	txn, ok := h.verifications[txnID]
+	if !ok {
+		h.verificationsLock.Unlock()
+		return false
+	}
+	fn(txn)
+	if txn.State == jsoncmd.VerificationStateDone || txn.State == jsoncmd.VerificationStateCancelled {
+		txn.timeout.Stop()
+		delete(h.verifications, txnID)
+	}
+	evt := txn.Verification
+	h.verificationsLock.Unlock()
+	h.EventHandler(&evt)
+	return true
+}
+
+func (h *HiClient) getVerification(txnID id.VerificationTransactionID) *verificationTransaction {
+	h.verificationsLock.Lock()
+	defer h.verificationsLock.Unlock()
+	return h.verifications[txnID]
+}
+
+func (h *HiClient) expireVerification(txnID id.VerificationTransactionID) {
+	log := h.Log.With().Str("action", "expire verification").Str("transaction_id", string(txnID)).Logger()
+	log.Debug().Msg("Cancelling verification transaction that timed out")
+	err := h.VerificationHelper.CancelVerification(log.WithContext(context.Background()), txnID, verificationCancelTimeout, "Verification timed out")
+	if err != nil {
+		log.Err(err).Msg("Failed to send cancellation of timed out verification")
+	}
+	h.markVerificationCancelled(txnID, verificationCancelTimeout, "Verification timed out")
+}
+
+func (h *HiClient) markVerificationCancelled(txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) {
+	h.updateVerification(txnID, func(txn *verificationTransaction) {
+		txn.State = jsoncmd.VerificationStateCancelled
+		txn.CancelCode = string(code)
+		txn.CancelReason = reason
+	})
+	h.stopVerificationSync()
+}
+
+func (h *HiClient) requireVerificationState(txnID string, states ...jsoncmd.VerificationState) (id.VerificationTransactionID, error) {
+	txn := h.getVerification(id.VerificationTransactionID(txnID))
+	if txn == nil {
+		return "", ErrUnknownVerificationTransaction
+	} else if !slices.Contains(states, txn.State) {
+		return "", ErrUnexpectedVerificationState
+	}
+	return id.VerificationTransactionID(txnID), nil
+}
+
+func (h *HiClient) setVerificationState(txnID id.VerificationTransactionID, state jsoncmd.VerificationState) {
+	h.updateVerification(txnID, func(txn *verificationTransaction) {
+		txn.State = state
+	})
+}
+
+// StartVerification sends a verification request to all devices of the given user.
+// The first device to accept the request will be used.
+func (h *HiClient) StartVerification(ctx context.Context, userID id.UserID) (*jsoncmd.Verification, error) {
+	if userID != h.Account.UserID && !h.Verified {
+		return nil, fmt.Errorf("can't verify other users before verifying the current device")
+	}
+	h.startVerificationSync()
+	txnID, err := h.VerificationHelper.StartVerification(ctx, userID)
+	if err != nil {
+		h.stopVerificationSync()
+		return nil, err
+	}
+	txn := &verificationTransaction{Verification: jsoncmd.Verification{
+		TransactionID: string(txnID),
+		UserID:        userID,
+		State:         jsoncmd.VerificationStateRequested,
+	}}
+	h.addVerification(txn)
+	return &txn.Verification, nil
+}
+
+// AcceptVerification accepts an incoming verification request by sending a m.key.verification.ready event.
+func (h *HiClient) AcceptVerification(ctx context.Context, txnID string) error {
+	verificationID, err := h.requireVerificationState(txnID, jsoncmd.VerificationStateRequested)
+	if err != nil {
+		return err
+	}
+	return h.VerificationHelper.AcceptVerification(ctx, verificationID)
+}
+
+// StartSAS starts emoji/decimal verification in a transaction that both sides have marked as ready.
+func (h *HiClient) StartSAS(ctx context.Context, txnID string) error {
+	verificationID, err := h.requireVerificationState(txnID, jsoncmd.VerificationStateReady)
+	if err != nil {
+		return err
+	}
+	err = h.VerificationHelper.StartSAS(ctx, verificationID)
+	if err != nil {
+		return err
+	}
+	h.setVerificationState(verificationID, jsoncmd.VerificationStateStarted)
+	return nil
+}
+
+// ConfirmSAS tells the verification helper whether the short authentication strings matched.
+// If they did, a MAC of our keys is sent to the other device, otherwise the verification is cancelled.
+func (h *HiClient) ConfirmSAS(ctx context.Context, txnID string, match bool) error {
+	verificationID, err := h.requireVerificationState(txnID, jsoncmd.VerificationStateSAS)
+	if err != nil {
+		return err
+	} else if !match {
+		return h.cancelVerification(ctx, verificationID, verificationCancelMismatchedSAS, "Short authentication strings didn't match")
+	}
+	err = h.VerificationHelper.ConfirmSAS(ctx, verificationID)
+	if err != nil {
+		return err
+	}
+	h.setVerificationState(verificationID, jsoncmd.VerificationStateConfirmed)
+	return nil
+}
+
+// GetVerificationQRCode returns the payload of a QR code that the other device can scan
+// to complete the given verification transaction.
+func (h *HiClient) GetVerificationQRCode(ctx context.Context, txnID string) (*jsoncmd.VerificationQRCode, error) {
+	txn := h.getVerification(id.VerificationTransactionID(txnID))
+	if txn == nil {
+		return nil, ErrUnknownVerificationTransaction
+	} else if txn.State != jsoncmd.VerificationStateReady {
+		return nil, ErrUnexpectedVerificationState
+	} else if txn.QRCode == nil {
+		return nil, fmt.Errorf("other device doesn't support scanning QR codes")
+	}
+	return &jsoncmd.VerificationQRCode{
+		TransactionID: txnID,
+		Data:          txn.QRCode.Bytes(),
+	}, nil
+}
+
+// ScanVerificationQRCode handles a QR code scanned from another device. The verification helper checks
+// the keys in the QR code, trusts the ones it contains and sends a m.reciprocate.v1 start event.
+func (h *HiClient) ScanVerificationQRCode(ctx context.Context, data []byte) error {
+	return h.VerificationHelper.HandleScannedQRData(ctx, data)
+}
+
+// ConfirmQRCodeScanned completes a verification where the other device scanned our QR code.
+// The user should only confirm after the other device has shown that the scan was successful.
+func (h *HiClient) ConfirmQRCodeScanned(ctx context.Context, txnID string, confirmed bool) error {
+	verificationID, err := h.requireVerificationState(txnID, jsoncmd.VerificationStateQRScanned)
+	if err != nil {
+		return err
+	} else if !confirmed {
+		return h.cancelVerification(ctx, verificationID, verificationCancelUser, "Other device didn't confirm QR code scan")
+	}
+	err = h.VerificationHelper.ConfirmQRCodeScanned(ctx, verificationID)
+	if err != nil {
+		return err
+	}
+	h.setVerificationState(verificationID, jsoncmd.VerificationStateConfirmed)
+	return nil
+}
+
+// CancelVerification cancels an ongoing verification transaction.
+func (h *HiClient) CancelVerification(ctx context.Context, txnID, reason string) error {
+	verificationID := id.VerificationTransactionID(txnID)
+	if h.getVerification(verificationID) == nil {
+		return ErrUnknownVerificationTransaction
+	} else if reason == "" {
+		reason = "User cancelled verification"
+	}
+	return h.cancelVerification(ctx, verificationID, verificationCancelUser, reason)
+}
+
+func (h *HiClient) cancelVerification(ctx context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) error {
+	err := h.VerificationHelper.CancelVerification(ctx, txnID, code, reason)
+	h.markVerificationCancelled(txnID, code, reason)
+	return err
+}
+
+// verificationCallbacks receives the progress of verifications from the mautrix-go verification helper.
+type verificationCallbacks HiClient
+
+var (
+	_ verificationhelper.RequiredCallbacks   = (*verificationCallbacks)(nil)
+	_ verificationhelper.ShowSASCallbacks    = (*verificationCallbacks)(nil)
+	_ verificationhelper.ShowQRCodeCallbacks = (*verificationCallbacks)(nil)
+)
+
+func (vc *verificationCallbacks) VerificationRequested(ctx context.Context, txnID id.VerificationTransactionID, from id.UserID, fromDevice id.DeviceID) {
+	h := (*HiClient)(vc)
+	if from != h.Account.UserID && !h.Verified {
+		zerolog.Ctx(ctx).Debug().
+			Str("transaction_id", string(txnID)).
+			Msg("Cancelling verification request from another user as current device is not verified")
+		// The helper may still be holding locks while calling the callback
+		go func() {
+			err := h.VerificationHelper.CancelVerification(context.WithoutCancel(ctx), txnID, verificationCancelUser, "Current device is not verified")
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Msg("Failed to cancel verification request")
+			}
+		}()
+		return
+	}
+	h.addVerification(&verificationTransaction{Verification: jsoncmd.Verification{
+		TransactionID: string(txnID),
+		UserID:        from,
+		DeviceID:      fromDevice,
+		Incoming:      true,
+		State:         jsoncmd.VerificationStateRequested,
+	}})
+}
+
+func (vc *verificationCallbacks) VerificationReady(ctx context.Context, txnID id.VerificationTransactionID, otherDeviceID id.DeviceID, supportsSAS, allowScanQRCode bool, qrCode *verificationhelper.QRCode) {
+	(*HiClient)(vc).updateVerification(txnID, func(txn *verificationTransaction) {
+		txn.DeviceID = otherDeviceID
+		txn.State = jsoncmd.VerificationStateReady
+		txn.QRCode = qrCode
+		txn.Methods = txn.Methods[:0]
+		if supportsSAS {
+			txn.Methods = append(txn.Methods, verificationMethodSAS)
+		}
+		if allowScanQRCode {
+			txn.Methods = append(txn.Methods, verificationMethodQRScan)
+		}
+		if qrCode != nil {
+			txn.Methods = append(txn.Methods, verificationMethodQRShow)
+		}
+	})
+}
+
+func (vc *verificationCallbacks) ShowSAS(ctx context.Context, txnID id.VerificationTransactionID, emojis []rune, emojiDescriptions []string, decimals []int) {
+	(*HiClient)(vc).updateVerification(txnID, func(txn *verificationTransaction) {
+		txn.State = jsoncmd.VerificationStateSAS
+		txn.Decimals = decimals
+		txn.Emojis = make([]jsoncmd.SASEmoji, len(emojis))
+		for i, emoji := range emojis {
+			txn.Emojis[i] = jsoncmd.SASEmoji{Emoji: string(emoji), Description: emojiDescriptions[i]}
+		}
+	})
+}
+
+func (vc *verificationCallbacks) QRCodeScanned(ctx context.Context, txnID id.VerificationTransactionID) {
+	(*HiClient)(vc).setVerificationState(txnID, jsoncmd.VerificationStateQRScanned)
+}
+
+func (vc *verificationCallbacks) VerificationCancelled(ctx context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) {
+	(*HiClient)(vc).markVerificationCancelled(txnID, code, reason)
+}
+
+func (vc *verificationCallbacks) VerificationDone(ctx context.Context, txnID id.VerificationTransactionID, method event.VerificationMethod) {
+	h := (*HiClient)(vc)
+	var userID id.UserID
+	h.updateVerification(txnID, func(txn *verificationTransaction) {
+		txn.State = jsoncmd.VerificationStateDone
+		userID = txn.UserID
+	})
+	if userID == h.Account.UserID && !h.Verified {
+		// The helper has already marked the other device or master key as trusted,
+		// so the other device will share the cross-signing and backup keys with us.
+		go h.fetchSecretsAfterVerification(context.WithoutCancel(ctx))
+	} else {
+		h.stopVerificationSync()
+	}
+}
+
+func (h *HiClient) fetchSecretsAfterVerification(ctx context.Context) {
+	err := h.requestSecretsFromVerifiedDevice(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to fetch secrets after verification")
+		h.stopVerificationSync()
+		return
+	}
+	h.dispatchCurrentState()
+}
+
+func (h *HiClient) requestSecretsFromVerifiedDevice(ctx context.Context) error {
+	secrets := []id.Secret{id.SecretXSMaster, id.SecretXSSelfSigning, id.SecretXSUserSigning, id.SecretMegolmBackupV1}
+	for _, secret := range secrets {
+		zerolog.Ctx(ctx).Debug().Str("secret", string(secret)).Msg("Requesting secret from verified device")
+		err := h.Crypto.GetOrRequestSecret(ctx, secret, func(value string) (bool, error) {
+			return true, h.CryptoStore.PutSecret(ctx, secret, value)
+		}, 1*time.Minute)
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", secret, err)
+		}
+	}
+	err := h.loadPrivateKeys(ctx)
+	if err != nil {
+		return err
+	}
+	err = h.Crypto.SignOwnDevice(ctx, h.Crypto.OwnIdentity())
+	if err != nil {
+		return fmt.Errorf("failed to sign own device: %w", err)
+	}
+	err = h.Crypto.SignOwnMasterKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to sign own master key: %w", err)
+	}
+	return h.markVerified(ctx)
+}
+
+// dispatchSyncerEvent passes an event received through sync to the handlers registered by mautrix-go helpers.
+func (h *HiClient) dispatchSyncerEvent(ctx context.Context, evt *event.Event) {
+	err := evt.Content.ParseRaw(evt.Type)
+	if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Stringer("event_type", &evt.Type).
+			Stringer("sender", evt.Sender).
+			Msg("Failed to parse event for syncer handlers")
+		return
+	}
+	h.syncerHandlersLock.RLock()
+	handlers := slices.Concat(h.syncerGlobalHandlers, h.syncerEventHandlers[evt.Type])
+	h.syncerHandlersLock.RUnlock()
+	for _, handler := range handlers {
+		handler(ctx, evt)
+	}
+}
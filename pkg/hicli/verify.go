@@ -13,6 +13,7 @@ import (
 	"fmt"
 
 	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/backup"
 	"maunium.net/go/mautrix/crypto/ssss"
@@ -20,6 +21,8 @@ import (
 	"maunium.net/go/mautrix/id"
 )
 
+var errSecretNotFound = errors.New("secret not found")
+
 func (h *HiClient) checkIsCurrentDeviceVerified(ctx context.Context) (bool, error) {
 	keys := h.Crypto.GetOwnCrossSigningPublicKeys(ctx)
 	if keys == nil {
@@ -59,7 +62,7 @@ func (h *HiClient) getAndDecodeSecret(ctx context.Context, secret id.Secret) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to get secret %s: %w", secret, err)
 	} else if secretData == "" {
-		return nil, fmt.Errorf("secret %s not found", secret)
+		return nil, fmt.Errorf("%w: %s", errSecretNotFound, secret)
 	}
 	data, err := base64.StdEncoding.DecodeString(secretData)
 	if err != nil {
@@ -70,29 +73,19 @@ func (h *HiClient) getAndDecodeSecret(ctx context.Context, secret id.Secret) ([]
 
 func (h *HiClient) loadPrivateKeys(ctx context.Context) error {
 	zerolog.Ctx(ctx).Debug().Msg("Loading cross-signing private keys")
-	masterKeySeed, err := h.getAndDecodeSecret(ctx, id.SecretXSMaster)
-	if err != nil {
-		return fmt.Errorf("failed to get master key: %w", err)
-	}
-	selfSigningKeySeed, err := h.getAndDecodeSecret(ctx, id.SecretXSSelfSigning)
-	if err != nil {
-		return fmt.Errorf("failed to get self-signing key: %w", err)
-	}
-	userSigningKeySeed, err := h.getAndDecodeSecret(ctx, id.SecretXSUserSigning)
-	if err != nil {
-		return fmt.Errorf("failed to get user signing key: %w", err)
-	}
-	err = h.Crypto.ImportCrossSigningKeys(crypto.CrossSigningSeeds{
-		MasterKey:      masterKeySeed,
-		SelfSigningKey: selfSigningKeySeed,
-		UserSigningKey: userSigningKeySeed,
-	})
+	keys, err := h.loadCrossSigningPrivateKeys(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to import cross-signing private keys: %w", err)
+		return err
+	} else if keys == nil {
+		return fmt.Errorf("%w: cross-signing private keys", errSecretNotFound)
 	}
 	zerolog.Ctx(ctx).Debug().Msg("Loading key backup key")
 	keyBackupKey, err := h.getAndDecodeSecret(ctx, id.SecretMegolmBackupV1)
-	if err != nil {
+	if errors.Is(err, errSecretNotFound) {
+		// The key backup may have been deleted, in which case there's nothing to upload keys to.
+		zerolog.Ctx(ctx).Warn().Msg("Key backup key not found, not uploading keys to backup")
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("failed to get megolm backup key: %w", err)
 	}
 	h.KeyBackupKey, err = backup.MegolmBackupKeyFromBytes(keyBackupKey)
@@ -101,7 +94,11 @@ func (h *HiClient) loadPrivateKeys(ctx context.Context) error {
 	}
 	zerolog.Ctx(ctx).Debug().Msg("Fetching key backup version")
 	latestVersion, err := h.Client.GetKeyBackupLatestVersion(ctx)
-	if err != nil {
+	if errors.Is(err, mautrix.MNotFound) {
+		zerolog.Ctx(ctx).Warn().Msg("No key backup version found on server, not uploading keys to backup")
+		h.KeyBackupKey = nil
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("failed to get key backup latest version: %w", err)
 	}
 	h.KeyBackupVersion = latestVersion.Version
@@ -109,6 +106,38 @@ func (h *HiClient) loadPrivateKeys(ctx context.Context) error {
 	return nil
 }
 
+// loadCrossSigningPrivateKeys imports the cross-signing private keys from the local crypto store.
+// If any of the keys are missing, nil is returned without an error.
+func (h *HiClient) loadCrossSigningPrivateKeys(ctx context.Context) (*crypto.CrossSigningKeysCache, error) {
+	masterKeySeed, err := h.getAndDecodeSecret(ctx, id.SecretXSMaster)
+	if errors.Is(err, errSecretNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get master key: %w", err)
+	}
+	selfSigningKeySeed, err := h.getAndDecodeSecret(ctx, id.SecretXSSelfSigning)
+	if errors.Is(err, errSecretNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get self-signing key: %w", err)
+	}
+	userSigningKeySeed, err := h.getAndDecodeSecret(ctx, id.SecretXSUserSigning)
+	if errors.Is(err, errSecretNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get user signing key: %w", err)
+	}
+	err = h.Crypto.ImportCrossSigningKeys(crypto.CrossSigningSeeds{
+		MasterKey:      masterKeySeed,
+		SelfSigningKey: selfSigningKeySeed,
+		UserSigningKey: userSigningKeySeed,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to import cross-signing private keys: %w", err)
+	}
+	return h.Crypto.CrossSigningKeys, nil
+}
+
 func (h *HiClient) storeCrossSigningPrivateKeys(ctx context.Context) error {
 	keys := h.Crypto.CrossSigningKeys
 	err := h.CryptoStore.PutSecret(ctx, id.SecretXSMaster, base64.StdEncoding.EncodeToString(keys.MasterKey.Seed()))
@@ -126,16 +155,24 @@ func (h *HiClient) storeCrossSigningPrivateKeys(ctx context.Context) error {
 	return nil
 }
 
-func (h *HiClient) Verify(ctx context.Context, code string) error {
-	defer h.dispatchCurrentState()
+func (h *HiClient) getDefaultSSSSKey(ctx context.Context, code string) (*ssss.Key, error) {
 	keyID, keyData, err := h.Crypto.SSSS.GetDefaultKeyData(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get default SSSS key data: %w", err)
+		return nil, fmt.Errorf("failed to get default SSSS key data: %w", err)
 	}
 	key, err := keyData.VerifyRecoveryKey(keyID, code)
 	if errors.Is(err, ssss.ErrInvalidRecoveryKey) && keyData.Passphrase != nil {
 		key, err = keyData.VerifyPassphrase(keyID, code)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (h *HiClient) Verify(ctx context.Context, code string) error {
+	defer h.dispatchCurrentState()
+	key, err := h.getDefaultSSSSKey(ctx, code)
 	if err != nil {
 		return err
 	}
@@ -159,9 +196,28 @@ func (h *HiClient) Verify(ctx context.Context, code string) error {
 	if err != nil {
 		return fmt.Errorf("failed to fetch key backup key: %w", err)
 	}
-	h.Verified = true
-	if !h.IsSyncing() {
-		go h.Sync()
-	}
+	return h.markVerified(ctx)
+}
+
+func (h *HiClient) markVerified(ctx context.Context) error {
+	if h.Verified {
+		if !h.IsSyncing() {
+			go h.Sync()
+		}
+		return nil
+	}
+	// Syncs made while unverified don't include any rooms, so stop syncing and start over from scratch.
+	// The previous sync loop must have fully exited before the new one is started.
+	h.stopSyncAndLock()
+	h.Account.NextBatch = ""
+	err := h.DB.Account.PutNextBatch(ctx, h.Account.UserID, "")
+	if err == nil {
+		h.Verified = true
+	}
+	h.syncLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to reset sync token: %w", err)
+	}
+	go h.Sync()
 	return nil
 }
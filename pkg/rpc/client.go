@@ -28,6 +28,9 @@ import (
 type GomuksRPC struct {
 	EventHandler func(ctx context.Context, evt any)
 	UserAgent    string
+	// MultiAccount makes the server send events of all accounts instead of only the default one.
+	// Use AccountFromContext in the event handler to find out which account an event belongs to.
+	MultiAccount bool
 
 	BaseURL *url.URL
 	http    *http.Client
@@ -66,6 +69,22 @@ func NewGomuksRPC(rawBaseURL string) (*GomuksRPC, error) {
 	}, nil
 }
 
+type contextKey int
+
+const accountContextKey contextKey = iota
+
+// WithAccount returns a context that makes requests sent with it target the given account instead of the default one.
+func WithAccount(ctx context.Context, account string) context.Context {
+	return context.WithValue(ctx, accountContextKey, account)
+}
+
+// AccountFromContext returns the account set with WithAccount. In event handlers,
+// it returns the account that the event came from. Empty string means the default account.
+func AccountFromContext(ctx context.Context) string {
+	account, _ := ctx.Value(accountContextKey).(string)
+	return account
+}
+
 type GomuksURLPath []any
 
 func (gup GomuksURLPath) FullPath() []any {
@@ -42,10 +42,66 @@ func (gr *GomuksRPC) SendEvent(ctx context.Context, params *jsoncmd.SendEventPar
 	return ParseResponse[*database.Event](gr.Request(ctx, jsoncmd.ReqSendEvent, params))
 }
 
+func (gr *GomuksRPC) SendPoll(ctx context.Context, params *jsoncmd.SendPollParams) (*database.Event, error) {
+	return ParseResponse[*database.Event](gr.Request(ctx, jsoncmd.ReqSendPoll, params))
+}
+
+func (gr *GomuksRPC) VotePoll(ctx context.Context, params *jsoncmd.VotePollParams) (*database.Event, error) {
+	return ParseResponse[*database.Event](gr.Request(ctx, jsoncmd.ReqVotePoll, params))
+}
+
+func (gr *GomuksRPC) EndPoll(ctx context.Context, params *jsoncmd.EndPollParams) (*database.Event, error) {
+	return ParseResponse[*database.Event](gr.Request(ctx, jsoncmd.ReqEndPoll, params))
+}
+
+func (gr *GomuksRPC) SendLocation(ctx context.Context, params *jsoncmd.SendLocationParams) (*database.Event, error) {
+	return ParseResponse[*database.Event](gr.Request(ctx, jsoncmd.ReqSendLocation, params))
+}
+
+func (gr *GomuksRPC) StartLiveLocation(ctx context.Context, params *jsoncmd.StartLiveLocationParams) (*database.LiveLocationShare, error) {
+	return ParseResponse[*database.LiveLocationShare](gr.Request(ctx, jsoncmd.ReqStartLiveLocation, params))
+}
+
+func (gr *GomuksRPC) UpdateLiveLocation(ctx context.Context, params *jsoncmd.UpdateLiveLocationParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqUpdateLiveLocation, params))
+}
+
+func (gr *GomuksRPC) StopLiveLocation(ctx context.Context, params *jsoncmd.StopLiveLocationParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqStopLiveLocation, params))
+}
+
+func (gr *GomuksRPC) GetLiveLocationShares(ctx context.Context) ([]*database.LiveLocationShare, error) {
+	return ParseResponse[[]*database.LiveLocationShare](gr.Request(ctx, jsoncmd.ReqGetLiveLocationShares, nil))
+}
+
+func (gr *GomuksRPC) GetBeacons(ctx context.Context, params *jsoncmd.GetBeaconsParams) ([]*database.Beacon, error) {
+	return ParseResponse[[]*database.Beacon](gr.Request(ctx, jsoncmd.ReqGetBeacons, params))
+}
+
 func (gr *GomuksRPC) ResendEvent(ctx context.Context, params *jsoncmd.ResendEventParams) (*database.Event, error) {
 	return ParseResponse[*database.Event](gr.Request(ctx, jsoncmd.ReqResendEvent, params))
 }
 
+// ScheduleMessage sends a message at a later time. The ScheduleAt field in the params must be set.
+func (gr *GomuksRPC) ScheduleMessage(ctx context.Context, params *jsoncmd.SendMessageParams) (*database.ScheduledMessage, error) {
+	if params.ScheduleAt.IsZero() {
+		return nil, fmt.Errorf("schedule time not set")
+	}
+	return ParseResponse[*database.ScheduledMessage](gr.Request(ctx, jsoncmd.ReqSendMessage, params))
+}
+
+func (gr *GomuksRPC) GetScheduledMessages(ctx context.Context, params *jsoncmd.GetScheduledMessagesParams) ([]*database.ScheduledMessage, error) {
+	return ParseResponse[[]*database.ScheduledMessage](gr.Request(ctx, jsoncmd.ReqGetScheduledMessages, params))
+}
+
+func (gr *GomuksRPC) CancelScheduledMessage(ctx context.Context, params *jsoncmd.CancelScheduledMessageParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqCancelScheduledMessage, params))
+}
+
+func (gr *GomuksRPC) RescheduleMessage(ctx context.Context, params *jsoncmd.RescheduleMessageParams) (*database.ScheduledMessage, error) {
+	return ParseResponse[*database.ScheduledMessage](gr.Request(ctx, jsoncmd.ReqRescheduleMessage, params))
+}
+
 func (gr *GomuksRPC) ReportEvent(ctx context.Context, params *jsoncmd.ReportEventParams) (bool, error) {
 	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqReportEvent, params))
 }
@@ -82,6 +138,14 @@ func (gr *GomuksRPC) GetProfile(ctx context.Context, params *jsoncmd.GetProfileP
 	return ParseResponse[*mautrix.RespUserProfile](gr.Request(ctx, jsoncmd.ReqGetProfile, params))
 }
 
+func (gr *GomuksRPC) SetPresence(ctx context.Context, params *jsoncmd.SetPresenceParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqSetPresence, params))
+}
+
+func (gr *GomuksRPC) GetPresence(ctx context.Context, params *jsoncmd.GetPresenceParams) ([]*database.Presence, error) {
+	return ParseResponse[[]*database.Presence](gr.Request(ctx, jsoncmd.ReqGetPresence, params))
+}
+
 func (gr *GomuksRPC) SetProfileField(ctx context.Context, params *jsoncmd.SetProfileFieldParams) (bool, error) {
 	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqSetProfileField, params))
 }
@@ -98,6 +162,18 @@ func (gr *GomuksRPC) GetProfileEncryptionInfo(ctx context.Context, params *jsonc
 	return ParseResponse[*jsoncmd.ProfileEncryptionInfo](gr.Request(ctx, jsoncmd.ReqGetProfileEncryptionInfo, params))
 }
 
+func (gr *GomuksRPC) GetOwnDevices(ctx context.Context) ([]*jsoncmd.OwnDevice, error) {
+	return ParseResponse[[]*jsoncmd.OwnDevice](gr.Request(ctx, jsoncmd.ReqGetOwnDevices, nil))
+}
+
+func (gr *GomuksRPC) RenameDevice(ctx context.Context, params *jsoncmd.RenameDeviceParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqRenameDevice, params))
+}
+
+func (gr *GomuksRPC) DeleteDevices(ctx context.Context, params *jsoncmd.DeleteDevicesParams) (*jsoncmd.DeleteDevicesResponse, error) {
+	return ParseResponse[*jsoncmd.DeleteDevicesResponse](gr.Request(ctx, jsoncmd.ReqDeleteDevices, params))
+}
+
 func (gr *GomuksRPC) GetEvent(ctx context.Context, params *jsoncmd.GetEventParams) (*database.Event, error) {
 	return ParseResponse[*database.Event](gr.Request(ctx, jsoncmd.ReqGetEvent, params))
 }
@@ -106,6 +182,10 @@ func (gr *GomuksRPC) GetRelatedEvents(ctx context.Context, params *jsoncmd.GetRe
 	return ParseResponse[[]*database.Event](gr.Request(ctx, jsoncmd.ReqGetRelatedEvents, params))
 }
 
+func (gr *GomuksRPC) GetEditHistory(ctx context.Context, params *jsoncmd.GetEditHistoryParams) (*jsoncmd.EditHistoryResponse, error) {
+	return ParseResponse[*jsoncmd.EditHistoryResponse](gr.Request(ctx, jsoncmd.ReqGetEditHistory, params))
+}
+
 func (gr *GomuksRPC) GetRoomState(ctx context.Context, params *jsoncmd.GetRoomStateParams) ([]*database.Event, error) {
 	return ParseResponse[[]*database.Event](gr.Request(ctx, jsoncmd.ReqGetRoomState, params))
 }
@@ -122,6 +202,26 @@ func (gr *GomuksRPC) Paginate(ctx context.Context, params *jsoncmd.PaginateParam
 	return ParseResponse[*jsoncmd.PaginationResponse](gr.Request(ctx, jsoncmd.ReqPaginate, params))
 }
 
+func (gr *GomuksRPC) GetThreads(ctx context.Context, params *jsoncmd.GetThreadsParams) (*jsoncmd.GetThreadsResponse, error) {
+	return ParseResponse[*jsoncmd.GetThreadsResponse](gr.Request(ctx, jsoncmd.ReqGetThreads, params))
+}
+
+func (gr *GomuksRPC) PaginateThread(ctx context.Context, params *jsoncmd.PaginateThreadParams) (*jsoncmd.ThreadPaginationResponse, error) {
+	return ParseResponse[*jsoncmd.ThreadPaginationResponse](gr.Request(ctx, jsoncmd.ReqPaginateThread, params))
+}
+
+func (gr *GomuksRPC) GetRoomStats(ctx context.Context, params *jsoncmd.GetRoomStatsParams) (*database.RoomStats, error) {
+	return ParseResponse[*database.RoomStats](gr.Request(ctx, jsoncmd.ReqGetRoomStats, params))
+}
+
+func (gr *GomuksRPC) SearchMessages(ctx context.Context, params *jsoncmd.SearchMessagesParams) (*jsoncmd.SearchMessagesResponse, error) {
+	return ParseResponse[*jsoncmd.SearchMessagesResponse](gr.Request(ctx, jsoncmd.ReqSearchMessages, params))
+}
+
+func (gr *GomuksRPC) SearchServer(ctx context.Context, params *jsoncmd.SearchServerParams) (*jsoncmd.ServerSearchResponse, error) {
+	return ParseResponse[*jsoncmd.ServerSearchResponse](gr.Request(ctx, jsoncmd.ReqSearchServer, params))
+}
+
 func (gr *GomuksRPC) GetRoomSummary(ctx context.Context, params *jsoncmd.JoinRoomParams) (*mautrix.RespRoomSummary, error) {
 	return ParseResponse[*mautrix.RespRoomSummary](gr.Request(ctx, jsoncmd.ReqGetRoomSummary, params))
 }
@@ -146,6 +246,30 @@ func (gr *GomuksRPC) MuteRoom(ctx context.Context, params *jsoncmd.MuteRoomParam
 	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqMuteRoom, params))
 }
 
+func (gr *GomuksRPC) CompactRoom(ctx context.Context, params *jsoncmd.CompactRoomParams) (*database.PruneResult, error) {
+	return ParseResponse[*database.PruneResult](gr.Request(ctx, jsoncmd.ReqCompactRoom, params))
+}
+
+func (gr *GomuksRPC) SetDraft(ctx context.Context, params *database.Draft) (*database.Draft, error) {
+	return ParseResponse[*database.Draft](gr.Request(ctx, jsoncmd.ReqSetDraft, params))
+}
+
+func (gr *GomuksRPC) GetDrafts(ctx context.Context) ([]*database.Draft, error) {
+	return ParseResponse[[]*database.Draft](gr.Request(ctx, jsoncmd.ReqGetDrafts, nil))
+}
+
+func (gr *GomuksRPC) AddBookmark(ctx context.Context, params *jsoncmd.AddBookmarkParams) (*jsoncmd.BookmarkedEvent, error) {
+	return ParseResponse[*jsoncmd.BookmarkedEvent](gr.Request(ctx, jsoncmd.ReqAddBookmark, params))
+}
+
+func (gr *GomuksRPC) RemoveBookmark(ctx context.Context, params *jsoncmd.RemoveBookmarkParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqRemoveBookmark, params))
+}
+
+func (gr *GomuksRPC) ListBookmarks(ctx context.Context) ([]*jsoncmd.BookmarkedEvent, error) {
+	return ParseResponse[[]*jsoncmd.BookmarkedEvent](gr.Request(ctx, jsoncmd.ReqListBookmarks, nil))
+}
+
 func (gr *GomuksRPC) EnsureGroupSessionShared(ctx context.Context, params *jsoncmd.EnsureGroupSessionSharedParams) (bool, error) {
 	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqEnsureGroupSessionShared, params))
 }
@@ -178,6 +302,58 @@ func (gr *GomuksRPC) Verify(ctx context.Context, params *jsoncmd.VerifyParams) (
 	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqVerify, params))
 }
 
+func (gr *GomuksRPC) BootstrapCrossSigning(ctx context.Context, params *jsoncmd.BootstrapCrossSigningParams) (*jsoncmd.RecoveryKeyResponse, error) {
+	return ParseResponse[*jsoncmd.RecoveryKeyResponse](gr.Request(ctx, jsoncmd.ReqBootstrapCrossSigning, params))
+}
+
+func (gr *GomuksRPC) GetLatestKeyBackupVersion(ctx context.Context) (*jsoncmd.KeyBackupVersion, error) {
+	return ParseResponse[*jsoncmd.KeyBackupVersion](gr.Request(ctx, jsoncmd.ReqGetLatestKeyBackup, nil))
+}
+
+func (gr *GomuksRPC) CreateKeyBackupVersion(ctx context.Context, params *jsoncmd.CreateKeyBackupVersionParams) (*jsoncmd.KeyBackupVersion, error) {
+	return ParseResponse[*jsoncmd.KeyBackupVersion](gr.Request(ctx, jsoncmd.ReqCreateKeyBackupVersion, params))
+}
+
+func (gr *GomuksRPC) DeleteKeyBackupVersion(ctx context.Context, params *jsoncmd.DeleteKeyBackupVersionParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqDeleteKeyBackupVersion, params))
+}
+
+func (gr *GomuksRPC) RotateRecoveryKey(ctx context.Context, params *jsoncmd.RotateRecoveryKeyParams) (*jsoncmd.RecoveryKeyResponse, error) {
+	return ParseResponse[*jsoncmd.RecoveryKeyResponse](gr.Request(ctx, jsoncmd.ReqRotateRecoveryKey, params))
+}
+
+func (gr *GomuksRPC) StartVerification(ctx context.Context, params *jsoncmd.StartVerificationParams) (*jsoncmd.Verification, error) {
+	return ParseResponse[*jsoncmd.Verification](gr.Request(ctx, jsoncmd.ReqStartVerification, params))
+}
+
+func (gr *GomuksRPC) AcceptVerification(ctx context.Context, params *jsoncmd.VerificationParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqAcceptVerification, params))
+}
+
+func (gr *GomuksRPC) StartSAS(ctx context.Context, params *jsoncmd.VerificationParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqStartSAS, params))
+}
+
+func (gr *GomuksRPC) ConfirmSAS(ctx context.Context, params *jsoncmd.ConfirmSASParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqConfirmSAS, params))
+}
+
+func (gr *GomuksRPC) GetVerificationQRCode(ctx context.Context, params *jsoncmd.VerificationParams) (*jsoncmd.VerificationQRCode, error) {
+	return ParseResponse[*jsoncmd.VerificationQRCode](gr.Request(ctx, jsoncmd.ReqGetVerificationQRCode, params))
+}
+
+func (gr *GomuksRPC) ScanVerificationQRCode(ctx context.Context, params *jsoncmd.ScanVerificationQRCodeParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqScanVerificationQRCode, params))
+}
+
+func (gr *GomuksRPC) ConfirmQRCodeScanned(ctx context.Context, params *jsoncmd.ConfirmQRCodeScannedParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqConfirmQRCodeScanned, params))
+}
+
+func (gr *GomuksRPC) CancelVerification(ctx context.Context, params *jsoncmd.CancelVerificationParams) (bool, error) {
+	return ParseResponse[bool](gr.Request(ctx, jsoncmd.ReqCancelVerification, params))
+}
+
 func (gr *GomuksRPC) DiscoverHomeserver(ctx context.Context, params *jsoncmd.DiscoverHomeserverParams) (*mautrix.ClientWellKnown, error) {
 	return ParseResponse[*mautrix.ClientWellKnown](gr.Request(ctx, jsoncmd.ReqDiscoverHomeserver, params))
 }
@@ -201,3 +377,11 @@ func (gr *GomuksRPC) GetTurnServers(ctx context.Context) (*mautrix.RespTurnServe
 func (gr *GomuksRPC) GetMediaConfig(ctx context.Context) (*mautrix.RespMediaConfig, error) {
 	return ParseResponse[*mautrix.RespMediaConfig](gr.Request(ctx, jsoncmd.ReqGetMediaConfig, nil))
 }
+
+func (gr *GomuksRPC) ListAccounts(ctx context.Context) ([]*jsoncmd.AccountInfo, error) {
+	return ParseResponse[[]*jsoncmd.AccountInfo](gr.Request(ctx, jsoncmd.ReqListAccounts, nil))
+}
+
+func (gr *GomuksRPC) AddAccount(ctx context.Context, params *jsoncmd.AddAccountParams) (*jsoncmd.AccountInfo, error) {
+	return ParseResponse[*jsoncmd.AccountInfo](gr.Request(ctx, jsoncmd.ReqAddAccount, params))
+}
@@ -35,6 +35,9 @@ func (gr *GomuksRPC) Connect(ctx context.Context) error {
 	}
 	wsURL := gr.BuildRawURL(GomuksURLPath{"websocket"})
 	wsURL.Scheme = strings.Replace(wsURL.Scheme, "http", "ws", 1)
+	if gr.MultiAccount {
+		wsURL.RawQuery = "multi_account=1"
+	}
 	ws, _, err := websocket.Dial(ctx, wsURL.String(), &websocket.DialOptions{
 		HTTPClient: gr.http,
 		HTTPHeader: http.Header{"User-Agent": {gr.UserAgent}},
@@ -44,7 +47,7 @@ func (gr *GomuksRPC) Connect(ctx context.Context) error {
 		return fmt.Errorf("failed to connect to websocket: %w", err)
 	}
 	ws.SetReadLimit(50 * 1024 * 1024)
-	evtChan := make(chan any, 256)
+	evtChan := make(chan *accountEvent, 256)
 	go gr.eventLoop(ctx, evtChan)
 	go gr.readLoop(ctx, ws, cancel, evtChan)
 	gr.connCtx.Store(&ctx)
@@ -69,7 +72,7 @@ func (gr *GomuksRPC) Disconnect() {
 	gr.clearPendingRequests()
 }
 
-func (gr *GomuksRPC) cancelRequest(reqID int64, reason string) {
+func (gr *GomuksRPC) cancelRequest(account string, reqID int64, reason string) {
 	ctxPtr := gr.connCtx.Load()
 	conn := gr.conn.Load()
 	if ctxPtr == nil || conn == nil {
@@ -85,6 +88,7 @@ func (gr *GomuksRPC) cancelRequest(reqID int64, reason string) {
 	}
 	_ = json.NewEncoder(wr).Encode(&jsoncmd.Container[*jsoncmd.CancelRequestParams]{
 		Command: jsoncmd.ReqCancel,
+		Account: account,
 		Data: &jsoncmd.CancelRequestParams{
 			RequestID: reqID,
 			Reason:    reason,
@@ -118,9 +122,11 @@ func (gr *GomuksRPC) Request(ctx context.Context, cmd jsoncmd.Name, data any) (j
 	if err != nil {
 		return nil, fmt.Errorf("failed to create websocket writer: %w", err)
 	}
+	account := AccountFromContext(ctx)
 	err = json.NewEncoder(wr).Encode(&jsoncmd.Container[any]{
 		Command:   cmd,
 		RequestID: reqID,
+		Account:   account,
 		Data:      data,
 	})
 	if err != nil {
@@ -144,19 +150,28 @@ func (gr *GomuksRPC) Request(ctx context.Context, cmd jsoncmd.Name, data any) (j
 		}
 		return resp.Data, nil
 	case <-ctx.Done():
-		go gr.cancelRequest(reqID, ctx.Err().Error())
+		go gr.cancelRequest(account, reqID, ctx.Err().Error())
 		return nil, fmt.Errorf("context finished while waiting for response: %w", ctx.Err())
 	}
 }
 
-func (gr *GomuksRPC) eventLoop(ctx context.Context, evtChan <-chan any) {
+type accountEvent struct {
+	account string
+	data    any
+}
+
+func (gr *GomuksRPC) eventLoop(ctx context.Context, evtChan <-chan *accountEvent) {
 	for {
 		select {
 		case evt := <-evtChan:
 			if evt == nil {
 				return
 			}
-			gr.handleEvent(ctx, evt)
+			evtCtx := ctx
+			if evt.account != "" {
+				evtCtx = WithAccount(ctx, evt.account)
+			}
+			gr.handleEvent(evtCtx, evt.data)
 		case <-ctx.Done():
 			return
 		}
@@ -180,7 +195,7 @@ func (gr *GomuksRPC) handleEvent(ctx context.Context, evt any) {
 	gr.EventHandler(ctx, evt)
 }
 
-func (gr *GomuksRPC) readLoop(ctx context.Context, ws *websocket.Conn, cancelFunc context.CancelFunc, evtChan chan<- any) {
+func (gr *GomuksRPC) readLoop(ctx context.Context, ws *websocket.Conn, cancelFunc context.CancelFunc, evtChan chan<- *accountEvent) {
 	log := zerolog.Ctx(ctx)
 	defer cancelFunc()
 	defer close(evtChan)
@@ -208,6 +223,14 @@ func parseEvent(ctx context.Context, evt *jsoncmd.Container[json.RawMessage]) an
 		data = &jsoncmd.ClientState{}
 	case jsoncmd.EventRunID:
 		data = &jsoncmd.RunData{}
+	case jsoncmd.EventVerification:
+		data = &jsoncmd.Verification{}
+	case jsoncmd.EventKeyBackupProgress:
+		data = &jsoncmd.KeyBackupProgress{}
+	case jsoncmd.EventPresence:
+		data = &jsoncmd.Presence{}
+	case jsoncmd.EventDraft:
+		data = &jsoncmd.Draft{}
 	case jsoncmd.EventImageAuthToken:
 		data = ptr.Ptr(jsoncmd.ImageAuthToken(""))
 	case jsoncmd.EventInitComplete:
@@ -226,7 +249,7 @@ func parseEvent(ctx context.Context, evt *jsoncmd.Container[json.RawMessage]) an
 	return data
 }
 
-func (gr *GomuksRPC) readLoopItem(ctx context.Context, log *zerolog.Logger, ws *websocket.Conn, evtHandler chan<- any) bool {
+func (gr *GomuksRPC) readLoopItem(ctx context.Context, log *zerolog.Logger, ws *websocket.Conn, evtHandler chan<- *accountEvent) bool {
 	var cmd *jsoncmd.Container[json.RawMessage]
 	msgType, reader, err := ws.Reader(ctx)
 	defer func() {
@@ -266,7 +289,7 @@ func (gr *GomuksRPC) readLoopItem(ctx context.Context, log *zerolog.Logger, ws *
 			close(pendingRequest)
 		}
 	} else {
-		parsedCmd := parseEvent(ctx, cmd)
+		parsedCmd := &accountEvent{account: cmd.Account, data: parseEvent(ctx, cmd)}
 		select {
 		case evtHandler <- parsedCmd:
 		default: